@@ -0,0 +1,196 @@
+// Package agecrypt encrypts exports to age recipients and decrypts them with age identities, so that an export
+// meant for one person can be handed over and kept without ever being written to disk in plaintext. The files are
+// standard age files (https://age-encryption.org/v1), binary or ASCII-armored, so the recipient can also open them
+// with the age command.
+//
+// Recipients are age X25519 public keys ("age1...") or SSH public keys ("ssh-ed25519 ..." or "ssh-rsa ...", as in
+// authorized_keys). Identities are the matching age secret keys ("AGE-SECRET-KEY-1...") or SSH private keys,
+// which may be passphrase protected.
+package agecrypt
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"filippo.io/age"
+	"filippo.io/age/agessh"
+	"filippo.io/age/armor"
+	"golang.org/x/crypto/ssh"
+)
+
+// MaxRecipients bounds the recipients of one file. Every recipient adds a stanza to the header, and an export is
+// meant for a few people, not a mailing list.
+const MaxRecipients = 64
+
+// maxLineLength bounds a line of a recipients or identity file, as sshd bounds authorized_keys lines.
+const maxLineLength = 8 << 10
+
+// maxIdentityFileSize bounds an identity file. SSH private keys are a few KiB.
+const maxIdentityFileSize = 64 << 10
+
+// MaxDecryptedSize bounds what Decrypt returns, so that a crafted file cannot exhaust memory.
+const MaxDecryptedSize = 256 << 20
+
+// binaryHeader starts every binary age file.
+const binaryHeader = "age-encryption.org/"
+
+var (
+	// ErrNoRecipients is returned when there is no recipient to encrypt for.
+	ErrNoRecipients = errors.New("no age recipients")
+	// ErrNoIdentityMatch is returned by Decrypt when none of the identities is a recipient of the file.
+	ErrNoIdentityMatch = errors.New("none of the identities can decrypt the file")
+	// ErrTooLarge is returned by Decrypt for content over MaxDecryptedSize.
+	ErrTooLarge = fmt.Errorf("decrypted content exceeds %d bytes", MaxDecryptedSize)
+)
+
+// ParseRecipient parses an age X25519 public key or an SSH public key.
+func ParseRecipient(s string) (age.Recipient, error) {
+	s = strings.TrimSpace(s)
+	switch {
+	case strings.HasPrefix(s, "age1"):
+		return age.ParseX25519Recipient(s)
+	case strings.HasPrefix(s, "ssh-"):
+		return agessh.ParseRecipient(s)
+	default:
+		return nil, errors.New("not an age or SSH public key")
+	}
+}
+
+// ParseRecipients parses recipients with ParseRecipient. Errors name the recipient by its position rather than
+// quoting it, since a pasted secret key would otherwise end up in a log.
+func ParseRecipients(recipients []string) ([]age.Recipient, error) {
+	if len(recipients) == 0 {
+		return nil, ErrNoRecipients
+	}
+	if len(recipients) > MaxRecipients {
+		return nil, fmt.Errorf("at most %d age recipients are allowed", MaxRecipients)
+	}
+	parsed := make([]age.Recipient, 0, len(recipients))
+	for i, recipient := range recipients {
+		r, err := ParseRecipient(recipient)
+		if err != nil {
+			return nil, fmt.Errorf("recipient %d: %v", i+1, err)
+		}
+		parsed = append(parsed, r)
+	}
+	return parsed, nil
+}
+
+// ReadRecipients reads a recipients file, one public key per line, as the age command does: empty lines and lines
+// starting with "#" are skipped. Every key is checked with ParseRecipient, so that a typo is caught before anything
+// is encrypted; the keys are returned as they were written.
+func ReadRecipients(r io.Reader) ([]string, error) {
+	var recipients []string
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, maxLineLength)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if _, err := ParseRecipient(line); err != nil {
+			return nil, fmt.Errorf("line %d: %v", n, err)
+		}
+		recipients = append(recipients, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(recipients) == 0 {
+		return nil, ErrNoRecipients
+	}
+	return recipients, nil
+}
+
+// Encrypt returns a writer that encrypts what is written to it for the recipients and writes it to w, ASCII-armored
+// if armored is set. The file is only complete once the writer is closed.
+func Encrypt(w io.Writer, recipients []age.Recipient, armored bool) (io.WriteCloser, error) {
+	if len(recipients) == 0 {
+		return nil, ErrNoRecipients
+	}
+	if !armored {
+		return age.Encrypt(w, recipients...)
+	}
+	armorWriter := armor.NewWriter(w)
+	encrypted, err := age.Encrypt(armorWriter, recipients...)
+	if err != nil {
+		return nil, err
+	}
+	return armoredWriter{encrypted, armorWriter}, nil
+}
+
+// armoredWriter closes the age writer, then the armor around it.
+type armoredWriter struct {
+	io.WriteCloser
+	armor io.WriteCloser
+}
+
+func (w armoredWriter) Close() error {
+	if err := w.WriteCloser.Close(); err != nil {
+		return err
+	}
+	return w.armor.Close()
+}
+
+// IsEncrypted reports whether content is an age file, binary or ASCII-armored.
+func IsEncrypted(content []byte) bool {
+	content = bytes.TrimLeft(content, " \t\r\n")
+	return bytes.HasPrefix(content, []byte(binaryHeader)) || bytes.HasPrefix(content, []byte(armor.Header))
+}
+
+// ParseIdentities parses an identity file: either age secret keys, one per line with "#" comments, or an SSH
+// private key in PEM form. For a passphrase-protected SSH key, passphrase is called when the key is first needed;
+// it may be nil if no prompt is possible, in which case such keys are rejected.
+func ParseIdentities(content []byte, passphrase func() ([]byte, error)) ([]age.Identity, error) {
+	if len(content) > maxIdentityFileSize {
+		return nil, errors.New("identity file is too large")
+	}
+	if bytes.HasPrefix(bytes.TrimSpace(content), []byte("-----BEGIN")) {
+		identity, err := agessh.ParseIdentity(content)
+		var missing *ssh.PassphraseMissingError
+		if errors.As(err, &missing) {
+			if passphrase == nil {
+				return nil, errors.New("the SSH key is passphrase protected and no passphrase can be asked for")
+			}
+			if missing.PublicKey == nil {
+				return nil, errors.New("the SSH key is passphrase protected in the old PEM format; convert it with ssh-keygen -p")
+			}
+			identity, err = agessh.NewEncryptedSSHIdentity(missing.PublicKey, content, passphrase)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid SSH private key: %v", err)
+		}
+		return []age.Identity{identity}, nil
+	}
+
+	return age.ParseIdentities(bytes.NewReader(content))
+}
+
+// Decrypt decrypts an age file, binary or ASCII-armored, with the first of the identities that is one of its
+// recipients. It returns ErrNoIdentityMatch if none is, and ErrTooLarge for content over MaxDecryptedSize.
+func Decrypt(content []byte, identities []age.Identity) ([]byte, error) {
+	var r io.Reader = bytes.NewReader(content)
+	if trimmed := bytes.TrimLeft(content, " \t\r\n"); bytes.HasPrefix(trimmed, []byte(armor.Header)) {
+		r = armor.NewReader(bytes.NewReader(trimmed))
+	}
+	decrypted, err := age.Decrypt(r, identities...)
+	var noMatch *age.NoIdentityMatchError
+	if errors.As(err, &noMatch) {
+		return nil, ErrNoIdentityMatch
+	}
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := io.ReadAll(io.LimitReader(decrypted, MaxDecryptedSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(plaintext) > MaxDecryptedSize {
+		return nil, ErrTooLarge
+	}
+	return plaintext, nil
+}
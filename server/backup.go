@@ -0,0 +1,301 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rihts-4/pasword-mango/backup"
+	"github.com/rihts-4/pasword-mango/config"
+	"github.com/rihts-4/pasword-mango/data"
+	"golang.org/x/term"
+)
+
+// backupHandler serves POST /admin/backup, which returns an encrypted backup archive of the whole vault (see the
+// backup package). The JSON body holds the backup `passphrase`, which must be at least backup.MinPassphraseLength
+// characters; it is sent as a POST so that the passphrase never appears in a URL. The archive is sent as
+// application/octet-stream.
+//
+// Sites whose credentials cannot be decrypted are logged and left out, and their number is sent in the
+// X-Backup-Failed header. The handler returns 400 for malformed requests and short passphrases, 500 for data errors,
+// and 405 for methods other than POST.
+func backupHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	recordAudit(r, data.AuditBackup, "")
+	var payload struct {
+		Passphrase string `json:"passphrase"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if len(payload.Passphrase) < backup.MinPassphraseLength {
+		http.Error(w, backup.ErrShortPassphrase.Error(), http.StatusBadRequest)
+		return
+	}
+
+	archive, failed, err := data.Backup(r.Context())
+	if err != nil {
+		log.Printf("Failed to back up the vault: %v", err)
+		http.Error(w, "Failed to back up the vault", http.StatusInternalServerError)
+		return
+	}
+	var encrypted bytes.Buffer
+	if err := backup.Write(&encrypted, archive, payload.Passphrase); err != nil {
+		log.Printf("Failed to write backup: %v", err)
+		http.Error(w, "Failed to write backup", http.StatusInternalServerError)
+		return
+	}
+	log.Printf("Backed up %d credentials, %d failed", len(archive.Items), len(failed))
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Cache-Control", "no-store")
+	if len(failed) > 0 {
+		w.Header().Set("X-Backup-Failed", strconv.Itoa(len(failed)))
+	}
+	w.Write(encrypted.Bytes())
+}
+
+// backupCommand implements `backup`, which writes an encrypted backup archive of the vault to a new file, or to
+// standard output, without starting the server. It returns 1 if any site could not be decrypted and was left out,
+// after writing the archive.
+func backupCommand(ctx context.Context, cfg config.Config, args []string) int {
+	flags := flag.NewFlagSet("backup", flag.ContinueOnError)
+	out := flags.String("out", "", "file to write the backup to, or - for standard output (default pasword-mango-TIMESTAMP.backup)")
+	passphraseFile := flags.String("passphrase-file", "", "read the backup passphrase from this file instead of prompting for it")
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: pasword-mango [flags] backup [-out FILE] [-passphrase-file PATH]")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil || flags.NArg() != 0 {
+		return 2
+	}
+	if *out == "" {
+		*out = backupName(time.Now())
+	}
+	passphrase, err := readPassphrase(*passphraseFile, true)
+	if err != nil {
+		log.Print(err)
+		return 1
+	}
+	if len(passphrase) < backup.MinPassphraseLength {
+		log.Print(backup.ErrShortPassphrase)
+		return 1
+	}
+
+	if err := data.InitDB(ctx, cfg.Storage, cfg.Key); err != nil {
+		log.Printf("Failed to initialize database: %v", err)
+		return 1
+	}
+	defer data.CloseDB()
+
+	archive, failed, err := data.Backup(ctx)
+	if err != nil {
+		log.Printf("Failed to back up the vault: %v", err)
+		return 1
+	}
+	var encrypted bytes.Buffer
+	if err := backup.Write(&encrypted, archive, passphrase); err != nil {
+		log.Printf("Failed to write backup: %v", err)
+		return 1
+	}
+	if *out == "-" {
+		_, err = os.Stdout.Write(encrypted.Bytes())
+	} else {
+		err = writeNewFile(*out, encrypted.Bytes())
+	}
+	if err != nil {
+		log.Printf("Failed to write backup: %v", err)
+		return 1
+	}
+	if *out != "-" {
+		fmt.Printf("Backed up %d credentials and %d rotation policies to %s.\n", len(archive.Items), len(archive.RotationPolicies), *out)
+	}
+	if len(failed) > 0 {
+		log.Printf("%d sites could not be decrypted and are missing from the backup: %s", len(failed), strings.Join(failed, ", "))
+		return 1
+	}
+	return 0
+}
+
+// restoreCommand implements `restore`, which decrypts a backup archive and writes its content into the configured
+// storage backend, encrypted with the configured key, then verifies it by reading every restored credential back.
+// The global flags select the target, so a backup can be restored into another project or emulator, with another
+// key. A replace asks for confirmation on a terminal unless -yes is given. It returns 1 if any site failed to
+// restore or verify.
+func restoreCommand(ctx context.Context, cfg config.Config, args []string) int {
+	flags := flag.NewFlagSet("restore", flag.ContinueOnError)
+	mode := flags.String("mode", data.RestoreMerge, "merge keeps stored credentials that are not in the backup; replace deletes them")
+	dryRun := flags.Bool("dry-run", false, "report what would change without writing anything")
+	passphraseFile := flags.String("passphrase-file", "", "read the backup passphrase from this file instead of prompting for it")
+	yes := flags.Bool("yes", false, "do not ask for confirmation before a replace")
+	asJSON := flags.Bool("json", false, "print the report as JSON")
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: pasword-mango [flags] restore [-mode merge|replace] [-dry-run] [-passphrase-file PATH] [-yes] [-json] FILE")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil || flags.NArg() != 1 {
+		return 2
+	}
+	if *mode != data.RestoreMerge && *mode != data.RestoreReplace {
+		log.Print(data.ErrInvalidRestoreMode)
+		return 2
+	}
+
+	f, err := os.Open(flags.Arg(0))
+	if err != nil {
+		log.Printf("Failed to open backup: %v", err)
+		return 1
+	}
+	defer f.Close()
+	header, err := backup.ReadHeader(f)
+	if err != nil {
+		log.Printf("Failed to read backup: %v", err)
+		return 1
+	}
+	passphrase, err := readPassphrase(*passphraseFile, false)
+	if err != nil {
+		log.Print(err)
+		return 1
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		log.Printf("Failed to read backup: %v", err)
+		return 1
+	}
+	archive, _, err := backup.Read(f, passphrase)
+	if err != nil {
+		log.Printf("Failed to decrypt backup: %v", err)
+		return 1
+	}
+	fmt.Fprintf(os.Stderr, "Backup of %s holds %d credentials and %d rotation policies.\n",
+		header.CreatedAt.Format(time.DateTime), len(archive.Items), len(archive.RotationPolicies))
+
+	if *mode == data.RestoreReplace && !*dryRun && !*yes {
+		if !term.IsTerminal(int(os.Stdin.Fd())) {
+			log.Print("Standard input is not a terminal; use -yes to confirm the replace.")
+			return 1
+		}
+		fmt.Fprint(os.Stderr, "Replace deletes every stored credential and rotation policy that is not in the backup. Continue? [y/N] ")
+		answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+		if answer = strings.ToLower(strings.TrimSpace(answer)); answer != "y" && answer != "yes" {
+			return 1
+		}
+	}
+
+	if err := data.InitDB(ctx, cfg.Storage, cfg.Key); err != nil {
+		log.Printf("Failed to initialize database: %v", err)
+		return 1
+	}
+	defer data.CloseDB()
+
+	report, err := data.Restore(ctx, archive, data.RestoreOptions{Mode: *mode, DryRun: *dryRun})
+	if errors.Is(err, data.ErrWrongKey) {
+		log.Print("The target vault holds data encrypted with another key; restore into it with its own key.")
+		return 1
+	}
+	if err != nil {
+		log.Printf("Failed to restore backup: %v", err)
+		return 1
+	}
+	if *asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		encoder.Encode(report)
+	} else {
+		printRestoreReport(report)
+	}
+	if len(report.Failed) > 0 || len(report.Mismatched) > 0 {
+		return 1
+	}
+	return 0
+}
+
+// printRestoreReport prints the failures and mismatches of a restore, followed by a summary.
+func printRestoreReport(report data.RestoreReport) {
+	for _, failure := range report.Failed {
+		fmt.Printf("Failed %s: %s\n", failure.Site, failure.Reason)
+	}
+	for _, site := range report.Mismatched {
+		fmt.Printf("Did not verify %s\n", site)
+	}
+	verb := "Restored"
+	if report.DryRun {
+		verb = "Would restore"
+	}
+	fmt.Printf("%s (%s): %d created, %d updated, %d unchanged, %d deleted; %d rotation policies, %d deleted; %d failed.\n",
+		verb, report.Mode, report.Created, report.Updated, report.Unchanged, report.Deleted, report.Policies, report.DeletedPolicies, len(report.Failed))
+	if !report.DryRun {
+		fmt.Printf("Verified %d credentials, %d mismatched.\n", report.Verified, len(report.Mismatched))
+	}
+}
+
+// readPassphrase reads a backup passphrase from the first line of a file or, without one, from standard input. On a
+// terminal it prompts on standard error without echoing, asking twice if confirm is set to catch typos.
+func readPassphrase(path string, confirm bool) (string, error) {
+	if path != "" {
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return "", fmt.Errorf("error reading passphrase file: %v", err)
+		}
+		line, _, _ := strings.Cut(string(content), "\n")
+		return strings.TrimRight(line, "\r"), nil
+	}
+
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && !errors.Is(err, io.EOF) {
+			return "", fmt.Errorf("error reading passphrase from standard input: %v", err)
+		}
+		return strings.TrimRight(line, "\r\n"), nil
+	}
+	prompt := func(text string) (string, error) {
+		fmt.Fprint(os.Stderr, text)
+		passphrase, err := term.ReadPassword(fd)
+		fmt.Fprintln(os.Stderr)
+		if err != nil {
+			return "", fmt.Errorf("error reading passphrase from terminal: %v", err)
+		}
+		return string(passphrase), nil
+	}
+	passphrase, err := prompt("Backup passphrase: ")
+	if err != nil || !confirm {
+		return passphrase, err
+	}
+	again, err := prompt("Repeat passphrase: ")
+	if err != nil {
+		return "", err
+	}
+	if again != passphrase {
+		return "", errors.New("passphrases do not match")
+	}
+	return passphrase, nil
+}
+
+// writeNewFile writes content to a new file that only the current user can read, refusing to overwrite one.
+func writeNewFile(path string, content []byte) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(content); err != nil {
+		f.Close()
+		os.Remove(path)
+		return err
+	}
+	return f.Close()
+}
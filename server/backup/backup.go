@@ -0,0 +1,332 @@
+// Package backup reads and writes the vault's encrypted backup archives. An archive is a single file holding every
+// credential, with its details, password history, and change time, together with the rotation policies and vault
+// metadata, so that a vault can be restored from it into any storage backend without trusting the backend's own
+// backups.
+//
+// An archive is encrypted with AES-256-GCM under a key derived from a backup passphrase with Argon2id. The
+// passphrase is independent of the vault's encryption key, so an archive can be restored into a vault with another
+// key. The archive starts with a plaintext header that describes it, including the format version and the KDF
+// parameters; the header is authenticated along with the encrypted content, so altering either is detected.
+//
+// The package only deals with the file format. It knows nothing of Firestore: data.Backup and data.Restore map the
+// vault to and from an Archive.
+package backup
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"golang.org/x/crypto/argon2"
+)
+
+// FormatVersion is the version of the archive format that Write writes. Read accepts archives up to this version.
+const FormatVersion = 1
+
+// magic starts every archive.
+var magic = []byte("MANGOBAK")
+
+// Names recorded in the header.
+const (
+	kdfArgon2id      = "argon2id"
+	cipherAES256GCM  = "aes-256-gcm"
+	compressionGzip  = "gzip"
+	contentTypeVault = "vault"
+)
+
+// Parameters of the key derivation Write uses: 64 MiB and three passes of Argon2id, about a second on a laptop.
+// Backups are rare, so they can afford a slower KDF than the vault's requests could.
+const (
+	argon2Memory  = 64 << 10 // KiB
+	argon2Time    = 3
+	argon2Threads = 4
+	saltSize      = 32
+)
+
+// Limits on the KDF parameters Read accepts, so that a crafted archive cannot make the server allocate gigabytes or
+// spin for minutes.
+const (
+	maxArgon2Memory  = 1 << 20 // KiB
+	maxArgon2Time    = 100
+	maxArgon2Threads = 64
+)
+
+// maxHeaderSize bounds the header, and maxContentSize the decompressed content, of an archive that Read accepts.
+const (
+	maxHeaderSize  = 64 << 10
+	maxContentSize = 256 << 20
+)
+
+// MinPassphraseLength is the shortest backup passphrase Write accepts. An archive can be attacked offline by anyone
+// who obtains it, so the passphrase has to hold up on its own.
+const MinPassphraseLength = 12
+
+var (
+	// ErrNotBackup is returned for a file that is not a backup archive.
+	ErrNotBackup = errors.New("not a pasword-mango backup")
+	// ErrUnsupportedVersion is returned for an archive written by a newer release.
+	ErrUnsupportedVersion = errors.New("backup was written by a newer version of pasword-mango")
+	// ErrWrongPassphrase is returned when an archive cannot be decrypted. AES-GCM cannot tell a wrong passphrase from
+	// an archive that was damaged or tampered with, so the error covers both.
+	ErrWrongPassphrase = errors.New("wrong passphrase, or the backup is corrupt")
+	// ErrCorrupt is returned for an archive whose header or content cannot be parsed.
+	ErrCorrupt = errors.New("backup is corrupt")
+	// ErrShortPassphrase is returned by Write for a passphrase shorter than MinPassphraseLength.
+	ErrShortPassphrase = fmt.Errorf("backup passphrase must be at least %d characters", MinPassphraseLength)
+)
+
+// Header describes an archive. It is stored in plaintext so that an archive can be identified without its
+// passphrase, and authenticated with the content.
+type Header struct {
+	Version     int       `json:"version"`
+	CreatedAt   time.Time `json:"createdAt"`
+	Content     string    `json:"content"`
+	KDF         KDF       `json:"kdf"`
+	Cipher      string    `json:"cipher"`
+	Nonce       []byte    `json:"nonce"`
+	Compression string    `json:"compression"`
+}
+
+// KDF holds the parameters of the Argon2id key derivation. Memory is in KiB.
+type KDF struct {
+	Name    string `json:"name"`
+	Salt    []byte `json:"salt"`
+	Time    uint32 `json:"time"`
+	Memory  uint32 `json:"memory"`
+	Threads uint8  `json:"threads"`
+}
+
+// Archive is the content of a backup.
+type Archive struct {
+	CreatedAt time.Time `json:"createdAt"`
+	// Vault is the metadata of the vault the backup was taken from. It is zero for a vault without metadata.
+	Vault            Vault            `json:"vault"`
+	Items            []Item           `json:"items"`
+	RotationPolicies []RotationPolicy `json:"rotationPolicies"`
+}
+
+// Vault is the metadata of a vault.
+type Vault struct {
+	CreatedAt     time.Time `json:"createdAt"`
+	FormatVersion int       `json:"formatVersion"`
+}
+
+// Item is the credentials of a site, with everything the vault stores about them.
+type Item struct {
+	Site              string    `json:"site"`
+	Username          string    `json:"username"`
+	Password          string    `json:"password"`
+	Tags              []string  `json:"tags,omitempty"`
+	Notes             string    `json:"notes,omitempty"`
+	TOTP              string    `json:"totp,omitempty"`
+	Fields            []Field   `json:"fields,omitempty"`
+	History           []Version `json:"history,omitempty"`
+	PasswordChangedAt time.Time `json:"passwordChangedAt"`
+}
+
+// Field is a custom field of an item.
+type Field struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+	Type  string `json:"type,omitempty"`
+}
+
+// Version is an earlier password of an item.
+type Version struct {
+	Username  string    `json:"username"`
+	Password  string    `json:"password"`
+	ChangedAt time.Time `json:"changedAt"`
+}
+
+// RotationPolicy is a rotation policy for a site or a tag.
+type RotationPolicy struct {
+	Scope string `json:"scope"`
+	Name  string `json:"name"`
+	Days  int    `json:"days"`
+}
+
+// Write encrypts an archive with a key derived from passphrase and writes it to w.
+//
+// The archive is laid out as the magic bytes "MANGOBAK", the format version and the length of the header as big-endian
+// uint16 and uint32, the JSON header, and the gzip-compressed JSON content sealed with AES-256-GCM. Everything before
+// the sealed content is its additional data.
+func Write(w io.Writer, archive Archive, passphrase string) error {
+	if len(passphrase) < MinPassphraseLength {
+		return ErrShortPassphrase
+	}
+	header := Header{
+		Version:     FormatVersion,
+		CreatedAt:   archive.CreatedAt,
+		Content:     contentTypeVault,
+		KDF:         KDF{Name: kdfArgon2id, Salt: make([]byte, saltSize), Time: argon2Time, Memory: argon2Memory, Threads: argon2Threads},
+		Cipher:      cipherAES256GCM,
+		Compression: compressionGzip,
+	}
+	if _, err := rand.Read(header.KDF.Salt); err != nil {
+		return err
+	}
+	gcm, err := newGCM(header.KDF, passphrase)
+	if err != nil {
+		return err
+	}
+	header.Nonce = make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(header.Nonce); err != nil {
+		return err
+	}
+
+	var content bytes.Buffer
+	zw := gzip.NewWriter(&content)
+	if err := json.NewEncoder(zw).Encode(archive); err != nil {
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+
+	prefix, err := encodePrefix(header)
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(prefix); err != nil {
+		return err
+	}
+	_, err = w.Write(gcm.Seal(nil, header.Nonce, content.Bytes(), prefix))
+	return err
+}
+
+// Read decrypts an archive with its passphrase and returns it with its header. It returns ErrWrongPassphrase if the
+// archive does not decrypt, which is also what happens if it was tampered with.
+func Read(r io.Reader, passphrase string) (Archive, Header, error) {
+	header, prefix, err := readPrefix(r)
+	if err != nil {
+		return Archive{}, Header{}, err
+	}
+	if err := header.check(); err != nil {
+		return Archive{}, header, err
+	}
+	gcm, err := newGCM(header.KDF, passphrase)
+	if err != nil {
+		return Archive{}, header, err
+	}
+	if len(header.Nonce) != gcm.NonceSize() {
+		return Archive{}, header, fmt.Errorf("%w: invalid nonce", ErrCorrupt)
+	}
+
+	sealed, err := io.ReadAll(io.LimitReader(r, maxContentSize+1))
+	if err != nil {
+		return Archive{}, header, err
+	}
+	if len(sealed) > maxContentSize {
+		return Archive{}, header, fmt.Errorf("%w: content exceeds %d bytes", ErrCorrupt, maxContentSize)
+	}
+	content, err := gcm.Open(nil, header.Nonce, sealed, prefix)
+	if err != nil {
+		return Archive{}, header, ErrWrongPassphrase
+	}
+
+	zr, err := gzip.NewReader(bytes.NewReader(content))
+	if err != nil {
+		return Archive{}, header, fmt.Errorf("%w: %v", ErrCorrupt, err)
+	}
+	decoded, err := io.ReadAll(io.LimitReader(zr, maxContentSize+1))
+	if err != nil {
+		return Archive{}, header, fmt.Errorf("%w: %v", ErrCorrupt, err)
+	}
+	if len(decoded) > maxContentSize {
+		return Archive{}, header, fmt.Errorf("%w: content exceeds %d bytes", ErrCorrupt, maxContentSize)
+	}
+	var archive Archive
+	if err := json.Unmarshal(decoded, &archive); err != nil {
+		return Archive{}, header, fmt.Errorf("%w: %v", ErrCorrupt, err)
+	}
+	return archive, header, nil
+}
+
+// ReadHeader reads the header of an archive without decrypting it.
+func ReadHeader(r io.Reader) (Header, error) {
+	header, _, err := readPrefix(r)
+	return header, err
+}
+
+// encodePrefix encodes everything in an archive that precedes the sealed content.
+func encodePrefix(header Header) ([]byte, error) {
+	encoded, err := json.Marshal(header)
+	if err != nil {
+		return nil, err
+	}
+	prefix := append([]byte{}, magic...)
+	prefix = binary.BigEndian.AppendUint16(prefix, uint16(header.Version))
+	prefix = binary.BigEndian.AppendUint32(prefix, uint32(len(encoded)))
+	return append(prefix, encoded...), nil
+}
+
+// readPrefix reads the magic bytes, version, and header of an archive, and returns the header along with the raw
+// bytes read, which authenticate the content.
+func readPrefix(r io.Reader) (Header, []byte, error) {
+	fixed := make([]byte, len(magic)+6)
+	if _, err := io.ReadFull(r, fixed); err != nil {
+		return Header{}, nil, ErrNotBackup
+	}
+	if !bytes.Equal(fixed[:len(magic)], magic) {
+		return Header{}, nil, ErrNotBackup
+	}
+	version := binary.BigEndian.Uint16(fixed[len(magic):])
+	if version > FormatVersion {
+		return Header{}, nil, fmt.Errorf("%w (format version %d)", ErrUnsupportedVersion, version)
+	}
+	size := binary.BigEndian.Uint32(fixed[len(magic)+2:])
+	if size > maxHeaderSize {
+		return Header{}, nil, fmt.Errorf("%w: header exceeds %d bytes", ErrCorrupt, maxHeaderSize)
+	}
+	encoded := make([]byte, size)
+	if _, err := io.ReadFull(r, encoded); err != nil {
+		return Header{}, nil, fmt.Errorf("%w: truncated header", ErrCorrupt)
+	}
+	var header Header
+	if err := json.Unmarshal(encoded, &header); err != nil {
+		return Header{}, nil, fmt.Errorf("%w: %v", ErrCorrupt, err)
+	}
+	if header.Version != int(version) {
+		return Header{}, nil, fmt.Errorf("%w: header version does not match", ErrCorrupt)
+	}
+	return header, append(fixed, encoded...), nil
+}
+
+// check rejects headers that Read cannot decrypt, or whose KDF parameters are out of bounds.
+func (h Header) check() error {
+	switch {
+	case h.Content != contentTypeVault:
+		return fmt.Errorf("%w: unknown content %q", ErrCorrupt, h.Content)
+	case h.Cipher != cipherAES256GCM:
+		return fmt.Errorf("%w: unsupported cipher %q", ErrCorrupt, h.Cipher)
+	case h.Compression != compressionGzip:
+		return fmt.Errorf("%w: unsupported compression %q", ErrCorrupt, h.Compression)
+	case h.KDF.Name != kdfArgon2id:
+		return fmt.Errorf("%w: unsupported key derivation %q", ErrCorrupt, h.KDF.Name)
+	case len(h.KDF.Salt) < 16:
+		return fmt.Errorf("%w: salt is too short", ErrCorrupt)
+	case h.KDF.Time == 0 || h.KDF.Time > maxArgon2Time,
+		h.KDF.Memory == 0 || h.KDF.Memory > maxArgon2Memory,
+		h.KDF.Threads == 0 || h.KDF.Threads > maxArgon2Threads:
+		return fmt.Errorf("%w: key derivation parameters out of bounds", ErrCorrupt)
+	}
+	return nil
+}
+
+// newGCM derives the archive key from the passphrase and returns the AES-256-GCM cipher keyed with it.
+func newGCM(kdf KDF, passphrase string) (cipher.AEAD, error) {
+	key := argon2.IDKey([]byte(passphrase), kdf.Salt, kdf.Time, kdf.Memory, kdf.Threads, 32)
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
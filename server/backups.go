@@ -0,0 +1,428 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rihts-4/pasword-mango/backup"
+	"github.com/rihts-4/pasword-mango/config"
+	"github.com/rihts-4/pasword-mango/data"
+)
+
+// Backups are named after the time they were taken, in UTC, as `backup` names them by default.
+const (
+	backupPrefix     = "pasword-mango-"
+	backupSuffix     = ".backup"
+	backupTimeLayout = "20060102-150405"
+)
+
+// backupName returns the file name of a backup taken at t.
+func backupName(t time.Time) string {
+	return backupPrefix + t.UTC().Format(backupTimeLayout) + backupSuffix
+}
+
+// parseBackupName returns the time a backup was taken from its file name. It reports false for other files.
+func parseBackupName(name string) (time.Time, bool) {
+	if !strings.HasPrefix(name, backupPrefix) || !strings.HasSuffix(name, backupSuffix) {
+		return time.Time{}, false
+	}
+	t, err := time.Parse(backupTimeLayout, strings.TrimSuffix(strings.TrimPrefix(name, backupPrefix), backupSuffix))
+	return t, err == nil
+}
+
+// backupStatus describes the scheduled backups, as reported by the health and metrics endpoints. It never includes
+// the passphrase.
+type backupStatus struct {
+	Enabled  bool   `json:"enabled"`
+	Dir      string `json:"dir,omitempty"`
+	Schedule string `json:"schedule,omitempty"`
+	// Healthy is false once a backup has failed, until one succeeds, and when the last successful backup is older
+	// than the configured maximum age.
+	Healthy     bool      `json:"healthy"`
+	Problem     string    `json:"problem,omitempty"`
+	NextRun     time.Time `json:"nextRun,omitzero"`
+	LastAttempt time.Time `json:"lastAttempt,omitzero"`
+	LastSuccess time.Time `json:"lastSuccess,omitzero"`
+	LastFile    string    `json:"lastFile,omitempty"`
+	LastItems   int       `json:"lastItems"`
+	LastSize    int64     `json:"lastSize"`
+	LastError   string    `json:"lastError,omitempty"`
+	// ConsecutiveFailures counts the failures since the last success; Successes and Failures count every backup
+	// since the server started.
+	ConsecutiveFailures int `json:"consecutiveFailures"`
+	Successes           int `json:"successes"`
+	Failures            int `json:"failures"`
+	// Kept is the number of backups in the directory after the last rotation.
+	Kept int `json:"kept"`
+}
+
+// scheduledBackups takes encrypted backups into a local directory on a cron schedule, verifies each by decrypting
+// it again, and rotates old ones out, keeping a number of daily, weekly, and monthly backups (grandfather-father-son
+// rotation). Failures are logged at error level and reported by the health and metrics endpoints.
+type scheduledBackups struct {
+	cfg      config.Backup
+	schedule config.Schedule
+
+	mu     sync.Mutex
+	status backupStatus
+}
+
+// newScheduledBackups configures the scheduled backups. They are disabled if no backup directory is configured.
+func newScheduledBackups(cfg config.Backup) (*scheduledBackups, error) {
+	sb := &scheduledBackups{cfg: cfg, status: backupStatus{Enabled: cfg.Dir != "", Healthy: true}}
+	if !sb.status.Enabled {
+		return sb, nil
+	}
+	schedule, err := config.ParseSchedule(cfg.Schedule)
+	if err != nil {
+		return nil, err
+	}
+	sb.schedule = schedule
+	sb.status.Dir, sb.status.Schedule = cfg.Dir, cfg.Schedule
+	return sb, nil
+}
+
+// watch takes a backup whenever the schedule says, until done is closed. If the newest backup in the directory is
+// older than the last time the schedule fired, because the server was not running then, a backup is taken right
+// away. It does nothing if scheduled backups are disabled.
+func (sb *scheduledBackups) watch(done <-chan struct{}) {
+	if !sb.status.Enabled {
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		<-done
+		cancel()
+	}()
+
+	if err := os.MkdirAll(sb.cfg.Dir, 0700); err != nil {
+		sb.fail(fmt.Errorf("failed to create backup directory: %w", err), -1)
+	}
+	log.Printf("Scheduled backups to %s on %q, keeping %d daily, %d weekly, and %d monthly backups.",
+		sb.cfg.Dir, sb.cfg.Schedule, sb.cfg.KeepDaily, sb.cfg.KeepWeekly, sb.cfg.KeepMonthly)
+	times, _ := sb.backups()
+	missed := len(times) == 0
+	if !missed {
+		sb.mu.Lock()
+		sb.status.LastSuccess, sb.status.Kept = times[0], len(times)
+		sb.mu.Unlock()
+		next := sb.schedule.Next(times[0].Local())
+		missed = !next.IsZero() && next.Before(time.Now())
+	}
+	if missed {
+		sb.run(ctx)
+	}
+
+	for {
+		next := sb.schedule.Next(time.Now())
+		if next.IsZero() {
+			log.Printf("Backup schedule %q never fires; scheduled backups are stopped.", sb.cfg.Schedule)
+			return
+		}
+		sb.mu.Lock()
+		sb.status.NextRun = next
+		sb.mu.Unlock()
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-done:
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		sb.run(ctx)
+	}
+}
+
+// run takes one backup, verifies it, and rotates the directory, recording the outcome in the status.
+func (sb *scheduledBackups) run(ctx context.Context) {
+	started := time.Now()
+	sb.mu.Lock()
+	sb.status.LastAttempt = started.UTC()
+	sb.mu.Unlock()
+
+	path, items, size, err := sb.take(ctx, started)
+	kept := -1
+	if path != "" {
+		// A backup kept although it failed is rotated too, or a site that never decrypts would fill the directory.
+		// A failed rotation does not fail the backup itself, but it is reported.
+		var rotateErr error
+		if kept, rotateErr = sb.rotate(); rotateErr != nil {
+			slog.Error("Failed to rotate backups", "dir", sb.cfg.Dir, "error", rotateErr)
+		}
+	}
+	if err != nil {
+		if ctx.Err() == nil {
+			sb.fail(err, kept)
+		}
+		return
+	}
+
+	sb.mu.Lock()
+	defer sb.mu.Unlock()
+	sb.status.LastSuccess = started.UTC()
+	sb.status.LastFile = filepath.Base(path)
+	sb.status.LastItems = items
+	sb.status.LastSize = size
+	sb.status.LastError = ""
+	sb.status.ConsecutiveFailures = 0
+	sb.status.Successes++
+	sb.status.Kept = kept
+	log.Printf("Backed up %d credentials to %s (%d bytes, verified) in %s; %d backups kept.",
+		items, path, size, time.Since(started).Round(time.Millisecond), kept)
+}
+
+// take writes a backup taken at t to a temporary file, decrypts it again to verify it against the vault it was
+// taken from, and only then moves it into place. It returns the path, the number of credentials, and the size of the
+// backup. A backup that leaves out sites that could not be decrypted is kept, but fails: its path is returned with
+// the error.
+func (sb *scheduledBackups) take(ctx context.Context, t time.Time) (string, int, int64, error) {
+	passphrase, err := readPassphrase(sb.cfg.PassphraseFile, false)
+	if err != nil {
+		return "", 0, 0, err
+	}
+	if len(passphrase) < backup.MinPassphraseLength {
+		return "", 0, 0, backup.ErrShortPassphrase
+	}
+
+	archive, failed, err := data.Backup(ctx)
+	if err != nil {
+		return "", 0, 0, err
+	}
+	var encrypted bytes.Buffer
+	if err := backup.Write(&encrypted, archive, passphrase); err != nil {
+		return "", 0, 0, fmt.Errorf("failed to encrypt backup: %w", err)
+	}
+
+	path := filepath.Join(sb.cfg.Dir, backupName(t))
+	temporary := path + ".tmp"
+	if err := writeSyncedFile(temporary, encrypted.Bytes()); err != nil {
+		return "", 0, 0, fmt.Errorf("failed to write backup: %w", err)
+	}
+	if err := verifyBackupFile(temporary, passphrase, archive); err != nil {
+		os.Remove(temporary)
+		return "", 0, 0, fmt.Errorf("backup did not verify: %w", err)
+	}
+	if err := os.Rename(temporary, path); err != nil {
+		os.Remove(temporary)
+		return "", 0, 0, fmt.Errorf("failed to write backup: %w", err)
+	}
+	if len(failed) > 0 {
+		return path, 0, 0, fmt.Errorf("%d sites could not be decrypted and are missing from %s: %s", len(failed), path, strings.Join(failed, ", "))
+	}
+	return path, len(archive.Items), int64(encrypted.Len()), nil
+}
+
+// fail records a failed backup and logs it at error level. kept is the number of backups left by the rotation after
+// it, or -1 if there was none.
+func (sb *scheduledBackups) fail(err error, kept int) {
+	sb.mu.Lock()
+	defer sb.mu.Unlock()
+	sb.status.LastError = err.Error()
+	sb.status.ConsecutiveFailures++
+	sb.status.Failures++
+	if kept >= 0 {
+		sb.status.Kept = kept
+	}
+	slog.Error("Scheduled backup failed", "dir", sb.cfg.Dir, "consecutiveFailures", sb.status.ConsecutiveFailures, "error", err)
+}
+
+// verifyBackupFile reads a backup back from disk and checks that it decrypts to the archive that was written.
+func verifyBackupFile(path, passphrase string, want backup.Archive) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	got, _, err := backup.Read(f, passphrase)
+	if err != nil {
+		return err
+	}
+	if len(got.Items) != len(want.Items) || len(got.RotationPolicies) != len(want.RotationPolicies) {
+		return fmt.Errorf("backup holds %d credentials and %d rotation policies instead of %d and %d",
+			len(got.Items), len(got.RotationPolicies), len(want.Items), len(want.RotationPolicies))
+	}
+	for i := range got.Items {
+		if got.Items[i].Site != want.Items[i].Site || got.Items[i].Password != want.Items[i].Password {
+			return fmt.Errorf("credentials for site %s differ from the vault", want.Items[i].Site)
+		}
+	}
+	return nil
+}
+
+// writeSyncedFile writes content to a file that only the current user can read and flushes it to disk.
+func writeSyncedFile(path string, content []byte) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(content); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// backups returns the times of the backups in the directory, newest first. Other files are ignored.
+func (sb *scheduledBackups) backups() ([]time.Time, error) {
+	entries, err := os.ReadDir(sb.cfg.Dir)
+	if err != nil {
+		return nil, err
+	}
+	var times []time.Time
+	for _, entry := range entries {
+		if t, ok := parseBackupName(entry.Name()); ok && entry.Type().IsRegular() {
+			times = append(times, t)
+		}
+	}
+	sort.Slice(times, func(i, j int) bool { return times[i].After(times[j]) })
+	return times, nil
+}
+
+// rotate deletes the backups that retainedBackups does not keep, and returns the number kept.
+func (sb *scheduledBackups) rotate() (int, error) {
+	times, err := sb.backups()
+	if err != nil {
+		return 0, err
+	}
+	keep := retainedBackups(times, sb.cfg.KeepDaily, sb.cfg.KeepWeekly, sb.cfg.KeepMonthly)
+	var problems []string
+	for _, t := range times {
+		if keep[t] {
+			continue
+		}
+		path := filepath.Join(sb.cfg.Dir, backupName(t))
+		if err := os.Remove(path); err != nil {
+			problems = append(problems, err.Error())
+			continue
+		}
+		log.Printf("Rotated out backup %s.", path)
+	}
+	if len(problems) > 0 {
+		return len(keep), fmt.Errorf("%s", strings.Join(problems, "; "))
+	}
+	return len(keep), nil
+}
+
+// retainedBackups decides which backups grandfather-father-son rotation keeps, given their times newest first: the
+// newest backup of each of the daily newest days, of each of the weekly newest ISO weeks, and of each of the monthly
+// newest months, in local time. The newest backup is always kept.
+func retainedBackups(times []time.Time, daily, weekly, monthly int) map[time.Time]bool {
+	keep := make(map[time.Time]bool)
+	if len(times) > 0 {
+		keep[times[0]] = true
+	}
+	for _, period := range []struct {
+		count  int
+		bucket func(time.Time) string
+	}{
+		{daily, func(t time.Time) string { return t.Format(time.DateOnly) }},
+		{weekly, func(t time.Time) string { year, week := t.ISOWeek(); return fmt.Sprintf("%d-W%02d", year, week) }},
+		{monthly, func(t time.Time) string { return t.Format("2006-01") }},
+	} {
+		var buckets []string
+		for _, t := range times {
+			if len(buckets) >= period.count {
+				break
+			}
+			bucket := period.bucket(t.Local())
+			if slices.Contains(buckets, bucket) {
+				continue
+			}
+			buckets = append(buckets, bucket)
+			keep[t] = true
+		}
+	}
+	return keep
+}
+
+// current returns the status, judging whether the last successful backup is too old.
+func (sb *scheduledBackups) current() backupStatus {
+	sb.mu.Lock()
+	status := sb.status
+	sb.mu.Unlock()
+	switch {
+	case !status.Enabled:
+	case status.ConsecutiveFailures > 0:
+		status.Healthy, status.Problem = false, "the last backup failed"
+	case sb.cfg.MaxAge > 0 && !status.LastSuccess.IsZero() && time.Since(status.LastSuccess) > sb.cfg.MaxAge.Std():
+		status.Healthy, status.Problem = false, fmt.Sprintf("the last successful backup is older than %s", sb.cfg.MaxAge.Std())
+	}
+	return status
+}
+
+// healthHandler serves GET /health, which reports whether the server is healthy, currently meaning whether the
+// scheduled backups are: {"status": "ok", "backup": {...}} with the backupStatus. The status is "failing", with
+// 503 Service Unavailable, when the last backup failed or the last successful one is too old, so that a load
+// balancer or uptime monitor notices. The handler returns 405 for methods other than GET.
+func (sb *scheduledBackups) healthHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	status := sb.current()
+	response := struct {
+		Status string       `json:"status"`
+		Backup backupStatus `json:"backup"`
+	}{"ok", status}
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Type", "application/json")
+	if !status.Healthy {
+		response.Status = "failing"
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(response)
+}
+
+// metricsHandler serves GET /metrics, which reports the scheduled backups in the Prometheus text format, so that
+// failed and missing backups can be alerted on. The handler returns 405 for methods other than GET.
+func (sb *scheduledBackups) metricsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	status := sb.current()
+	seconds := func(t time.Time) float64 {
+		if t.IsZero() {
+			return 0
+		}
+		return float64(t.UnixMilli()) / 1000
+	}
+	healthy := 0
+	if status.Healthy {
+		healthy = 1
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	for _, metric := range []struct {
+		name, kind, help string
+		value            any
+	}{
+		{"pasword_mango_backup_healthy", "gauge", "Whether the scheduled backups are healthy.", healthy},
+		{"pasword_mango_backup_last_attempt_timestamp_seconds", "gauge", "When the last scheduled backup started.", seconds(status.LastAttempt)},
+		{"pasword_mango_backup_last_success_timestamp_seconds", "gauge", "When the last successful backup was taken.", seconds(status.LastSuccess)},
+		{"pasword_mango_backup_last_size_bytes", "gauge", "Size of the last successful backup.", status.LastSize},
+		{"pasword_mango_backup_last_items", "gauge", "Credentials in the last successful backup.", status.LastItems},
+		{"pasword_mango_backup_consecutive_failures", "gauge", "Scheduled backups failed since the last success.", status.ConsecutiveFailures},
+		{"pasword_mango_backup_successes_total", "counter", "Scheduled backups that succeeded since the server started.", status.Successes},
+		{"pasword_mango_backup_failures_total", "counter", "Scheduled backups that failed since the server started.", status.Failures},
+		{"pasword_mango_backup_kept", "gauge", "Backups kept in the backup directory.", status.Kept},
+	} {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s %v\n", metric.name, metric.help, metric.name, metric.kind, metric.name, metric.value)
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	gcfirestore "cloud.google.com/go/firestore"
+
+	"github.com/rihts-4/pasword-mango/config"
+)
+
+// TestScheduledBackupRotatesPartialBackup checks that a backup that leaves out a site that cannot be decrypted, which
+// fails, is still rotated, so that such a site does not make the backups pile up.
+func TestScheduledBackupRotatesPartialBackup(t *testing.T) {
+	firestore.Reset()
+	ctx := context.Background()
+	raw, err := gcfirestore.NewClient(ctx, "pasword-mango-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer raw.Close()
+	if _, err := raw.Collection("credentials").Doc("broken").Set(ctx, map[string]any{"username": "alice", "password": "not encrypted"}); err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	passphraseFile := filepath.Join(t.TempDir(), "passphrase")
+	if err := os.WriteFile(passphraseFile, []byte("correct horse battery staple\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	for _, age := range []time.Duration{48 * time.Hour, 72 * time.Hour} {
+		if err := os.WriteFile(filepath.Join(dir, backupName(time.Now().Add(-age))), nil, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	sb, err := newScheduledBackups(config.Backup{Dir: dir, Schedule: "@daily", PassphraseFile: passphraseFile, KeepDaily: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sb.run(ctx)
+	status := sb.current()
+	if status.Failures != 1 || !strings.Contains(status.LastError, "broken") || status.Kept != 1 {
+		t.Errorf("status %+v, want one failure for the broken site and one backup kept", status)
+	}
+	times, err := sb.backups()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(times) != 1 || time.Since(times[0]) > time.Minute {
+		t.Errorf("backups taken at %v, want only the new one", times)
+	}
+}
+
+func TestRetainedBackups(t *testing.T) {
+	at := func(value string) time.Time {
+		t, err := time.ParseInLocation(time.DateTime, value, time.Local)
+		if err != nil {
+			panic(err)
+		}
+		return t
+	}
+	tests := []struct {
+		name                   string
+		times                  []string
+		daily, weekly, monthly int
+		want                   []string
+	}{
+		{
+			name:  "newest of each day",
+			times: []string{"2025-03-12 03:30:00", "2025-03-12 01:00:00", "2025-03-11 23:59:59", "2025-03-11 00:00:00", "2025-03-10 03:30:00"},
+			daily: 2,
+			want:  []string{"2025-03-12 03:30:00", "2025-03-11 23:59:59"},
+		},
+		{
+			// 2024-12-30 is the Monday that starts ISO week 1 of 2025, although the year has not changed yet
+			name:   "ISO weeks",
+			times:  []string{"2025-01-01 03:30:00", "2024-12-30 03:30:00", "2024-12-29 03:30:00", "2024-12-23 03:30:00", "2024-12-22 03:30:00"},
+			weekly: 3,
+			want:   []string{"2025-01-01 03:30:00", "2024-12-29 03:30:00", "2024-12-22 03:30:00"},
+		},
+		{
+			name:    "months",
+			times:   []string{"2025-03-01 00:00:00", "2025-02-28 23:59:59", "2025-02-01 00:00:00", "2025-01-31 23:59:59", "2024-12-31 03:30:00"},
+			monthly: 2,
+			want:    []string{"2025-03-01 00:00:00", "2025-02-28 23:59:59"},
+		},
+		{
+			name:  "periods combined",
+			times: []string{"2025-03-12 03:30:00", "2025-03-11 03:30:00", "2025-03-10 03:30:00", "2025-03-09 03:30:00", "2025-02-27 03:30:00", "2025-01-15 03:30:00"},
+			daily: 2, weekly: 2, monthly: 3,
+			want: []string{"2025-03-12 03:30:00", "2025-03-11 03:30:00", "2025-03-09 03:30:00", "2025-02-27 03:30:00", "2025-01-15 03:30:00"},
+		},
+		{
+			name:  "keep counts of 0 keep the newest",
+			times: []string{"2025-03-12 03:30:00", "2025-03-11 03:30:00", "2025-02-11 03:30:00"},
+			want:  []string{"2025-03-12 03:30:00"},
+		},
+		{
+			name:  "more kept than there are",
+			times: []string{"2025-03-12 03:30:00", "2025-03-11 03:30:00"},
+			daily: 7, weekly: 4, monthly: 12,
+			want: []string{"2025-03-12 03:30:00", "2025-03-11 03:30:00"},
+		},
+		{
+			name:  "no backups",
+			daily: 7,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var times []time.Time
+			for _, value := range tt.times {
+				times = append(times, at(value))
+			}
+			keep := retainedBackups(times, tt.daily, tt.weekly, tt.monthly)
+			var got []string
+			for _, t := range times {
+				if keep[t] {
+					got = append(got, t.Format(time.DateTime))
+				}
+			}
+			if !slices.Equal(got, tt.want) || len(keep) != len(tt.want) {
+				t.Errorf("kept %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"text/tabwriter"
+
+	"github.com/rihts-4/pasword-mango/config"
+	"github.com/rihts-4/pasword-mango/data"
+	"github.com/rihts-4/pasword-mango/pwned"
+)
+
+// openBreachFile opens the configured Pwned Passwords file and makes the data package check passwords against it.
+// It returns a function that closes the file; without a configured file it does nothing.
+func openBreachFile(cfg config.Passwords) (func(), error) {
+	if cfg.BreachFile == "" {
+		return func() {}, nil
+	}
+	f, err := pwned.Open(cfg.BreachFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open Pwned Passwords file: %w", err)
+	}
+	data.SetBreachFile(f)
+	log.Printf("Checking passwords against %s (%s hashes).", cfg.BreachFile, f.Format())
+	return func() {
+		data.SetBreachFile(nil)
+		f.Close()
+	}, nil
+}
+
+// breachesHandler serves GET /admin/breaches, which decrypts every stored password, checks it against the
+// configured Pwned Passwords file, and reports the credentials whose passwords appear in known breaches as a
+// data.BreachReport. No password is included in the report.
+//
+// The handler returns 404 when no Pwned Passwords file is configured, 500 for data errors, and 405 for methods
+// other than GET.
+func breachesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	recordAudit(r, data.AuditScan, "breaches")
+	report, err := data.ScanBreaches(r.Context())
+	if errors.Is(err, data.ErrNoBreachFile) {
+		http.Error(w, "No Pwned Passwords file is configured. Set PWNED_PASSWORDS_FILE to enable breach checks.", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to scan credentials", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
+
+// breachesCommand implements `breaches`, which scans the vault for breached passwords without starting the server
+// and prints the affected sites. It returns 1 if any password is breached or could not be checked, so that it can
+// run from cron or CI.
+func breachesCommand(ctx context.Context, cfg config.Config, args []string) int {
+	flags := flag.NewFlagSet("breaches", flag.ContinueOnError)
+	asJSON := flags.Bool("json", false, "print the report as JSON")
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: pasword-mango [flags] breaches [-json]")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil || flags.NArg() != 0 {
+		return 2
+	}
+	if cfg.Passwords.BreachFile == "" {
+		log.Print("No Pwned Passwords file is configured. Set PWNED_PASSWORDS_FILE or passwords.breachFile.")
+		return 2
+	}
+
+	if err := data.InitDB(ctx, cfg.Storage, cfg.Key); err != nil {
+		log.Printf("Failed to initialize database: %v", err)
+		return 1
+	}
+	defer data.CloseDB()
+	closeBreachFile, err := openBreachFile(cfg.Passwords)
+	if err != nil {
+		log.Print(err)
+		return 1
+	}
+	defer closeBreachFile()
+
+	report, err := data.ScanBreaches(ctx)
+	if err != nil {
+		log.Printf("Failed to scan credentials: %v", err)
+		return 1
+	}
+	if *asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		encoder.Encode(report)
+	} else {
+		if len(report.Breached) > 0 {
+			tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+			fmt.Fprintln(tw, "SITE\tUSERNAME\tBREACHES")
+			for _, c := range report.Breached {
+				fmt.Fprintf(tw, "%s\t%s\t%d\n", c.Site, c.Username, c.Count)
+			}
+			tw.Flush()
+		}
+		fmt.Printf("Checked %d passwords: %d breached, %d could not be checked.\n", report.Checked, len(report.Breached), len(report.Failed))
+		for _, site := range report.Failed {
+			fmt.Printf("Could not check %s\n", site)
+		}
+	}
+	if len(report.Breached) > 0 || len(report.Failed) > 0 {
+		return 1
+	}
+	return 0
+}
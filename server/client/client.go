@@ -0,0 +1,585 @@
+// Package client is a Go client for the Pasword Mango REST API. It is what the mango command-line client is built
+// on, and can be imported by other Go programs that manage credentials.
+//
+//	c, err := client.New(client.Config{BaseURL: "http://localhost:8080"})
+//	if err != nil { ... }
+//	creds, err := c.Get(ctx, "github.com")
+//	if errors.Is(err, client.ErrNotFound) { ... }
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rihts-4/pasword-mango/generate"
+	"github.com/rihts-4/pasword-mango/share"
+	"github.com/rihts-4/pasword-mango/strength"
+)
+
+// DefaultBaseURL is the address the server listens on by default.
+const DefaultBaseURL = "http://localhost:8080"
+
+// Defaults applied to zero Config fields.
+const (
+	DefaultTimeout    = 30 * time.Second
+	DefaultMaxRetries = 3
+)
+
+// Retry backoff: the first retry waits retryBaseDelay, doubling each time up to retryMaxDelay. A Retry-After
+// header from the server is honored up to retryMaxDelay as well.
+const (
+	retryBaseDelay = 200 * time.Millisecond
+	retryMaxDelay  = 10 * time.Second
+)
+
+// ErrNotFound is returned when the requested site does not exist (404 Not Found).
+var ErrNotFound = errors.New("credentials not found")
+
+// ErrAlreadyExists is returned when creating credentials for a site that already exists (409 Conflict).
+var ErrAlreadyExists = errors.New("credentials for site already exist")
+
+// ErrWeakPassword is returned when a new password scores below the server's minimum strength (422 Unprocessable Entity).
+var ErrWeakPassword = errors.New("password is too weak")
+
+// Error is returned for every response other than 2xx. It matches ErrNotFound, ErrAlreadyExists, and
+// ErrWeakPassword with errors.Is for the corresponding status codes.
+type Error struct {
+	StatusCode int
+	// Message is the error message sent by the server.
+	Message string
+}
+
+func (e *Error) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("server returned %d %s", e.StatusCode, http.StatusText(e.StatusCode))
+	}
+	return fmt.Sprintf("%s (%d %s)", e.Message, e.StatusCode, http.StatusText(e.StatusCode))
+}
+
+// Is maps status codes onto the package's sentinel errors.
+func (e *Error) Is(target error) bool {
+	switch target {
+	case ErrNotFound:
+		return e.StatusCode == http.StatusNotFound
+	case ErrAlreadyExists:
+		return e.StatusCode == http.StatusConflict
+	case ErrWeakPassword:
+		return e.StatusCode == http.StatusUnprocessableEntity
+	}
+	return false
+}
+
+// Credentials are a site's stored username and password, and its tags.
+type Credentials struct {
+	Site     string   `json:"site"`
+	Username string   `json:"username"`
+	Password string   `json:"password"`
+	Tags     []string `json:"tags,omitempty"`
+}
+
+// Length limits enforced by the server when credentials are created.
+const (
+	MaxSiteLength     = 255
+	MaxUsernameLength = 255
+	MaxPasswordLength = 1000
+)
+
+// Validate applies the checks the server makes before storing credentials, after trimming surrounding whitespace
+// as the server does, so that an interactive client can report problems before sending the request. The messages
+// match the server's.
+func (c Credentials) Validate() error {
+	site, username, password := strings.TrimSpace(c.Site), strings.TrimSpace(c.Username), strings.TrimSpace(c.Password)
+	switch {
+	case site == "":
+		return errors.New("Site is required and cannot be empty")
+	case username == "":
+		return errors.New("Username is required and cannot be empty")
+	case password == "":
+		return errors.New("Password is required and cannot be empty")
+	case len(site) > MaxSiteLength:
+		return fmt.Errorf("Site must not exceed %d characters", MaxSiteLength)
+	case len(username) > MaxUsernameLength:
+		return fmt.Errorf("Username must not exceed %d characters", MaxUsernameLength)
+	case len(password) > MaxPasswordLength:
+		return fmt.Errorf("Password must not exceed %d characters", MaxPasswordLength)
+	}
+	return nil
+}
+
+// Config configures a Client. Only BaseURL is commonly needed.
+type Config struct {
+	// BaseURL is the server's address, such as "https://localhost:8080", or "unix:///path/to/socket" for a Unix
+	// domain socket listener. Defaults to DefaultBaseURL.
+	BaseURL string
+	// Token, if set, is sent as a bearer token in the Authorization header.
+	Token string
+
+	// CAFile is a PEM file of certificates to trust in addition to the system roots, such as the server's
+	// self-signed certificate.
+	CAFile string
+	// CertFile and KeyFile are the client certificate and private key presented in mutual TLS mode.
+	CertFile string
+	KeyFile  string
+	// TLSConfig, if set, is used as the base TLS configuration; CAFile and CertFile are added to a copy of it.
+	TLSConfig *tls.Config
+
+	// HTTPClient, if set, is used instead of a client built from the settings above, which are then ignored
+	// except for Token.
+	HTTPClient *http.Client
+	// Timeout bounds each attempt of a request. Defaults to DefaultTimeout.
+	Timeout time.Duration
+	// MaxRetries is how many times idempotent requests (List, Get, Update, Delete) are retried after a network error,
+	// 429 Too Many Requests, or a 502, 503, or 504 response. Create is never retried. Defaults to DefaultMaxRetries;
+	// a negative value disables retries.
+	MaxRetries int
+}
+
+// Client calls the Pasword Mango API. It is safe for concurrent use.
+type Client struct {
+	http       *http.Client
+	baseURL    string
+	token      string
+	maxRetries int
+}
+
+// New returns a client configured by cfg. It returns an error if the base URL is invalid or a certificate file
+// cannot be loaded.
+func New(cfg Config) (*Client, error) {
+	c := &Client{
+		http:       cfg.HTTPClient,
+		baseURL:    strings.TrimRight(cfg.BaseURL, "/"),
+		token:      cfg.Token,
+		maxRetries: cfg.MaxRetries,
+	}
+	if c.baseURL == "" {
+		c.baseURL = DefaultBaseURL
+	}
+	switch {
+	case cfg.MaxRetries == 0:
+		c.maxRetries = DefaultMaxRetries
+	case cfg.MaxRetries < 0:
+		c.maxRetries = 0
+	}
+
+	var socket string
+	if path, ok := strings.CutPrefix(c.baseURL, "unix://"); ok {
+		socket = path
+		c.baseURL = "http://localhost"
+	} else if u, err := url.Parse(c.baseURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid base URL %q", cfg.BaseURL)
+	}
+	if c.http != nil {
+		return c, nil
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if socket != "" {
+		transport.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", socket)
+		}
+	}
+	tlsConfig, err := buildTLSConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	transport.TLSClientConfig = tlsConfig
+
+	timeout := cfg.Timeout
+	if timeout == 0 {
+		timeout = DefaultTimeout
+	}
+	c.http = &http.Client{Transport: transport, Timeout: timeout}
+	return c, nil
+}
+
+// buildTLSConfig adds the CA and client certificate files to the base TLS configuration.
+func buildTLSConfig(cfg Config) (*tls.Config, error) {
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if cfg.TLSConfig != nil {
+		tlsConfig = cfg.TLSConfig.Clone()
+	}
+	if cfg.CAFile != "" {
+		pem, err := os.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("error reading CA file: %v", err)
+		}
+		pool := tlsConfig.RootCAs
+		if pool == nil {
+			if pool, err = x509.SystemCertPool(); err != nil {
+				pool = x509.NewCertPool()
+			}
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", cfg.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if cfg.CertFile != "" || cfg.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("error loading client certificate: %v", err)
+		}
+		tlsConfig.Certificates = append(tlsConfig.Certificates, cert)
+	}
+	return tlsConfig, nil
+}
+
+// List returns the names of all stored sites.
+func (c *Client) List(ctx context.Context) ([]string, error) {
+	var sites []string
+	err := c.do(ctx, http.MethodGet, "/credentials", nil, &sites)
+	return sites, err
+}
+
+// Get returns the credentials for a site, including the decrypted password. The server also matches the site
+// with or without a ".com" suffix, so the returned Site, the name the credentials are stored under, may differ from
+// the one asked for.
+func (c *Client) Get(ctx context.Context, site string) (Credentials, error) {
+	var creds Credentials
+	if err := c.do(ctx, http.MethodGet, sitePath(site), nil, &creds); err != nil {
+		return Credentials{}, err
+	}
+	if creds.Site == "" {
+		// Servers before the site was returned
+		creds.Site = site
+	}
+	return creds, nil
+}
+
+// Create stores credentials for a new site. It returns ErrAlreadyExists if the site already has credentials.
+func (c *Client) Create(ctx context.Context, creds Credentials) error {
+	return c.do(ctx, http.MethodPost, "/credentials", creds, nil)
+}
+
+// CreateGenerated stores credentials for a new site with a password the server generates as described by req,
+// and returns them including the generated password.
+func (c *Client) CreateGenerated(ctx context.Context, site, username string, req generate.Request) (Credentials, error) {
+	body := struct {
+		Site     string           `json:"site"`
+		Username string           `json:"username"`
+		Generate generate.Request `json:"generate"`
+	}{site, username, req}
+	var creds Credentials
+	err := c.do(ctx, http.MethodPost, "/credentials", body, &creds)
+	return creds, err
+}
+
+// Generate asks the server for a random password or passphrase described by req. Nothing is stored.
+// Programs that import this package can also call req.Generate directly.
+func (c *Client) Generate(ctx context.Context, req generate.Request) (generate.Result, error) {
+	var result generate.Result
+	err := c.do(ctx, http.MethodPost, "/generate", req, &result)
+	return result, err
+}
+
+// Strength is the server's strength estimate of a password.
+type Strength struct {
+	strength.Result
+	// MinScore is the least score the server accepts for new passwords.
+	MinScore int `json:"minScore"`
+	// Acceptable reports whether the password meets MinScore.
+	Acceptable bool `json:"acceptable"`
+}
+
+// Strength asks the server to estimate the strength of a password, which should not be built from userInputs such
+// as the site and username. Nothing is stored. Programs that import this package can also call strength.Estimate
+// directly, but only the server knows its minimum score.
+func (c *Client) Strength(ctx context.Context, password string, userInputs ...string) (Strength, error) {
+	body := struct {
+		Password   string   `json:"password"`
+		UserInputs []string `json:"userInputs,omitempty"`
+	}{password, userInputs}
+	var result Strength
+	err := c.do(ctx, http.MethodPost, "/strength", body, &result)
+	return result, err
+}
+
+// Update replaces the username and password of an existing site, named by creds.Site.
+func (c *Client) Update(ctx context.Context, creds Credentials) error {
+	body := struct {
+		Username string `json:"username"`
+		Password string `json:"password"`
+	}{creds.Username, creds.Password}
+	return c.do(ctx, http.MethodPut, sitePath(creds.Site), body, nil)
+}
+
+// ImportOptions control an import. The zero value imports a CSV or unencrypted Bitwarden export, skipping sites
+// that already exist.
+type ImportOptions struct {
+	// Format is the export's format: "csv" for Chrome, Edge, Firefox, and Safari exports, "bitwarden" for
+	// Bitwarden's JSON export, or "kdbx" for a KeePass database. The server detects it if it is empty.
+	Format string `json:"format,omitempty"`
+	// Password decrypts a password-protected Bitwarden export or a KeePass database.
+	Password string `json:"password,omitempty"`
+	// Conflict is what happens to entries for sites that already exist: "skip" (the default), "overwrite", or
+	// "keep-both", which stores them as "username@site".
+	Conflict string `json:"conflict,omitempty"`
+	// DryRun reports what would happen without storing anything.
+	DryRun bool `json:"dryRun,omitempty"`
+	// Tags are added to every imported entry.
+	Tags []string `json:"tags,omitempty"`
+}
+
+// ImportRow is the outcome of one row of an export.
+type ImportRow struct {
+	Row      int    `json:"row"`
+	Site     string `json:"site,omitempty"`
+	Username string `json:"username,omitempty"`
+	// Action is "created", "updated", "skipped", or "failed".
+	Action string `json:"action"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// ImportReport summarizes an import.
+type ImportReport struct {
+	Format   string      `json:"format"`
+	Conflict string      `json:"conflict"`
+	DryRun   bool        `json:"dryRun"`
+	Created  int         `json:"created"`
+	Updated  int         `json:"updated"`
+	Skipped  int         `json:"skipped"`
+	Failed   int         `json:"failed"`
+	Rows     []ImportRow `json:"rows"`
+}
+
+// kdbxSignature starts every KeePass database.
+var kdbxSignature = []byte{0x03, 0xD9, 0xA2, 0x9A}
+
+// Import stores the logins of an export, such as a browser's password CSV, and reports the outcome of every row.
+// KeePass databases are recognized and sent base64-encoded, as the server expects them. Like Create, it is never
+// retried.
+func (c *Client) Import(ctx context.Context, export []byte, opts ImportOptions) (ImportReport, error) {
+	data := string(export)
+	if strings.EqualFold(opts.Format, "kdbx") || bytes.HasPrefix(export, kdbxSignature) {
+		opts.Format, data = "kdbx", base64.StdEncoding.EncodeToString(export)
+	}
+	body := struct {
+		ImportOptions
+		Data string `json:"data"`
+	}{opts, data}
+	var report ImportReport
+	err := c.do(ctx, http.MethodPost, "/import", body, &report)
+	return report, err
+}
+
+// ExportOptions control an export. The zero value exports the whole vault as an unencrypted Bitwarden export.
+type ExportOptions struct {
+	// Format is the export's format: "bitwarden", the default, or "kdbx" for a KeePass database.
+	Format string `json:"format,omitempty"`
+	// Password makes the export password protected. A KeePass database requires one.
+	Password string `json:"password,omitempty"`
+	// Sites and Tags select the credentials stored under one of the sites or with one of the tags; Query narrows the
+	// selection to credentials whose site, username, or notes contain it. Without any of them, every credential is
+	// exported.
+	Sites []string `json:"sites,omitempty"`
+	Tags  []string `json:"tags,omitempty"`
+	Query string   `json:"query,omitempty"`
+	// Recipients, age X25519 or SSH public keys, make the server encrypt the export for them with age. Armor makes
+	// the age file ASCII-armored.
+	Recipients []string `json:"recipients,omitempty"`
+	Armor      bool     `json:"armor,omitempty"`
+}
+
+// Export returns the vault, or the part of it the options select, including every password, as another password
+// manager's export, together with the number of sites the server could not decrypt and left out. It returns
+// ErrNotFound if a selected site does not exist or nothing matches the selection. Like Create, it is never retried.
+func (c *Client) Export(ctx context.Context, opts ExportOptions) ([]byte, int, error) {
+	var export []byte
+	header, err := c.doHeader(ctx, http.MethodPost, "/export", opts, &export)
+	if err != nil {
+		return nil, 0, err
+	}
+	failed, _ := strconv.Atoi(header.Get("X-Export-Failed"))
+	return export, failed, nil
+}
+
+// ShareOptions describe a one-time share: either the credentials of Site or free Text.
+type ShareOptions struct {
+	Site string
+	Text string
+	// MaxViews is how often the share can be opened before it is deleted; zero means once.
+	MaxViews int
+	// TTL is how long the share lives; zero means the server's default.
+	TTL time.Duration
+}
+
+// Share is a one-time share created by CreateShare. URL is its link, with the key that decrypts it in the fragment;
+// the server does not keep the key, so the link cannot be recovered.
+type Share struct {
+	ID        string    `json:"id"`
+	URL       string    `json:"url"`
+	MaxViews  int       `json:"maxViews"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// CreateShare creates a one-time share link. It returns ErrNotFound if the site does not exist. Like Create, it is
+// never retried.
+func (c *Client) CreateShare(ctx context.Context, opts ShareOptions) (Share, error) {
+	body := struct {
+		Site     string `json:"site,omitempty"`
+		Text     string `json:"text,omitempty"`
+		MaxViews int    `json:"maxViews,omitempty"`
+		TTL      string `json:"ttl,omitempty"`
+	}{Site: opts.Site, Text: opts.Text, MaxViews: opts.MaxViews}
+	if opts.TTL != 0 {
+		body.TTL = opts.TTL.String()
+	}
+	var created Share
+	err := c.do(ctx, http.MethodPost, "/shares", body, &created)
+	return created, err
+}
+
+// DeleteShare deletes a share before it is spent. It returns ErrNotFound if the share does not exist, has expired,
+// or has been spent.
+func (c *Client) DeleteShare(ctx context.Context, id string) error {
+	return c.do(ctx, http.MethodDelete, "/shares/"+url.PathEscape(id), nil, nil)
+}
+
+// OpenedShare is a share opened by OpenShare.
+type OpenedShare struct {
+	share.Content
+	Views     int
+	MaxViews  int
+	ExpiresAt time.Time
+}
+
+// OpenShare opens the share with the given ID and decrypts it with key, which counts as one of its views. The client
+// needs no access to the vault, but its base URL must be the one in the share's link (see share.ParseLink). It
+// returns ErrNotFound if the share does not exist, has expired, or has been spent. Like Create, it is never retried.
+func (c *Client) OpenShare(ctx context.Context, id string, key []byte) (OpenedShare, error) {
+	var opened struct {
+		Ciphertext []byte    `json:"ciphertext"`
+		Views      int       `json:"views"`
+		MaxViews   int       `json:"maxViews"`
+		ExpiresAt  time.Time `json:"expiresAt"`
+	}
+	if err := c.do(ctx, http.MethodPost, share.PagePath+url.PathEscape(id), nil, &opened); err != nil {
+		return OpenedShare{}, err
+	}
+	content, err := share.Open(key, id, opened.Ciphertext)
+	if err != nil {
+		return OpenedShare{}, err
+	}
+	return OpenedShare{Content: content, Views: opened.Views, MaxViews: opened.MaxViews, ExpiresAt: opened.ExpiresAt}, nil
+}
+
+// Delete removes the credentials for a site. It returns ErrNotFound if the site does not exist, which can also
+// happen when a retry follows a deletion whose response was lost.
+func (c *Client) Delete(ctx context.Context, site string) error {
+	return c.do(ctx, http.MethodDelete, sitePath(site), nil, nil)
+}
+
+// sitePath returns the API path of a site's credentials.
+func sitePath(site string) string {
+	return "/credentials/" + url.PathEscape(site)
+}
+
+// do sends a request with an optional JSON body and decodes a JSON response into out, if given, retrying
+// idempotent requests after transient failures. If out is a *[]byte, it receives the response body as is.
+func (c *Client) do(ctx context.Context, method, path string, body, out any) error {
+	_, err := c.doHeader(ctx, method, path, body, out)
+	return err
+}
+
+// doHeader is do, also returning the headers of a successful response.
+func (c *Client) doHeader(ctx context.Context, method, path string, body, out any) (http.Header, error) {
+	var encoded []byte
+	if body != nil {
+		var err error
+		if encoded, err = json.Marshal(body); err != nil {
+			return nil, err
+		}
+	}
+
+	retries := c.maxRetries
+	if method == http.MethodPost {
+		retries = 0
+	}
+	for attempt := 0; ; attempt++ {
+		delay, header, err := c.attempt(ctx, method, path, encoded, out)
+		if delay < 0 || attempt >= retries {
+			return header, err
+		}
+		if delay == 0 {
+			delay = min(retryBaseDelay<<attempt, retryMaxDelay)
+		}
+		select {
+		case <-ctx.Done():
+			return nil, err
+		case <-time.After(delay):
+		}
+	}
+}
+
+// attempt sends a request once. It returns a negative delay if the outcome is final, or the delay the server asked
+// for before a retry, which is zero if it did not say, and the response headers if it succeeded.
+func (c *Client) attempt(ctx context.Context, method, path string, body []byte, out any) (time.Duration, http.Header, error) {
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, bytes.NewReader(body))
+	if err != nil {
+		return -1, nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.Header.Set("Accept", "application/json")
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return -1, nil, err
+		}
+		return 0, nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		apiErr := &Error{StatusCode: resp.StatusCode, Message: strings.TrimSpace(string(message))}
+		switch resp.StatusCode {
+		case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+			return retryAfter(resp.Header.Get("Retry-After")), nil, apiErr
+		}
+		return -1, nil, apiErr
+	}
+	if out == nil {
+		return -1, resp.Header, nil
+	}
+	if raw, ok := out.(*[]byte); ok {
+		if *raw, err = io.ReadAll(resp.Body); err != nil {
+			return -1, nil, fmt.Errorf("invalid response from server: %v", err)
+		}
+		return -1, resp.Header, nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return -1, nil, fmt.Errorf("invalid response from server: %v", err)
+	}
+	return -1, resp.Header, nil
+}
+
+// retryAfter parses a Retry-After header in seconds, capped at retryMaxDelay. It returns zero if the header is absent
+// or not in seconds.
+func retryAfter(header string) time.Duration {
+	seconds, err := strconv.Atoi(header)
+	if err != nil || seconds < 0 {
+		return 0
+	}
+	return min(time.Duration(seconds)*time.Second, retryMaxDelay)
+}
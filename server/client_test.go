@@ -0,0 +1,219 @@
+package main
+
+// Tests of the client package against the real credentials handlers, on an in-memory Firestore. They live here
+// rather than in the client package because the handlers belong to the server.
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"slices"
+	"sync"
+	"testing"
+
+	"github.com/rihts-4/pasword-mango/client"
+	"github.com/rihts-4/pasword-mango/config"
+	"github.com/rihts-4/pasword-mango/data"
+	"github.com/rihts-4/pasword-mango/firestoretest"
+)
+
+// firestore is the in-memory Firestore the data package is connected to for the tests.
+var firestore *firestoretest.Server
+
+func TestMain(m *testing.M) {
+	var err error
+	if firestore, err = firestoretest.NewServer(); err != nil {
+		log.Fatal(err)
+	}
+	key := make([]byte, 32)
+	rand.Read(key)
+	storage := config.Storage{Backend: "firestore", ProjectID: "pasword-mango-test", EmulatorHost: firestore.Addr}
+	if err := data.OpenDB(context.Background(), storage, key); err != nil {
+		log.Fatal(err)
+	}
+	code := m.Run()
+	data.CloseDB()
+	firestore.Close()
+	os.Exit(code)
+}
+
+// newTestClient serves the credentials endpoints with the given minimum password score on an empty vault, with
+// wrap applied to them if it is not nil, and returns a client for them.
+func newTestClient(t *testing.T, minScore int, wrap func(http.Handler) http.Handler) *client.Client {
+	t.Helper()
+	firestore.Reset()
+	api := newCredentialsAPI(config.Passwords{MinScore: minScore})
+	var handler http.Handler = http.HandlerFunc(api.credentialsHandler)
+	if wrap != nil {
+		handler = wrap(handler)
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/credentials", handler)
+	mux.Handle("/credentials/", handler)
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	c, err := client.New(client.Config{BaseURL: server.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestClientRoundTrip(t *testing.T) {
+	c := newTestClient(t, 0, nil)
+	ctx := context.Background()
+
+	created := client.Credentials{Site: "example.com", Username: "alice", Password: "correct horse battery staple"}
+	if err := c.Create(ctx, created); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	got, err := c.Get(ctx, "example.com")
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if got.Site != created.Site || got.Username != created.Username || got.Password != created.Password {
+		t.Errorf("Get returned %+v, want %+v", got, created)
+	}
+	// The lookup finds the site without ".com" and reports the name it is stored under
+	if got, err := c.Get(ctx, "example"); err != nil || got.Site != "example.com" {
+		t.Errorf(`Get("example") returned %+v, %v; want the site example.com`, got, err)
+	}
+
+	updated := client.Credentials{Site: "example.com", Username: "bob", Password: "another long passphrase here"}
+	if err := c.Update(ctx, updated); err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+	if got, err := c.Get(ctx, "example.com"); err != nil || got.Username != updated.Username || got.Password != updated.Password {
+		t.Errorf("Get after Update returned %+v, %v; want %+v", got, err, updated)
+	}
+
+	sites, err := c.List(ctx)
+	if err != nil || !slices.Equal(sites, []string{"example.com"}) {
+		t.Errorf("List returned %v, %v; want [example.com]", sites, err)
+	}
+
+	if err := c.Delete(ctx, "example.com"); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if _, err := c.Get(ctx, "example.com"); !errors.Is(err, client.ErrNotFound) {
+		t.Errorf("Get after Delete returned %v, want ErrNotFound", err)
+	}
+}
+
+func TestClientErrors(t *testing.T) {
+	c := newTestClient(t, 3, nil)
+	ctx := context.Background()
+	strong := client.Credentials{Site: "example.com", Username: "alice", Password: "correct horse battery staple"}
+	if err := c.Create(ctx, strong); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		call   func() error
+		want   error
+		status int
+	}{
+		{"Get missing", func() error { _, err := c.Get(ctx, "missing.org"); return err }, client.ErrNotFound, http.StatusNotFound},
+		{"Update missing", func() error {
+			return c.Update(ctx, client.Credentials{Site: "missing.org", Username: "alice", Password: strong.Password})
+		}, client.ErrNotFound, http.StatusNotFound},
+		{"Delete missing", func() error { return c.Delete(ctx, "missing.org") }, client.ErrNotFound, http.StatusNotFound},
+		{"Create existing", func() error { return c.Create(ctx, strong) }, client.ErrAlreadyExists, http.StatusConflict},
+		{"Create existing without .com", func() error {
+			return c.Create(ctx, client.Credentials{Site: "example", Username: "bob", Password: strong.Password})
+		}, client.ErrAlreadyExists, http.StatusConflict},
+		{"Create weak", func() error {
+			return c.Create(ctx, client.Credentials{Site: "weak.org", Username: "alice", Password: "password1"})
+		}, client.ErrWeakPassword, http.StatusUnprocessableEntity},
+		{"Update weak", func() error {
+			return c.Update(ctx, client.Credentials{Site: "example.com", Username: "alice", Password: "password1"})
+		}, client.ErrWeakPassword, http.StatusUnprocessableEntity},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.call()
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("got %v, want %v", err, tt.want)
+			}
+			var apiErr *client.Error
+			if !errors.As(err, &apiErr) || apiErr.StatusCode != tt.status {
+				t.Errorf("got %#v, want a *client.Error with status %d", err, tt.status)
+			}
+		})
+	}
+}
+
+// flaky fails the first failures requests of each method with 503 Service Unavailable and counts the requests.
+type flaky struct {
+	failures int
+	mu       sync.Mutex
+	requests map[string]int
+}
+
+func (f *flaky) wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		f.mu.Lock()
+		f.requests[r.Method]++
+		n := f.requests[r.Method]
+		f.mu.Unlock()
+		if n <= f.failures {
+			http.Error(w, "Service unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (f *flaky) count(method string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.requests[method]
+}
+
+func TestClientRetries(t *testing.T) {
+	f := &flaky{failures: 2, requests: make(map[string]int)}
+	c := newTestClient(t, 0, f.wrap)
+	ctx := context.Background()
+	creds := client.Credentials{Site: "example.com", Username: "alice", Password: "correct horse battery staple"}
+
+	// Create is a POST, which is never retried, so the first 503 is final
+	var apiErr *client.Error
+	if err := c.Create(ctx, creds); !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("first Create returned %v, want the 503", err)
+	}
+	if n := f.count(http.MethodPost); n != 1 {
+		t.Fatalf("Create sent %d requests, want 1", n)
+	}
+	if err := c.Create(ctx, creds); !errors.As(err, &apiErr) {
+		t.Fatalf("second Create returned %v, want the 503", err)
+	}
+	if err := c.Create(ctx, creds); err != nil {
+		t.Fatalf("third Create: %v", err)
+	}
+	if n := f.count(http.MethodPost); n != 3 {
+		t.Errorf("three Creates sent %d requests, want 3", n)
+	}
+
+	// Idempotent calls are retried past the failures
+	for _, call := range []struct {
+		method string
+		do     func() error
+	}{
+		{http.MethodGet, func() error { _, err := c.Get(ctx, "example.com"); return err }},
+		{http.MethodPut, func() error { return c.Update(ctx, creds) }},
+		{http.MethodDelete, func() error { return c.Delete(ctx, "example.com") }},
+	} {
+		if err := call.do(); err != nil {
+			t.Errorf("%s failed despite retries: %v", call.method, err)
+		}
+		if n := f.count(call.method); n != f.failures+1 {
+			t.Errorf("%s sent %d requests, want %d", call.method, n, f.failures+1)
+		}
+	}
+}
@@ -0,0 +1,470 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"log"
+	"math/big"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/rihts-4/pasword-mango/config"
+	"github.com/rihts-4/pasword-mango/data"
+)
+
+// Defaults for the client certificate authority used in mutual TLS mode.
+const (
+	caCertName             = "ca.pem"
+	caKeyName              = "ca-key.pem"
+	caCommonName           = "Pasword Mango client CA"
+	caValidity             = 10 * 365 * 24 * time.Hour
+	defaultClientCertDays  = 365
+	maxClientCertDays      = 10 * 365
+	maxClientNameLength    = 64
+	clientCertCacheTTL     = 30 * time.Second
+	clientCertCheckTimeout = 5 * time.Second
+	deviceActorPrefix      = "device:"
+)
+
+// clientAuthority is the small certificate authority that issues client certificates in mutual TLS mode,
+// together with a cache of the issued-certificate registry used to reject unknown and revoked certificates.
+type clientAuthority struct {
+	mu     sync.RWMutex
+	cert   *x509.Certificate
+	key    crypto.Signer
+	cache  map[string]cachedClientCert
+	loaded bool
+}
+
+// cachedClientCert is a registry lookup remembered for clientCertCacheTTL, so that every TLS handshake
+// and request does not hit the database while revocations still take effect promptly.
+type cachedClientCert struct {
+	cert    data.ClientCert
+	err     error
+	fetched time.Time
+}
+
+func newClientAuthority() *clientAuthority {
+	return &clientAuthority{cache: make(map[string]cachedClientCert)}
+}
+
+// load reads the CA certificate and key from dir, generating and persisting them on first use.
+func (ca *clientAuthority) load(dir string) error {
+	certFile, keyFile := filepath.Join(dir, caCertName), filepath.Join(dir, caKeyName)
+	pair, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if errors.Is(err, fs.ErrNotExist) {
+		if err := createClientCA(dir, certFile, keyFile); err != nil {
+			return err
+		}
+		pair, err = tls.LoadX509KeyPair(certFile, keyFile)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to load client CA: %w", err)
+	}
+	key, ok := pair.PrivateKey.(crypto.Signer)
+	if !ok {
+		return fmt.Errorf("client CA key in %s cannot sign certificates", keyFile)
+	}
+
+	ca.mu.Lock()
+	ca.cert, ca.key, ca.loaded = pair.Leaf, key, true
+	ca.mu.Unlock()
+	return nil
+}
+
+// enabled reports whether the CA has been loaded, i.e. whether mutual TLS mode is on.
+func (ca *clientAuthority) enabled() bool {
+	ca.mu.RLock()
+	defer ca.mu.RUnlock()
+	return ca.loaded
+}
+
+// pool returns a certificate pool containing only the CA certificate, for verifying client certificates.
+func (ca *clientAuthority) pool() *x509.CertPool {
+	ca.mu.RLock()
+	defer ca.mu.RUnlock()
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+	return pool
+}
+
+// issue creates a client certificate and private key for the named device, records it in the registry,
+// and returns both as PEM along with the registry record.
+func (ca *clientAuthority) issue(ctx context.Context, name string, validity time.Duration) (certPEM, keyPEM []byte, record data.ClientCert, err error) {
+	ca.mu.RLock()
+	caCert, caKey := ca.cert, ca.key
+	ca.mu.RUnlock()
+	if caCert == nil {
+		return nil, nil, data.ClientCert{}, errors.New("client CA is not loaded")
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, data.ClientCert{}, fmt.Errorf("failed to generate client key: %w", err)
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, nil, data.ClientCert{}, fmt.Errorf("failed to generate certificate serial number: %w", err)
+	}
+
+	now := time.Now().UTC()
+	notAfter := now.Add(validity)
+	if notAfter.After(caCert.NotAfter) {
+		notAfter = caCert.NotAfter
+	}
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{Organization: []string{selfSignedOrgName}, CommonName: name},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     notAfter,
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, caCert, &key.PublicKey, caKey)
+	if err != nil {
+		return nil, nil, data.ClientCert{}, fmt.Errorf("failed to create client certificate: %w", err)
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, nil, data.ClientCert{}, fmt.Errorf("failed to encode client key: %w", err)
+	}
+
+	record = data.ClientCert{
+		Serial:   serialString(serial),
+		Name:     name,
+		IssuedAt: now.Truncate(time.Microsecond),
+		NotAfter: notAfter.Truncate(time.Microsecond),
+	}
+	if err := data.RecordClientCert(ctx, record); err != nil {
+		return nil, nil, data.ClientCert{}, err
+	}
+	certPEM = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM = pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER})
+	return certPEM, keyPEM, record, nil
+}
+
+// check returns the registry record for a presented client certificate, or an error if the certificate
+// was never issued by this server or has been revoked.
+func (ca *clientAuthority) check(ctx context.Context, cert *x509.Certificate) (data.ClientCert, error) {
+	serial := serialString(cert.SerialNumber)
+	now := time.Now()
+
+	ca.mu.RLock()
+	cached, ok := ca.cache[serial]
+	ca.mu.RUnlock()
+	if !ok || now.Sub(cached.fetched) > clientCertCacheTTL {
+		cached.cert, cached.err = data.GetClientCert(ctx, serial)
+		cached.fetched = now
+		// Cache definitive answers only, so a transient database error is retried on the next connection.
+		if cached.err == nil || errors.Is(cached.err, data.ErrClientCertNotFound) {
+			ca.mu.Lock()
+			ca.cache[serial] = cached
+			ca.mu.Unlock()
+		}
+	}
+
+	switch {
+	case cached.err != nil:
+		return data.ClientCert{}, fmt.Errorf("client certificate %s rejected: %w", serial, cached.err)
+	case cached.cert.Revoked:
+		return data.ClientCert{}, fmt.Errorf("client certificate %s (%s) has been revoked", serial, cached.cert.Name)
+	}
+	return cached.cert, nil
+}
+
+// forget drops a serial number from the registry cache so a revocation takes effect immediately.
+func (ca *clientAuthority) forget(serial string) {
+	ca.mu.Lock()
+	delete(ca.cache, serial)
+	ca.mu.Unlock()
+}
+
+// verifyConnection implements tls.Config.VerifyConnection, rejecting handshakes that present a
+// certificate the CA signed but the registry does not accept.
+func (ca *clientAuthority) verifyConnection(cs tls.ConnectionState) error {
+	if len(cs.PeerCertificates) == 0 {
+		return errors.New("client certificate required")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), clientCertCheckTimeout)
+	defer cancel()
+	_, err := ca.check(ctx, cs.PeerCertificates[0])
+	return err
+}
+
+// clientCertMiddleware re-checks the client certificate of every request, so revoking a certificate also
+// cuts off connections established before the revocation, and identifies the request's actor as the
+// certificate's device name. Requests without a client certificate pass through unchanged.
+func clientCertMiddleware(ca *clientAuthority, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+		record, err := ca.check(r.Context(), r.TLS.PeerCertificates[0])
+		if err != nil {
+			log.Printf("Rejecting request from %s: %v", r.RemoteAddr, err)
+			http.Error(w, "Client certificate not accepted", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, withActor(r, deviceActorPrefix+record.Name))
+	})
+}
+
+// clientsHandler serves the admin client certificate API under /admin/clients.
+//
+// It supports the following methods:
+// - POST: issues a certificate from a JSON body with `name` and optional `validDays` (default 365); returns 201 with the certificate and private key as PEM.
+// - GET: lists every issued certificate, including revoked ones.
+// - DELETE: revokes the certificate whose serial number is in the URL path (returns 404 if it was never issued).
+//
+// The handler returns 404 when mutual TLS is not enabled, 400 for malformed requests, 500 for internal errors, and 405 for unsupported methods.
+func (ca *clientAuthority) clientsHandler(w http.ResponseWriter, r *http.Request) {
+	if !ca.enabled() {
+		http.Error(w, "Mutual TLS is not enabled", http.StatusNotFound)
+		return
+	}
+	serial := strings.Trim(strings.TrimPrefix(r.URL.Path, "/admin/clients"), "/")
+	ctx := r.Context()
+
+	switch r.Method {
+	case http.MethodPost:
+		var payload struct {
+			Name      string `json:"name"`
+			ValidDays int    `json:"validDays"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		name, validity, err := validateClientRequest(payload.Name, payload.ValidDays)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		certPEM, keyPEM, record, err := ca.issue(ctx, name, validity)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to issue client certificate: %v", err), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(struct {
+			data.ClientCert
+			Certificate string `json:"certificate"`
+			PrivateKey  string `json:"privateKey"`
+		}{record, string(certPEM), string(keyPEM)})
+
+	case http.MethodGet:
+		certs, err := data.ListClientCerts(ctx)
+		if err != nil {
+			http.Error(w, "Failed to list client certificates", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(certs)
+
+	case http.MethodDelete:
+		if serial == "" {
+			http.Error(w, "Missing serial number in URL path for revoke", http.StatusBadRequest)
+			return
+		}
+		record, err := data.RevokeClientCert(ctx, serial)
+		if err != nil {
+			if errors.Is(err, data.ErrClientCertNotFound) {
+				http.Error(w, "Client certificate not found", http.StatusNotFound)
+				return
+			}
+			http.Error(w, fmt.Sprintf("Failed to revoke client certificate: %v", err), http.StatusInternalServerError)
+			return
+		}
+		ca.forget(serial)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(record)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// validateClientRequest trims and validates a device name and converts a validity in days, defaulting to
+// defaultClientCertDays, into a duration.
+func validateClientRequest(name string, validDays int) (string, time.Duration, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return "", 0, errors.New("Name is required and cannot be empty")
+	}
+	if len(name) > maxClientNameLength {
+		return "", 0, fmt.Errorf("Name must not exceed %d characters", maxClientNameLength)
+	}
+	if validDays == 0 {
+		validDays = defaultClientCertDays
+	}
+	if validDays < 0 || validDays > maxClientCertDays {
+		return "", 0, fmt.Errorf("validDays must be between 1 and %d", maxClientCertDays)
+	}
+	return name, time.Duration(validDays) * 24 * time.Hour, nil
+}
+
+// clientCommand implements the `client` admin command, which manages client certificates from the
+// server's machine. This is how the first device certificate is issued, since in mutual TLS mode the
+// admin API itself requires one. It returns the process exit code.
+//
+// Usage:
+//
+//	pasword-mango client issue [-days N] [-out PREFIX] NAME
+//	pasword-mango client list
+//	pasword-mango client revoke SERIAL
+func clientCommand(ctx context.Context, cfg config.Config, args []string) int {
+	usage := func() int {
+		fmt.Fprintln(os.Stderr, "usage: pasword-mango client issue [-days N] [-out PREFIX] NAME")
+		fmt.Fprintln(os.Stderr, "       pasword-mango client list")
+		fmt.Fprintln(os.Stderr, "       pasword-mango client revoke SERIAL")
+		return 2
+	}
+	if len(args) == 0 {
+		return usage()
+	}
+
+	if err := data.InitDB(ctx, cfg.Storage, cfg.Key); err != nil {
+		log.Printf("Failed to initialize database: %v", err)
+		return 1
+	}
+	defer data.CloseDB()
+
+	switch args[0] {
+	case "issue":
+		flags := flag.NewFlagSet("client issue", flag.ContinueOnError)
+		days := flags.Int("days", defaultClientCertDays, "validity in days")
+		out := flags.String("out", "", "write PREFIX.crt and PREFIX.key (default: the device name)")
+		if err := flags.Parse(args[1:]); err != nil || flags.NArg() != 1 {
+			return usage()
+		}
+		name, validity, err := validateClientRequest(flags.Arg(0), *days)
+		if err != nil {
+			log.Print(err)
+			return 2
+		}
+		ca := newClientAuthority()
+		if err := ca.load(cfg.TLS.Dir); err != nil {
+			log.Print(err)
+			return 1
+		}
+		certPEM, keyPEM, record, err := ca.issue(ctx, name, validity)
+		if err != nil {
+			log.Print(err)
+			return 1
+		}
+		prefix := *out
+		if prefix == "" {
+			prefix = name
+		}
+		if err := os.WriteFile(prefix+".key", keyPEM, 0600); err != nil {
+			log.Printf("Failed to write private key: %v", err)
+			return 1
+		}
+		if err := os.WriteFile(prefix+".crt", certPEM, 0644); err != nil {
+			log.Printf("Failed to write certificate: %v", err)
+			return 1
+		}
+		fmt.Printf("Issued certificate %s for %q, valid until %s\n", record.Serial, record.Name, record.NotAfter.Format(time.DateOnly))
+		fmt.Printf("Wrote %s.crt and %s.key\n", prefix, prefix)
+
+	case "list":
+		certs, err := data.ListClientCerts(ctx)
+		if err != nil {
+			log.Print(err)
+			return 1
+		}
+		tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "SERIAL\tNAME\tISSUED\tEXPIRES\tSTATUS")
+		for _, c := range certs {
+			state := "active"
+			if c.Revoked {
+				state = "revoked " + c.RevokedAt.Format(time.DateOnly)
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", c.Serial, c.Name, c.IssuedAt.Format(time.DateOnly), c.NotAfter.Format(time.DateOnly), state)
+		}
+		tw.Flush()
+
+	case "revoke":
+		if len(args) != 2 {
+			return usage()
+		}
+		record, err := data.RevokeClientCert(ctx, args[1])
+		if err != nil {
+			log.Print(err)
+			return 1
+		}
+		fmt.Printf("Revoked certificate %s for %q\n", record.Serial, record.Name)
+
+	default:
+		return usage()
+	}
+	return 0
+}
+
+// createClientCA generates a self-signed ECDSA P-256 CA certificate and key and writes them to dir.
+func createClientCA(dir, certFile, keyFile string) error {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return fmt.Errorf("failed to generate client CA key: %w", err)
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return fmt.Errorf("failed to generate certificate serial number: %w", err)
+	}
+
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{Organization: []string{selfSignedOrgName}, CommonName: caCommonName},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(caValidity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLenZero:        true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return fmt.Errorf("failed to create client CA certificate: %w", err)
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return fmt.Errorf("failed to encode client CA key: %w", err)
+	}
+
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return fmt.Errorf("failed to create TLS directory: %w", err)
+	}
+	if err := writePEM(keyFile, "PRIVATE KEY", keyDER, 0600); err != nil {
+		return err
+	}
+	if err := writePEM(certFile, "CERTIFICATE", der, 0644); err != nil {
+		return err
+	}
+	log.Printf("Generated client certificate authority %s", certFile)
+	return nil
+}
+
+// serialString formats a certificate serial number as lower-case hex, the form used as its registry key.
+func serialString(serial *big.Int) string {
+	return serial.Text(16)
+}
@@ -0,0 +1,294 @@
+// Command git-credential-mango is a Git credential helper that keeps HTTPS credentials, such as personal access
+// tokens, in a Pasword Mango vault instead of a plaintext ~/.git-credentials. Git runs it with an operation and
+// describes the credential on standard input as key=value lines; see gitcredentials(7).
+//
+// Usage:
+//
+//	git config --global credential.helper 'mango -server https://localhost:8080 -ca /path/to/cert.pem'
+//	git-credential-mango [flags] get | store | erase
+//
+// A credential for https://github.com is stored under the site github.com, named like imported logins: the lower-case
+// host without "www." or a port. Lookups go through the server's site lookup, so an existing item for github or
+// github.com is found too. Items are matched as follows:
+//
+//   - A username from Git must match the item's. If the host's item belongs to another user, the credential is kept
+//     under USER@HOST instead, so that one host can hold several accounts.
+//   - With credential.useHttpPath set, Git also sends the repository path, and the credential is kept under
+//     HOST:PATH, with the path's slashes also replaced by colons, such as github.com:org:repo.git. Lookups fall back
+//     to the host's item.
+//
+// `store` creates the item, tagged "git", or updates the password of the matching item tagged "git". `erase`, which
+// Git runs when a credential is rejected, deletes the matching item only if it is tagged "git" and still holds the
+// rejected password. A website login stored under the same host is never changed or deleted by Git: the helper keeps
+// its own item beside it, under USER@HOST, and `get` prefers that item, with or without a username from Git.
+package main
+
+import (
+	"bufio"
+	"cmp"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"os/signal"
+	"slices"
+	"strings"
+
+	"github.com/rihts-4/pasword-mango/client"
+	"github.com/rihts-4/pasword-mango/importer"
+)
+
+// Environment variables that provide defaults for the flags, shared with the mango command.
+const (
+	serverEnvVar = "MANGO_SERVER"
+	caEnvVar     = "MANGO_CA"
+	certEnvVar   = "MANGO_CERT"
+	keyEnvVar    = "MANGO_KEY"
+)
+
+// gitTag marks the items the helper stores, which are the only ones it erases.
+const gitTag = "git"
+
+func main() {
+	var cfg client.Config
+	flags := flag.NewFlagSet("git-credential-mango", flag.ExitOnError)
+	flags.StringVar(&cfg.BaseURL, "server", envOr(serverEnvVar, client.DefaultBaseURL), "server URL, or unix:///path/to/socket ($"+serverEnvVar+")")
+	flags.StringVar(&cfg.CAFile, "ca", os.Getenv(caEnvVar), "PEM file of the CA or certificate to trust for HTTPS ($"+caEnvVar+")")
+	flags.StringVar(&cfg.CertFile, "cert", os.Getenv(certEnvVar), "client certificate for mutual TLS ($"+certEnvVar+")")
+	flags.StringVar(&cfg.KeyFile, "key", os.Getenv(keyEnvVar), "client certificate private key for mutual TLS ($"+keyEnvVar+")")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: git-credential-mango [flags] get | store | erase")
+		flags.PrintDefaults()
+	}
+	flags.Parse(os.Args[1:])
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	c, err := client.New(cfg)
+	if err == nil {
+		err = run(ctx, c, flags.Arg(0), os.Stdin, os.Stdout)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "git-credential-mango: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// envOr returns the value of the environment variable, or fallback if it is unset or empty.
+func envOr(name, fallback string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+	return fallback
+}
+
+// run performs a helper operation on the credential read from in. Only get writes to out. Operations other than
+// get, store, and erase are ignored, as Git expects of helpers, and so are credentials for other protocols than
+// http and https.
+func run(ctx context.Context, c *client.Client, operation string, in io.Reader, out io.Writer) error {
+	if operation != "get" && operation != "store" && operation != "erase" {
+		return nil
+	}
+	cred, err := readCredential(in)
+	if err != nil {
+		return err
+	}
+	if (cred.protocol != "http" && cred.protocol != "https") || cred.host == "" {
+		return nil
+	}
+	host, err := importer.NormalizeSite(cred.protocol + "://" + cred.host)
+	if err != nil {
+		return nil
+	}
+
+	switch operation {
+	case "get":
+		found, err := retrieve(ctx, c, host, cred)
+		if err != nil || found.Password == "" {
+			return err
+		}
+		return writeCredential(out, credential{username: found.Username, password: found.Password})
+	case "store":
+		return store(ctx, c, host, cred)
+	default:
+		return erase(ctx, c, host, cred)
+	}
+}
+
+// credential holds the attributes of the credential protocol the helper uses.
+type credential struct {
+	protocol string
+	host     string
+	path     string
+	username string
+	password string
+}
+
+// readCredential reads key=value lines up to a blank line or the end of input. A url attribute is broken down into
+// the others, which it does not override. Unknown attributes are ignored.
+func readCredential(in io.Reader) (credential, error) {
+	var cred credential
+	var rawURL string
+	scanner := bufio.NewScanner(in)
+	for scanner.Scan() {
+		line := strings.TrimSuffix(scanner.Text(), "\r")
+		if line == "" {
+			break
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			return credential{}, fmt.Errorf("invalid input line %q", line)
+		}
+		switch key {
+		case "protocol":
+			cred.protocol = value
+		case "host":
+			cred.host = value
+		case "path":
+			cred.path = value
+		case "username":
+			cred.username = value
+		case "password":
+			cred.password = value
+		case "url":
+			rawURL = value
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return credential{}, fmt.Errorf("error reading input: %v", err)
+	}
+	if rawURL != "" {
+		u, err := url.Parse(rawURL)
+		if err != nil {
+			return credential{}, fmt.Errorf("invalid url %q", rawURL)
+		}
+		cred.protocol = cmp.Or(cred.protocol, u.Scheme)
+		cred.host = cmp.Or(cred.host, u.Host)
+		cred.path = cmp.Or(cred.path, strings.TrimPrefix(u.Path, "/"))
+		cred.username = cmp.Or(cred.username, u.User.Username())
+	}
+	return cred, nil
+}
+
+// writeCredential writes the username and password of a credential for Git.
+func writeCredential(out io.Writer, cred credential) error {
+	for _, value := range []string{cred.username, cred.password} {
+		if strings.ContainsAny(value, "\n\x00") {
+			return errors.New("stored credentials contain a newline and cannot be passed to Git")
+		}
+	}
+	_, err := fmt.Fprintf(out, "username=%s\npassword=%s\n", cred.username, cred.password)
+	return err
+}
+
+// siteNames returns the site names a credential may be stored under, most specific first: with a path, HOST:PATH
+// and then HOST; with a username, USER@NAME before each NAME.
+func siteNames(host string, cred credential) []string {
+	bases := []string{host}
+	if path := strings.Trim(cred.path, "/"); path != "" {
+		bases = []string{host + ":" + strings.ReplaceAll(path, "/", ":"), host}
+	}
+	var names []string
+	for _, base := range bases {
+		if cred.username != "" {
+			names = append(names, cred.username+"@"+base)
+		}
+		names = append(names, base)
+	}
+	return names
+}
+
+// lookup returns the first stored item among the credential's site names whose username matches the credential's,
+// if it has one, and that is tagged tag, unless tag is empty. It also returns the name the item was found under, or
+// zero credentials if there is none.
+func lookup(ctx context.Context, c *client.Client, host string, cred credential, tag string) (string, client.Credentials, error) {
+	for _, name := range siteNames(host, cred) {
+		stored, err := c.Get(ctx, name)
+		if errors.Is(err, client.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return "", client.Credentials{}, err
+		}
+		if (cred.username == "" || stored.Username == cred.username) && (tag == "" || slices.Contains(stored.Tags, tag)) {
+			return name, stored, nil
+		}
+	}
+	return "", client.Credentials{}, nil
+}
+
+// retrieve returns the credentials to give Git: the matching item the helper stored, or else any matching item. If
+// Git sent no username and the host's item is a login the helper did not store, the helper's item stored beside it
+// for the same user, under USER@HOST, is preferred.
+func retrieve(ctx context.Context, c *client.Client, host string, cred credential) (client.Credentials, error) {
+	_, found, err := lookup(ctx, c, host, cred, gitTag)
+	if err != nil || found.Password != "" {
+		return found, err
+	}
+	_, found, err = lookup(ctx, c, host, cred, "")
+	if err != nil || found.Password == "" || cred.username != "" {
+		return found, err
+	}
+	cred.username = found.Username
+	_, own, err := lookup(ctx, c, host, cred, gitTag)
+	if err != nil || own.Password == "" {
+		return found, err
+	}
+	return own, nil
+}
+
+// store keeps a credential Git has used successfully. The password of a matching item the helper stored is updated
+// if it changed; otherwise a new item is created under the most specific free site name without the username, or
+// with it if the name is taken, as it is by a login of the user's own that the helper did not store and never changes.
+func store(ctx context.Context, c *client.Client, host string, cred credential) error {
+	if cred.username == "" || cred.password == "" {
+		return nil
+	}
+	name, stored, err := lookup(ctx, c, host, cred, gitTag)
+	if err != nil {
+		return err
+	}
+	if name != "" {
+		if stored.Password == cred.password {
+			return nil
+		}
+		return c.Update(ctx, client.Credentials{Site: name, Username: cred.username, Password: cred.password})
+	}
+
+	names := siteNames(host, cred)
+	if cred.path != "" {
+		// Only the names with the path, so that a path-specific credential never takes the host's name
+		names = names[:len(names)/2]
+	}
+	for _, name := range slices.Backward(names) {
+		err := c.Create(ctx, client.Credentials{Site: name, Username: cred.username, Password: cred.password, Tags: []string{gitTag}})
+		if !errors.Is(err, client.ErrAlreadyExists) {
+			return err
+		}
+	}
+	return fmt.Errorf("no free site name for %s", host)
+}
+
+// erase deletes the item Git rejected, if the helper stored it and it still holds the rejected password.
+func erase(ctx context.Context, c *client.Client, host string, cred credential) error {
+	name, stored, err := lookup(ctx, c, host, cred, gitTag)
+	if err != nil || name == "" {
+		return err
+	}
+	if cred.password == "" || stored.Password != cred.password {
+		return nil
+	}
+	err = c.Delete(ctx, name)
+	if errors.Is(err, client.ErrNotFound) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,330 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/cgi"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/rihts-4/pasword-mango/client"
+)
+
+// helperEnvVar makes the test binary act as the helper when Git runs it, or as Git's askpass program when Git asks
+// for a username or password.
+const helperEnvVar = "GIT_CREDENTIAL_MANGO_TEST_HELPER"
+
+func TestMain(m *testing.M) {
+	if os.Getenv(helperEnvVar) == "" {
+		os.Exit(m.Run())
+	}
+	if len(os.Args) == 2 && strings.HasPrefix(os.Args[1], "Username for ") {
+		fmt.Println(os.Getenv("TEST_ASKPASS_USERNAME"))
+		return
+	}
+	if len(os.Args) == 2 && strings.HasPrefix(os.Args[1], "Password for ") {
+		fmt.Println(os.Getenv("TEST_ASKPASS_PASSWORD"))
+		return
+	}
+	main()
+}
+
+// fakeVault stands in for the server's /credentials endpoints, including the lookup of a site with or without
+// ".com".
+type fakeVault struct {
+	mu    sync.Mutex
+	items map[string]client.Credentials
+}
+
+func newFakeVault(t *testing.T, items ...client.Credentials) (*fakeVault, string) {
+	v := &fakeVault{items: make(map[string]client.Credentials)}
+	for _, item := range items {
+		v.items[item.Site] = item
+	}
+	server := httptest.NewServer(v)
+	t.Cleanup(server.Close)
+	return v, server.URL
+}
+
+// find returns the name a site is stored under, trying the alternative with or without ".com" like the server.
+func (v *fakeVault) find(site string) (string, bool) {
+	alternative := site + ".com"
+	if trimmed, ok := strings.CutSuffix(site, ".com"); ok {
+		alternative = trimmed
+	}
+	for _, name := range []string{site, alternative} {
+		if _, ok := v.items[name]; ok {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+func (v *fakeVault) get(site string) (client.Credentials, bool) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	creds, ok := v.items[site]
+	return creds, ok
+}
+
+func (v *fakeVault) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	site := strings.Trim(strings.TrimPrefix(r.URL.Path, "/credentials"), "/")
+	name, found := v.find(site)
+	switch {
+	case r.Method == http.MethodPost && site == "":
+		var creds client.Credentials
+		if err := json.NewDecoder(r.Body).Decode(&creds); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		if _, exists := v.find(creds.Site); exists {
+			http.Error(w, "Credentials already exist", http.StatusConflict)
+			return
+		}
+		v.items[creds.Site] = creds
+		w.WriteHeader(http.StatusCreated)
+	case !found:
+		http.Error(w, "Credentials not found", http.StatusNotFound)
+	case r.Method == http.MethodGet:
+		creds := v.items[name]
+		creds.Site = ""
+		json.NewEncoder(w).Encode(creds)
+	case r.Method == http.MethodPut:
+		var update client.Credentials
+		if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		creds := v.items[name]
+		creds.Username, creds.Password = update.Username, update.Password
+		v.items[name] = creds
+	case r.Method == http.MethodDelete:
+		delete(v.items, name)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// newGitServer serves a bare repository over HTTP with git http-backend, accepting only the given username and
+// password, and returns the repository's URL.
+func newGitServer(t *testing.T, username, password string) string {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	execPath, err := exec.Command("git", "--exec-path").Output()
+	if err != nil {
+		t.Skipf("git --exec-path: %v", err)
+	}
+	backend := filepath.Join(strings.TrimSpace(string(execPath)), "git-http-backend")
+	if _, err := os.Stat(backend); err != nil {
+		t.Skip("git http-backend is not installed")
+	}
+	root := t.TempDir()
+	if out, err := exec.Command("git", "init", "--bare", filepath.Join(root, "repo.git")).CombinedOutput(); err != nil {
+		t.Fatalf("git init: %v\n%s", err, out)
+	}
+
+	handler := &cgi.Handler{Path: backend, Root: "/", Env: []string{"GIT_PROJECT_ROOT=" + root, "GIT_HTTP_EXPORT_ALL=1"}}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, pass, ok := r.BasicAuth(); !ok || user != username || pass != password {
+			w.Header().Set("WWW-Authenticate", `Basic realm="git"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	}))
+	t.Cleanup(server.Close)
+	return server.URL + "/repo.git"
+}
+
+// lsRemote runs git ls-remote against repo with the helper as the only credential helper. Without askpass answers,
+// Git cannot prompt, so it fails unless the helper supplies working credentials.
+func lsRemote(t *testing.T, vaultURL, repo string, askpass ...string) error {
+	helper, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmd := exec.Command("git",
+		"-c", "credential.helper=",
+		"-c", fmt.Sprintf("credential.helper=!'%s' -server %s", helper, vaultURL),
+		"ls-remote", repo)
+	cmd.Env = append(os.Environ(), helperEnvVar+"=1", "HOME="+t.TempDir(), "GIT_CONFIG_NOSYSTEM=1", "GIT_TERMINAL_PROMPT=0")
+	if len(askpass) == 2 {
+		cmd.Env = append(cmd.Env, "GIT_ASKPASS="+helper, "TEST_ASKPASS_USERNAME="+askpass[0], "TEST_ASKPASS_PASSWORD="+askpass[1])
+	}
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%v\n%s", err, out)
+	}
+	return nil
+}
+
+func TestGitUsesStoredCredentials(t *testing.T) {
+	repo := newGitServer(t, "alice", "token-1")
+	_, vaultURL := newFakeVault(t, client.Credentials{Site: "127.0.0.1", Username: "alice", Password: "token-1"})
+	if err := lsRemote(t, vaultURL, repo); err != nil {
+		t.Fatalf("git ls-remote with stored credentials failed: %v", err)
+	}
+}
+
+func TestGitStoresCredentials(t *testing.T) {
+	repo := newGitServer(t, "alice", "token-1")
+	vault, vaultURL := newFakeVault(t)
+	if err := lsRemote(t, vaultURL, repo); err == nil {
+		t.Fatal("git ls-remote succeeded without credentials")
+	}
+	if err := lsRemote(t, vaultURL, repo, "alice", "token-1"); err != nil {
+		t.Fatalf("git ls-remote with prompted credentials failed: %v", err)
+	}
+	stored, ok := vault.get("127.0.0.1")
+	if !ok || stored.Username != "alice" || stored.Password != "token-1" || !slices.Contains(stored.Tags, gitTag) {
+		t.Fatalf("stored %+v, %v; want alice's token tagged %q", stored, ok, gitTag)
+	}
+	if err := lsRemote(t, vaultURL, repo); err != nil {
+		t.Fatalf("git ls-remote with the stored credentials failed: %v", err)
+	}
+}
+
+func TestGitErasesRejectedCredentials(t *testing.T) {
+	repo := newGitServer(t, "alice", "token-2")
+	vault, vaultURL := newFakeVault(t, client.Credentials{Site: "127.0.0.1", Username: "alice", Password: "token-1", Tags: []string{gitTag}})
+	if err := lsRemote(t, vaultURL, repo); err == nil {
+		t.Fatal("git ls-remote succeeded with a revoked token")
+	}
+	if stored, ok := vault.get("127.0.0.1"); ok {
+		t.Fatalf("rejected credentials were kept: %+v", stored)
+	}
+}
+
+func TestGitKeepsRejectedLogin(t *testing.T) {
+	repo := newGitServer(t, "alice", "token-1")
+	login := client.Credentials{Site: "127.0.0.1", Username: "alice", Password: "website-password"}
+	vault, vaultURL := newFakeVault(t, login)
+	if err := lsRemote(t, vaultURL, repo); err == nil {
+		t.Fatal("git ls-remote succeeded with a website password")
+	}
+	if stored, ok := vault.get("127.0.0.1"); !ok || stored.Password != login.Password {
+		t.Fatalf("a login not stored by the helper was changed: %+v, %v", stored, ok)
+	}
+}
+
+func TestRun(t *testing.T) {
+	_, vaultURL := newFakeVault(t,
+		client.Credentials{Site: "example", Username: "alice", Password: "alice-token"},
+		client.Credentials{Site: "bob@example.com", Username: "bob", Password: "bob-token"},
+		client.Credentials{Site: "example.com:org:repo.git", Username: "deploy", Password: "deploy-token"},
+	)
+	c, err := client.New(client.Config{BaseURL: vaultURL})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{"host", "protocol=https\nhost=www.Example.com:8443\n\n", "username=alice\npassword=alice-token\n"},
+		{"username", "protocol=https\nhost=example.com\nusername=alice\n", "username=alice\npassword=alice-token\n"},
+		{"other user", "protocol=https\nhost=example.com\nusername=bob\n", "username=bob\npassword=bob-token\n"},
+		{"unknown user", "protocol=https\nhost=example.com\nusername=carol\n", ""},
+		{"path", "protocol=https\nhost=example.com\npath=org/repo.git\n", "username=deploy\npassword=deploy-token\n"},
+		{"path falls back to host", "protocol=https\nhost=example.com\npath=org/other.git\n", "username=alice\npassword=alice-token\n"},
+		{"url", "url=https://bob@example.com/org/other.git\n", "username=bob\npassword=bob-token\n"},
+		{"other protocol", "protocol=ssh\nhost=example.com\n", ""},
+		{"unknown host", "protocol=https\nhost=unknown.org\n", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out strings.Builder
+			if err := run(context.Background(), c, "get", strings.NewReader(tt.input), &out); err != nil {
+				t.Fatal(err)
+			}
+			if out.String() != tt.want {
+				t.Errorf("get wrote %q, want %q", out.String(), tt.want)
+			}
+		})
+	}
+}
+
+func TestStoreNamesSecondAccount(t *testing.T) {
+	vault, vaultURL := newFakeVault(t, client.Credentials{Site: "example.com", Username: "alice", Password: "alice-token", Tags: []string{gitTag}})
+	c, err := client.New(client.Config{BaseURL: vaultURL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, input := range []string{
+		"protocol=https\nhost=example.com\nusername=bob\npassword=bob-token\n",
+		"protocol=https\nhost=example.com\npath=org/repo.git\nusername=deploy\npassword=deploy-token\n",
+		"protocol=https\nhost=example.com\nusername=alice\npassword=alice-token-2\n",
+	} {
+		if err := run(context.Background(), c, "store", strings.NewReader(input), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for site, want := range map[string]string{
+		"example.com":              "alice-token-2",
+		"bob@example.com":          "bob-token",
+		"example.com:org:repo.git": "deploy-token",
+	} {
+		if stored, ok := vault.get(site); !ok || stored.Password != want {
+			t.Errorf("%s: stored %+v, %v; want password %q", site, stored, ok, want)
+		}
+	}
+}
+
+func TestStoreKeepsLogin(t *testing.T) {
+	login := client.Credentials{Site: "example.com", Username: "alice", Password: "website-password"}
+	vault, vaultURL := newFakeVault(t, login)
+	c, err := client.New(client.Config{BaseURL: vaultURL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	operations := []struct{ operation, input string }{
+		{"store", "protocol=https\nhost=example.com\nusername=alice\npassword=token-1\n"},
+		{"store", "protocol=https\nhost=example.com\nusername=alice\npassword=token-2\n"},
+	}
+	for _, op := range operations {
+		if err := run(context.Background(), c, op.operation, strings.NewReader(op.input), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if stored, ok := vault.get("example.com"); !ok || !reflect.DeepEqual(stored, login) {
+		t.Errorf("the login became %+v, %v; want it unchanged", stored, ok)
+	}
+	stored, ok := vault.get("alice@example.com")
+	if !ok || stored.Password != "token-2" || !slices.Contains(stored.Tags, gitTag) {
+		t.Fatalf("stored %+v, %v; want alice's latest token tagged %q", stored, ok, gitTag)
+	}
+
+	// Git is given the token rather than the login's password, and a rejected token leaves the login alone
+	var out strings.Builder
+	if err := run(context.Background(), c, "get", strings.NewReader("protocol=https\nhost=example.com\n"), &out); err != nil {
+		t.Fatal(err)
+	}
+	if want := "username=alice\npassword=token-2\n"; out.String() != want {
+		t.Errorf("get wrote %q, want %q", out.String(), want)
+	}
+	erase := "protocol=https\nhost=example.com\nusername=alice\npassword=token-2\n"
+	if err := run(context.Background(), c, "erase", strings.NewReader(erase), nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := vault.get("alice@example.com"); ok {
+		t.Error("the rejected token was kept")
+	}
+	if stored, ok := vault.get("example.com"); !ok || !reflect.DeepEqual(stored, login) {
+		t.Errorf("the login became %+v, %v; want it unchanged", stored, ok)
+	}
+}
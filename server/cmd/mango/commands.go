@@ -0,0 +1,252 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"slices"
+	"text/tabwriter"
+
+	"github.com/rihts-4/pasword-mango/client"
+	"github.com/rihts-4/pasword-mango/generate"
+	"github.com/rihts-4/pasword-mango/strength"
+)
+
+// maskedPassword stands in for a password that was not explicitly requested.
+const maskedPassword = "********"
+
+// listCommand implements `list`, which prints the stored site names.
+func listCommand(ctx context.Context, c *client.Client, opts options, args []string) error {
+	flags := flag.NewFlagSet("list", flag.ContinueOnError)
+	flags.Usage = func() { fmt.Fprintln(os.Stderr, "usage: mango [flags] list") }
+	if err := parseFlags(flags, args, 0); err != nil {
+		return err
+	}
+
+	sites, err := c.List(ctx)
+	if err != nil {
+		return err
+	}
+	slices.Sort(sites)
+	if opts.output == "json" {
+		return writeJSON(sites)
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "SITE")
+	for _, site := range sites {
+		fmt.Fprintln(tw, site)
+	}
+	return tw.Flush()
+}
+
+// getCommand implements `get`, which prints a site's credentials. The password is masked unless -show is given;
+// -password prints only the password, for piping into another program.
+func getCommand(ctx context.Context, c *client.Client, opts options, args []string) error {
+	flags := flag.NewFlagSet("get", flag.ContinueOnError)
+	show := flags.Bool("show", false, "include the password in the output")
+	passwordOnly := flags.Bool("password", false, "print only the password")
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: mango [flags] get [-show | -password] SITE")
+		flags.PrintDefaults()
+	}
+	if err := parseFlags(flags, args, 1); err != nil {
+		return err
+	}
+
+	creds, err := c.Get(ctx, flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	if *passwordOnly {
+		_, err := fmt.Println(creds.Password)
+		return err
+	}
+	if !*show {
+		creds.Password = maskedPassword
+	}
+	if opts.output == "json" {
+		return writeJSON(creds)
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "SITE\tUSERNAME\tPASSWORD")
+	fmt.Fprintf(tw, "%s\t%s\t%s\n", creds.Site, creds.Username, creds.Password)
+	return tw.Flush()
+}
+
+// addCommand implements `add`, which stores credentials for a new site. The username and password are prompted
+// for unless given; a password is generated with -generate.
+func addCommand(ctx context.Context, c *client.Client, args []string) error {
+	flags := flag.NewFlagSet("add", flag.ContinueOnError)
+	username := flags.String("username", "", "username (prompted for if omitted)")
+	generated := flags.Bool("generate", false, "generate a random password instead of prompting for one")
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: mango [flags] add [-username NAME] [-generate] SITE")
+		flags.PrintDefaults()
+	}
+	if err := parseFlags(flags, args, 1); err != nil {
+		return err
+	}
+
+	creds := client.Credentials{Site: flags.Arg(0), Username: *username}
+	if creds.Username == "" {
+		var err error
+		if creds.Username, err = promptLine("Username: "); err != nil {
+			return err
+		}
+	}
+	var err error
+	if *generated {
+		creds.Password, err = generate.Password(generate.DefaultLength)
+	} else {
+		creds.Password, err = promptNewPassword(false)
+	}
+	if err != nil {
+		return err
+	}
+	if err := creds.Validate(); err != nil {
+		return err
+	}
+	if !*generated {
+		warnIfWeak(creds)
+	}
+
+	if err := c.Create(ctx, creds); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Stored credentials for %s.\n", creds.Site)
+	return nil
+}
+
+// editCommand implements `edit`, which changes the username or password of an existing site. Anything not given
+// on the command line is prompted for, and an empty answer keeps the current value.
+func editCommand(ctx context.Context, c *client.Client, args []string) error {
+	flags := flag.NewFlagSet("edit", flag.ContinueOnError)
+	username := flags.String("username", "", "new username (prompted for if omitted)")
+	generated := flags.Bool("generate", false, "generate a new random password")
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: mango [flags] edit [-username NAME] [-generate] SITE")
+		flags.PrintDefaults()
+	}
+	if err := parseFlags(flags, args, 1); err != nil {
+		return err
+	}
+	site := flags.Arg(0)
+
+	// The API replaces both fields, so start from the current ones
+	current, err := c.Get(ctx, site)
+	if err != nil {
+		return err
+	}
+	newUsername := *username
+	if newUsername == "" {
+		if newUsername, err = promptLine(fmt.Sprintf("Username [%s]: ", current.Username)); err != nil {
+			return err
+		}
+		if newUsername == "" {
+			newUsername = current.Username
+		}
+	}
+	var newPassword string
+	if *generated {
+		newPassword, err = generate.Password(generate.DefaultLength)
+	} else {
+		newPassword, err = promptNewPassword(true)
+	}
+	if err != nil {
+		return err
+	}
+	if newPassword == "" {
+		newPassword = current.Password
+	} else if !*generated {
+		warnIfWeak(client.Credentials{Site: site, Username: newUsername, Password: newPassword})
+	}
+
+	if err := c.Update(ctx, client.Credentials{Site: site, Username: newUsername, Password: newPassword}); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Updated credentials for %s.\n", site)
+	return nil
+}
+
+// rmCommand implements `rm`, which deletes a site's credentials after confirmation.
+func rmCommand(ctx context.Context, c *client.Client, args []string) error {
+	flags := flag.NewFlagSet("rm", flag.ContinueOnError)
+	yes := flags.Bool("yes", false, "do not ask for confirmation")
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: mango [flags] rm [-yes] SITE")
+		flags.PrintDefaults()
+	}
+	if err := parseFlags(flags, args, 1); err != nil {
+		return err
+	}
+	site := flags.Arg(0)
+
+	if !*yes {
+		ok, err := confirm(fmt.Sprintf("Delete credentials for %s?", site))
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return fmt.Errorf("not deleted")
+		}
+	}
+	if err := c.Delete(ctx, site); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Deleted credentials for %s.\n", site)
+	return nil
+}
+
+// generateCommand implements `generate`, which prints a random password or passphrase generated locally.
+func generateCommand(opts options, args []string) error {
+	flags := flag.NewFlagSet("generate", flag.ContinueOnError)
+	var req generate.Request
+	flags.IntVar(&req.Length, "length", generate.DefaultLength, "password length")
+	noSymbols := flags.Bool("no-symbols", false, "use only letters and digits")
+	flags.BoolVar(&req.ExcludeAmbiguous, "no-ambiguous", false, "leave out easily confused characters such as l, 1, O, and 0")
+	passphrase := flags.Bool("passphrase", false, "generate a passphrase of words instead")
+	flags.IntVar(&req.Words, "words", generate.DefaultWords, "number of words in a passphrase")
+	flags.StringVar(&req.Separator, "separator", generate.DefaultSeparator, "separator between the words of a passphrase")
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: mango [flags] generate [-length N] [-no-symbols] [-no-ambiguous]")
+		fmt.Fprintln(os.Stderr, "       mango [flags] generate -passphrase [-words N] [-separator S]")
+		flags.PrintDefaults()
+	}
+	if err := parseFlags(flags, args, 0); err != nil {
+		return err
+	}
+	if *passphrase {
+		req.Type = generate.TypePassphrase
+	}
+	if *noSymbols {
+		req.Classes = []string{generate.ClassLower, generate.ClassUpper, generate.ClassDigits}
+	}
+
+	result, err := req.Generate()
+	if err != nil {
+		return err
+	}
+	if opts.output == "json" {
+		return writeJSON(result)
+	}
+	_, err = fmt.Println(result.Password)
+	return err
+}
+
+// warnIfWeak prints a warning on standard error if a typed password is easy to guess. The server may also reject
+// it if it is below the configured minimum score.
+func warnIfWeak(creds client.Credentials) {
+	result := strength.Estimate(creds.Password, creds.Site, creds.Username)
+	if result.Score < strength.ScoreSafelyUnguessable {
+		fmt.Fprintf(os.Stderr, "Warning: weak password (score %d of %d): %s\n", result.Score, strength.MaxScore, result.Reason())
+	}
+}
+
+// writeJSON writes v to standard output as indented JSON.
+func writeJSON(v any) error {
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(v)
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/rihts-4/pasword-mango/client"
+)
+
+// testServer answers GET /credentials/{site} with the credentials of sites, found with or without ".com", and
+// records the paths it was asked for. With omitSite, responses leave out the site as older servers did.
+type testServer struct {
+	sites    map[string]client.Credentials
+	omitSite bool
+
+	mu    sync.Mutex
+	paths []string
+}
+
+func newTestClient(t *testing.T, s *testServer) *client.Client {
+	t.Helper()
+	server := httptest.NewServer(s)
+	t.Cleanup(server.Close)
+	c, err := client.New(client.Config{BaseURL: server.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func (s *testServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	s.paths = append(s.paths, r.Method+" "+r.URL.Path)
+	s.mu.Unlock()
+	site := strings.TrimPrefix(r.URL.Path, "/credentials/")
+	if r.Method != http.MethodGet || site == "" || site == r.URL.Path {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	for _, name := range []string{site, site + ".com"} {
+		if creds, ok := s.sites[name]; ok {
+			creds.Site = name
+			if s.omitSite {
+				creds.Site = ""
+			}
+			json.NewEncoder(w).Encode(creds)
+			return
+		}
+	}
+	http.Error(w, "Credentials not found", http.StatusNotFound)
+}
+
+func (s *testServer) requests() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.paths...)
+}
+
+// captureStdout returns what f writes to standard output.
+func captureStdout(t *testing.T, f func() error) string {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = stdout }()
+	done := make(chan string)
+	go func() {
+		out, _ := io.ReadAll(r)
+		done <- string(out)
+	}()
+	ferr := f()
+	w.Close()
+	out := <-done
+	if ferr != nil {
+		t.Fatal(ferr)
+	}
+	return out
+}
+
+func TestGetCommandPrintsSite(t *testing.T) {
+	for _, omitSite := range []bool{false, true} {
+		s := &testServer{sites: map[string]client.Credentials{"example.com": {Username: "alice", Password: "secret"}}, omitSite: omitSite}
+		c := newTestClient(t, s)
+		// An older server does not say where it found the site, so the name asked for is shown
+		want := "example.com"
+		if omitSite {
+			want = "example"
+		}
+
+		table := captureStdout(t, func() error {
+			return getCommand(context.Background(), c, options{output: "table"}, []string{"example"})
+		})
+		lines := strings.Split(strings.TrimSpace(table), "\n")
+		if len(lines) != 2 || strings.Fields(lines[1])[0] != want || !strings.Contains(lines[1], maskedPassword) {
+			t.Errorf("omitSite=%v: table output %q, want the site %s and a masked password", omitSite, table, want)
+		}
+
+		var creds client.Credentials
+		out := captureStdout(t, func() error { return getCommand(context.Background(), c, options{output: "json"}, []string{"example"}) })
+		if err := json.Unmarshal([]byte(out), &creds); err != nil {
+			t.Fatalf("JSON output %q: %v", out, err)
+		}
+		if creds.Site != want || creds.Username != "alice" || creds.Password != maskedPassword {
+			t.Errorf("omitSite=%v: JSON output %+v, want the site %s", omitSite, creds, want)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"slices"
+
+	"github.com/rihts-4/pasword-mango/client"
+)
+
+// completeCommand is the hidden command the completion scripts run to list site names.
+const completeCommand = "__complete"
+
+// completionScripts are printed by `mango completion SHELL`. They complete command names, and site names for
+// the commands that take one by asking the server through `mango __complete`, which uses $MANGO_SERVER and the
+// other environment defaults.
+var completionScripts = map[string]string{
+	"bash": `# mango bash completion; load with: source <(mango completion bash)
+_mango() {
+	local cur=${COMP_WORDS[COMP_CWORD]} i cmd=""
+	for ((i = 1; i < COMP_CWORD; i++)); do
+		case ${COMP_WORDS[i]} in
+		-*) ;;
+		*) cmd=${COMP_WORDS[i]}; break ;;
+		esac
+	done
+	case $cmd in
+	"") COMPREPLY=($(compgen -W "list get add edit rm import export share unshare receive generate tui completion" -- "$cur")) ;;
+	get|edit|rm|share) COMPREPLY=($(compgen -W "$(mango __complete 2>/dev/null)" -- "$cur")) ;;
+	import) COMPREPLY=($(compgen -f -- "$cur")) ;;
+	completion) COMPREPLY=($(compgen -W "bash zsh fish" -- "$cur")) ;;
+	esac
+}
+complete -F _mango mango
+`,
+	"zsh": `#compdef mango
+# mango zsh completion; load with: source <(mango completion zsh)
+_mango() {
+	if (( CURRENT == 2 )); then
+		compadd list get add edit rm import export share unshare receive generate tui completion
+		return
+	fi
+	case ${words[2]} in
+	get|edit|rm|share) compadd -- ${(f)"$(mango __complete 2>/dev/null)"} ;;
+	import) _files ;;
+	completion) compadd bash zsh fish ;;
+	esac
+}
+compdef _mango mango
+`,
+	"fish": `# mango fish completion; load with: mango completion fish | source
+complete -c mango -f
+complete -c mango -n __fish_use_subcommand -a 'list get add edit rm import export share unshare receive generate tui completion'
+complete -c mango -n '__fish_seen_subcommand_from get edit rm share' -a '(mango __complete 2>/dev/null)'
+complete -c mango -n '__fish_seen_subcommand_from completion' -a 'bash zsh fish'
+complete -c mango -n '__fish_seen_subcommand_from import' -F
+`,
+}
+
+// completionCommand implements `completion`, which prints the completion script for a shell.
+func completionCommand(args []string) error {
+	if len(args) != 1 || completionScripts[args[0]] == "" {
+		fmt.Fprintln(os.Stderr, "usage: mango completion bash|zsh|fish")
+		return errUsage
+	}
+	_, err := fmt.Print(completionScripts[args[0]])
+	return err
+}
+
+// completeSites prints the stored site names, one per line, for the completion scripts.
+func completeSites(ctx context.Context, c *client.Client) error {
+	sites, err := c.List(ctx)
+	if err != nil {
+		return err
+	}
+	slices.Sort(sites)
+	for _, site := range sites {
+		fmt.Println(site)
+	}
+	return nil
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/rihts-4/pasword-mango/agecrypt"
+	"github.com/rihts-4/pasword-mango/client"
+	"golang.org/x/term"
+)
+
+// exportCommand implements `export`, which writes the vault, passwords included, as another password manager's
+// export to a file or standard output. -site, -tag, and -query export only part of it. With -password, the export is
+// password protected with a password that is prompted for; a KeePass database always is. With -recipient or
+// -recipients-file, the server encrypts the export with age for those public keys, so that it is never written
+// anywhere in plaintext. It fails if the server left out sites it could not decrypt, after writing the export.
+func exportCommand(ctx context.Context, c *client.Client, args []string) error {
+	flags := flag.NewFlagSet("export", flag.ContinueOnError)
+	var exportOpts client.ExportOptions
+	var recipients, recipientsFiles stringsFlag
+	flags.StringVar(&exportOpts.Format, "format", "bitwarden", "export format: bitwarden (Bitwarden JSON) or kdbx (KeePass database)")
+	protect := flags.Bool("password", false, "password protect the export with a password that is prompted for")
+	sites := flags.String("site", "", "comma-separated sites to export")
+	tags := flags.String("tag", "", "comma-separated tags whose credentials to export")
+	flags.StringVar(&exportOpts.Query, "query", "", "export only credentials whose site, username, or notes contain this text")
+	flags.Var(&recipients, "recipient", "encrypt the export with age for this age or SSH public key (repeatable)")
+	flags.Var(&recipientsFiles, "recipients-file", "encrypt the export with age for the public keys in this file, one per line (repeatable)")
+	flags.BoolVar(&exportOpts.Armor, "armor", false, "write an age-encrypted export as ASCII-armored text")
+	out := flags.String("out", "-", "file to write the export to, or - for standard output")
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: mango [flags] export [-format bitwarden|kdbx] [-password] [-site S1,S2] [-tag T1,T2] [-query TEXT] [-recipient KEY]... [-recipients-file FILE]... [-armor] [-out FILE]")
+		flags.PrintDefaults()
+	}
+	if err := parseFlags(flags, args, 0); err != nil {
+		return err
+	}
+	if *sites != "" {
+		exportOpts.Sites = strings.Split(*sites, ",")
+	}
+	if *tags != "" {
+		exportOpts.Tags = strings.Split(*tags, ",")
+	}
+	for i, recipient := range recipients {
+		if _, err := agecrypt.ParseRecipient(recipient); err != nil {
+			return fmt.Errorf("recipient %d: %v", i+1, err)
+		}
+		exportOpts.Recipients = append(exportOpts.Recipients, recipient)
+	}
+	for _, name := range recipientsFiles {
+		f, err := os.Open(name)
+		if err != nil {
+			return err
+		}
+		fileRecipients, err := agecrypt.ReadRecipients(f)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("%s: %v", name, err)
+		}
+		exportOpts.Recipients = append(exportOpts.Recipients, fileRecipients...)
+	}
+	if exportOpts.Armor && len(exportOpts.Recipients) == 0 {
+		return errors.New("-armor requires -recipient or -recipients-file")
+	}
+	if len(exportOpts.Recipients) > 0 && !exportOpts.Armor && *out == "-" && term.IsTerminal(int(os.Stdout.Fd())) {
+		return errors.New("refusing to write an age-encrypted export to a terminal; use -armor or -out")
+	}
+	if *protect || strings.EqualFold(exportOpts.Format, "kdbx") {
+		var err error
+		if exportOpts.Password, err = promptNewPassword(false); err != nil {
+			return err
+		}
+	}
+
+	export, failed, err := c.Export(ctx, exportOpts)
+	if err != nil {
+		return err
+	}
+	if *out == "-" {
+		_, err = os.Stdout.Write(export)
+	} else {
+		err = os.WriteFile(*out, export, 0o600)
+	}
+	if err != nil {
+		return err
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d sites could not be decrypted and are missing from the export; see the server log", failed)
+	}
+	return nil
+}
+
+// stringsFlag is a flag that may be given more than once, collecting every value.
+type stringsFlag []string
+
+func (f *stringsFlag) String() string {
+	return strings.Join(*f, ", ")
+}
+
+func (f *stringsFlag) Set(value string) error {
+	*f = append(*f, value)
+	return nil
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"filippo.io/age"
+	"github.com/rihts-4/pasword-mango/agecrypt"
+	"github.com/rihts-4/pasword-mango/client"
+)
+
+// importCommand implements `import`, which sends an export file, or standard input for "-", to the server and
+// prints the outcome of every row that was not created as asked. It fails if any row failed, so that scripts notice.
+// The password of a password-protected export or a KeePass database is prompted for with -password. An export
+// encrypted with age is decrypted here with the -identity files, so that the identities never leave this machine.
+func importCommand(ctx context.Context, c *client.Client, opts options, args []string) error {
+	flags := flag.NewFlagSet("import", flag.ContinueOnError)
+	var importOpts client.ImportOptions
+	flags.StringVar(&importOpts.Format, "format", "", "export format: csv (Chrome, Edge, Firefox, or Safari), bitwarden (Bitwarden JSON), or kdbx (KeePass database); detected if omitted")
+	flags.StringVar(&importOpts.Conflict, "conflict", "skip", "for sites that already exist: skip, overwrite, or keep-both")
+	flags.BoolVar(&importOpts.DryRun, "dry-run", false, "only show what would be imported")
+	tags := flags.String("tags", "", "comma-separated tags to add to every imported entry")
+	protected := flags.Bool("password", false, "prompt for the password of a password-protected export or KeePass database")
+	var identityFiles stringsFlag
+	flags.Var(&identityFiles, "identity", "decrypt an age-encrypted export with the age secret keys or SSH private key in this file (repeatable)")
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: mango [flags] import [-format csv|bitwarden|kdbx] [-password] [-identity FILE]... [-conflict skip|overwrite|keep-both] [-dry-run] [-tags T1,T2] FILE")
+		flags.PrintDefaults()
+	}
+	if err := parseFlags(flags, args, 1); err != nil {
+		return err
+	}
+	if *tags != "" {
+		importOpts.Tags = strings.Split(*tags, ",")
+	}
+	if *protected && flags.Arg(0) == "-" {
+		return errors.New("the password is read from standard input, so a password-protected export must be a file")
+	}
+
+	var export []byte
+	var err error
+	if name := flags.Arg(0); name == "-" {
+		export, err = io.ReadAll(os.Stdin)
+	} else {
+		export, err = os.ReadFile(name)
+	}
+	if err != nil {
+		return err
+	}
+	if agecrypt.IsEncrypted(export) {
+		if export, err = decryptExport(export, identityFiles); err != nil {
+			return err
+		}
+	}
+	if *protected {
+		if importOpts.Password, err = promptPassword("Export password: "); err != nil {
+			return err
+		}
+	}
+
+	report, err := c.Import(ctx, export, importOpts)
+	if err != nil {
+		return err
+	}
+	if opts.output == "json" {
+		if err := writeJSON(report); err != nil {
+			return err
+		}
+	} else {
+		printImportReport(report)
+	}
+	if report.Failed > 0 {
+		return fmt.Errorf("%d rows failed to import", report.Failed)
+	}
+	return nil
+}
+
+// decryptExport decrypts an age-encrypted export with the identities in the named files. A passphrase-protected SSH
+// key's passphrase is prompted for when the key is needed.
+func decryptExport(export []byte, identityFiles []string) ([]byte, error) {
+	if len(identityFiles) == 0 {
+		return nil, errors.New("the export is encrypted with age; give the key to decrypt it with -identity")
+	}
+	var identities []age.Identity
+	for _, name := range identityFiles {
+		content, err := os.ReadFile(name)
+		if err != nil {
+			return nil, err
+		}
+		passphrase := func() ([]byte, error) {
+			passphrase, err := promptPassword(fmt.Sprintf("Passphrase for %s: ", name))
+			return []byte(passphrase), err
+		}
+		fileIdentities, err := agecrypt.ParseIdentities(content, passphrase)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", name, err)
+		}
+		identities = append(identities, fileIdentities...)
+	}
+	return agecrypt.Decrypt(export, identities)
+}
+
+// printImportReport prints the rows that were skipped or failed, then a summary. Created and updated rows are only
+// counted, since a migration may have hundreds of them.
+func printImportReport(report client.ImportReport) {
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	header := false
+	for _, row := range report.Rows {
+		if row.Action != "skipped" && row.Action != "failed" {
+			continue
+		}
+		if !header {
+			fmt.Fprintln(tw, "ROW\tSITE\tUSERNAME\tACTION\tREASON")
+			header = true
+		}
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%s\n", row.Row, row.Site, row.Username, row.Action, row.Reason)
+	}
+	tw.Flush()
+
+	verb := "Imported"
+	if report.DryRun {
+		verb = "Dry run, nothing stored. Would import"
+	}
+	fmt.Printf("%s %d rows of a %s export: %d created, %d updated, %d skipped, %d failed.\n",
+		verb, len(report.Rows), report.Format, report.Created, report.Updated, report.Skipped, report.Failed)
+}
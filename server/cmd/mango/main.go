@@ -0,0 +1,152 @@
+// Command mango is a command-line client for the Pasword Mango server. It talks to the same REST endpoints as the
+// Qt app, so that credentials can be managed and scripted without hand-written curl calls.
+//
+// Usage:
+//
+//	mango [flags] list
+//	mango [flags] get [-show | -password] SITE
+//	mango [flags] add [-username NAME] [-generate] SITE
+//	mango [flags] edit [-username NAME] [-generate] SITE
+//	mango [flags] rm [-yes] SITE
+//	mango [flags] import [-format csv|bitwarden|kdbx] [-password] [-identity FILE]... [-conflict skip|overwrite|keep-both] [-dry-run] [-tags T1,T2] FILE
+//	mango [flags] export [-format bitwarden|kdbx] [-password] [-site S1,S2] [-tag T1,T2] [-query TEXT] [-recipient KEY]... [-recipients-file FILE]... [-armor] [-out FILE]
+//	mango [flags] share [-text] [-views N] [-ttl DURATION] [SITE]
+//	mango [flags] unshare ID
+//	mango [flags] receive LINK
+//	mango [flags] generate [-length N] [-no-symbols]
+//	mango [flags] tui [-reveal-timeout DURATION]
+//	mango completion bash|zsh|fish
+//
+// Passwords are only written to standard output when asked for with `get -password` or `get -show`, or by `generate`
+// and `export`, or when a share is received.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+
+	"github.com/rihts-4/pasword-mango/client"
+)
+
+// Environment variables that provide defaults for the global flags.
+const (
+	serverEnvVar = "MANGO_SERVER"
+	caEnvVar     = "MANGO_CA"
+	certEnvVar   = "MANGO_CERT"
+	keyEnvVar    = "MANGO_KEY"
+)
+
+// errUsage reports a command line that could not be parsed; the command has already printed its usage.
+var errUsage = errors.New("usage")
+
+// options holds the global flags shared by every command.
+type options struct {
+	server string
+	ca     string
+	cert   string
+	key    string
+	output string
+}
+
+func main() {
+	var opts options
+	flags := flag.NewFlagSet("mango", flag.ExitOnError)
+	flags.StringVar(&opts.server, "server", envOr(serverEnvVar, client.DefaultBaseURL), "server URL, or unix:///path/to/socket ($"+serverEnvVar+")")
+	flags.StringVar(&opts.ca, "ca", os.Getenv(caEnvVar), "PEM file of the CA or certificate to trust for HTTPS ($"+caEnvVar+")")
+	flags.StringVar(&opts.cert, "cert", os.Getenv(certEnvVar), "client certificate for mutual TLS ($"+certEnvVar+")")
+	flags.StringVar(&opts.key, "key", os.Getenv(keyEnvVar), "client certificate private key for mutual TLS ($"+keyEnvVar+")")
+	flags.StringVar(&opts.output, "o", "table", "output format: table or json")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: mango [flags] list | get | add | edit | rm | import | export | share | unshare | receive | generate | tui | completion")
+		flags.PrintDefaults()
+	}
+	flags.Parse(os.Args[1:])
+	if flags.NArg() == 0 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	if opts.output != "table" && opts.output != "json" {
+		fmt.Fprintf(os.Stderr, "mango: unknown output format %q\n", opts.output)
+		os.Exit(2)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	command, args := flags.Arg(0), flags.Args()[1:]
+	var err error
+	switch command {
+	case "completion":
+		err = completionCommand(args)
+	case "generate":
+		err = generateCommand(opts, args)
+	case "receive":
+		err = receiveCommand(ctx, opts, args)
+	case "list", "get", "add", "edit", "rm", "import", "export", "share", "unshare", "tui", completeCommand:
+		var c *client.Client
+		c, err = client.New(client.Config{BaseURL: opts.server, CAFile: opts.ca, CertFile: opts.cert, KeyFile: opts.key})
+		if err != nil {
+			break
+		}
+		switch command {
+		case "list":
+			err = listCommand(ctx, c, opts, args)
+		case "get":
+			err = getCommand(ctx, c, opts, args)
+		case "add":
+			err = addCommand(ctx, c, args)
+		case "edit":
+			err = editCommand(ctx, c, args)
+		case "rm":
+			err = rmCommand(ctx, c, args)
+		case "import":
+			err = importCommand(ctx, c, opts, args)
+		case "export":
+			err = exportCommand(ctx, c, args)
+		case "share":
+			err = shareCommand(ctx, c, opts, args)
+		case "unshare":
+			err = unshareCommand(ctx, c, args)
+		case "tui":
+			err = tuiCommand(ctx, c, args)
+		case completeCommand:
+			err = completeSites(ctx, c)
+		}
+	default:
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	if errors.Is(err, errUsage) {
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "mango: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// envOr returns the value of the environment variable, or fallback if it is unset or empty.
+func envOr(name, fallback string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+	return fallback
+}
+
+// parseFlags parses a command's flags and checks that exactly want positional arguments remain.
+func parseFlags(flags *flag.FlagSet, args []string, want int) error {
+	flags.SetOutput(os.Stderr)
+	if err := flags.Parse(args); err != nil {
+		return errUsage
+	}
+	if flags.NArg() != want {
+		flags.Usage()
+		return errUsage
+	}
+	return nil
+}
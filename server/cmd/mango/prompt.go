@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"golang.org/x/term"
+)
+
+// stdin is shared by the prompts, so that buffered input is not lost between them when standard input is a pipe.
+var stdin = bufio.NewReader(os.Stdin)
+
+// isTerminal reports whether standard input is a terminal.
+func isTerminal() bool {
+	return term.IsTerminal(int(os.Stdin.Fd()))
+}
+
+// promptLine prompts on standard error and reads a line of standard input.
+func promptLine(prompt string) (string, error) {
+	if isTerminal() {
+		fmt.Fprint(os.Stderr, prompt)
+	}
+	line, err := stdin.ReadString('\n')
+	if err != nil && !(errors.Is(err, io.EOF) && line != "") {
+		return "", fmt.Errorf("error reading input: %v", err)
+	}
+	return strings.TrimSpace(line), nil
+}
+
+// promptPassword prompts on standard error and reads a password without echoing it. If standard input is not a
+// terminal, it reads a line instead, so that passwords can be piped in.
+func promptPassword(prompt string) (string, error) {
+	if !isTerminal() {
+		return promptLine(prompt)
+	}
+	fmt.Fprint(os.Stderr, prompt)
+	password, err := term.ReadPassword(int(os.Stdin.Fd()))
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", fmt.Errorf("error reading password: %v", err)
+	}
+	return strings.TrimSpace(string(password)), nil
+}
+
+// promptNewPassword reads a new password, asking for it twice on a terminal to catch typos.
+// If optional is set, an empty password is accepted and means "keep the current one".
+func promptNewPassword(optional bool) (string, error) {
+	prompt := "Password: "
+	if optional {
+		prompt = "New password (empty to keep): "
+	}
+	password, err := promptPassword(prompt)
+	if err != nil {
+		return "", err
+	}
+	if password == "" {
+		if optional {
+			return "", nil
+		}
+		return "", errors.New("password cannot be empty")
+	}
+	if isTerminal() {
+		again, err := promptPassword("Repeat password: ")
+		if err != nil {
+			return "", err
+		}
+		if again != password {
+			return "", errors.New("passwords do not match")
+		}
+	}
+	return password, nil
+}
+
+// confirm asks a yes/no question on a terminal. Without a terminal nothing can be confirmed, so it fails.
+func confirm(question string) (bool, error) {
+	if !isTerminal() {
+		return false, errors.New("standard input is not a terminal; use -yes to confirm")
+	}
+	answer, err := promptLine(question + " [y/N] ")
+	if err != nil {
+		return false, err
+	}
+	answer = strings.ToLower(answer)
+	return answer == "y" || answer == "yes", nil
+}
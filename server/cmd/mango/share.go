@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/rihts-4/pasword-mango/client"
+	"github.com/rihts-4/pasword-mango/share"
+)
+
+// shareCommand implements `share`, which creates a one-time share link for a site's credentials, or with -text for
+// a secret read from standard input, and prints the link. Whoever has the link can open the share without access to
+// the vault, until it has been opened -views times or -ttl has passed.
+func shareCommand(ctx context.Context, c *client.Client, opts options, args []string) error {
+	flags := flag.NewFlagSet("share", flag.ContinueOnError)
+	var shareOpts client.ShareOptions
+	text := flags.Bool("text", false, "share text read from standard input instead of a site's credentials")
+	flags.IntVar(&shareOpts.MaxViews, "views", 1, "how often the share can be opened")
+	flags.DurationVar(&shareOpts.TTL, "ttl", 0, "how long the share lives (default the server's default)")
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: mango [flags] share [-views N] [-ttl DURATION] SITE")
+		fmt.Fprintln(os.Stderr, "       mango [flags] share -text [-views N] [-ttl DURATION]")
+		flags.PrintDefaults()
+	}
+	flags.SetOutput(os.Stderr)
+	if err := flags.Parse(args); err != nil {
+		return errUsage
+	}
+	want := 1
+	if *text {
+		want = 0
+	}
+	if flags.NArg() != want {
+		flags.Usage()
+		return errUsage
+	}
+
+	if *text {
+		var err error
+		if shareOpts.Text, err = readShareText(); err != nil {
+			return err
+		}
+		if shareOpts.Text == "" {
+			return errors.New("nothing to share")
+		}
+	} else {
+		shareOpts.Site = flags.Arg(0)
+	}
+
+	created, err := c.CreateShare(ctx, shareOpts)
+	if err != nil {
+		return err
+	}
+	if opts.output == "json" {
+		return writeJSON(created)
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tVIEWS\tEXPIRES\tURL")
+	fmt.Fprintf(tw, "%s\t%d\t%s\t%s\n", created.ID, created.MaxViews, created.ExpiresAt.Local().Format("2006-01-02 15:04"), created.URL)
+	return tw.Flush()
+}
+
+// readShareText reads the text of a share: prompted for without echo on a terminal, or all of standard input
+// otherwise, so that multi-line secrets can be piped in.
+func readShareText() (string, error) {
+	if isTerminal() {
+		return promptPassword("Text: ")
+	}
+	text, err := io.ReadAll(stdin)
+	if err != nil {
+		return "", fmt.Errorf("error reading input: %v", err)
+	}
+	return strings.TrimRight(string(text), "\r\n"), nil
+}
+
+// unshareCommand implements `unshare`, which deletes a share before it is spent.
+func unshareCommand(ctx context.Context, c *client.Client, args []string) error {
+	flags := flag.NewFlagSet("unshare", flag.ContinueOnError)
+	flags.Usage = func() { fmt.Fprintln(os.Stderr, "usage: mango [flags] unshare ID") }
+	if err := parseFlags(flags, args, 1); err != nil {
+		return err
+	}
+	if err := c.DeleteShare(ctx, flags.Arg(0)); err != nil {
+		return err
+	}
+	fmt.Fprintln(os.Stderr, "Deleted the share.")
+	return nil
+}
+
+// receiveCommand implements `receive`, which opens a share link, counting a view, and prints what it holds. It talks
+// to the server in the link rather than -server, and needs no client certificate, though -ca is honored.
+func receiveCommand(ctx context.Context, opts options, args []string) error {
+	flags := flag.NewFlagSet("receive", flag.ContinueOnError)
+	flags.Usage = func() { fmt.Fprintln(os.Stderr, "usage: mango [flags] receive LINK") }
+	if err := parseFlags(flags, args, 1); err != nil {
+		return err
+	}
+	baseURL, id, key, err := share.ParseLink(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	c, err := client.New(client.Config{BaseURL: baseURL, CAFile: opts.ca})
+	if err != nil {
+		return err
+	}
+
+	opened, err := c.OpenShare(ctx, id, key)
+	if errors.Is(err, client.ErrNotFound) {
+		return errors.New("the share does not exist, has expired, or has already been opened")
+	}
+	if err != nil {
+		return err
+	}
+	if opts.output == "json" {
+		return writeJSON(opened.Content)
+	}
+	if opened.Text != "" {
+		fmt.Println(opened.Text)
+	} else {
+		tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "SITE\tUSERNAME\tPASSWORD")
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", opened.Site, opened.Username, opened.Password)
+		if err := tw.Flush(); err != nil {
+			return err
+		}
+	}
+	if left := opened.MaxViews - opened.Views; left > 0 {
+		fmt.Fprintf(os.Stderr, "The share can be opened %d more times until %s.\n", left, opened.ExpiresAt.Local().Format("2006-01-02 15:04"))
+	} else {
+		fmt.Fprintln(os.Stderr, "The share has now been deleted.")
+	}
+	return nil
+}
@@ -0,0 +1,532 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/bubbles/textinput"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/rihts-4/pasword-mango/client"
+	"github.com/rihts-4/pasword-mango/generate"
+)
+
+// defaultRevealTimeout is how long a revealed password stays on screen unless told otherwise.
+const defaultRevealTimeout = 15 * time.Second
+
+// tuiCommand implements `tui`, an interactive terminal interface for browsing and editing credentials.
+func tuiCommand(ctx context.Context, c *client.Client, args []string) error {
+	flags := flag.NewFlagSet("tui", flag.ContinueOnError)
+	revealTimeout := flags.Duration("reveal-timeout", defaultRevealTimeout, "hide a revealed password after this long")
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: mango [flags] tui [-reveal-timeout DURATION]")
+		flags.PrintDefaults()
+	}
+	if err := parseFlags(flags, args, 0); err != nil {
+		return err
+	}
+	if !isTerminal() {
+		return fmt.Errorf("standard input is not a terminal")
+	}
+
+	_, err := tea.NewProgram(newTUIModel(ctx, c, *revealTimeout), tea.WithAltScreen(), tea.WithContext(ctx)).Run()
+	return err
+}
+
+// tuiScreen is the screen the interface is showing.
+type tuiScreen int
+
+const (
+	screenList tuiScreen = iota
+	screenDetail
+	screenForm
+)
+
+// Form fields, in tab order.
+const (
+	fieldSite = iota
+	fieldUsername
+	fieldPassword
+	fieldCount
+)
+
+// Messages delivered to Update when a request finishes or a timer fires.
+type (
+	sitesMsg struct {
+		sites []string
+		err   error
+	}
+	detailMsg struct {
+		creds  client.Credentials
+		reveal bool
+		err    error
+	}
+	savedMsg struct {
+		site    string
+		created bool
+		err     error
+	}
+	deletedMsg struct {
+		site string
+		err  error
+	}
+	// hideMsg hides the password revealed with the matching generation, unless it was hidden already.
+	hideMsg struct{ generation int }
+)
+
+var (
+	titleStyle    = lipgloss.NewStyle().Bold(true)
+	selectedStyle = lipgloss.NewStyle().Reverse(true)
+	helpStyle     = lipgloss.NewStyle().Faint(true)
+	errorStyle    = lipgloss.NewStyle().Foreground(lipgloss.Color("9"))
+)
+
+// tuiModel is the Bubble Tea model of the interface. Passwords are only held in memory while revealed or while
+// being entered in the form; the details screen keeps the username alone.
+type tuiModel struct {
+	ctx           context.Context
+	client        *client.Client
+	revealTimeout time.Duration
+
+	screen tuiScreen
+	status string
+	err    error
+	height int
+
+	// List screen
+	sites    []string
+	filtered []string
+	cursor   int
+	search   textinput.Model
+
+	// Details screen
+	detail     client.Credentials
+	revealed   bool
+	generation int
+	confirm    string // "reveal" or "delete" while awaiting y/n
+
+	// Form screen
+	inputs  [fieldCount]textinput.Model
+	focus   int
+	editing bool
+}
+
+func newTUIModel(ctx context.Context, c *client.Client, revealTimeout time.Duration) *tuiModel {
+	search := textinput.New()
+	search.Prompt = "Search: "
+	search.Focus()
+
+	m := &tuiModel{ctx: ctx, client: c, revealTimeout: revealTimeout, search: search}
+	for i := range m.inputs {
+		m.inputs[i] = textinput.New()
+	}
+	m.inputs[fieldSite].Prompt = "Site:     "
+	m.inputs[fieldSite].CharLimit = client.MaxSiteLength
+	m.inputs[fieldUsername].Prompt = "Username: "
+	m.inputs[fieldUsername].CharLimit = client.MaxUsernameLength
+	m.inputs[fieldPassword].Prompt = "Password: "
+	m.inputs[fieldPassword].CharLimit = client.MaxPasswordLength
+	m.inputs[fieldPassword].EchoMode = textinput.EchoPassword
+	m.inputs[fieldPassword].EchoCharacter = '*'
+	return m
+}
+
+func (m *tuiModel) Init() tea.Cmd {
+	return tea.Batch(textinput.Blink, m.loadSites())
+}
+
+// loadSites fetches the site names.
+func (m *tuiModel) loadSites() tea.Cmd {
+	return func() tea.Msg {
+		sites, err := m.client.List(m.ctx)
+		slices.Sort(sites)
+		return sitesMsg{sites, err}
+	}
+}
+
+// loadDetail fetches a site's credentials. Unless reveal is set, the password is dropped as soon as it arrives.
+// The details keep the name they were asked for, which the later reveal, edit, and delete go to.
+func (m *tuiModel) loadDetail(site string, reveal bool) tea.Cmd {
+	return func() tea.Msg {
+		creds, err := m.client.Get(m.ctx, site)
+		creds.Site = site
+		if !reveal {
+			creds.Password = ""
+		}
+		return detailMsg{creds, reveal, err}
+	}
+}
+
+func (m *tuiModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tea.WindowSizeMsg:
+		m.height = msg.Height
+		return m, nil
+
+	case tea.KeyMsg:
+		if msg.Type == tea.KeyCtrlC {
+			return m, tea.Quit
+		}
+		m.err = nil
+		switch m.screen {
+		case screenList:
+			return m.updateList(msg)
+		case screenDetail:
+			return m.updateDetail(msg)
+		case screenForm:
+			return m.updateForm(msg)
+		}
+
+	case sitesMsg:
+		m.err = msg.err
+		if msg.err == nil {
+			m.sites = msg.sites
+			m.applyFilter()
+		}
+		return m, nil
+
+	case detailMsg:
+		if msg.err != nil {
+			m.err = msg.err
+			return m, nil
+		}
+		m.detail = msg.creds
+		m.screen = screenDetail
+		if msg.reveal {
+			m.revealed = true
+			m.generation++
+			generation := m.generation
+			return m, tea.Tick(m.revealTimeout, func(time.Time) tea.Msg { return hideMsg{generation} })
+		}
+		return m, nil
+
+	case hideMsg:
+		if msg.generation == m.generation {
+			m.hide()
+		}
+		return m, nil
+
+	case savedMsg:
+		if msg.err != nil {
+			m.err = msg.err
+			return m, nil
+		}
+		m.clearForm()
+		m.status = fmt.Sprintf("Saved credentials for %s.", msg.site)
+		if msg.created {
+			m.screen = screenList
+			return m, m.loadSites()
+		}
+		return m, m.loadDetail(msg.site, false)
+
+	case deletedMsg:
+		if msg.err != nil {
+			m.err = msg.err
+			return m, nil
+		}
+		m.leaveDetail()
+		m.status = fmt.Sprintf("Deleted credentials for %s.", msg.site)
+		return m, m.loadSites()
+	}
+
+	if m.screen == screenList {
+		var cmd tea.Cmd
+		m.search, cmd = m.search.Update(msg)
+		return m, cmd
+	}
+	return m, nil
+}
+
+// updateList handles keys on the list screen. Keys other than the ones below edit the search, which filters as
+// it is typed.
+func (m *tuiModel) updateList(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	switch msg.Type {
+	case tea.KeyUp:
+		m.cursor = max(m.cursor-1, 0)
+		return m, nil
+	case tea.KeyDown:
+		m.cursor = min(m.cursor+1, max(len(m.filtered)-1, 0))
+		return m, nil
+	case tea.KeyEnter:
+		if len(m.filtered) > 0 {
+			m.status = ""
+			return m, m.loadDetail(m.filtered[m.cursor], false)
+		}
+		return m, nil
+	case tea.KeyCtrlA:
+		m.status = ""
+		return m, m.openForm(client.Credentials{}, false)
+	case tea.KeyCtrlR:
+		return m, m.loadSites()
+	case tea.KeyEsc:
+		if m.search.Value() == "" {
+			return m, tea.Quit
+		}
+		m.search.SetValue("")
+		m.applyFilter()
+		return m, nil
+	}
+
+	var cmd tea.Cmd
+	m.search, cmd = m.search.Update(msg)
+	m.applyFilter()
+	return m, cmd
+}
+
+// applyFilter narrows the site list to names containing the search text, ignoring case.
+func (m *tuiModel) applyFilter() {
+	query := strings.ToLower(strings.TrimSpace(m.search.Value()))
+	m.filtered = m.filtered[:0]
+	for _, site := range m.sites {
+		if strings.Contains(strings.ToLower(site), query) {
+			m.filtered = append(m.filtered, site)
+		}
+	}
+	m.cursor = min(m.cursor, max(len(m.filtered)-1, 0))
+}
+
+// updateDetail handles keys on the details screen. Revealing and deleting ask for confirmation first, as the Qt
+// app does.
+func (m *tuiModel) updateDetail(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	if m.confirm != "" {
+		action := m.confirm
+		m.confirm = ""
+		if msg.String() != "y" {
+			return m, nil
+		}
+		if action == "reveal" {
+			return m, m.loadDetail(m.detail.Site, true)
+		}
+		site := m.detail.Site
+		return m, func() tea.Msg { return deletedMsg{site, m.client.Delete(m.ctx, site)} }
+	}
+
+	switch msg.String() {
+	case "r", " ":
+		if m.revealed {
+			m.hide()
+		} else {
+			m.confirm = "reveal"
+		}
+	case "e":
+		m.status = ""
+		username := m.detail.Username
+		m.hide()
+		return m, m.openForm(client.Credentials{Site: m.detail.Site, Username: username}, true)
+	case "d":
+		m.confirm = "delete"
+	case "esc", "q":
+		m.leaveDetail()
+	}
+	return m, nil
+}
+
+// hide masks the revealed password and drops it from memory.
+func (m *tuiModel) hide() {
+	m.revealed = false
+	m.generation++
+	m.detail.Password = ""
+}
+
+// leaveDetail returns to the list, forgetting the credentials that were shown.
+func (m *tuiModel) leaveDetail() {
+	m.hide()
+	m.detail = client.Credentials{}
+	m.confirm = ""
+	m.screen = screenList
+}
+
+// openForm shows the add or edit form. When editing, the site cannot be changed and an empty password keeps the
+// current one.
+func (m *tuiModel) openForm(creds client.Credentials, editing bool) tea.Cmd {
+	m.clearForm()
+	m.editing = editing
+	m.inputs[fieldSite].SetValue(creds.Site)
+	m.inputs[fieldUsername].SetValue(creds.Username)
+	m.focus = fieldSite
+	if editing {
+		m.focus = fieldUsername
+		m.inputs[fieldPassword].Placeholder = "unchanged"
+	}
+	m.screen = screenForm
+	return m.inputs[m.focus].Focus()
+}
+
+// clearForm empties the form, including any password typed into it.
+func (m *tuiModel) clearForm() {
+	for i := range m.inputs {
+		m.inputs[i].SetValue("")
+		m.inputs[i].Placeholder = ""
+		m.inputs[i].Blur()
+	}
+}
+
+// updateForm handles keys on the add and edit form.
+func (m *tuiModel) updateForm(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	first := fieldSite
+	if m.editing {
+		first = fieldUsername
+	}
+	move := func(delta int) tea.Cmd {
+		m.inputs[m.focus].Blur()
+		m.focus += delta
+		if m.focus < first {
+			m.focus = fieldCount - 1
+		} else if m.focus >= fieldCount {
+			m.focus = first
+		}
+		return m.inputs[m.focus].Focus()
+	}
+
+	switch msg.Type {
+	case tea.KeyEsc:
+		m.clearForm()
+		if m.editing {
+			m.screen = screenDetail
+		} else {
+			m.screen = screenList
+		}
+		return m, nil
+	case tea.KeyTab, tea.KeyDown:
+		return m, move(1)
+	case tea.KeyShiftTab, tea.KeyUp:
+		return m, move(-1)
+	case tea.KeyCtrlG:
+		password, err := generate.Password(generate.DefaultLength)
+		if err != nil {
+			m.err = err
+			return m, nil
+		}
+		m.inputs[fieldPassword].SetValue(password)
+		return m, nil
+	case tea.KeyCtrlS:
+		return m, m.submit()
+	case tea.KeyEnter:
+		if m.focus == fieldCount-1 {
+			return m, m.submit()
+		}
+		return m, move(1)
+	}
+
+	var cmd tea.Cmd
+	m.inputs[m.focus], cmd = m.inputs[m.focus].Update(msg)
+	return m, cmd
+}
+
+// submit validates the form as the server would and sends it.
+func (m *tuiModel) submit() tea.Cmd {
+	creds := client.Credentials{
+		Site:     strings.TrimSpace(m.inputs[fieldSite].Value()),
+		Username: strings.TrimSpace(m.inputs[fieldUsername].Value()),
+		Password: strings.TrimSpace(m.inputs[fieldPassword].Value()),
+	}
+	keepPassword := m.editing && creds.Password == ""
+
+	check := creds
+	if keepPassword {
+		check.Password = "unchanged"
+	}
+	if err := check.Validate(); err != nil {
+		m.err = err
+		return nil
+	}
+
+	if !m.editing {
+		return func() tea.Msg { return savedMsg{creds.Site, true, m.client.Create(m.ctx, creds)} }
+	}
+	return func() tea.Msg {
+		// The API replaces both fields, so an unchanged password is fetched just before the update
+		if keepPassword {
+			current, err := m.client.Get(m.ctx, creds.Site)
+			if err != nil {
+				return savedMsg{creds.Site, false, err}
+			}
+			creds.Password = current.Password
+		}
+		return savedMsg{creds.Site, false, m.client.Update(m.ctx, creds)}
+	}
+}
+
+func (m *tuiModel) View() string {
+	var b strings.Builder
+	switch m.screen {
+	case screenList:
+		m.viewList(&b)
+	case screenDetail:
+		m.viewDetail(&b)
+	case screenForm:
+		m.viewForm(&b)
+	}
+
+	b.WriteString("\n")
+	if m.err != nil {
+		b.WriteString(errorStyle.Render(m.err.Error()) + "\n")
+	} else if m.status != "" {
+		b.WriteString(m.status + "\n")
+	}
+	return b.String()
+}
+
+func (m *tuiModel) viewList(b *strings.Builder) {
+	b.WriteString(titleStyle.Render("Pasword Mango") + "\n\n")
+	b.WriteString(m.search.View() + "\n\n")
+
+	// Keep the cursor on screen, leaving room for the header and footer
+	visible := max(m.height-9, 5)
+	start := max(0, m.cursor-visible+1)
+	end := min(len(m.filtered), start+visible)
+	if len(m.filtered) == 0 {
+		b.WriteString(helpStyle.Render("  No matching sites") + "\n")
+	}
+	for i := start; i < end; i++ {
+		line := "  " + m.filtered[i]
+		if i == m.cursor {
+			line = selectedStyle.Render(line)
+		}
+		b.WriteString(line + "\n")
+	}
+	b.WriteString("\n" + helpStyle.Render(fmt.Sprintf("%d of %d sites · ↑/↓ select · enter open · ctrl+a add · ctrl+r reload · esc clear/quit", len(m.filtered), len(m.sites))) + "\n")
+}
+
+func (m *tuiModel) viewDetail(b *strings.Builder) {
+	b.WriteString(titleStyle.Render(m.detail.Site) + "\n\n")
+	password := maskedPassword
+	if m.revealed {
+		password = m.detail.Password
+	}
+	fmt.Fprintf(b, "  Username: %s\n  Password: %s\n\n", m.detail.Username, password)
+
+	switch m.confirm {
+	case "reveal":
+		b.WriteString("Are you sure you want to show the password in plain text? (y/n)\n")
+	case "delete":
+		fmt.Fprintf(b, "Are you sure you want to delete the credentials for %s? (y/n)\n", m.detail.Site)
+	default:
+		reveal := "r reveal"
+		if m.revealed {
+			reveal = fmt.Sprintf("r hide (hides itself after %s)", m.revealTimeout)
+		}
+		b.WriteString(helpStyle.Render(reveal+" · e edit · d delete · esc back") + "\n")
+	}
+}
+
+func (m *tuiModel) viewForm(b *strings.Builder) {
+	title := "Add credentials"
+	if m.editing {
+		title = "Edit credentials"
+	}
+	b.WriteString(titleStyle.Render(title) + "\n\n")
+	for i := range m.inputs {
+		if i == fieldSite && m.editing {
+			fmt.Fprintf(b, "%s%s\n", m.inputs[i].Prompt, m.inputs[i].Value())
+			continue
+		}
+		b.WriteString(m.inputs[i].View() + "\n")
+	}
+	b.WriteString("\n" + helpStyle.Render("tab next field · ctrl+g generate password · enter/ctrl+s save · esc cancel") + "\n")
+}
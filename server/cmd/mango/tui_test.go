@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+
+	"github.com/rihts-4/pasword-mango/client"
+)
+
+// press sends keys to the model and runs the command of the last one, feeding its message back to the model.
+func press(m *tuiModel, keys ...string) {
+	var cmd tea.Cmd
+	for _, key := range keys {
+		_, cmd = m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune(key)})
+	}
+	if cmd != nil {
+		m.Update(cmd())
+	}
+}
+
+func TestTUIDetailKeepsSite(t *testing.T) {
+	// The server leaves the site out of its responses, so the model has to remember the name it asked for
+	s := &testServer{sites: map[string]client.Credentials{"example.com": {Username: "alice", Password: "secret"}}, omitSite: true}
+	m := newTUIModel(context.Background(), newTestClient(t, s), time.Minute)
+	m.Update(m.loadDetail("example.com", false)())
+
+	if m.detail.Site != "example.com" || m.detail.Password != "" {
+		t.Fatalf("details are %+v, want example.com without the password", m.detail)
+	}
+	if view := m.View(); !strings.Contains(view, "example.com") {
+		t.Errorf("details screen does not show the site:\n%s", view)
+	}
+
+	press(m, "r", "y")
+	if !m.revealed || m.detail.Password != "secret" {
+		t.Errorf("after revealing, details are %+v", m.detail)
+	}
+	press(m, "d")
+	if view := m.View(); !strings.Contains(view, "delete the credentials for example.com?") {
+		t.Errorf("delete confirmation does not name the site:\n%s", view)
+	}
+	press(m, "y")
+
+	want := []string{"GET /credentials/example.com", "GET /credentials/example.com", "DELETE /credentials/example.com"}
+	if got := s.requests(); !slices.Equal(got[:3], want) {
+		t.Errorf("requests %q, want %q first", got, want)
+	}
+}
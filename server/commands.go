@@ -0,0 +1,383 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/debug"
+	"time"
+
+	"github.com/rihts-4/pasword-mango/config"
+	"github.com/rihts-4/pasword-mango/data"
+	"github.com/rihts-4/pasword-mango/pwned"
+)
+
+// version is the release version, set at build time with -ldflags "-X main.version=v1.2.3".
+var version = "dev"
+
+// defaultKeyFile is where `init` writes the encryption key unless told otherwise.
+const defaultKeyFile = "mango.key"
+
+// backendCheckTimeout bounds each round trip `init` and `doctor` make to the storage backend, so that an
+// unreachable backend is reported instead of hanging.
+const backendCheckTimeout = 15 * time.Second
+
+// versionCommand implements `version`, which prints the release version, the commit it was built from, and the
+// Go toolchain and platform. It returns the process exit code.
+func versionCommand() int {
+	commit := "unknown commit"
+	if info, ok := debug.ReadBuildInfo(); ok {
+		var revision, modified string
+		for _, setting := range info.Settings {
+			switch setting.Key {
+			case "vcs.revision":
+				revision = setting.Value
+			case "vcs.modified":
+				modified = setting.Value
+			}
+		}
+		if len(revision) > 12 {
+			revision = revision[:12]
+		}
+		if revision != "" {
+			commit = "commit " + revision
+			if modified == "true" {
+				commit += "-dirty"
+			}
+		}
+	}
+	fmt.Printf("pasword-mango %s (%s, %s %s/%s)\n", version, commit, runtime.Version(), runtime.GOOS, runtime.GOARCH)
+	return 0
+}
+
+// initCommand implements `init`, which sets up a new vault: it generates an encryption key with crypto/rand,
+// writes it to a key file only the current user can read, writes a configuration file pointing at it, and
+// records the vault metadata, including a canary encrypted with the new key, in the storage backend.
+//
+// It refuses to overwrite an existing key file and to initialize a vault that already holds data, since
+// either would make the existing credentials unreadable. It returns the process exit code.
+func initCommand(ctx context.Context, cfg config.Config, loadErr error, configFile string, args []string) int {
+	flags := flag.NewFlagSet("init", flag.ContinueOnError)
+	keyFile := flags.String("key-file", defaultKeyFile, "write the new encryption key to this file")
+	force := flags.Bool("force", false, "overwrite an existing configuration file")
+	noVault := flags.Bool("no-vault", false, "only write the key and configuration files; do not contact the storage backend")
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: pasword-mango [flags] init [-key-file PATH] [-force] [-no-vault]")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil || flags.NArg() != 0 {
+		return 2
+	}
+
+	// The new configuration reads the key from the new file, so problems with the current key source do not matter
+	var problems []error
+	for _, problem := range configProblems(loadErr) {
+		var fieldErr *config.FieldError
+		if !errors.As(problem, &fieldErr) || fieldErr.Section() != "key" {
+			problems = append(problems, problem)
+		}
+	}
+	if len(problems) > 0 {
+		printConfigProblems(errors.Join(problems...))
+		return 1
+	}
+	cfg.Key = config.Key{Source: config.KeySourceFile, File: *keyFile, Credential: cfg.Key.Credential}
+	if _, err := os.Lstat(*keyFile); err == nil {
+		log.Printf("Key file %s already exists; remove it or choose another with -key-file.", *keyFile)
+		return 1
+	}
+	writeConfig := *force
+	if _, err := os.Stat(configFile); errors.Is(err, fs.ErrNotExist) {
+		writeConfig = true
+	}
+
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		log.Printf("Failed to generate encryption key: %v", err)
+		return 1
+	}
+
+	// Check the vault before writing anything, so that a failure leaves no half-initialized state behind
+	if !*noVault {
+		if err := data.OpenDB(ctx, cfg.Storage, key); err != nil {
+			log.Printf("Failed to initialize database: %v", err)
+			return 1
+		}
+		defer data.CloseDB()
+
+		checkCtx, cancel := context.WithTimeout(ctx, backendCheckTimeout)
+		_, err := data.CheckKey(checkCtx)
+		cancel()
+		switch {
+		case errors.Is(err, data.ErrVaultNotInitialized):
+		case err == nil, errors.Is(err, data.ErrWrongKey):
+			log.Println("The vault already holds data encrypted with another key; refusing to initialize it again.")
+			return 1
+		default:
+			log.Printf("Failed to reach the storage backend: %v", err)
+			return 1
+		}
+	}
+
+	if err := writeKeyFile(*keyFile, key); err != nil {
+		log.Printf("Failed to write key file: %v", err)
+		return 1
+	}
+	fmt.Printf("Wrote encryption key to %s. Back it up: without it the vault cannot be decrypted.\n", *keyFile)
+
+	if writeConfig {
+		if err := writeConfigFile(configFile, cfg); err != nil {
+			log.Printf("Failed to write configuration file: %v", err)
+			return 1
+		}
+		fmt.Printf("Wrote configuration to %s.\n", configFile)
+	} else {
+		fmt.Printf("Left %s unchanged; set key.file to %s in it, or run init with -force to replace it.\n", configFile, *keyFile)
+	}
+
+	if *noVault {
+		return 0
+	}
+	createCtx, cancel := context.WithTimeout(ctx, backendCheckTimeout)
+	defer cancel()
+	meta, err := data.CreateVaultMeta(createCtx)
+	if err != nil {
+		log.Printf("Failed to create vault metadata: %v", err)
+		return 1
+	}
+	fmt.Printf("Initialized vault (format version %d).\n", meta.FormatVersion)
+	return 0
+}
+
+// writeKeyFile writes a hex-encoded key to a new file that only the current user can read.
+func writeKeyFile(path string, key []byte) error {
+	if dir := filepath.Dir(path); dir != "." {
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			return err
+		}
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintln(f, hex.EncodeToString(key)); err != nil {
+		f.Close()
+		os.Remove(path)
+		return err
+	}
+	return f.Close()
+}
+
+// writeConfigFile writes cfg in the configuration file format. Secrets that came from the environment are
+// left out, so that the file never holds more than the environment did.
+func writeConfigFile(path string, cfg config.Config) error {
+	cfg.Key.Hex = ""
+	cfg.Admin.Token = ""
+	content, err := cfg.YAML()
+	if err != nil {
+		return err
+	}
+	header := "# Pasword Mango configuration, written by `pasword-mango init`.\n# Environment variables and flags override these settings.\n"
+	return os.WriteFile(path, append([]byte(header), content...), 0600)
+}
+
+// doctorCheck is the outcome of one `doctor` check.
+type doctorCheck struct {
+	status string // "ok", "warn", or "FAIL"
+	name   string
+	detail string
+}
+
+// doctorCommand implements `doctor`, which checks the configuration, that the encryption key loads and is valid,
+// that the storage backend is reachable, that the key decrypts the vault's canary record, that secret files,
+// including the backup passphrase file, are not accessible to other users, and that the Pwned Passwords file, if
+// configured, can be read. It prints one line per check and returns 1 if any check failed.
+func doctorCommand(ctx context.Context, cfg config.Config, loadErr error, configFile, envFile string) int {
+	var checks []doctorCheck
+	add := func(status, name, format string, args ...any) {
+		checks = append(checks, doctorCheck{status, name, fmt.Sprintf(format, args...)})
+	}
+	defer func() {
+		failed := false
+		for _, c := range checks {
+			fmt.Printf("[%-4s] %-16s %s\n", c.status, c.name, c.detail)
+			failed = failed || c.status == "FAIL"
+		}
+		if failed {
+			fmt.Println("Some checks failed.")
+		}
+	}()
+
+	// The remaining checks run even if the configuration is invalid, since they may explain the problem
+	for _, problem := range configProblems(loadErr) {
+		add("FAIL", "configuration", "%v", problem)
+	}
+	switch {
+	case loadErr != nil:
+	case configFile != "":
+		add("ok", "configuration", "loaded from %s", configFile)
+	default:
+		add("ok", "configuration", "defaults and environment (no configuration file)")
+	}
+
+	for _, f := range secretFiles(cfg, configFile, envFile) {
+		checks = append(checks, checkFileMode(f.path, f.mask))
+	}
+	if path := cfg.Passwords.BreachFile; path != "" {
+		if f, err := pwned.Open(path); err != nil {
+			add("FAIL", "breach file", "%v", err)
+		} else {
+			add("ok", "breach file", "%s (%s hashes)", path, f.Format())
+			f.Close()
+		}
+	}
+
+	key, source, err := data.LoadKey(ctx, cfg.Key)
+	if err != nil {
+		add("FAIL", "encryption key", "%v", err)
+		return 1
+	}
+	add("ok", "encryption key", "loaded from %s", source)
+
+	if err := data.OpenDB(ctx, cfg.Storage, key); err != nil {
+		add("FAIL", "backend", "%v", err)
+		return 1
+	}
+	defer data.CloseDB()
+
+	checkCtx, cancel := context.WithTimeout(ctx, backendCheckTimeout)
+	defer cancel()
+	meta, err := data.GetVaultMeta(checkCtx)
+	switch {
+	case err == nil:
+		add("ok", "backend", "reachable; vault created %s, format version %d", meta.CreatedAt.Format(time.DateOnly), meta.FormatVersion)
+	case errors.Is(err, data.ErrVaultNotInitialized):
+		add("warn", "backend", "reachable; vault has no metadata (run init to create it)")
+	default:
+		add("FAIL", "backend", "%v", err)
+		return 1
+	}
+
+	target, err := data.CheckKey(checkCtx)
+	switch {
+	case err == nil:
+		add("ok", "canary", "decrypted %s", target)
+	case errors.Is(err, data.ErrVaultNotInitialized):
+		add("warn", "canary", "vault is empty; nothing to decrypt")
+	default:
+		add("FAIL", "canary", "%s: %v", target, err)
+		return 1
+	}
+
+	for _, c := range checks {
+		if c.status == "FAIL" {
+			return 1
+		}
+	}
+	return 0
+}
+
+// secretFile is a file doctor checks the permissions of; mask holds the permission bits it must not have.
+type secretFile struct {
+	path string
+	mask fs.FileMode
+}
+
+// secretFiles lists the files holding or guarding secrets that exist in this configuration.
+func secretFiles(cfg config.Config, configFile, envFile string) []secretFile {
+	var files []secretFile
+	if configFile != "" {
+		// The configuration file may hold the admin token, and decides where the key comes from
+		files = append(files, secretFile{configFile, 0o022})
+		if cfg.Admin.Token != "" || cfg.Key.Hex != "" {
+			files[len(files)-1].mask = 0o077
+		}
+	}
+	if envFile != "" {
+		files = append(files, secretFile{envFile, 0o077})
+	}
+	if cfg.Storage.CredentialsFile != "" && cfg.Storage.EmulatorHost == "" {
+		files = append(files, secretFile{cfg.Storage.CredentialsFile, 0o077})
+	}
+	if cfg.TLS.KeyFile != "" {
+		files = append(files, secretFile{cfg.TLS.KeyFile, 0o077})
+	}
+	if cfg.Backup.PassphraseFile != "" {
+		files = append(files, secretFile{cfg.Backup.PassphraseFile, 0o077})
+	}
+	for _, name := range []string{selfSignedKeyName, caKeyName} {
+		path := filepath.Join(cfg.TLS.Dir, name)
+		if _, err := os.Stat(path); err == nil {
+			files = append(files, secretFile{path, 0o077})
+		}
+	}
+	return files
+}
+
+// checkFileMode reports whether a file lacks the permission bits in mask.
+func checkFileMode(path string, mask fs.FileMode) doctorCheck {
+	info, err := os.Stat(path)
+	if err != nil {
+		return doctorCheck{"FAIL", "permissions", err.Error()}
+	}
+	if runtime.GOOS == "windows" {
+		return doctorCheck{"ok", "permissions", path + " (not checked on Windows)"}
+	}
+	if perm := info.Mode().Perm(); perm&mask != 0 {
+		return doctorCheck{"warn", "permissions", fmt.Sprintf("%s has mode %04o; run chmod %04o %s", path, perm, perm&^mask, path)}
+	}
+	return doctorCheck{"ok", "permissions", path}
+}
+
+// auditCommand implements `audit verify`, which checks the audit log's hash chain as POST /admin/audit/verify does,
+// without starting the server. It prints the result and returns 1 if the chain is broken, so that it can run from
+// cron or CI.
+func auditCommand(ctx context.Context, cfg config.Config, args []string) int {
+	flags := flag.NewFlagSet("audit verify", flag.ContinueOnError)
+	asJSON := flags.Bool("json", false, "print the result as JSON")
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: pasword-mango [flags] audit verify [-json]")
+		flags.PrintDefaults()
+	}
+	if len(args) == 0 || args[0] != "verify" {
+		flags.Usage()
+		return 2
+	}
+	if err := flags.Parse(args[1:]); err != nil || flags.NArg() != 0 {
+		return 2
+	}
+
+	if err := data.InitDB(ctx, cfg.Storage, cfg.Key); err != nil {
+		log.Printf("Failed to initialize database: %v", err)
+		return 1
+	}
+	defer data.CloseDB()
+
+	result, err := data.VerifyAudit(ctx)
+	if err != nil {
+		log.Printf("Failed to verify audit log: %v", err)
+		return 1
+	}
+	if *asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		encoder.Encode(result)
+	} else if result.Valid {
+		fmt.Printf("Audit log intact: %d entries verified.\n", result.Entries)
+	} else {
+		fmt.Printf("Audit log broken at entry %d: %s.\n", result.BrokenAt, result.Reason)
+	}
+	if !result.Valid {
+		return 1
+	}
+	return 0
+}
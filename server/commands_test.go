@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/rihts-4/pasword-mango/config"
+)
+
+// TestInitIgnoresKeyProblems checks that init, which configures a new key file, is not stopped by problems with
+// the current key source, but is by others.
+func TestInitIgnoresKeyProblems(t *testing.T) {
+	t.Setenv("CREDENTIALS_DIRECTORY", "")
+	cfg := config.Default()
+	cfg.Storage.ProjectID = "pasword-mango-test"
+	cfg.Key = config.Key{Source: config.KeySourceEnv, Hex: "not hex"}
+
+	dir := t.TempDir()
+	configFile := filepath.Join(dir, "config.yaml")
+	keyFile := filepath.Join(dir, "key")
+	loadErr := errors.Join(cfg.Validate()...)
+	if loadErr == nil {
+		t.Fatal("the key source has no problems")
+	}
+	if code := initCommand(context.Background(), cfg, loadErr, configFile, []string{"-key-file", keyFile, "-no-vault"}); code != 0 {
+		t.Fatalf("init exited with %d", code)
+	}
+	for _, path := range []string{configFile, keyFile} {
+		if _, err := os.Stat(path); err != nil {
+			t.Error(err)
+		}
+	}
+
+	cfg.Log.Level = "verbose"
+	loadErr = errors.Join(cfg.Validate()...)
+	keyFile = filepath.Join(dir, "other-key")
+	if code := initCommand(context.Background(), cfg, loadErr, configFile, []string{"-key-file", keyFile, "-no-vault"}); code != 1 {
+		t.Errorf("init with an invalid log level exited with %d, want 1", code)
+	}
+	if _, err := os.Stat(keyFile); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("init with an invalid log level wrote a key file: %v", err)
+	}
+}
@@ -0,0 +1,291 @@
+// Package config defines the server's configuration and loads it in layers: built-in defaults, a YAML
+// configuration file, environment variables (including those in an optional .env file), and command-line flags.
+package config
+
+import (
+	"fmt"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config is the complete server configuration.
+type Config struct {
+	Storage    Storage    `yaml:"storage"`
+	Key        Key        `yaml:"key"`
+	Listen     []string   `yaml:"listen"`
+	UnixSocket UnixSocket `yaml:"unixSocket"`
+	Timeouts   Timeouts   `yaml:"timeouts"`
+	TLS        TLS        `yaml:"tls"`
+	Access     Access     `yaml:"access"`
+	RateLimits RateLimits `yaml:"rateLimits"`
+	Passwords  Passwords  `yaml:"passwords"`
+	Rotation   Rotation   `yaml:"rotation"`
+	Backup     Backup     `yaml:"backup"`
+	Share      Share      `yaml:"share"`
+	Admin      Admin      `yaml:"admin"`
+	Log        Log        `yaml:"log"`
+}
+
+// Storage selects and configures the storage backend.
+type Storage struct {
+	// Backend names the storage backend. Only "firestore" is supported.
+	Backend string `yaml:"backend"`
+	// ProjectID is the Google Cloud project holding the Firestore database.
+	ProjectID string `yaml:"projectID"`
+	// CredentialsFile is a service account key file. If empty, Application Default Credentials are used.
+	CredentialsFile string `yaml:"credentialsFile"`
+	// EmulatorHost connects to a Firestore emulator at host:port instead of Google Cloud.
+	EmulatorHost string `yaml:"emulatorHost"`
+}
+
+// Key sources, selected by Key.Source.
+const (
+	KeySourceAuto    = ""
+	KeySourceFile    = "file"
+	KeySourceStdin   = "stdin"
+	KeySourceSystemd = "systemd"
+	KeySourceCommand = "command"
+	KeySourceEnv     = "env"
+)
+
+// Key configures where the AES-256 encryption key comes from. Keys are read as 64 hex characters
+// (surrounding whitespace is ignored) or as 32 raw bytes.
+type Key struct {
+	// Source selects one of the sources below. If empty, the first available one is used, in the order
+	// file, systemd credential, command, then the legacy environment variable.
+	Source string `yaml:"source"`
+	// File is a key file that must not be accessible to other users.
+	File string `yaml:"file"`
+	// Credential is the name of the systemd credential in $CREDENTIALS_DIRECTORY holding the key.
+	Credential string `yaml:"credential"`
+	// Command is run, without a shell, and its standard output is the key. Each item is one argument, as given; to
+	// use shell features, run a shell explicitly, as in [sh, -c, "..."]. KEY_COMMAND is split like a shell would,
+	// honouring quotes and backslashes, but is not expanded.
+	Command []string `yaml:"command"`
+	// Hex is the hex-encoded key itself, from the legacy ENCRYPTION_KEY environment variable. Prefer not to set it in a file.
+	Hex string `yaml:"hex"`
+}
+
+// UnixSocket restricts access to Unix domain socket listeners.
+type UnixSocket struct {
+	// Mode is the octal permission string for socket files the server creates.
+	Mode string `yaml:"mode"`
+	// AllowedUIDs lists the user IDs whose processes may connect, or "*" for any. Empty means the server's own user.
+	AllowedUIDs []string `yaml:"allowedUIDs"`
+}
+
+// Timeouts bounds how long the server waits on clients and on itself.
+type Timeouts struct {
+	Shutdown   Duration `yaml:"shutdown"`
+	ReadHeader Duration `yaml:"readHeader"`
+	Read       Duration `yaml:"read"`
+	Write      Duration `yaml:"write"`
+	Idle       Duration `yaml:"idle"`
+}
+
+// TLS configures native HTTPS and mutual TLS.
+type TLS struct {
+	Enabled    bool     `yaml:"enabled"`
+	CertFile   string   `yaml:"certFile"`
+	KeyFile    string   `yaml:"keyFile"`
+	Dir        string   `yaml:"dir"`
+	Hosts      []string `yaml:"hosts"`
+	MinVersion string   `yaml:"minVersion"`
+	ClientAuth bool     `yaml:"clientAuth"`
+}
+
+// Access decides which Host headers and browser origins may reach the API.
+type Access struct {
+	AllowedHosts []string `yaml:"allowedHosts"`
+	CORS         CORS     `yaml:"cors"`
+}
+
+// CORS configures the headers sent to allowed browser origins.
+type CORS struct {
+	AllowedOrigins []string `yaml:"allowedOrigins"`
+	AllowedMethods []string `yaml:"allowedMethods"`
+	AllowedHeaders []string `yaml:"allowedHeaders"`
+	MaxAge         int      `yaml:"maxAge"`
+}
+
+// RateLimits configures the token bucket for each endpoint class in the form "<count>/<period>[:<burst>]".
+type RateLimits struct {
+	List   string `yaml:"list"`
+	Reveal string `yaml:"reveal"`
+	Write  string `yaml:"write"`
+	Admin  string `yaml:"admin"`
+}
+
+// Passwords configures the checks on passwords stored through the API.
+type Passwords struct {
+	// MinScore is the least strength score, from 0 to 4, a new password must have; weaker passwords are rejected.
+	// 0 accepts every password, though weak ones are still stored with a warning.
+	MinScore int `yaml:"minScore"`
+	// BreachFile is a locally downloaded Pwned Passwords file (SHA-1 or NTLM, ordered by hash) that new and stored
+	// passwords are checked against. If empty, passwords are not checked for breaches.
+	BreachFile string `yaml:"breachFile"`
+	// MaxAge is how long a password may go unchanged before the health report lists it as old. 0 disables the check.
+	MaxAge Duration `yaml:"maxAge"`
+}
+
+// Rotation configures the reminders for passwords due for rotation under a rotation policy.
+type Rotation struct {
+	// Notice is how long before a password expires it is listed as due and a reminder is sent.
+	Notice Duration `yaml:"notice"`
+	// CheckInterval is how often the server looks for passwords due for rotation. 0 disables the reminders.
+	CheckInterval Duration `yaml:"checkInterval"`
+	// Webhook is an http or https URL that reminders are POSTed to as JSON. Reminders are always logged.
+	Webhook string `yaml:"webhook"`
+}
+
+// Backup configures the scheduled backups the server takes while it runs.
+type Backup struct {
+	// Dir is the directory encrypted backups are written to. If empty, scheduled backups are disabled.
+	Dir string `yaml:"dir"`
+	// Schedule is a cron expression, in the server's local time, for when backups are taken; see ParseSchedule.
+	Schedule string `yaml:"schedule"`
+	// PassphraseFile holds the backup passphrase on its first line. It is read before every backup.
+	PassphraseFile string `yaml:"passphraseFile"`
+	// KeepDaily, KeepWeekly, and KeepMonthly are how many of the newest daily, weekly, and monthly backups are kept.
+	// The newest backup of each day, week, and month stands for it.
+	KeepDaily   int `yaml:"keepDaily"`
+	KeepWeekly  int `yaml:"keepWeekly"`
+	KeepMonthly int `yaml:"keepMonthly"`
+	// MaxAge is how old the last successful backup may be before the health endpoint reports it as stale.
+	// 0 disables the check.
+	MaxAge Duration `yaml:"maxAge"`
+}
+
+// Share configures one-time share links.
+type Share struct {
+	// BaseURL is the public http or https URL share links point at, such as https://vault.example.com. If empty, links
+	// point at the scheme and host the share was created through.
+	BaseURL string `yaml:"baseURL"`
+	// DefaultTTL is how long a share lasts unless its creator asks for less or more; MaxTTL is the longest it may.
+	DefaultTTL Duration `yaml:"defaultTTL"`
+	MaxTTL     Duration `yaml:"maxTTL"`
+	// MaxViews is the most times a share may be opened before it is deleted.
+	MaxViews int `yaml:"maxViews"`
+	// SweepInterval is how often expired shares are purged. 0 disables the sweeper, though an expired share is still
+	// deleted when someone tries to open it.
+	SweepInterval Duration `yaml:"sweepInterval"`
+}
+
+// Admin configures the admin API.
+type Admin struct {
+	// Token is the bearer token required by admin endpoints. If empty, the admin API is disabled.
+	Token string `yaml:"token"`
+}
+
+// Log configures the server log.
+type Log struct {
+	// Level is the minimum level logged: debug, info, warn, or error.
+	Level string `yaml:"level"`
+	// Format is text or json.
+	Format string `yaml:"format"`
+	// File is appended to instead of writing to standard error.
+	File string `yaml:"file"`
+}
+
+// Default returns the built-in configuration that the other layers override.
+func Default() Config {
+	return Config{
+		Storage: Storage{
+			Backend:         "firestore",
+			CredentialsFile: "adminkey.json",
+		},
+		Key:        Key{Credential: "encryption_key"},
+		Listen:     []string{":8080"},
+		UnixSocket: UnixSocket{Mode: "0600"},
+		Timeouts: Timeouts{
+			Shutdown:   Duration(5 * time.Second),
+			ReadHeader: Duration(10 * time.Second),
+			Read:       Duration(30 * time.Second),
+			Write:      Duration(30 * time.Second),
+			Idle:       Duration(2 * time.Minute),
+		},
+		TLS: TLS{
+			Dir:        "tls",
+			Hosts:      []string{"localhost", "127.0.0.1", "::1"},
+			MinVersion: "1.2",
+		},
+		Access: Access{
+			AllowedHosts: []string{"localhost", "127.0.0.1", "::1"},
+			CORS: CORS{
+				AllowedMethods: []string{"GET", "POST", "PUT", "DELETE"},
+				AllowedHeaders: []string{"Authorization", "Content-Type"},
+				MaxAge:         600,
+			},
+		},
+		RateLimits: RateLimits{
+			List:   "60/1m:20",
+			Reveal: "30/1m:10",
+			Write:  "30/1m:10",
+			Admin:  "10/1m:5",
+		},
+		Passwords: Passwords{MaxAge: Duration(365 * 24 * time.Hour)},
+		Rotation: Rotation{
+			Notice:        Duration(14 * 24 * time.Hour),
+			CheckInterval: Duration(time.Hour),
+		},
+		Backup: Backup{
+			Schedule:    "@daily",
+			KeepDaily:   7,
+			KeepWeekly:  4,
+			KeepMonthly: 12,
+			MaxAge:      Duration(48 * time.Hour),
+		},
+		Share: Share{
+			DefaultTTL:    Duration(24 * time.Hour),
+			MaxTTL:        Duration(7 * 24 * time.Hour),
+			MaxViews:      10,
+			SweepInterval: Duration(10 * time.Minute),
+		},
+		Log: Log{Level: "info", Format: "text"},
+	}
+}
+
+// redacted replaces a non-empty secret.
+const redacted = "[redacted]"
+
+// Redacted returns a copy of the configuration with secrets replaced, suitable for printing.
+func (c Config) Redacted() Config {
+	redact := func(s *string) {
+		if *s != "" {
+			*s = redacted
+		}
+	}
+	redact(&c.Key.Hex)
+	redact(&c.Admin.Token)
+	redact(&c.Rotation.Webhook)
+	return c
+}
+
+// YAML encodes the configuration in the configuration file format.
+func (c Config) YAML() ([]byte, error) {
+	return yaml.Marshal(c)
+}
+
+// Duration is a time.Duration written as a Go duration string such as "5s" in configuration files.
+type Duration time.Duration
+
+// MarshalYAML implements yaml.Marshaler.
+func (d Duration) MarshalYAML() (any, error) {
+	return time.Duration(d).String(), nil
+}
+
+// UnmarshalYAML implements yaml.Unmarshaler.
+func (d *Duration) UnmarshalYAML(node *yaml.Node) error {
+	parsed, err := time.ParseDuration(node.Value)
+	if err != nil {
+		return fmt.Errorf("line %d: invalid duration %q", node.Line, node.Value)
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+// Std returns the duration as a time.Duration.
+func (d Duration) Std() time.Duration {
+	return time.Duration(d)
+}
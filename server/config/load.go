@@ -0,0 +1,376 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/joho/godotenv"
+	"gopkg.in/yaml.v3"
+)
+
+// Default locations of the configuration file and .env file, relative to the working directory.
+// Both are optional unless named explicitly.
+const (
+	DefaultFile    = "pasword-mango.yaml"
+	DefaultEnvFile = ".env"
+)
+
+// FileEnvVar names the environment variable that can point at the configuration file.
+const FileEnvVar = "PASWORD_MANGO_CONFIG"
+
+// Flags is the command-line layer of the configuration. Register it on a flag set, parse the
+// arguments, then call Load.
+type Flags struct {
+	fs *flag.FlagSet
+
+	file            string
+	envFile         string
+	listen          string
+	backend         string
+	projectID       string
+	credentialsFile string
+	keySource       string
+	keyFile         string
+	shutdownTimeout time.Duration
+	tls             bool
+	logLevel        string
+	logFormat       string
+	logFile         string
+
+	loadedFile    string
+	loadedEnvFile string
+}
+
+// RegisterFlags defines the configuration flags on fs.
+func RegisterFlags(fs *flag.FlagSet) *Flags {
+	f := &Flags{fs: fs}
+	fs.StringVar(&f.file, "config", "", "configuration file (default $"+FileEnvVar+" or "+DefaultFile+" if present)")
+	fs.StringVar(&f.envFile, "env-file", "", "file of environment variables to load (default "+DefaultEnvFile+" if present)")
+	fs.StringVar(&f.listen, "listen", "", "comma-separated listen addresses")
+	fs.StringVar(&f.backend, "backend", "", "storage backend")
+	fs.StringVar(&f.projectID, "project-id", "", "Google Cloud project ID")
+	fs.StringVar(&f.credentialsFile, "credentials-file", "", "Google Cloud service account key file")
+	fs.StringVar(&f.keySource, "key-source", "", "encryption key source: file, stdin, systemd, command, or env")
+	fs.StringVar(&f.keyFile, "key-file", "", "read the encryption key from this file")
+	fs.DurationVar(&f.shutdownTimeout, "shutdown-timeout", 0, "graceful shutdown timeout")
+	fs.BoolVar(&f.tls, "tls", false, "serve HTTPS on TCP listeners")
+	fs.StringVar(&f.logLevel, "log-level", "", "minimum log level: debug, info, warn, or error")
+	fs.StringVar(&f.logFormat, "log-format", "", "log format: text or json")
+	fs.StringVar(&f.logFile, "log-file", "", "append logs to this file instead of standard error")
+	return f
+}
+
+// Load builds the effective configuration from the defaults, the configuration file, the environment,
+// and the flags that were set on the command line, in increasing order of precedence, then validates it.
+//
+// The returned error joins every problem found, so they can all be reported at once. The configuration
+// is returned even when it is invalid, so that it can still be inspected.
+func (f *Flags) Load() (Config, error) {
+	cfg := Default()
+	var problems []error
+
+	var err error
+	if f.loadedFile, err = loadFile(&cfg, f.file); err != nil {
+		problems = append(problems, err)
+	}
+	if f.loadedEnvFile, err = loadEnvFile(f.envFile); err != nil {
+		problems = append(problems, err)
+	}
+	problems = append(problems, applyEnv(&cfg)...)
+	f.apply(&cfg)
+	problems = append(problems, cfg.Validate()...)
+
+	return cfg, errors.Join(problems...)
+}
+
+// Files returns the configuration file and .env file that Load read, or empty strings for those that were absent.
+func (f *Flags) Files() (configFile, envFile string) {
+	return f.loadedFile, f.loadedEnvFile
+}
+
+// ConfigFile returns the configuration file that Load reads or would read: the one named by the flag or
+// environment variable, or the default.
+func (f *Flags) ConfigFile() string {
+	if f.file != "" {
+		return f.file
+	}
+	if path := os.Getenv(FileEnvVar); path != "" {
+		return path
+	}
+	return DefaultFile
+}
+
+// loadFile decodes the configuration file over cfg and returns its path, or an empty path if the default
+// file is absent. An explicitly named file must exist; the default one is optional. Files named *.toml are TOML,
+// with the same keys; others are YAML.
+func loadFile(cfg *Config, path string) (string, error) {
+	explicit := path != ""
+	if !explicit {
+		path = os.Getenv(FileEnvVar)
+		explicit = path != ""
+	}
+	if !explicit {
+		path = DefaultFile
+	}
+
+	content, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) && !explicit {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("error reading configuration file: %v", err)
+	}
+
+	if strings.EqualFold(filepath.Ext(path), ".toml") {
+		if err := decodeTOML(cfg, content); err != nil {
+			return path, fmt.Errorf("error parsing configuration file %s: %v", path, err)
+		}
+		return path, nil
+	}
+	decoder := yaml.NewDecoder(bytes.NewReader(content))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(cfg); err != nil && !errors.Is(err, io.EOF) {
+		return path, fmt.Errorf("error parsing configuration file %s: %v", path, err)
+	}
+	return path, nil
+}
+
+// loadEnvFile loads variables from a .env file into the environment without overriding variables that
+// are already set, and returns its path, or an empty path if the default file is absent. An explicitly named
+// file must exist; the default one is optional.
+func loadEnvFile(path string) (string, error) {
+	explicit := path != ""
+	if !explicit {
+		path = DefaultEnvFile
+	}
+	if err := godotenv.Load(path); err != nil {
+		if errors.Is(err, fs.ErrNotExist) && !explicit {
+			return "", nil
+		}
+		return "", fmt.Errorf("error loading %s file: %v", path, err)
+	}
+	return path, nil
+}
+
+// envVar maps an environment variable onto a configuration field.
+type envVar struct {
+	name  string
+	apply func(cfg *Config, value string) error
+}
+
+// envVars lists every environment variable the configuration reads. The names predate the configuration
+// file and are kept for compatibility with existing .env files.
+var envVars = []envVar{
+	{"STORAGE_BACKEND", func(c *Config, v string) error { c.Storage.Backend = v; return nil }},
+	{"PROJECT_ID", func(c *Config, v string) error { c.Storage.ProjectID = v; return nil }},
+	{"GOOGLE_APPLICATION_CREDENTIALS", func(c *Config, v string) error { c.Storage.CredentialsFile = v; return nil }},
+	{"FIRESTORE_EMULATOR_HOST", func(c *Config, v string) error { c.Storage.EmulatorHost = v; return nil }},
+	{"KEY_SOURCE", func(c *Config, v string) error { c.Key.Source = v; return nil }},
+	{"KEY_FILE", func(c *Config, v string) error { c.Key.File = v; return nil }},
+	{"KEY_CREDENTIAL", func(c *Config, v string) error { c.Key.Credential = v; return nil }},
+	{"KEY_COMMAND", func(c *Config, v string) (err error) { c.Key.Command, err = splitCommand(v); return err }},
+	{"ENCRYPTION_KEY", func(c *Config, v string) error {
+		c.Key.Hex = v
+		// Child processes such as a key command must not inherit the key.
+		return os.Unsetenv("ENCRYPTION_KEY")
+	}},
+	{"LISTEN", func(c *Config, v string) error { c.Listen = splitList(v); return nil }},
+	{"UNIX_SOCKET_MODE", func(c *Config, v string) error { c.UnixSocket.Mode = v; return nil }},
+	{"UNIX_SOCKET_ALLOWED_UIDS", func(c *Config, v string) error { c.UnixSocket.AllowedUIDs = splitList(v); return nil }},
+	{"SHUTDOWN_TIMEOUT", durationVar(func(c *Config) *Duration { return &c.Timeouts.Shutdown })},
+	{"READ_HEADER_TIMEOUT", durationVar(func(c *Config) *Duration { return &c.Timeouts.ReadHeader })},
+	{"READ_TIMEOUT", durationVar(func(c *Config) *Duration { return &c.Timeouts.Read })},
+	{"WRITE_TIMEOUT", durationVar(func(c *Config) *Duration { return &c.Timeouts.Write })},
+	{"IDLE_TIMEOUT", durationVar(func(c *Config) *Duration { return &c.Timeouts.Idle })},
+	{"TLS_ENABLED", boolVar(func(c *Config) *bool { return &c.TLS.Enabled })},
+	{"TLS_CERT_FILE", func(c *Config, v string) error { c.TLS.CertFile = v; return nil }},
+	{"TLS_KEY_FILE", func(c *Config, v string) error { c.TLS.KeyFile = v; return nil }},
+	{"TLS_DIR", func(c *Config, v string) error { c.TLS.Dir = v; return nil }},
+	{"TLS_HOSTS", func(c *Config, v string) error { c.TLS.Hosts = splitList(v); return nil }},
+	{"TLS_MIN_VERSION", func(c *Config, v string) error { c.TLS.MinVersion = v; return nil }},
+	{"TLS_CLIENT_AUTH", boolVar(func(c *Config) *bool { return &c.TLS.ClientAuth })},
+	{"ALLOWED_HOSTS", func(c *Config, v string) error { c.Access.AllowedHosts = splitList(v); return nil }},
+	{"CORS_ALLOWED_ORIGINS", func(c *Config, v string) error { c.Access.CORS.AllowedOrigins = splitList(v); return nil }},
+	{"CORS_ALLOWED_METHODS", func(c *Config, v string) error { c.Access.CORS.AllowedMethods = splitList(v); return nil }},
+	{"CORS_ALLOWED_HEADERS", func(c *Config, v string) error { c.Access.CORS.AllowedHeaders = splitList(v); return nil }},
+	{"CORS_MAX_AGE", intVar(func(c *Config) *int { return &c.Access.CORS.MaxAge })},
+	{"RATE_LIMIT_LIST", func(c *Config, v string) error { c.RateLimits.List = v; return nil }},
+	{"RATE_LIMIT_REVEAL", func(c *Config, v string) error { c.RateLimits.Reveal = v; return nil }},
+	{"RATE_LIMIT_WRITE", func(c *Config, v string) error { c.RateLimits.Write = v; return nil }},
+	{"RATE_LIMIT_ADMIN", func(c *Config, v string) error { c.RateLimits.Admin = v; return nil }},
+	{"PASSWORD_MIN_SCORE", intVar(func(c *Config) *int { return &c.Passwords.MinScore })},
+	{"PWNED_PASSWORDS_FILE", func(c *Config, v string) error { c.Passwords.BreachFile = v; return nil }},
+	{"PASSWORD_MAX_AGE", durationVar(func(c *Config) *Duration { return &c.Passwords.MaxAge })},
+	{"ROTATION_NOTICE", durationVar(func(c *Config) *Duration { return &c.Rotation.Notice })},
+	{"ROTATION_CHECK_INTERVAL", durationVar(func(c *Config) *Duration { return &c.Rotation.CheckInterval })},
+	{"ROTATION_WEBHOOK", func(c *Config, v string) error { c.Rotation.Webhook = v; return nil }},
+	{"BACKUP_DIR", func(c *Config, v string) error { c.Backup.Dir = v; return nil }},
+	{"BACKUP_SCHEDULE", func(c *Config, v string) error { c.Backup.Schedule = v; return nil }},
+	{"BACKUP_PASSPHRASE_FILE", func(c *Config, v string) error { c.Backup.PassphraseFile = v; return nil }},
+	{"BACKUP_KEEP_DAILY", intVar(func(c *Config) *int { return &c.Backup.KeepDaily })},
+	{"BACKUP_KEEP_WEEKLY", intVar(func(c *Config) *int { return &c.Backup.KeepWeekly })},
+	{"BACKUP_KEEP_MONTHLY", intVar(func(c *Config) *int { return &c.Backup.KeepMonthly })},
+	{"BACKUP_MAX_AGE", durationVar(func(c *Config) *Duration { return &c.Backup.MaxAge })},
+	{"SHARE_BASE_URL", func(c *Config, v string) error { c.Share.BaseURL = v; return nil }},
+	{"SHARE_DEFAULT_TTL", durationVar(func(c *Config) *Duration { return &c.Share.DefaultTTL })},
+	{"SHARE_MAX_TTL", durationVar(func(c *Config) *Duration { return &c.Share.MaxTTL })},
+	{"SHARE_MAX_VIEWS", intVar(func(c *Config) *int { return &c.Share.MaxViews })},
+	{"SHARE_SWEEP_INTERVAL", durationVar(func(c *Config) *Duration { return &c.Share.SweepInterval })},
+	{"ADMIN_TOKEN", func(c *Config, v string) error { c.Admin.Token = v; return nil }},
+	{"LOG_LEVEL", func(c *Config, v string) error { c.Log.Level = v; return nil }},
+	{"LOG_FORMAT", func(c *Config, v string) error { c.Log.Format = v; return nil }},
+	{"LOG_FILE", func(c *Config, v string) error { c.Log.File = v; return nil }},
+}
+
+// applyEnv overrides cfg with every environment variable that is set and non-empty.
+func applyEnv(cfg *Config) []error {
+	var problems []error
+	for _, ev := range envVars {
+		value := os.Getenv(ev.name)
+		if value == "" {
+			continue
+		}
+		if err := ev.apply(cfg, value); err != nil {
+			problems = append(problems, fmt.Errorf("%s: %v", ev.name, err))
+		}
+	}
+	return problems
+}
+
+func durationVar(field func(*Config) *Duration) func(*Config, string) error {
+	return func(c *Config, v string) error {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return fmt.Errorf("invalid duration %q", v)
+		}
+		*field(c) = Duration(d)
+		return nil
+	}
+}
+
+func boolVar(field func(*Config) *bool) func(*Config, string) error {
+	return func(c *Config, v string) error {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return fmt.Errorf("invalid boolean %q", v)
+		}
+		*field(c) = b
+		return nil
+	}
+}
+
+func intVar(field func(*Config) *int) func(*Config, string) error {
+	return func(c *Config, v string) error {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("invalid integer %q", v)
+		}
+		*field(c) = n
+		return nil
+	}
+}
+
+// apply overrides cfg with the flags that were set explicitly on the command line.
+func (f *Flags) apply(cfg *Config) {
+	f.fs.Visit(func(fl *flag.Flag) {
+		switch fl.Name {
+		case "listen":
+			cfg.Listen = splitList(f.listen)
+		case "backend":
+			cfg.Storage.Backend = f.backend
+		case "project-id":
+			cfg.Storage.ProjectID = f.projectID
+		case "credentials-file":
+			cfg.Storage.CredentialsFile = f.credentialsFile
+		case "key-source":
+			cfg.Key.Source = f.keySource
+		case "key-file":
+			cfg.Key.File = f.keyFile
+		case "shutdown-timeout":
+			cfg.Timeouts.Shutdown = Duration(f.shutdownTimeout)
+		case "tls":
+			cfg.TLS.Enabled = f.tls
+		case "log-level":
+			cfg.Log.Level = f.logLevel
+		case "log-format":
+			cfg.Log.Format = f.logFormat
+		case "log-file":
+			cfg.Log.File = f.logFile
+		}
+	})
+}
+
+// splitList splits a comma-separated list into trimmed, non-empty items.
+func splitList(list string) []string {
+	var items []string
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// splitCommand splits a command line into its arguments the way a shell would, honouring single and double quotes
+// and backslash escapes, but without expanding variables, globs, or anything else. It never runs a shell.
+func splitCommand(line string) ([]string, error) {
+	var (
+		args    []string
+		arg     strings.Builder
+		inArg   bool
+		quote   rune
+		escaped bool
+	)
+	for _, r := range line {
+		switch {
+		case escaped:
+			// Inside double quotes a backslash only escapes the characters it would in a shell
+			if quote == '"' && !strings.ContainsRune("\"\\$`", r) {
+				arg.WriteRune('\\')
+			}
+			arg.WriteRune(r)
+			escaped = false
+		case quote == '\'':
+			if r == '\'' {
+				quote = 0
+			} else {
+				arg.WriteRune(r)
+			}
+		case r == '\\':
+			escaped, inArg = true, true
+		case quote == '"':
+			if r == '"' {
+				quote = 0
+			} else {
+				arg.WriteRune(r)
+			}
+		case r == '\'' || r == '"':
+			quote, inArg = r, true
+		case r == ' ' || r == '\t' || r == '\n':
+			if inArg {
+				args = append(args, arg.String())
+				arg.Reset()
+				inArg = false
+			}
+		default:
+			arg.WriteRune(r)
+			inArg = true
+		}
+	}
+	if escaped || quote != 0 {
+		return nil, fmt.Errorf("unterminated quote or escape in %q", line)
+	}
+	if inArg {
+		args = append(args, arg.String())
+	}
+	return args, nil
+}
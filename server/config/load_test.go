@@ -0,0 +1,162 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSplitCommand(t *testing.T) {
+	for line, want := range map[string][]string{
+		`pass show pasword-mango/key`:              {"pass", "show", "pasword-mango/key"},
+		`  gpg  --decrypt  `:                       {"gpg", "--decrypt"},
+		`cat "/run/keys/mango key"`:                {"cat", "/run/keys/mango key"},
+		`printf '%s' 'it''s' "a \"b\" \c $HOME"`:   {"printf", "%s", "its", `a "b" \c $HOME`},
+		`vault read -field=key secret/mango\ key`:  {"vault", "read", "-field=key", "secret/mango key"},
+		`sh -c 'pass show "$KEY_NAME" | head -n1'`: {"sh", "-c", `pass show "$KEY_NAME" | head -n1`},
+		`echo "" ''`: {"echo", "", ""},
+	} {
+		got, err := splitCommand(line)
+		if err != nil || !reflect.DeepEqual(got, want) {
+			t.Errorf("splitCommand(%s) = %q, %v; want %q", line, got, err, want)
+		}
+	}
+	for _, line := range []string{`cat "unterminated`, `cat 'unterminated`, `cat trailing\`} {
+		if _, err := splitCommand(line); err == nil {
+			t.Errorf("splitCommand(%s) succeeded, want an error", line)
+		}
+	}
+}
+
+func TestLoadFileTOML(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"pasword-mango.yaml": `
+storage:
+  backend: firestore
+  projectID: my-project
+key:
+  source: command
+  command: [pass, show, "mango key"]
+listen: [":8443", "unix:///run/mango.sock"]
+unixSocket:
+  mode: "0660"
+timeouts:
+  shutdown: 10s
+tls:
+  enabled: true
+  hosts: [localhost, "::1"]
+backup:
+  dir: /var/backups/mango
+  schedule: "30 3 * * *"
+  keepDaily: 7
+  keepMonthly: 12
+log:
+  level: warn
+`,
+		"pasword-mango.toml": `
+# Keys are the same as in YAML
+listen = [
+  ":8443",
+  'unix:///run/mango.sock', # with a trailing comma
+]
+unixSocket = { mode = "0660" }
+timeouts.shutdown = "10s"
+
+[storage]
+backend = "firestore"
+projectID = "my-project"
+
+[key]
+source = "command"
+command = ["pass", "show", "mango\u0020key"]
+
+[tls]
+enabled = true
+hosts = ["localhost", "::1"]
+
+[backup]
+dir = '/var/backups/mango'
+schedule = "30 3 * * *"
+keepDaily = 7
+keepMonthly = 1_2
+
+[log]
+level = "warn"
+`,
+	}
+	loaded := make(map[string]Config)
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+		cfg := Default()
+		if _, err := loadFile(&cfg, path); err != nil {
+			t.Fatalf("loadFile(%s): %v", name, err)
+		}
+		loaded[name] = cfg
+	}
+	if yamlCfg, tomlCfg := loaded["pasword-mango.yaml"], loaded["pasword-mango.toml"]; !reflect.DeepEqual(tomlCfg, yamlCfg) {
+		t.Errorf("the TOML file loaded as\n%+v\nwant, as from YAML,\n%+v", tomlCfg, yamlCfg)
+	}
+}
+
+func TestLoadFileTOMLErrors(t *testing.T) {
+	for content, want := range map[string]string{
+		"[storage]\nbackend = \"firestore\"\nproject = \"x\"\n": "line 3: field project not found in type config.Storage",
+		"[timeouts]\n\nshutdown = \"soon\"\n":                   "line 3: invalid duration",
+		"[backup]\nkeepDaily = \"seven\"\n":                     "line 2: cannot unmarshal",
+		"[tls]\nenabled = true\nenabled = false\n":              "line 3: key enabled is defined twice",
+		"[tls]\n[log]\n[tls]\n":                                 "line 3: table tls is defined twice",
+		"listen = \":8080\"\nlisten.port = 1\n":                 "line 2: listen is not a table",
+		"[storage\n":                                            "line 1: expected ]",
+		"listen = [\":8080\"\n":                                 "line 2: expected , or ]",
+		"log.level = warn\n":                                    "line 1: invalid value warn",
+		"unixSocket.mode = 0660\n":                              "line 1: invalid number 0660",
+		"log.file = \"\"\"multi\nline\"\"\"\n":                  "line 1: multi-line strings are not supported",
+		"[[listen]]\n":                                          "line 1: arrays of tables are not supported",
+		"backup.schedule = 1979-05-27\n":                        "line 1: dates and times are not supported",
+		"log.level = \"warn\" log.format = \"json\"\n":          "line 1: expected a new line",
+	} {
+		cfg := Default()
+		if err := decodeTOML(&cfg, []byte(content)); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("decoding %q returned %v, want an error containing %q", content, err, want)
+		}
+	}
+}
+
+func TestValidateFieldErrors(t *testing.T) {
+	t.Setenv("CREDENTIALS_DIRECTORY", "")
+	cfg := Default()
+	cfg.Storage.ProjectID = ""
+	cfg.Key = Key{Source: KeySourceFile}
+	cfg.Log.Level = "verbose"
+
+	var fields []string
+	for _, problem := range cfg.Validate() {
+		var fieldErr *FieldError
+		if !errors.As(problem, &fieldErr) {
+			t.Fatalf("problem %v is not a *FieldError", problem)
+		}
+		fields = append(fields, fieldErr.Field)
+		if section := fieldErr.Section(); !strings.HasPrefix(fieldErr.Field, section) || strings.Contains(section, ".") {
+			t.Errorf("%s is in section %q", fieldErr.Field, section)
+		}
+	}
+	if want := []string{"storage.projectID", "key.file", "log.level"}; !reflect.DeepEqual(fields, want) {
+		t.Errorf("problems with %q, want %q", fields, want)
+	}
+
+	cfg = Default()
+	cfg.Storage.ProjectID = "pasword-mango"
+	cfg.Key = Key{Source: KeySourceAuto}
+	problems := cfg.Validate()
+	var fieldErr *FieldError
+	if len(problems) != 1 || !errors.As(problems[0], &fieldErr) || fieldErr.Field != "key" || fieldErr.Section() != "key" {
+		t.Errorf("problems %v without a key source, want one for key", problems)
+	}
+}
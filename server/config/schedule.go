@@ -0,0 +1,138 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schedule is a parsed cron expression. Each field holds the set of values it matches as a bit mask.
+type Schedule struct {
+	minute, hour, dom, month, dow uint64
+	// domAny and dowAny record a "*" day-of-month or day-of-week, which cron treats specially: when both day fields
+	// are restricted, a day matches if either does.
+	domAny, dowAny bool
+}
+
+// scheduleMacros are the cron shorthands ParseSchedule accepts.
+var scheduleMacros = map[string]string{
+	"@hourly":   "0 * * * *",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@weekly":   "0 0 * * 0",
+	"@monthly":  "0 0 1 * *",
+}
+
+// ParseSchedule parses a cron expression of five fields, minute (0-59), hour (0-23), day of month (1-31), month
+// (1-12), and day of week (0-6, Sunday is 0 or 7), such as "30 3 * * *" for 03:30 every day. Each field is "*", a
+// value, a range such as "1-5", or a comma-separated list of these, each optionally followed by a step such as
+// "*/15". The shorthands @hourly, @daily, @midnight, @weekly, and @monthly are accepted too. Names of months and
+// days are not.
+func ParseSchedule(spec string) (Schedule, error) {
+	spec = strings.TrimSpace(spec)
+	if expanded, ok := scheduleMacros[strings.ToLower(spec)]; ok {
+		spec = expanded
+	}
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return Schedule{}, fmt.Errorf("expected five fields (minute hour day-of-month month day-of-week), got %q", spec)
+	}
+	var s Schedule
+	var err error
+	if s.minute, err = parseScheduleField(fields[0], 0, 59); err != nil {
+		return Schedule{}, fmt.Errorf("minute: %v", err)
+	}
+	if s.hour, err = parseScheduleField(fields[1], 0, 23); err != nil {
+		return Schedule{}, fmt.Errorf("hour: %v", err)
+	}
+	if s.dom, err = parseScheduleField(fields[2], 1, 31); err != nil {
+		return Schedule{}, fmt.Errorf("day of month: %v", err)
+	}
+	if s.month, err = parseScheduleField(fields[3], 1, 12); err != nil {
+		return Schedule{}, fmt.Errorf("month: %v", err)
+	}
+	if s.dow, err = parseScheduleField(fields[4], 0, 7); err != nil {
+		return Schedule{}, fmt.Errorf("day of week: %v", err)
+	}
+	if s.dow&(1<<7) != 0 {
+		s.dow |= 1 // 7 is Sunday too
+	}
+	s.domAny, s.dowAny = strings.HasPrefix(fields[2], "*"), strings.HasPrefix(fields[4], "*")
+	return s, nil
+}
+
+// parseScheduleField parses one field of a cron expression whose values run from min to max.
+func parseScheduleField(field string, min, max int) (uint64, error) {
+	var mask uint64
+	for _, part := range strings.Split(field, ",") {
+		rangePart, stepPart, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			var err error
+			if step, err = strconv.Atoi(stepPart); err != nil || step <= 0 {
+				return 0, fmt.Errorf("invalid step %q", stepPart)
+			}
+		}
+		low, high := min, max
+		if rangePart != "*" {
+			lowPart, highPart, isRange := strings.Cut(rangePart, "-")
+			var err error
+			if low, err = strconv.Atoi(lowPart); err != nil {
+				return 0, fmt.Errorf("invalid value %q", lowPart)
+			}
+			high = low
+			if isRange {
+				if high, err = strconv.Atoi(highPart); err != nil {
+					return 0, fmt.Errorf("invalid value %q", highPart)
+				}
+			} else if hasStep {
+				high = max
+			}
+		}
+		if low < min || high > max || low > high {
+			return 0, fmt.Errorf("%q is out of range %d-%d", rangePart, min, max)
+		}
+		for v := low; v <= high; v += step {
+			mask |= 1 << v
+		}
+	}
+	return mask, nil
+}
+
+// Next returns the first time after t, to the minute, that the schedule matches, in t's location. It returns the
+// zero time if nothing matches within five years, as for "0 0 30 2 *".
+func (s Schedule) Next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		if s.month&(1<<uint(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if !s.matchesDay(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if s.hour&(1<<uint(t.Hour())) == 0 {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+			continue
+		}
+		if s.minute&(1<<uint(t.Minute())) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
+
+// matchesDay reports whether the day of t matches the day-of-month and day-of-week fields.
+func (s Schedule) matchesDay(t time.Time) bool {
+	dom := s.dom&(1<<uint(t.Day())) != 0
+	dow := s.dow&(1<<uint(t.Weekday())) != 0
+	if s.domAny || s.dowAny {
+		return dom && dow
+	}
+	return dom || dow
+}
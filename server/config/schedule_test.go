@@ -0,0 +1,79 @@
+package config
+
+import (
+	"testing"
+	"time"
+)
+
+func TestScheduleNext(t *testing.T) {
+	// 2025-03-12 is a Wednesday
+	from := time.Date(2025, 3, 12, 10, 17, 30, 0, time.UTC)
+	tests := []struct {
+		spec string
+		from time.Time
+		want string
+	}{
+		{"@daily", from, "2025-03-13 00:00"},
+		{"@hourly", from, "2025-03-12 11:00"},
+		{"@weekly", from, "2025-03-16 00:00"},
+		{"@monthly", from, "2025-04-01 00:00"},
+		{"30 3 * * *", from, "2025-03-13 03:30"},
+		{"30 3 * * *", time.Date(2025, 3, 12, 3, 29, 59, 0, time.UTC), "2025-03-12 03:30"},
+		{"30 3 * * *", time.Date(2025, 3, 12, 3, 30, 0, 0, time.UTC), "2025-03-13 03:30"},
+		{"*/15 * * * *", from, "2025-03-12 10:30"},
+		{"5-10/2 * * * *", from, "2025-03-12 11:05"},
+		{"0 9-17 * * 1-5", from, "2025-03-12 11:00"},
+		{"0 9-17 * * 1-5", time.Date(2025, 3, 14, 18, 0, 0, 0, time.UTC), "2025-03-17 09:00"},
+		{"0 0 * * 7", from, "2025-03-16 00:00"},
+		{"0 12 1,15 * *", from, "2025-03-15 12:00"},
+		{"0 0 31 * *", time.Date(2025, 4, 1, 0, 0, 0, 0, time.UTC), "2025-05-31 00:00"},
+		{"0 0 29 2 *", from, "2028-02-29 00:00"},
+		// With both day fields restricted, either matches: the 20th, or a Monday
+		{"0 0 20 * 1", from, "2025-03-17 00:00"},
+		{"0 0 20 * 1", time.Date(2025, 3, 18, 0, 0, 0, 0, time.UTC), "2025-03-20 00:00"},
+		{"59 23 31 12 *", from, "2025-12-31 23:59"},
+	}
+	for _, tt := range tests {
+		s, err := ParseSchedule(tt.spec)
+		if err != nil {
+			t.Errorf("ParseSchedule(%q): %v", tt.spec, err)
+			continue
+		}
+		if got := s.Next(tt.from).Format("2006-01-02 15:04"); got != tt.want {
+			t.Errorf("%q from %s fires at %s, want %s", tt.spec, tt.from.Format(time.DateTime), got, tt.want)
+		}
+	}
+}
+
+func TestScheduleNeverFires(t *testing.T) {
+	for _, spec := range []string{"0 0 30 2 *", "0 0 31 4,6,9,11 *"} {
+		s, err := ParseSchedule(spec)
+		if err != nil {
+			t.Fatalf("ParseSchedule(%q): %v", spec, err)
+		}
+		if next := s.Next(time.Now()); !next.IsZero() {
+			t.Errorf("%q fires at %s, want never", spec, next)
+		}
+	}
+}
+
+func TestParseScheduleErrors(t *testing.T) {
+	for _, spec := range []string{
+		"",
+		"@yearly",
+		"0 0 * *",
+		"0 0 * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"*/0 * * * *",
+		"5-1 * * * *",
+		"0 0 * JAN *",
+	} {
+		if _, err := ParseSchedule(spec); err == nil {
+			t.Errorf("ParseSchedule(%q) succeeded, want an error", spec)
+		}
+	}
+}
@@ -0,0 +1,433 @@
+package config
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"gopkg.in/yaml.v3"
+)
+
+// decodeTOML decodes a TOML configuration file over cfg.
+func decodeTOML(cfg *Config, content []byte) error {
+	node, err := parseTOML(content)
+	if err != nil {
+		return err
+	}
+	if err := checkTOMLFields(node, reflect.TypeOf(cfg)); err != nil {
+		return err
+	}
+	return node.Decode(cfg)
+}
+
+// parseTOML parses a TOML document (https://toml.io/en/v1.0.0) into the YAML node it is equivalent to, so that TOML
+// configuration files are decoded like YAML ones, with the same keys and the line numbers of the TOML file. It
+// supports what configuration files need: tables, dotted and quoted keys, basic and literal strings, integers,
+// floats, booleans, arrays, and inline tables. Multi-line strings, dates and times, and arrays of tables are
+// reported as unsupported.
+func parseTOML(content []byte) (*yaml.Node, error) {
+	if !utf8.Valid(content) {
+		return nil, fmt.Errorf("not valid UTF-8")
+	}
+	p := &tomlParser{src: string(content), line: 1}
+	root := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map", Line: 1}
+	table := root
+	headers := make(map[*yaml.Node]bool)
+	for {
+		p.skipSpace(true)
+		if p.eof() {
+			return root, nil
+		}
+		if strings.HasPrefix(p.rest(), "[[") {
+			return nil, p.errorf("arrays of tables are not supported")
+		}
+		if p.consume('[') {
+			p.skipSpace(false)
+			keys, err := p.key()
+			if err != nil {
+				return nil, err
+			}
+			p.skipSpace(false)
+			if !p.consume(']') {
+				return nil, p.errorf("expected ] after the table name")
+			}
+			if table, err = p.table(root, keys); err != nil {
+				return nil, err
+			}
+			if headers[table] {
+				return nil, p.errorf("table %s is defined twice", strings.Join(keys, "."))
+			}
+			headers[table] = true
+		} else if err := p.keyValue(table); err != nil {
+			return nil, err
+		}
+		p.skipSpace(false)
+		if !p.eof() && !p.newline() {
+			return nil, p.errorf("expected a new line, found %q", p.peek())
+		}
+	}
+}
+
+// tomlParser reads a TOML document, keeping track of the line it is on for error messages and YAML nodes.
+type tomlParser struct {
+	src  string
+	pos  int
+	line int
+}
+
+func (p *tomlParser) eof() bool {
+	return p.pos >= len(p.src)
+}
+
+func (p *tomlParser) rest() string {
+	return p.src[p.pos:]
+}
+
+func (p *tomlParser) peek() byte {
+	if p.eof() {
+		return 0
+	}
+	return p.src[p.pos]
+}
+
+// consume skips c if it comes next, and reports whether it did.
+func (p *tomlParser) consume(c byte) bool {
+	if p.peek() != c {
+		return false
+	}
+	p.pos++
+	return true
+}
+
+// newline skips a line ending if one comes next, and reports whether it did.
+func (p *tomlParser) newline() bool {
+	if strings.HasPrefix(p.rest(), "\r\n") {
+		p.pos++
+	}
+	if !p.consume('\n') {
+		return false
+	}
+	p.line++
+	return true
+}
+
+// skipSpace skips spaces, tabs, and comments, and line endings too if newlines is true.
+func (p *tomlParser) skipSpace(newlines bool) {
+	for !p.eof() {
+		switch c := p.peek(); {
+		case c == ' ' || c == '\t':
+			p.pos++
+		case c == '#':
+			end := strings.IndexAny(p.rest(), "\r\n")
+			if end < 0 {
+				end = len(p.rest())
+			}
+			p.pos += end
+		case newlines && p.newline():
+		default:
+			return
+		}
+	}
+}
+
+func (p *tomlParser) errorf(format string, args ...any) error {
+	return fmt.Errorf("line %d: %s", p.line, fmt.Sprintf(format, args...))
+}
+
+// key reads a key of one or more dot-separated parts, each bare or quoted.
+func (p *tomlParser) key() ([]string, error) {
+	var keys []string
+	for {
+		var key string
+		var err error
+		switch p.peek() {
+		case '"':
+			key, err = p.basicString()
+		case '\'':
+			key, err = p.literalString()
+		default:
+			start := p.pos
+			for !p.eof() && isBareKeyChar(p.peek()) {
+				p.pos++
+			}
+			if key = p.src[start:p.pos]; key == "" {
+				err = p.errorf("expected a key, found %q", p.peek())
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+		p.skipSpace(false)
+		if !p.consume('.') {
+			return keys, nil
+		}
+		p.skipSpace(false)
+	}
+}
+
+func isBareKeyChar(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_' || c == '-'
+}
+
+// keyValue reads a key = value pair into table.
+func (p *tomlParser) keyValue(table *yaml.Node) error {
+	line := p.line
+	keys, err := p.key()
+	if err != nil {
+		return err
+	}
+	if !p.consume('=') {
+		return p.errorf("expected = after the key %s", strings.Join(keys, "."))
+	}
+	p.skipSpace(false)
+	value, err := p.value()
+	if err != nil {
+		return err
+	}
+	if table, err = p.table(table, keys[:len(keys)-1]); err != nil {
+		return err
+	}
+	last := keys[len(keys)-1]
+	if tomlChild(table, last) != nil {
+		return fmt.Errorf("line %d: key %s is defined twice", line, strings.Join(keys, "."))
+	}
+	table.Content = append(table.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: last, Line: line}, value)
+	return nil
+}
+
+// table returns the table that keys name below parent, creating the tables that do not exist yet.
+func (p *tomlParser) table(parent *yaml.Node, keys []string) (*yaml.Node, error) {
+	for i, key := range keys {
+		child := tomlChild(parent, key)
+		if child == nil {
+			child = &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map", Line: p.line}
+			parent.Content = append(parent.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: key, Line: p.line}, child)
+		} else if child.Kind != yaml.MappingNode {
+			return nil, p.errorf("%s is not a table", strings.Join(keys[:i+1], "."))
+		}
+		parent = child
+	}
+	return parent, nil
+}
+
+// tomlChild returns the value of key in a mapping node, or nil if it has none.
+func tomlChild(table *yaml.Node, key string) *yaml.Node {
+	for i := 0; i+1 < len(table.Content); i += 2 {
+		if table.Content[i].Value == key {
+			return table.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// value reads a value: a string, number, boolean, array, or inline table.
+func (p *tomlParser) value() (*yaml.Node, error) {
+	line := p.line
+	scalar := func(tag, value string) *yaml.Node {
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: tag, Value: value, Line: line}
+	}
+	switch {
+	case strings.HasPrefix(p.rest(), `"""`) || strings.HasPrefix(p.rest(), "'''"):
+		return nil, p.errorf("multi-line strings are not supported")
+	case p.peek() == '"':
+		s, err := p.basicString()
+		return scalar("!!str", s), err
+	case p.peek() == '\'':
+		s, err := p.literalString()
+		return scalar("!!str", s), err
+	case p.consume('['):
+		array := &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq", Line: line}
+		for {
+			p.skipSpace(true)
+			if p.consume(']') {
+				return array, nil
+			}
+			item, err := p.value()
+			if err != nil {
+				return nil, err
+			}
+			array.Content = append(array.Content, item)
+			p.skipSpace(true)
+			if !p.consume(',') {
+				p.skipSpace(true)
+				if !p.consume(']') {
+					return nil, p.errorf("expected , or ] in an array")
+				}
+				return array, nil
+			}
+		}
+	case p.consume('{'):
+		table := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map", Line: line}
+		p.skipSpace(false)
+		if p.consume('}') {
+			return table, nil
+		}
+		for {
+			p.skipSpace(false)
+			if err := p.keyValue(table); err != nil {
+				return nil, err
+			}
+			p.skipSpace(false)
+			if p.consume('}') {
+				return table, nil
+			}
+			if !p.consume(',') {
+				return nil, p.errorf("expected , or } in an inline table")
+			}
+		}
+	}
+
+	start := p.pos
+	for !p.eof() && !strings.ContainsRune(" \t\r\n,]}#", rune(p.peek())) {
+		p.pos++
+	}
+	token := p.src[start:p.pos]
+	switch token {
+	case "":
+		return nil, p.errorf("expected a value")
+	case "true", "false":
+		return scalar("!!bool", token), nil
+	case "inf", "+inf":
+		return scalar("!!float", ".inf"), nil
+	case "-inf":
+		return scalar("!!float", "-.inf"), nil
+	case "nan", "+nan", "-nan":
+		return scalar("!!float", ".nan"), nil
+	}
+	digits := strings.TrimLeft(token, "+-")
+	if len(digits) > 4 && digits[4] == '-' || len(digits) > 2 && digits[2] == ':' {
+		return nil, p.errorf("dates and times are not supported")
+	}
+	if strings.HasPrefix(digits, "0x") || strings.HasPrefix(digits, "0o") || strings.HasPrefix(digits, "0b") {
+		if digits != token {
+			return nil, p.errorf("invalid number %s", token)
+		}
+	} else if len(digits) > 1 && digits[0] == '0' && digits[1] != '.' && digits[1] != 'e' && digits[1] != 'E' {
+		return nil, p.errorf("invalid number %s: leading zeros are not allowed", token)
+	}
+	if n, err := strconv.ParseInt(token, 0, 64); err == nil {
+		return scalar("!!int", strconv.FormatInt(n, 10)), nil
+	}
+	if !strings.ContainsAny(digits, ".eE") || strings.HasPrefix(digits, "0x") {
+		return nil, p.errorf("invalid value %s", token)
+	}
+	f, err := strconv.ParseFloat(strings.ReplaceAll(token, "_", ""), 64)
+	if err != nil || math.IsInf(f, 0) {
+		return nil, p.errorf("invalid value %s", token)
+	}
+	return scalar("!!float", strconv.FormatFloat(f, 'g', -1, 64)), nil
+}
+
+// basicString reads a double-quoted string, decoding its escapes.
+func (p *tomlParser) basicString() (string, error) {
+	p.pos++
+	var b strings.Builder
+	for {
+		if p.eof() || p.peek() == '\n' || p.peek() == '\r' {
+			return "", p.errorf("unterminated string")
+		}
+		c := p.src[p.pos]
+		p.pos++
+		switch c {
+		case '"':
+			return b.String(), nil
+		case '\\':
+			if p.eof() {
+				return "", p.errorf("unterminated string")
+			}
+			escape := p.src[p.pos]
+			p.pos++
+			switch escape {
+			case 'b':
+				b.WriteByte('\b')
+			case 't':
+				b.WriteByte('\t')
+			case 'n':
+				b.WriteByte('\n')
+			case 'f':
+				b.WriteByte('\f')
+			case 'r':
+				b.WriteByte('\r')
+			case '"', '\\':
+				b.WriteByte(escape)
+			case 'u', 'U':
+				size := 4
+				if escape == 'U' {
+					size = 8
+				}
+				if len(p.rest()) < size {
+					return "", p.errorf("invalid escape \\%c", escape)
+				}
+				code, err := strconv.ParseUint(p.src[p.pos:p.pos+size], 16, 32)
+				if err != nil || !utf8.ValidRune(rune(code)) {
+					return "", p.errorf("invalid escape \\%c%s", escape, p.src[p.pos:p.pos+size])
+				}
+				b.WriteRune(rune(code))
+				p.pos += size
+			default:
+				return "", p.errorf("invalid escape \\%c", escape)
+			}
+		default:
+			b.WriteByte(c)
+		}
+	}
+}
+
+// literalString reads a single-quoted string, which has no escapes.
+func (p *tomlParser) literalString() (string, error) {
+	p.pos++
+	end := strings.IndexAny(p.rest(), "'\r\n")
+	if end < 0 || p.src[p.pos+end] != '\'' {
+		return "", p.errorf("unterminated string")
+	}
+	s := p.src[p.pos : p.pos+end]
+	p.pos += end + 1
+	return s, nil
+}
+
+// checkTOMLFields reports a key in node that has no field in the type it is decoded into, like a yaml.Decoder with
+// KnownFields does for YAML files, since decoding a node directly does not.
+func checkTOMLFields(node *yaml.Node, t reflect.Type) error {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	switch {
+	case node.Kind == yaml.MappingNode && t.Kind() == reflect.Struct:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key := node.Content[i]
+			field, ok := yamlField(t, key.Value)
+			if !ok {
+				return fmt.Errorf("line %d: field %s not found in type %s", key.Line, key.Value, t)
+			}
+			if err := checkTOMLFields(node.Content[i+1], field.Type); err != nil {
+				return err
+			}
+		}
+	case node.Kind == yaml.SequenceNode && t.Kind() == reflect.Slice:
+		for _, item := range node.Content {
+			if err := checkTOMLFields(item, t.Elem()); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// yamlField returns the field of struct type t that the YAML key name decodes into.
+func yamlField(t reflect.Type, name string) (reflect.StructField, bool) {
+	for i := range t.NumField() {
+		field := t.Field(i)
+		tag, _, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+		if tag == "" {
+			tag = strings.ToLower(field.Name)
+		}
+		if field.IsExported() && tag == name {
+			return field, true
+		}
+	}
+	return reflect.StructField{}, false
+}
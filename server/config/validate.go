@@ -0,0 +1,346 @@
+package config
+
+import (
+	"crypto/tls"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// FieldError is a problem with a field of the configuration, as found by Validate.
+type FieldError struct {
+	// Field is the field's key in the configuration file, such as "key.file", or the key of the section the problem
+	// concerns, such as "tls".
+	Field   string
+	Problem string
+}
+
+func (e *FieldError) Error() string {
+	return e.Field + ": " + e.Problem
+}
+
+// Section returns the top-level section of the field, such as "key" for "key.file".
+func (e *FieldError) Section() string {
+	section, _, _ := strings.Cut(e.Field, ".")
+	return section
+}
+
+// Validate checks the configuration and returns every problem found, each a *FieldError.
+func (c Config) Validate() []error {
+	var problems []error
+	add := func(field, format string, args ...any) {
+		problems = append(problems, &FieldError{Field: field, Problem: fmt.Sprintf(format, args...)})
+	}
+
+	switch c.Storage.Backend {
+	case "firestore":
+		if c.Storage.ProjectID == "" {
+			add("storage.projectID", "required for the firestore backend (set PROJECT_ID)")
+		}
+	default:
+		add("storage.backend", "unsupported backend %q (supported: firestore)", c.Storage.Backend)
+	}
+
+	switch c.Key.Source {
+	case KeySourceAuto:
+		if c.Key.File == "" && len(c.Key.Command) == 0 && c.Key.Hex == "" && os.Getenv("CREDENTIALS_DIRECTORY") == "" {
+			add("key", "no encryption key source configured (set KEY_FILE, KEY_COMMAND, or KEY_SOURCE=stdin)")
+		}
+	case KeySourceFile:
+		if c.Key.File == "" {
+			add("key.file", "required when key.source is file")
+		}
+	case KeySourceCommand:
+		if len(c.Key.Command) == 0 {
+			add("key.command", "required when key.source is command")
+		}
+	case KeySourceEnv:
+		if c.Key.Hex == "" {
+			add("key.hex", "required when key.source is env (set ENCRYPTION_KEY)")
+		}
+	case KeySourceStdin:
+	case KeySourceSystemd:
+		if c.Key.Credential == "" {
+			add("key.credential", "required when key.source is systemd")
+		}
+	default:
+		add("key.source", "must be file, stdin, systemd, command, or env, got %q", c.Key.Source)
+	}
+	if c.Key.Hex != "" {
+		if key, err := hex.DecodeString(c.Key.Hex); err != nil || len(key) != 32 {
+			add("key.hex", "must be 64 hex characters (32 bytes)")
+		}
+	}
+
+	if _, err := ParseListen(c.Listen); err != nil {
+		add("listen", "%v", err)
+	}
+	if _, err := c.UnixSocket.FileMode(); err != nil {
+		add("unixSocket.mode", "%v", err)
+	}
+	if _, _, err := c.UnixSocket.UIDs(); err != nil {
+		add("unixSocket.allowedUIDs", "%v", err)
+	}
+
+	if c.Timeouts.Shutdown <= 0 {
+		add("timeouts.shutdown", "must be positive")
+	}
+	for _, t := range []struct {
+		field string
+		value Duration
+	}{
+		{"timeouts.readHeader", c.Timeouts.ReadHeader},
+		{"timeouts.read", c.Timeouts.Read},
+		{"timeouts.write", c.Timeouts.Write},
+		{"timeouts.idle", c.Timeouts.Idle},
+	} {
+		if t.value < 0 {
+			add(t.field, "must not be negative")
+		}
+	}
+
+	if _, err := c.TLS.Version(); err != nil {
+		add("tls.minVersion", "%v", err)
+	}
+	if (c.TLS.CertFile == "") != (c.TLS.KeyFile == "") {
+		add("tls", "certFile and keyFile must be set together")
+	}
+	if c.TLS.ClientAuth && !c.TLS.Enabled {
+		add("tls.clientAuth", "requires tls.enabled")
+	}
+	if c.TLS.Enabled && c.TLS.CertFile == "" && c.TLS.Dir == "" {
+		add("tls.dir", "required to store the self-signed certificate")
+	}
+
+	if len(c.Access.AllowedHosts) == 0 {
+		add("access.allowedHosts", "must list at least one host")
+	}
+	for _, origin := range c.Access.CORS.AllowedOrigins {
+		u, err := url.Parse(origin)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || (u.Path != "" && u.Path != "/") {
+			add("access.cors.allowedOrigins", "%q is not an origin of the form scheme://host[:port]", origin)
+		}
+	}
+	if c.Access.CORS.MaxAge < 0 {
+		add("access.cors.maxAge", "must not be negative")
+	}
+
+	for _, limit := range []struct {
+		field string
+		spec  string
+	}{
+		{"rateLimits.list", c.RateLimits.List},
+		{"rateLimits.reveal", c.RateLimits.Reveal},
+		{"rateLimits.write", c.RateLimits.Write},
+		{"rateLimits.admin", c.RateLimits.Admin},
+	} {
+		if _, err := ParseRateLimit(limit.spec); err != nil {
+			add(limit.field, "%v", err)
+		}
+	}
+
+	if c.Passwords.MinScore < 0 || c.Passwords.MinScore > 4 {
+		add("passwords.minScore", "must be between 0 and 4, got %d", c.Passwords.MinScore)
+	}
+	if c.Passwords.MaxAge < 0 {
+		add("passwords.maxAge", "must not be negative")
+	}
+
+	if c.Rotation.Notice < 0 {
+		add("rotation.notice", "must not be negative")
+	}
+	if c.Rotation.CheckInterval < 0 {
+		add("rotation.checkInterval", "must not be negative")
+	}
+	if c.Rotation.Webhook != "" {
+		u, err := url.Parse(c.Rotation.Webhook)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			// The URL may hold a secret, so it is not repeated
+			add("rotation.webhook", "must be an http or https URL")
+		}
+	}
+
+	if c.Backup.Dir != "" {
+		if _, err := ParseSchedule(c.Backup.Schedule); err != nil {
+			add("backup.schedule", "%v", err)
+		}
+		if c.Backup.PassphraseFile == "" {
+			add("backup.passphraseFile", "required for scheduled backups (set BACKUP_PASSPHRASE_FILE)")
+		}
+		if c.Backup.KeepDaily < 0 || c.Backup.KeepWeekly < 0 || c.Backup.KeepMonthly < 0 {
+			add("backup", "keepDaily, keepWeekly, and keepMonthly must not be negative")
+		}
+		if c.Backup.KeepDaily+c.Backup.KeepWeekly+c.Backup.KeepMonthly == 0 {
+			add("backup", "keepDaily, keepWeekly, and keepMonthly must keep at least one backup")
+		}
+	}
+	if c.Backup.MaxAge < 0 {
+		add("backup.maxAge", "must not be negative")
+	}
+
+	if c.Share.BaseURL != "" {
+		u, err := url.Parse(c.Share.BaseURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || u.RawQuery != "" || u.Fragment != "" {
+			add("share.baseURL", "must be an http or https URL without a query or fragment, got %q", c.Share.BaseURL)
+		}
+	}
+	if c.Share.DefaultTTL <= 0 {
+		add("share.defaultTTL", "must be positive")
+	}
+	if c.Share.MaxTTL < c.Share.DefaultTTL {
+		add("share.maxTTL", "must not be shorter than share.defaultTTL")
+	}
+	if c.Share.MaxViews < 1 {
+		add("share.maxViews", "must be at least 1, got %d", c.Share.MaxViews)
+	}
+	if c.Share.SweepInterval < 0 {
+		add("share.sweepInterval", "must not be negative")
+	}
+
+	switch strings.ToLower(c.Log.Level) {
+	case "debug", "info", "warn", "error":
+	default:
+		add("log.level", "must be debug, info, warn, or error, got %q", c.Log.Level)
+	}
+	switch strings.ToLower(c.Log.Format) {
+	case "text", "json":
+	default:
+		add("log.format", "must be text or json, got %q", c.Log.Format)
+	}
+
+	return problems
+}
+
+// ListenSpec describes one address the server listens on. Listen entries take the forms:
+//   - "host:port" or "tcp://host:port": a TCP socket, served over HTTPS when TLS is enabled.
+//   - "unix:///path/to.sock": a Unix domain socket with restricted permissions and peer-credential checks.
+//   - "systemd" or "systemd:NAME": all sockets passed by systemd socket activation, or only those whose
+//     FileDescriptorName is NAME.
+type ListenSpec struct {
+	Network string
+	Address string
+	Systemd bool
+}
+
+func (s ListenSpec) String() string {
+	if s.Systemd {
+		if s.Address == "" {
+			return "systemd"
+		}
+		return "systemd:" + s.Address
+	}
+	return s.Network + "://" + s.Address
+}
+
+// ParseListen parses listen entries into listener specifications.
+func ParseListen(entries []string) ([]ListenSpec, error) {
+	var specs []ListenSpec
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		switch {
+		case entry == "":
+			continue
+		case entry == "systemd":
+			specs = append(specs, ListenSpec{Systemd: true})
+		case strings.HasPrefix(entry, "systemd:"):
+			specs = append(specs, ListenSpec{Systemd: true, Address: strings.TrimPrefix(entry, "systemd:")})
+		case strings.HasPrefix(entry, "unix://"):
+			path := strings.TrimPrefix(entry, "unix://")
+			if path == "" {
+				return nil, fmt.Errorf("listen address %q has no socket path", entry)
+			}
+			specs = append(specs, ListenSpec{Network: "unix", Address: path})
+		default:
+			address := strings.TrimPrefix(entry, "tcp://")
+			if _, _, err := net.SplitHostPort(address); err != nil {
+				return nil, fmt.Errorf("listen address %q: %v", entry, err)
+			}
+			specs = append(specs, ListenSpec{Network: "tcp", Address: address})
+		}
+	}
+	if len(specs) == 0 {
+		return nil, fmt.Errorf("no listen addresses configured")
+	}
+	return specs, nil
+}
+
+// RateLimit is a parsed token bucket specification: Count requests per Period, with bursts of up to Burst.
+type RateLimit struct {
+	Count  int
+	Period time.Duration
+	Burst  int
+}
+
+// ParseRateLimit parses a bucket specification of the form "<count>/<period>[:<burst>]", e.g. "30/1m:10"
+// for thirty requests per minute with bursts of up to ten. The burst defaults to the count, and a bare unit
+// such as "m" is shorthand for "1m".
+func ParseRateLimit(spec string) (RateLimit, error) {
+	ratePart, burstPart, hasBurst := strings.Cut(strings.TrimSpace(spec), ":")
+	countPart, periodPart, ok := strings.Cut(ratePart, "/")
+	if !ok {
+		return RateLimit{}, fmt.Errorf("expected <count>/<period>[:<burst>], got %q", spec)
+	}
+	count, err := strconv.Atoi(countPart)
+	if err != nil || count <= 0 {
+		return RateLimit{}, fmt.Errorf("count must be a positive integer, got %q", countPart)
+	}
+	if periodPart != "" && !strings.ContainsAny(periodPart[:1], "0123456789") {
+		periodPart = "1" + periodPart
+	}
+	period, err := time.ParseDuration(periodPart)
+	if err != nil || period <= 0 {
+		return RateLimit{}, fmt.Errorf("period must be a positive duration, got %q", periodPart)
+	}
+	burst := count
+	if hasBurst {
+		if burst, err = strconv.Atoi(burstPart); err != nil || burst <= 0 {
+			return RateLimit{}, fmt.Errorf("burst must be a positive integer, got %q", burstPart)
+		}
+	}
+	return RateLimit{Count: count, Period: period, Burst: burst}, nil
+}
+
+// FileMode parses the socket file permissions.
+func (u UnixSocket) FileMode() (fs.FileMode, error) {
+	mode, err := strconv.ParseUint(u.Mode, 8, 32)
+	if err != nil || mode > 0777 {
+		return 0, fmt.Errorf("must be octal permissions such as 0600, got %q", u.Mode)
+	}
+	return fs.FileMode(mode), nil
+}
+
+// UIDs parses the allowed user IDs. It reports anyUID as true if "*" is listed. An empty list returns no
+// user IDs and anyUID as false; callers substitute the server's own user.
+func (u UnixSocket) UIDs() (uids map[int]bool, anyUID bool, err error) {
+	uids = make(map[int]bool)
+	for _, entry := range u.AllowedUIDs {
+		if entry == "*" {
+			anyUID = true
+			continue
+		}
+		uid, err := strconv.Atoi(entry)
+		if err != nil || uid < 0 {
+			return nil, false, fmt.Errorf("must list numeric user IDs or \"*\", got %q", entry)
+		}
+		uids[uid] = true
+	}
+	return uids, anyUID, nil
+}
+
+// Version returns the minimum TLS version as a crypto/tls constant.
+func (t TLS) Version() (uint16, error) {
+	switch t.MinVersion {
+	case "1.2":
+		return tls.VersionTLS12, nil
+	case "1.3":
+		return tls.VersionTLS13, nil
+	default:
+		return 0, fmt.Errorf("must be 1.2 or 1.3, got %q", t.MinVersion)
+	}
+}
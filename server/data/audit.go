@@ -0,0 +1,303 @@
+package data
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/api/iterator"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// AuditAction identifies the kind of operation recorded in the audit log.
+type AuditAction string
+
+// Actions recorded in the audit log.
+const (
+	AuditList   AuditAction = "list"
+	AuditReveal AuditAction = "reveal"
+	AuditCreate AuditAction = "create"
+	AuditUpdate AuditAction = "update"
+	AuditDelete AuditAction = "delete"
+	AuditScan   AuditAction = "scan"
+	AuditImport AuditAction = "import"
+	AuditExport AuditAction = "export"
+	AuditBackup AuditAction = "backup"
+	AuditShare  AuditAction = "share"
+	// AuditRateLimit records a request the rate limiter rejected; its target is the request's path.
+	AuditRateLimit AuditAction = "ratelimit"
+)
+
+// Results recorded in the audit log.
+const (
+	AuditSuccess = "success"
+	AuditFailure = "failure"
+	AuditDenied  = "denied"
+)
+
+// AuditEntry is a single record in the append-only audit log.
+//
+// Every entry carries the hash of its predecessor in PrevHash, and Hash is an HMAC over the entry's
+// fields and PrevHash keyed from the encryption key. Altering, removing, or reordering an entry breaks
+// the chain, and forging a replacement chain requires the encryption key.
+type AuditEntry struct {
+	Seq        int64       `firestore:"seq" json:"seq"`
+	Time       time.Time   `firestore:"time" json:"time"`
+	Actor      string      `firestore:"actor" json:"actor"`
+	Action     AuditAction `firestore:"action" json:"action"`
+	Target     string      `firestore:"target" json:"target,omitempty"`
+	ClientAddr string      `firestore:"clientAddr" json:"clientAddr"`
+	Result     string      `firestore:"result" json:"result"`
+	Status     int         `firestore:"status" json:"status"`
+	PrevHash   string      `firestore:"prevHash" json:"prevHash"`
+	Hash       string      `firestore:"hash" json:"hash"`
+}
+
+// AuditFilter narrows the entries returned by QueryAudit. Zero-valued fields match everything.
+type AuditFilter struct {
+	Actor  string
+	Action AuditAction
+	Target string
+	Since  time.Time
+	Until  time.Time
+	Limit  int
+}
+
+// AuditVerification is the outcome of checking the audit log's hash chain.
+type AuditVerification struct {
+	Valid    bool   `json:"valid"`
+	Entries  int64  `json:"entries"`
+	BrokenAt int64  `json:"brokenAt,omitempty"`
+	Reason   string `json:"reason,omitempty"`
+}
+
+const auditCollection = "audit"
+
+// defaultAuditLimit caps QueryAudit results when the filter does not specify a limit.
+const defaultAuditLimit = 100
+
+// auditMutex serializes appends so that entries are chained in the order they are written.
+var auditMutex = &sync.Mutex{}
+
+// auditHead caches the sequence number and hash of the newest entry written by this process.
+// auditHeadKnown is false until the head has been read from Firestore.
+var auditHead struct {
+	seq  int64
+	hash string
+}
+var auditHeadKnown bool
+
+// AppendAudit appends an entry to the audit log and returns it with its sequence number, timestamp,
+// and hashes filled in.
+//
+// Entries are stored in the Firestore "audit" collection under zero-padded sequence numbers. Documents
+// are created rather than overwritten, so if another process appended concurrently the head is re-read
+// and the append retried.
+func AppendAudit(ctx context.Context, entry AuditEntry) (AuditEntry, error) {
+	auditMutex.Lock()
+	defer auditMutex.Unlock()
+
+	const maxAttempts = 5
+	for attempt := 0; attempt < maxAttempts; attempt++ {
+		if !auditHeadKnown {
+			if err := loadAuditHeadLocked(ctx); err != nil {
+				return AuditEntry{}, err
+			}
+		}
+
+		entry.Seq = auditHead.seq + 1
+		// Firestore stores timestamps with microsecond precision; truncate so the hash survives a round trip.
+		entry.Time = time.Now().UTC().Truncate(time.Microsecond)
+		entry.PrevHash = auditHead.hash
+		entry.Hash = auditHash(entry)
+
+		_, err := firestoreClient.Collection(auditCollection).Doc(auditDocID(entry.Seq)).Create(ctx, entry)
+		if err == nil {
+			auditHead.seq = entry.Seq
+			auditHead.hash = entry.Hash
+			return entry, nil
+		}
+		if status.Code(err) != codes.AlreadyExists {
+			return AuditEntry{}, fmt.Errorf("failed to append audit entry: %w", err)
+		}
+		auditHeadKnown = false
+	}
+	return AuditEntry{}, fmt.Errorf("failed to append audit entry: log head kept moving after %d attempts", maxAttempts)
+}
+
+// loadAuditHeadLocked reads the newest audit entry from Firestore into auditHead.
+// The caller must hold auditMutex.
+func loadAuditHeadLocked(ctx context.Context) error {
+	iter := firestoreClient.Collection(auditCollection).OrderBy("seq", firestore.Desc).Limit(1).Documents(ctx)
+	defer iter.Stop()
+
+	auditHead.seq, auditHead.hash = 0, ""
+	doc, err := iter.Next()
+	if err != nil && err != iterator.Done {
+		return fmt.Errorf("failed to read audit log head: %w", err)
+	}
+	if err == nil {
+		var last AuditEntry
+		if err := doc.DataTo(&last); err != nil {
+			return fmt.Errorf("failed to parse audit log head: %w", err)
+		}
+		auditHead.seq, auditHead.hash = last.Seq, last.Hash
+	}
+	auditHeadKnown = true
+	return nil
+}
+
+// QueryAudit returns audit entries matching the filter, newest first.
+//
+// Filtering happens in memory while walking the log in sequence order, which avoids requiring
+// composite Firestore indexes for every combination of filter fields.
+func QueryAudit(ctx context.Context, filter AuditFilter) ([]AuditEntry, error) {
+	limit := filter.Limit
+	if limit <= 0 {
+		limit = defaultAuditLimit
+	}
+
+	entries := []AuditEntry{}
+	iter := firestoreClient.Collection(auditCollection).OrderBy("seq", firestore.Desc).Documents(ctx)
+	defer iter.Stop()
+	for len(entries) < limit {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to iterate audit log: %w", err)
+		}
+
+		var entry AuditEntry
+		if err := doc.DataTo(&entry); err != nil {
+			return nil, fmt.Errorf("failed to parse audit entry %s: %w", doc.Ref.ID, err)
+		}
+		if !filter.Since.IsZero() && entry.Time.Before(filter.Since) {
+			break // Entries are in descending order, so nothing older can match.
+		}
+		if filter.matches(entry) {
+			entries = append(entries, entry)
+		}
+	}
+	return entries, nil
+}
+
+func (f AuditFilter) matches(entry AuditEntry) bool {
+	if f.Actor != "" && entry.Actor != f.Actor {
+		return false
+	}
+	if f.Action != "" && entry.Action != f.Action {
+		return false
+	}
+	if f.Target != "" && entry.Target != f.Target {
+		return false
+	}
+	if !f.Until.IsZero() && entry.Time.After(f.Until) {
+		return false
+	}
+	return true
+}
+
+// VerifyAudit walks the whole audit log in sequence order and checks that sequence numbers are
+// contiguous, that each entry references its predecessor's hash, and that each hash matches the
+// entry's contents. It reports the first entry at which the chain breaks.
+//
+// Truncating the newest entries cannot be detected from the chain alone; VerifyAudit additionally
+// compares the log against the newest entry this process has written.
+func VerifyAudit(ctx context.Context) (AuditVerification, error) {
+	var result AuditVerification
+	var prevHash string
+
+	iter := firestoreClient.Collection(auditCollection).OrderBy("seq", firestore.Asc).Documents(ctx)
+	defer iter.Stop()
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return AuditVerification{}, fmt.Errorf("failed to iterate audit log: %w", err)
+		}
+
+		expected := result.Entries + 1
+		var entry AuditEntry
+		if err := doc.DataTo(&entry); err != nil {
+			return result.broken(expected, fmt.Sprintf("entry %s cannot be parsed: %v", doc.Ref.ID, err)), nil
+		}
+		switch {
+		case doc.Ref.ID != auditDocID(entry.Seq):
+			return result.broken(expected, fmt.Sprintf("document %s holds sequence number %d", doc.Ref.ID, entry.Seq)), nil
+		case entry.Seq != expected:
+			return result.broken(expected, fmt.Sprintf("expected sequence number %d, found %d", expected, entry.Seq)), nil
+		case entry.PrevHash != prevHash:
+			return result.broken(expected, "previous-hash link does not match the preceding entry"), nil
+		case !hmac.Equal([]byte(entry.Hash), []byte(auditHash(entry))):
+			return result.broken(expected, "entry hash does not match its contents"), nil
+		}
+		result.Entries = entry.Seq
+		prevHash = entry.Hash
+	}
+
+	auditMutex.Lock()
+	known, headSeq := auditHeadKnown, auditHead.seq
+	auditMutex.Unlock()
+	if known && result.Entries < headSeq {
+		return result.broken(result.Entries+1, fmt.Sprintf("log ends at %d but entry %d was written by this server", result.Entries, headSeq)), nil
+	}
+
+	result.Valid = true
+	return result, nil
+}
+
+func (v AuditVerification) broken(seq int64, reason string) AuditVerification {
+	v.Valid = false
+	v.BrokenAt = seq
+	v.Reason = reason
+	return v
+}
+
+// auditDocID returns the document ID for a sequence number, zero-padded so IDs sort like the numbers.
+func auditDocID(seq int64) string {
+	return fmt.Sprintf("%020d", seq)
+}
+
+// auditHash computes the chained HMAC-SHA256 of an entry. Each field is length-prefixed so that
+// no two distinct entries share an encoding.
+func auditHash(entry AuditEntry) string {
+	mac := hmac.New(sha256.New, auditKey())
+	fields := []string{
+		strconv.FormatInt(entry.Seq, 10),
+		entry.Time.UTC().Format(time.RFC3339Nano),
+		entry.Actor,
+		string(entry.Action),
+		entry.Target,
+		entry.ClientAddr,
+		entry.Result,
+		strconv.Itoa(entry.Status),
+		entry.PrevHash,
+	}
+	var length [4]byte
+	for _, field := range fields {
+		binary.BigEndian.PutUint32(length[:], uint32(len(field)))
+		mac.Write(length[:])
+		mac.Write([]byte(field))
+	}
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// auditKey derives the audit log's MAC key from the encryption key, so the key used to encrypt
+// passwords is never used directly for anything else.
+func auditKey() []byte {
+	mac := hmac.New(sha256.New, encryptionKey)
+	mac.Write([]byte("pasword-mango audit log v1"))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,391 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/rihts-4/pasword-mango/backup"
+	"google.golang.org/api/iterator"
+)
+
+// Restore modes, deciding what happens to stored data that is not in the backup.
+const (
+	// RestoreMerge writes the backed-up credentials and rotation policies over those of the same name, and keeps the rest.
+	RestoreMerge = "merge"
+	// RestoreReplace makes the vault hold exactly what the backup holds, deleting everything else.
+	RestoreReplace = "replace"
+)
+
+// ErrInvalidRestoreMode is returned by Restore for an unknown mode.
+var ErrInvalidRestoreMode = errors.New("restore mode must be merge or replace")
+
+// RestoreOptions control a restore.
+type RestoreOptions struct {
+	// Mode is RestoreMerge or RestoreReplace. Empty means RestoreMerge.
+	Mode string
+	// DryRun reports what would happen without writing anything.
+	DryRun bool
+}
+
+// RestoreFailure is a backed-up site that could not be restored.
+type RestoreFailure struct {
+	Site   string `json:"site"`
+	Reason string `json:"reason"`
+}
+
+// RestoreReport summarizes a restore. It never includes a password.
+type RestoreReport struct {
+	Mode      string `json:"mode"`
+	DryRun    bool   `json:"dryRun"`
+	Created   int    `json:"created"`
+	Updated   int    `json:"updated"`
+	Unchanged int    `json:"unchanged"`
+	// Deleted counts the credentials, and DeletedPolicies the rotation policies, that a replace removed.
+	Deleted         int              `json:"deleted"`
+	Policies        int              `json:"policies"`
+	DeletedPolicies int              `json:"deletedPolicies"`
+	Failed          []RestoreFailure `json:"failed"`
+	// Verified counts the restored credentials that were read back, decrypted, and found identical to the backup.
+	// Mismatched lists the sites that were not, and after a replace also any site left that is not in the backup.
+	Verified   int      `json:"verified"`
+	Mismatched []string `json:"mismatched"`
+}
+
+// Backup reads the whole vault into a backup archive: every credential with its details, earlier passwords, and
+// the time its password was changed, the rotation policies, and the vault metadata. The credentials are decrypted,
+// sorted by site, and returned along with the sites that could not be read or decrypted, which are logged and left
+// out. It holds the credentials lock while reading, so that the archive is consistent with this server's writes, and
+// returns an error only if Firestore cannot be read.
+func Backup(ctx context.Context) (backup.Archive, []string, error) {
+	credMutex.Lock()
+	defer credMutex.Unlock()
+
+	archive := backup.Archive{CreatedAt: time.Now().UTC(), Items: []backup.Item{}}
+	meta, err := GetVaultMeta(ctx)
+	if err != nil && !errors.Is(err, ErrVaultNotInitialized) {
+		return backup.Archive{}, nil, err
+	}
+	if err == nil {
+		archive.Vault = backup.Vault{CreatedAt: meta.CreatedAt, FormatVersion: meta.FormatVersion}
+	}
+
+	failed, err := walkCredentials(ctx, func(c storedCredential) error {
+		archive.Items = append(archive.Items, backupItem(c.Site, c.Credentials, c.ChangedAt))
+		return nil
+	})
+	if err != nil {
+		return backup.Archive{}, nil, err
+	}
+	sort.Slice(archive.Items, func(i, j int) bool { return archive.Items[i].Site < archive.Items[j].Site })
+
+	policies, err := RotationPolicies(ctx)
+	if err != nil {
+		return backup.Archive{}, nil, err
+	}
+	for _, policy := range policies {
+		archive.RotationPolicies = append(archive.RotationPolicies, backup.RotationPolicy(policy))
+	}
+	return archive, failed, nil
+}
+
+// Restore writes the content of a backup archive into the vault, encrypting it with the vault's key, and then reads
+// every restored credential back to verify it. Credentials keep the site name, password change time, and history
+// they were backed up with; sites already holding identical credentials are left alone, so restoring the same backup
+// twice changes nothing. A vault without metadata gets the metadata of the backup, with a new canary.
+//
+// Backed-up credentials that fail the checks POST /credentials makes are reported as failed without stopping the
+// restore, and the vault's credentials for their site are left as they are, even by a replace. Restore holds the
+// credentials lock throughout. It returns ErrInvalidRestoreMode for an unknown mode, ErrWrongKey if the vault already
+// holds data encrypted with another key, and an error if Firestore cannot be read.
+func Restore(ctx context.Context, archive backup.Archive, opts RestoreOptions) (RestoreReport, error) {
+	if opts.Mode == "" {
+		opts.Mode = RestoreMerge
+	}
+	if opts.Mode != RestoreMerge && opts.Mode != RestoreReplace {
+		return RestoreReport{}, ErrInvalidRestoreMode
+	}
+	if _, err := CheckKey(ctx); err != nil && !errors.Is(err, ErrVaultNotInitialized) {
+		return RestoreReport{}, err
+	}
+	_, err := GetVaultMeta(ctx)
+	needsMeta := errors.Is(err, ErrVaultNotInitialized)
+	if err != nil && !needsMeta {
+		return RestoreReport{}, err
+	}
+
+	credMutex.Lock()
+	defer credMutex.Unlock()
+
+	report := RestoreReport{Mode: opts.Mode, DryRun: opts.DryRun, Failed: []RestoreFailure{}, Mismatched: []string{}}
+	stored, err := storedSitesLocked(ctx)
+	if err != nil {
+		return RestoreReport{}, err
+	}
+
+	// named holds every site in the backup, including the ones that fail, so that a replace never deletes the
+	// vault's copy of a site whose backed-up item could not be restored
+	restored := make(map[string]Credentials)
+	named := make(map[string]bool)
+	for _, item := range archive.Items {
+		named[item.Site] = true
+		creds, err := restoredCredentials(item)
+		if err != nil {
+			report.Failed = append(report.Failed, RestoreFailure{Site: item.Site, Reason: err.Error()})
+			continue
+		}
+		if _, ok := restored[item.Site]; ok {
+			report.Failed = append(report.Failed, RestoreFailure{Site: item.Site, Reason: "site appears more than once in the backup"})
+			continue
+		}
+		restored[item.Site] = creds
+
+		if !stored[item.Site] {
+			report.Created++
+		} else if current, ok := currentLocked(ctx, item.Site); ok && sameCredentials(current, creds) {
+			report.Unchanged++
+			continue
+		} else {
+			report.Updated++
+		}
+		if !opts.DryRun {
+			if err := writeRestoredLocked(ctx, item.Site, creds); err != nil {
+				report.Failed = append(report.Failed, RestoreFailure{Site: item.Site, Reason: err.Error()})
+			}
+		}
+	}
+
+	if opts.Mode == RestoreReplace {
+		for site := range stored {
+			if named[site] {
+				continue
+			}
+			report.Deleted++
+			if opts.DryRun {
+				continue
+			}
+			if _, err := firestoreClient.Collection("credentials").Doc(site).Delete(ctx); err != nil {
+				report.Failed = append(report.Failed, RestoreFailure{Site: site, Reason: fmt.Sprintf("failed to delete: %v", err)})
+			}
+		}
+	}
+	if err := restorePoliciesLocked(ctx, archive.RotationPolicies, opts, &report); err != nil {
+		return RestoreReport{}, err
+	}
+
+	if opts.DryRun {
+		return report, nil
+	}
+	if needsMeta {
+		createdAt := archive.Vault.CreatedAt
+		if createdAt.IsZero() {
+			createdAt = time.Now().UTC()
+		}
+		if _, err := createVaultMeta(ctx, createdAt); err != nil && !errors.Is(err, ErrVaultExists) {
+			return RestoreReport{}, err
+		}
+	}
+	if err := verifyRestoreLocked(ctx, restored, named, opts.Mode, &report); err != nil {
+		return RestoreReport{}, err
+	}
+	return report, nil
+}
+
+// restorePoliciesLocked writes the backed-up rotation policies and, for a replace, deletes the others. A policy that
+// is invalid is reported as failed, and a replace keeps any stored policy of its name. The caller must hold credMutex.
+func restorePoliciesLocked(ctx context.Context, policies []backup.RotationPolicy, opts RestoreOptions, report *RestoreReport) error {
+	current, err := RotationPolicies(ctx)
+	if err != nil {
+		return err
+	}
+	kept := make(map[string]bool)
+	for _, backedUp := range policies {
+		policy := RotationPolicy(backedUp)
+		kept[policy.String()] = true
+		if (policy.Scope != RotationScopeSite && policy.Scope != RotationScopeTag) || policy.Name == "" ||
+			strings.Contains(policy.Name, "/") || policy.Days <= 0 {
+			report.Failed = append(report.Failed, RestoreFailure{Site: policy.String(), Reason: ErrInvalidPolicy.Error()})
+			continue
+		}
+		report.Policies++
+		if opts.DryRun {
+			continue
+		}
+		if _, err := rotationPolicyRef(policy.Scope, policy.Name).Set(ctx, policy); err != nil {
+			report.Failed = append(report.Failed, RestoreFailure{Site: policy.String(), Reason: fmt.Sprintf("failed to store rotation policy: %v", err)})
+		}
+	}
+	if opts.Mode != RestoreReplace {
+		return nil
+	}
+	for _, policy := range current {
+		if kept[policy.String()] {
+			continue
+		}
+		report.DeletedPolicies++
+		if opts.DryRun {
+			continue
+		}
+		if _, err := rotationPolicyRef(policy.Scope, policy.Name).Delete(ctx); err != nil {
+			report.Failed = append(report.Failed, RestoreFailure{Site: policy.String(), Reason: fmt.Sprintf("failed to delete rotation policy: %v", err)})
+		}
+	}
+	return nil
+}
+
+// verifyRestoreLocked reads back every restored credential and records in the report whether it matches the
+// backup. After a replace it also reports any stored site that is not in the backup. The caller must hold credMutex.
+func verifyRestoreLocked(ctx context.Context, restored map[string]Credentials, named map[string]bool, mode string, report *RestoreReport) error {
+	for site, creds := range restored {
+		if current, ok := currentLocked(ctx, site); ok && sameCredentials(current, creds) {
+			report.Verified++
+		} else {
+			report.Mismatched = append(report.Mismatched, site)
+		}
+	}
+	if mode == RestoreReplace {
+		stored, err := storedSitesLocked(ctx)
+		if err != nil {
+			return err
+		}
+		for site := range stored {
+			if !named[site] {
+				report.Mismatched = append(report.Mismatched, site)
+			}
+		}
+	}
+	sort.Strings(report.Mismatched)
+	if len(report.Mismatched) > 0 {
+		log.Printf("Restore verification failed for %d sites: %s", len(report.Mismatched), strings.Join(report.Mismatched, ", "))
+	}
+	return nil
+}
+
+// backupItem converts decrypted credentials into an archive item.
+func backupItem(site string, creds Credentials, changedAt time.Time) backup.Item {
+	item := backup.Item{
+		Site:              site,
+		Username:          creds.Username,
+		Password:          creds.Password,
+		Tags:              creds.Tags,
+		Notes:             creds.Notes,
+		TOTP:              creds.TOTP,
+		PasswordChangedAt: changedAt,
+	}
+	for _, field := range creds.Fields {
+		item.Fields = append(item.Fields, backup.Field(field))
+	}
+	for _, version := range creds.History {
+		item.History = append(item.History, backup.Version(version))
+	}
+	return item
+}
+
+// restoredCredentials converts an archive item into the credentials to store, applying the checks that
+// POST /credentials makes. Earlier passwords beyond maxPasswordHistory are dropped.
+func restoredCredentials(item backup.Item) (Credentials, error) {
+	switch {
+	case item.Site == "":
+		return Credentials{}, errors.New("site is empty")
+	case strings.Contains(item.Site, "/"):
+		return Credentials{}, errors.New("site must not contain a slash")
+	case len(item.Site) > MaxSiteLength:
+		return Credentials{}, fmt.Errorf("site exceeds %d characters", MaxSiteLength)
+	case item.Username == "":
+		return Credentials{}, errors.New("username is empty")
+	case item.Password == "":
+		return Credentials{}, errors.New("password is empty")
+	case len(item.Username) > MaxUsernameLength:
+		return Credentials{}, fmt.Errorf("username exceeds %d characters", MaxUsernameLength)
+	case len(item.Password) > MaxPasswordLength:
+		return Credentials{}, fmt.Errorf("password exceeds %d characters", MaxPasswordLength)
+	}
+	tags, err := NormalizeTags(item.Tags)
+	if err != nil {
+		return Credentials{}, err
+	}
+	creds := Credentials{
+		Username:          item.Username,
+		Password:          item.Password,
+		Tags:              tags,
+		Notes:             item.Notes,
+		TOTP:              item.TOTP,
+		PasswordChangedAt: item.PasswordChangedAt.UTC(),
+	}
+	if len(creds.Tags) == 0 {
+		creds.Tags = nil
+	}
+	for _, field := range item.Fields {
+		creds.Fields = append(creds.Fields, CustomField(field))
+	}
+	if err := CheckDetails(creds); err != nil {
+		return Credentials{}, err
+	}
+	for _, version := range item.History {
+		if len(creds.History) < maxPasswordHistory {
+			creds.History = append(creds.History, PasswordVersion{Username: version.Username, Password: version.Password, ChangedAt: version.ChangedAt.UTC()})
+		}
+	}
+	return creds, nil
+}
+
+// writeRestoredLocked stores restored credentials as they are, unlike updateLocked, which records a new password
+// change time and history. The caller must hold credMutex.
+func writeRestoredLocked(ctx context.Context, site string, creds Credentials) error {
+	encrypted, err := encryptCredentials(creds)
+	if err != nil {
+		return fmt.Errorf("failed to encrypt credentials: %v", err)
+	}
+	if _, err := firestoreClient.Collection("credentials").Doc(site).Set(ctx, encrypted); err != nil {
+		return fmt.Errorf("failed to store credentials: %v", err)
+	}
+	return nil
+}
+
+// currentLocked reads and decrypts the credentials stored for a site, with the effective password change time. It
+// reports false if there are none or they cannot be read or decrypted. The caller must hold credMutex.
+func currentLocked(ctx context.Context, site string) (Credentials, bool) {
+	doc, stored, ok := storedLocked(ctx, site)
+	if !ok {
+		return Credentials{}, false
+	}
+	creds, err := decryptCredentials(stored)
+	if err != nil {
+		return Credentials{}, false
+	}
+	creds.PasswordChangedAt = passwordChangedAt(doc, stored)
+	return creds, true
+}
+
+// storedSitesLocked returns the names of every stored site. The caller must hold credMutex.
+func storedSitesLocked(ctx context.Context) (map[string]bool, error) {
+	sites := make(map[string]bool)
+	iter := firestoreClient.Collection("credentials").Documents(ctx)
+	defer iter.Stop()
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			return sites, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to iterate credentials: %w", err)
+		}
+		sites[doc.Ref.ID] = true
+	}
+}
+
+// sameCredentials reports whether two credentials are identical, including their details, history, and password
+// change time.
+func sameCredentials(a, b Credentials) bool {
+	return a.Username == b.Username && a.Password == b.Password && slices.Equal(a.Tags, b.Tags) &&
+		a.Notes == b.Notes && a.TOTP == b.TOTP && slices.Equal(a.Fields, b.Fields) &&
+		a.PasswordChangedAt.Equal(b.PasswordChangedAt) &&
+		slices.EqualFunc(a.History, b.History, func(x, y PasswordVersion) bool {
+			return x.Username == y.Username && x.Password == y.Password && x.ChangedAt.Equal(y.ChangedAt)
+		})
+}
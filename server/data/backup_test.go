@@ -0,0 +1,84 @@
+package data
+
+import (
+	"context"
+	"crypto/rand"
+	"log"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/rihts-4/pasword-mango/backup"
+	"github.com/rihts-4/pasword-mango/config"
+	"github.com/rihts-4/pasword-mango/firestoretest"
+)
+
+// fakeFirestore is the in-memory Firestore the package is connected to for the tests.
+var fakeFirestore *firestoretest.Server
+
+func TestMain(m *testing.M) {
+	var err error
+	if fakeFirestore, err = firestoretest.NewServer(); err != nil {
+		log.Fatal(err)
+	}
+	key := make([]byte, 32)
+	rand.Read(key)
+	storage := config.Storage{Backend: "firestore", ProjectID: "pasword-mango-test", EmulatorHost: fakeFirestore.Addr}
+	if err := OpenDB(context.Background(), storage, key); err != nil {
+		log.Fatal(err)
+	}
+	code := m.Run()
+	CloseDB()
+	fakeFirestore.Close()
+	os.Exit(code)
+}
+
+func TestRestoreReplaceKeepsFailedSites(t *testing.T) {
+	fakeFirestore.Reset()
+	ctx := context.Background()
+	for site, password := range map[string]string{"kept.com": "old-kept", "gone.com": "old-gone", "same.com": "old-same"} {
+		if err := Store(ctx, site, Credentials{Username: "alice", Password: password}); err != nil {
+			t.Fatalf("Store(%s): %v", site, err)
+		}
+	}
+	changedAt := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	archive := backup.Archive{Items: []backup.Item{
+		{Site: "kept.com", Username: "alice", PasswordChangedAt: changedAt}, // No password, so it fails
+		{Site: "new.org", Username: "bob", Password: "new-password", PasswordChangedAt: changedAt},
+		{Site: "same.com", Username: "alice", Password: "new-same", PasswordChangedAt: changedAt},
+	}}
+
+	dryRun, err := Restore(ctx, archive, RestoreOptions{Mode: RestoreReplace, DryRun: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dryRun.Deleted != 1 {
+		t.Errorf("dry run would delete %d sites, want only gone.com", dryRun.Deleted)
+	}
+
+	report, err := Restore(ctx, archive, RestoreOptions{Mode: RestoreReplace})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Deleted != 1 || report.Created != 1 || report.Updated != 1 {
+		t.Errorf("report %+v, want 1 deleted, 1 created, and 1 updated", report)
+	}
+	if len(report.Failed) != 1 || report.Failed[0].Site != "kept.com" {
+		t.Errorf("failed %+v, want kept.com alone", report.Failed)
+	}
+	if len(report.Mismatched) != 0 {
+		t.Errorf("mismatched %v, want none", report.Mismatched)
+	}
+
+	if creds, ok := Retrieve(ctx, "kept.com"); !ok || creds.Password != "old-kept" {
+		t.Errorf("kept.com is %+v, %v; want its credentials from before the restore", creds, ok)
+	}
+	if _, ok := Retrieve(ctx, "gone.com"); ok {
+		t.Error("gone.com, which is not in the backup, was not deleted")
+	}
+	for site, password := range map[string]string{"new.org": "new-password", "same.com": "new-same"} {
+		if creds, ok := Retrieve(ctx, site); !ok || creds.Password != password {
+			t.Errorf("%s is %+v, %v; want the backed-up password", site, creds, ok)
+		}
+	}
+}
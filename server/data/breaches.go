@@ -0,0 +1,98 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+
+	"github.com/rihts-4/pasword-mango/pwned"
+)
+
+// ErrNoBreachFile is returned by ScanBreaches when no Pwned Passwords file is configured.
+var ErrNoBreachFile = errors.New("no Pwned Passwords file configured")
+
+// breachFile is the Pwned Passwords file passwords are checked against, or nil if none is configured.
+var breachFile *pwned.File
+
+// SetBreachFile sets the Pwned Passwords file that Import, BreachCount, and ScanBreaches check passwords
+// against. nil disables the checks. The caller keeps ownership of the file and closes it after the last check.
+func SetBreachFile(f *pwned.File) {
+	breachFile = f
+}
+
+// BreachCount returns the number of times a password appears in known breaches according to the configured
+// Pwned Passwords file, or 0 if it does not appear or no file is configured.
+func BreachCount(password string) (int, error) {
+	if breachFile == nil {
+		return 0, nil
+	}
+	count, err := breachFile.Count(password)
+	if err != nil {
+		return 0, fmt.Errorf("failed to check password against breach file: %w", err)
+	}
+	return count, nil
+}
+
+// warnIfBreached logs a warning when a password being stored for a site appears in known breaches. A failed
+// check is logged too, but never prevents the password from being stored.
+func warnIfBreached(site string, password string) {
+	count, err := BreachCount(password)
+	if err != nil {
+		log.Printf("Failed to check the password for site %s against known breaches: %v", site, err)
+		return
+	}
+	if count > 0 {
+		log.Printf("Warning: the password stored for site %s appears %d times in known data breaches", site, count)
+	}
+}
+
+// BreachedCredential is a stored credential whose password appears in known breaches.
+type BreachedCredential struct {
+	Site     string `json:"site"`
+	Username string `json:"username"`
+	// Count is the number of times the password appears in the breaches.
+	Count int `json:"count"`
+}
+
+// BreachReport is the result of a vault-wide breach scan.
+type BreachReport struct {
+	// Checked is the number of credentials whose password was checked.
+	Checked int `json:"checked"`
+	// Breached lists the credentials with breached passwords, most frequently breached first.
+	Breached []BreachedCredential `json:"breached"`
+	// Failed lists the sites whose password could not be read or checked.
+	Failed []string `json:"failed,omitempty"`
+}
+
+// ScanBreaches decrypts every stored password and checks it against the configured Pwned Passwords file. Passwords
+// are only held in memory while they are checked, and never leave the process.
+//
+// It returns ErrNoBreachFile if no file is configured, and an error if iterating the credentials fails. Credentials
+// that cannot be parsed, decrypted, or checked are logged and listed in the report's Failed sites.
+func ScanBreaches(ctx context.Context) (BreachReport, error) {
+	if breachFile == nil {
+		return BreachReport{}, ErrNoBreachFile
+	}
+	report := BreachReport{Breached: []BreachedCredential{}}
+	failed, err := walkCredentials(ctx, func(c storedCredential) error {
+		count, err := breachFile.Count(c.Password)
+		if err != nil {
+			return err
+		}
+		report.Checked++
+		if count > 0 {
+			report.Breached = append(report.Breached, BreachedCredential{Site: c.Site, Username: c.Username, Count: count})
+		}
+		return nil
+	})
+	if err != nil {
+		return BreachReport{}, err
+	}
+	report.Failed = failed
+	sort.SliceStable(report.Breached, func(i, j int) bool {
+		return report.Breached[i].Count > report.Breached[j].Count
+	})
+	return report, nil
+}
@@ -0,0 +1,109 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/api/iterator"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ErrClientCertNotFound is returned when no client certificate with the requested serial number has been issued.
+var ErrClientCertNotFound = errors.New("client certificate not found")
+
+// ClientCert records a client certificate issued by the server's certificate authority.
+// Only certificates recorded here and not revoked are accepted in mutual TLS mode.
+type ClientCert struct {
+	Serial    string    `firestore:"serial" json:"serial"`
+	Name      string    `firestore:"name" json:"name"`
+	IssuedAt  time.Time `firestore:"issuedAt" json:"issuedAt"`
+	NotAfter  time.Time `firestore:"notAfter" json:"notAfter"`
+	Revoked   bool      `firestore:"revoked" json:"revoked"`
+	RevokedAt time.Time `firestore:"revokedAt" json:"revokedAt,omitzero"`
+}
+
+const clientCertCollection = "client_certs"
+
+// RecordClientCert stores a newly issued client certificate, keyed by its serial number.
+func RecordClientCert(ctx context.Context, cert ClientCert) error {
+	_, err := firestoreClient.Collection(clientCertCollection).Doc(cert.Serial).Create(ctx, cert)
+	if err != nil {
+		return fmt.Errorf("failed to record client certificate %s: %w", cert.Serial, err)
+	}
+	return nil
+}
+
+// GetClientCert returns the record for the client certificate with the given serial number,
+// or ErrClientCertNotFound if it was never issued.
+func GetClientCert(ctx context.Context, serial string) (ClientCert, error) {
+	doc, err := firestoreClient.Collection(clientCertCollection).Doc(serial).Get(ctx)
+	if status.Code(err) == codes.NotFound {
+		return ClientCert{}, ErrClientCertNotFound
+	}
+	if err != nil {
+		return ClientCert{}, fmt.Errorf("failed to get client certificate %s: %w", serial, err)
+	}
+	var cert ClientCert
+	if err := doc.DataTo(&cert); err != nil {
+		return ClientCert{}, fmt.Errorf("failed to parse client certificate %s: %w", serial, err)
+	}
+	return cert, nil
+}
+
+// ListClientCerts returns every issued client certificate, including revoked ones, oldest first.
+func ListClientCerts(ctx context.Context) ([]ClientCert, error) {
+	certs := []ClientCert{}
+	iter := firestoreClient.Collection(clientCertCollection).OrderBy("issuedAt", firestore.Asc).Documents(ctx)
+	defer iter.Stop()
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to iterate client certificates: %w", err)
+		}
+		var cert ClientCert
+		if err := doc.DataTo(&cert); err != nil {
+			return nil, fmt.Errorf("failed to parse client certificate %s: %w", doc.Ref.ID, err)
+		}
+		certs = append(certs, cert)
+	}
+	return certs, nil
+}
+
+// RevokeClientCert marks the client certificate with the given serial number as revoked and returns
+// the updated record. Revoking an already revoked certificate keeps its original revocation time.
+func RevokeClientCert(ctx context.Context, serial string) (ClientCert, error) {
+	docRef := firestoreClient.Collection(clientCertCollection).Doc(serial)
+	var cert ClientCert
+	err := firestoreClient.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		doc, err := tx.Get(docRef)
+		if status.Code(err) == codes.NotFound {
+			return ErrClientCertNotFound
+		}
+		if err != nil {
+			return err
+		}
+		if err := doc.DataTo(&cert); err != nil {
+			return err
+		}
+		if cert.Revoked {
+			return nil
+		}
+		cert.Revoked = true
+		cert.RevokedAt = time.Now().UTC()
+		return tx.Set(docRef, cert)
+	})
+	if errors.Is(err, ErrClientCertNotFound) {
+		return ClientCert{}, err
+	}
+	if err != nil {
+		return ClientCert{}, fmt.Errorf("failed to revoke client certificate %s: %w", serial, err)
+	}
+	return cert, nil
+}
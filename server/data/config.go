@@ -2,7 +2,6 @@ package data
 
 import (
 	"context"
-	"encoding/hex"
 	"fmt"
 	"log"
 	"os"
@@ -10,7 +9,7 @@ import (
 
 	"cloud.google.com/go/firestore"
 	firebase "firebase.google.com/go/v4"
-	"github.com/joho/godotenv"
+	"github.com/rihts-4/pasword-mango/config"
 	"google.golang.org/api/option"
 )
 
@@ -19,33 +18,50 @@ var firestoreClient *firestore.Client
 var encryptionKey []byte
 var credMutex = &sync.Mutex{}
 
-// InitDB loads environment configuration, validates a hex-encoded AES-256 encryption key, and initializes the Firestore client.
+// InitDB loads the AES-256 encryption key and initializes the Firestore client.
 //
-// It loads variables from a ".env" file and reads ENCRYPTION_KEY, PROJECT_ID, and GOOGLE_APPLICATION_CREDENTIALS from the environment. On success it assigns the decoded 32-byte AES key to the package-level `encryptionKey` and the initialized Firestore client to the package-level `firestoreClient`. It returns an error if the encryption key is missing, cannot be hex-decoded, is not 32 bytes long, or if Firebase app or Firestore client initialization fails.
-func InitDB(ctx context.Context) error {
-	err := godotenv.Load(".env")
-	if err != nil {
-		return fmt.Errorf("error loading .env file: %v", err)
+// It reads the key from the source selected by the key configuration (see LoadKey) and the project, service account credentials, and optional emulator address from the storage configuration. On success it assigns the decoded 32-byte AES key to the package-level `encryptionKey` and the initialized Firestore client to the package-level `firestoreClient`. It returns an error if the backend is not supported, the encryption key cannot be loaded, or if Firebase app or Firestore client initialization fails.
+func InitDB(ctx context.Context, storage config.Storage, key config.Key) error {
+	if storage.Backend != "firestore" {
+		return fmt.Errorf("unsupported storage backend %q", storage.Backend)
 	}
 
 	// Load and validate the encryption key
-	keyString := os.Getenv("ENCRYPTION_KEY")
-	if keyString == "" {
-		return fmt.Errorf("ENCRYPTION_KEY environment variable not set")
-	}
-	encryptionKey, err = hex.DecodeString(keyString)
+	loaded, source, err := LoadKey(ctx, key)
 	if err != nil {
-		return fmt.Errorf("failed to decode encryption key: %v", err)
+		return err
+	}
+	log.Printf("Encryption key loaded from %s.", source)
+	return OpenDB(ctx, storage, loaded)
+}
+
+// OpenDB initializes the Firestore client with an encryption key that has already been loaded, for callers
+// such as `init` and `doctor` that obtain or check the key themselves.
+//
+// It returns an error if the backend is not supported, the key is not 32 bytes long, or if Firebase app or
+// Firestore client initialization fails.
+func OpenDB(ctx context.Context, storage config.Storage, key []byte) error {
+	if storage.Backend != "firestore" {
+		return fmt.Errorf("unsupported storage backend %q", storage.Backend)
+	}
+	if len(key) != 32 { // AES-256 requires a 32-byte key
+		return fmt.Errorf("encryption key must be 32 bytes long, but got %d bytes", len(key))
 	}
-	if len(encryptionKey) != 32 { // AES-256 requires a 32-byte key
-		return fmt.Errorf("encryption key must be 32 bytes (64 hex characters) long, but got %d bytes", len(encryptionKey))
+	encryptionKey = key
+
+	// The Firestore client connects to the emulator named by this variable
+	if storage.EmulatorHost != "" {
+		os.Setenv("FIRESTORE_EMULATOR_HOST", storage.EmulatorHost)
 	}
 
-	config := &firebase.Config{
-		ProjectID: os.Getenv("PROJECT_ID"),
+	fbConfig := &firebase.Config{
+		ProjectID: storage.ProjectID,
 	}
-	sa := option.WithCredentialsFile(os.Getenv("GOOGLE_APPLICATION_CREDENTIALS"))
-	app, err := firebase.NewApp(ctx, config, sa)
+	var opts []option.ClientOption
+	if storage.CredentialsFile != "" && storage.EmulatorHost == "" {
+		opts = append(opts, option.WithCredentialsFile(storage.CredentialsFile))
+	}
+	app, err := firebase.NewApp(ctx, fbConfig, opts...)
 	if err != nil {
 		return fmt.Errorf("error initializing app: %v", err)
 	}
@@ -66,4 +82,4 @@ func CloseDB() {
 			log.Printf("Failed to close Firestore client: %v", err)
 		}
 	}
-}
\ No newline at end of file
+}
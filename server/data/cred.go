@@ -13,13 +13,15 @@ import (
 var ErrAlreadyExists = errors.New("credentials for site already exist")
 
 // Store saves credentials for the given site in Firestore, encrypting the password
-// before writing and updating existing entries when present.
+// and details before writing and updating existing entries when present.
 //
 // Store acquires a package-level mutex to serialize write operations. If a document
 // for the site already exists, it returns ErrAlreadyExists; otherwise it creates a new one.
 // It returns an error if checking existence fails (e.g., network or permission errors),
 // if encrypting the password fails, or if writing to Firestore fails.
-func Store(ctx context.Context, site string, username string, password string) error {
+// The password is not checked against known breaches; the caller does that before storing it.
+// The tags must be normalized with NormalizeTags and the details checked with CheckDetails.
+func Store(ctx context.Context, site string, creds Credentials) error {
 	credMutex.Lock()
 	defer credMutex.Unlock()
 
@@ -34,7 +36,7 @@ func Store(ctx context.Context, site string, username string, password string) e
 	}
 
 	// If not found, create a new document using the original site name.
-	return updateLocked(ctx, site, username, password)
+	return updateLocked(ctx, site, creds)
 }
 
 // Update updates the stored credentials for the named site with the provided username and password.
@@ -42,7 +44,10 @@ func Store(ctx context.Context, site string, username string, password string) e
 // Update updates stored credentials for a site with a new username and password.
 // It locates the existing site entry, serializes the update to prevent concurrent writes,
 // and returns an error if the site is not found, if encryption fails, or if the Firestore write fails.
-func Update(ctx context.Context, site string, username string, password string) error {
+// Like Store, it leaves checking the new password against known breaches to the caller.
+// The tags and fields replace the stored ones, and so do the notes and TOTP secret; nil tags and fields
+// and empty notes and TOTP secrets keep them.
+func Update(ctx context.Context, site string, creds Credentials) error {
 	docRef, err := findSiteDocument(ctx, site)
 	if err != nil {
 		return err // Will be a "not found" error if neither version exists
@@ -52,7 +57,7 @@ func Update(ctx context.Context, site string, username string, password string)
 	defer credMutex.Unlock()
 
 	// Use the ID of the document that was actually found
-	return updateLocked(ctx, docRef.ID, username, password)
+	return updateLocked(ctx, docRef.ID, creds)
 }
 
 // Show retrieves a list of all stored site names.
@@ -76,39 +81,46 @@ func Show(ctx context.Context) ([]string, error) {
 
 // Retrieve fetches credentials for the given site from Firestore and decrypts the stored password.
 // It attempts to find the site with and without a ".com" suffix.
-// Retrieve fetches stored credentials for the given site, decrypts the stored password, and returns the credentials.
+// Retrieve fetches stored credentials for the given site, decrypts the stored password and details, and returns the credentials.
 //
-// If the site document is not found, the document cannot be read or parsed, or password decryption fails, Retrieve returns an empty Credentials and false.
+// If the site document is not found, the document cannot be read or parsed, or decryption fails, Retrieve returns an empty Credentials and false.
 func Retrieve(ctx context.Context, site string) (Credentials, bool) {
+	_, creds, found := Lookup(ctx, site)
+	return creds, found
+}
+
+// Lookup is Retrieve, also returning the name the credentials are stored under, which differs from site when they
+// were found under the name with or without ".com".
+func Lookup(ctx context.Context, site string) (string, Credentials, bool) {
 	docRef, err := findSiteDocument(ctx, site)
 	if err != nil {
-		return Credentials{}, false // Document not found
+		return "", Credentials{}, false // Document not found
 	}
 
 	doc, err := docRef.Get(ctx)
 	if err != nil {
 		log.Printf("Failed to get document snapshot for site %s: %v", docRef.ID, err)
-		return Credentials{}, false
+		return "", Credentials{}, false
 	}
 
 	var creds Credentials
 	if err := doc.DataTo(&creds); err != nil {
 		log.Printf("Failed to parse data for site %s: %v", doc.Ref.ID, err)
-		return Credentials{}, false
+		return "", Credentials{}, false
 	}
 
-	decryptedPassword, err := decrypt(creds.Password)
+	decrypted, err := decryptCredentials(creds)
 	if err != nil {
-		log.Printf("Failed to decrypt password for site %s: %v", doc.Ref.ID, err)
-		return Credentials{}, false
+		log.Printf("Failed to decrypt credentials for site %s: %v", doc.Ref.ID, err)
+		return "", Credentials{}, false
 	}
-	creds.Password = decryptedPassword
-	return creds, true
+	return docRef.ID, decrypted, true
 }
 
 // Delete removes the credentials document for the named site from Firestore.
 // It acquires an internal mutex to serialize the deletion. If the site is not found,
 // it returns ErrNotFound. If the deletion fails for any other reason, it returns the error.
+// The site's rotation policy, if any, is deleted with it.
 // On successful deletion, it returns nil.
 func Delete(ctx context.Context, site string) error {
 	docRef, err := findSiteDocument(ctx, site)
@@ -123,5 +135,8 @@ func Delete(ctx context.Context, site string) error {
 	if err != nil {
 		return fmt.Errorf("failed to delete site %s: %v", site, err)
 	}
+	if _, err := rotationPolicyRef(RotationScopeSite, docRef.ID).Delete(ctx); err != nil {
+		log.Printf("Failed to delete the rotation policy of site %s: %v", docRef.ID, err)
+	}
 	return nil
 }
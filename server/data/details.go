@@ -0,0 +1,48 @@
+package data
+
+import "fmt"
+
+// Limits on the site name, username, and password of credentials, which POST /credentials, imports, and restores
+// enforce.
+const (
+	MaxSiteLength     = 255
+	MaxUsernameLength = 255
+	MaxPasswordLength = 1000
+)
+
+// Limits on the optional details of credentials.
+const (
+	maxNotesLength      = 10000
+	maxTOTPLength       = 1000
+	maxFields           = 50
+	maxFieldNameLength  = 255
+	maxFieldValueLength = 1000
+)
+
+// CheckDetails checks the notes, TOTP secret, and custom fields of credentials against the limits the vault
+// enforces, and that every field has a known type.
+func CheckDetails(creds Credentials) error {
+	if len(creds.Notes) > maxNotesLength {
+		return fmt.Errorf("notes must not exceed %d characters", maxNotesLength)
+	}
+	if len(creds.TOTP) > maxTOTPLength {
+		return fmt.Errorf("TOTP secret must not exceed %d characters", maxTOTPLength)
+	}
+	if len(creds.Fields) > maxFields {
+		return fmt.Errorf("there must not be more than %d fields", maxFields)
+	}
+	for _, field := range creds.Fields {
+		switch {
+		case len(field.Name) > maxFieldNameLength:
+			return fmt.Errorf("field names must not exceed %d characters", maxFieldNameLength)
+		case len(field.Value) > maxFieldValueLength:
+			return fmt.Errorf("field %q must not exceed %d characters", field.Name, maxFieldValueLength)
+		}
+		switch field.Type {
+		case "", FieldText, FieldHidden, FieldBoolean:
+		default:
+			return fmt.Errorf("field %q has unknown type %q", field.Name, field.Type)
+		}
+	}
+	return nil
+}
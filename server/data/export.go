@@ -0,0 +1,73 @@
+package data
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// ExportFilter selects the credentials Export returns. A credential is selected if it is stored under one of Sites
+// or has one of Tags, or, with neither, always; a Query then narrows the selection to credentials whose site,
+// username, or notes contain it, ignoring case. The zero value selects every credential.
+type ExportFilter struct {
+	// Sites are looked up like Retrieve looks them up, so "example" finds "example.com".
+	Sites []string
+	// Tags must be normalized with NormalizeTags.
+	Tags  []string
+	Query string
+}
+
+// IsZero reports whether the filter selects every credential.
+func (f ExportFilter) IsZero() bool {
+	return len(f.Sites) == 0 && len(f.Tags) == 0 && f.Query == ""
+}
+
+// Export decrypts the stored credentials the filter selects, with their tags, details, and earlier passwords, for
+// moving them into another password manager. The credentials are returned sorted by site, along with the sites that
+// could not be read or decrypted, which are logged; those are reported whether or not the filter would have selected
+// them. It returns an error wrapping ErrNotFound if one of the filter's sites has no credentials, and an error if
+// iterating the credentials fails.
+func Export(ctx context.Context, filter ExportFilter) ([]SiteCredentials, []string, error) {
+	sites := make(map[string]bool, len(filter.Sites))
+	for _, site := range filter.Sites {
+		ref, err := findSiteDocument(ctx, site)
+		if err != nil {
+			return nil, nil, fmt.Errorf("site %s: %w", site, err)
+		}
+		sites[ref.ID] = true
+	}
+	tags := make(map[string]bool, len(filter.Tags))
+	for _, tag := range filter.Tags {
+		tags[tag] = true
+	}
+	query := strings.ToLower(filter.Query)
+
+	exported := []SiteCredentials{}
+	failed, err := walkCredentials(ctx, func(c storedCredential) error {
+		selected := len(sites) == 0 && len(tags) == 0 || sites[c.Site]
+		for _, tag := range c.Tags {
+			selected = selected || tags[tag]
+		}
+		if !selected || query != "" && !strings.Contains(strings.ToLower(c.Site), query) &&
+			!strings.Contains(strings.ToLower(c.Username), query) && !strings.Contains(strings.ToLower(c.Notes), query) {
+			return nil
+		}
+		exported = append(exported, SiteCredentials{
+			Site:     c.Site,
+			Username: c.Username,
+			Password: c.Password,
+			Tags:     c.Tags,
+			Notes:    c.Notes,
+			TOTP:     c.TOTP,
+			Fields:   c.Fields,
+			History:  c.History,
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	sort.Slice(exported, func(i, j int) bool { return exported[i].Site < exported[j].Site })
+	return exported, failed, nil
+}
@@ -0,0 +1,199 @@
+package data
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"sort"
+	"time"
+
+	"github.com/rihts-4/pasword-mango/strength"
+)
+
+// HealthPolicy sets the thresholds of a health report.
+type HealthPolicy struct {
+	// MinScore is the least strength score a password may have without being reported as weak.
+	MinScore int
+	// MaxAge is how long a password may go unchanged before it is reported as old. Zero disables the check.
+	MaxAge time.Duration
+}
+
+// ReusedPassword is a password shared by several sites. The password itself is never reported: ID is derived from
+// a keyed hash of it, so it identifies the same password across reports from the same vault, but reveals nothing
+// about it without the encryption key.
+type ReusedPassword struct {
+	ID    string   `json:"id"`
+	Sites []string `json:"sites"`
+}
+
+// WeakPassword is a stored credential whose password scores below the policy's minimum.
+type WeakPassword struct {
+	Site     string `json:"site"`
+	Username string `json:"username"`
+	Score    int    `json:"score"`
+	// Reason is the main reason the password is weak, such as "This is a top-100 common password".
+	Reason string `json:"reason,omitempty"`
+}
+
+// OldPassword is a stored credential whose password has not changed for longer than the policy's maximum age.
+type OldPassword struct {
+	Site      string    `json:"site"`
+	Username  string    `json:"username"`
+	ChangedAt time.Time `json:"changedAt"`
+	AgeDays   int       `json:"ageDays"`
+}
+
+// DuplicateSites are stored sites that name the same site with and without ".com", such as "example" and
+// "example.com". Lookups try the alternative name only when the given one is not stored, so one of the entries is
+// easily shadowed by the other.
+type DuplicateSites struct {
+	Sites []string `json:"sites"`
+}
+
+// HealthReport is the result of a vault-wide password health check. No password is included in it.
+type HealthReport struct {
+	GeneratedAt time.Time `json:"generatedAt"`
+	MinScore    int       `json:"minScore"`
+	// MaxAgeDays is the policy's maximum age in days, or 0 if the age check is disabled.
+	MaxAgeDays int `json:"maxAgeDays"`
+	// Checked is the number of credentials whose password was checked.
+	Checked int `json:"checked"`
+	// Reused lists the passwords used by more than one site, most widely used first.
+	Reused []ReusedPassword `json:"reused"`
+	// Weak lists the credentials with weak passwords, weakest first.
+	Weak []WeakPassword `json:"weak"`
+	// Old lists the credentials with old passwords, oldest first.
+	Old []OldPassword `json:"old"`
+	// Duplicates lists the sites stored under both a ".com" and a non-".com" name.
+	Duplicates []DuplicateSites `json:"duplicates"`
+	// Breached lists the credentials with breached passwords, most frequently breached first. It is only present
+	// when a Pwned Passwords file is configured.
+	Breached []BreachedCredential `json:"breached,omitempty"`
+	// Failed lists the sites whose password could not be read or checked.
+	Failed []string `json:"failed,omitempty"`
+}
+
+// Findings returns the number of problems in the report, counting each reused password, weak, old, or breached
+// credential, set of duplicate sites, and failed site once.
+func (r HealthReport) Findings() int {
+	return len(r.Reused) + len(r.Weak) + len(r.Old) + len(r.Duplicates) + len(r.Breached) + len(r.Failed)
+}
+
+// CheckHealth decrypts every stored password and reports the passwords reused across sites, weak passwords, old
+// passwords, breached passwords if a Pwned Passwords file is configured, and sites stored under both a ".com" and a
+// non-".com" name. Passwords are only held in memory while they are checked, and reuse is detected by comparing
+// keyed hashes.
+//
+// It returns an error if iterating the credentials fails. Credentials that cannot be parsed, decrypted, or checked
+// are logged and listed in the report's Failed sites.
+func CheckHealth(ctx context.Context, policy HealthPolicy) (HealthReport, error) {
+	now := time.Now().UTC()
+	report := HealthReport{
+		GeneratedAt: now,
+		MinScore:    policy.MinScore,
+		MaxAgeDays:  int(policy.MaxAge / (24 * time.Hour)),
+		Reused:      []ReusedPassword{},
+		Weak:        []WeakPassword{},
+		Old:         []OldPassword{},
+		Duplicates:  []DuplicateSites{},
+	}
+	if breachFile != nil {
+		report.Breached = []BreachedCredential{}
+	}
+
+	key := healthKey()
+	sitesByPassword := make(map[string][]string)
+	stored := make(map[string]bool)
+	failed, err := walkCredentials(ctx, func(c storedCredential) error {
+		stored[c.Site] = true
+		if breachFile != nil {
+			count, err := breachFile.Count(c.Password)
+			if err != nil {
+				return err
+			}
+			if count > 0 {
+				report.Breached = append(report.Breached, BreachedCredential{Site: c.Site, Username: c.Username, Count: count})
+			}
+		}
+		report.Checked++
+
+		mac := hmac.New(sha256.New, key)
+		mac.Write([]byte(c.Password))
+		id := hex.EncodeToString(mac.Sum(nil))[:16]
+		sitesByPassword[id] = append(sitesByPassword[id], c.Site)
+
+		if result := strength.Estimate(c.Password, c.Site, c.Username); result.Score < policy.MinScore {
+			report.Weak = append(report.Weak, WeakPassword{Site: c.Site, Username: c.Username, Score: result.Score, Reason: result.Reason()})
+		}
+		if age := now.Sub(c.ChangedAt); policy.MaxAge > 0 && age > policy.MaxAge {
+			report.Old = append(report.Old, OldPassword{
+				Site:      c.Site,
+				Username:  c.Username,
+				ChangedAt: c.ChangedAt,
+				AgeDays:   int(age / (24 * time.Hour)),
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		return HealthReport{}, err
+	}
+	report.Failed = failed
+
+	for id, sites := range sitesByPassword {
+		if len(sites) > 1 {
+			report.Reused = append(report.Reused, ReusedPassword{ID: id, Sites: sites})
+		}
+	}
+	sort.Slice(report.Reused, func(i, j int) bool {
+		a, b := report.Reused[i], report.Reused[j]
+		if len(a.Sites) != len(b.Sites) {
+			return len(a.Sites) > len(b.Sites)
+		}
+		return a.Sites[0] < b.Sites[0]
+	})
+	sort.SliceStable(report.Weak, func(i, j int) bool {
+		return report.Weak[i].Score < report.Weak[j].Score
+	})
+	sort.SliceStable(report.Old, func(i, j int) bool {
+		return report.Old[i].ChangedAt.Before(report.Old[j].ChangedAt)
+	})
+	sort.SliceStable(report.Breached, func(i, j int) bool {
+		return report.Breached[i].Count > report.Breached[j].Count
+	})
+	report.Duplicates = duplicateSites(stored)
+	return report, nil
+}
+
+// duplicateSites finds the stored sites whose alternative name, as tried by findSiteDocument, is stored too.
+func duplicateSites(stored map[string]bool) []DuplicateSites {
+	duplicates := []DuplicateSites{}
+	seen := make(map[[2]string]bool)
+	for site := range stored {
+		alternative := getAlternativeSite(site)
+		if alternative == "" || alternative == site || !stored[alternative] {
+			continue
+		}
+		pair := [2]string{site, alternative}
+		if pair[1] < pair[0] {
+			pair[0], pair[1] = pair[1], pair[0]
+		}
+		if !seen[pair] {
+			seen[pair] = true
+			duplicates = append(duplicates, DuplicateSites{Sites: []string{pair[0], pair[1]}})
+		}
+	}
+	sort.Slice(duplicates, func(i, j int) bool {
+		return duplicates[i].Sites[0] < duplicates[j].Sites[0]
+	})
+	return duplicates
+}
+
+// healthKey derives the key that health reports hash passwords with from the encryption key, so the key used to
+// encrypt passwords is never used directly for anything else.
+func healthKey() []byte {
+	mac := hmac.New(sha256.New, encryptionKey)
+	mac.Write([]byte("pasword-mango health report v1"))
+	return mac.Sum(nil)
+}
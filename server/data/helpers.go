@@ -3,10 +3,13 @@ package data
 import (
 	"context"
 	"fmt"
+	"log"
 	"strings"
+	"time"
 
 	"cloud.google.com/go/firestore"
 	"golang.org/x/net/publicsuffix"
+	"google.golang.org/api/iterator"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 )
@@ -15,21 +18,50 @@ import (
 // updateLocked encrypts the provided password and writes the credential document for the specified site to Firestore.
 // The caller must hold credMutex.
 // updateLocked updates credentials for a site; the caller must hold credMutex.
-// It encrypts the provided password and stores the username and encrypted password
+// It encrypts the provided password, notes, TOTP secret, and field values and stores them
 // in the Firestore "credentials" collection using the site as the document ID.
+// It records when the password was changed, keeping the previous time if the password
+// is the same as the one already stored, so that changing only the username does not
+// make an old password look new. Rotation expiry is computed from this time. The password it
+// replaces is added to the history, which keeps the maxPasswordHistory newest passwords.
+// The tags, fields, and history replace the stored ones, unless they are nil, and the notes and
+// TOTP secret unless they are empty; otherwise the stored ones are kept.
 // On success it prints a confirmation message. It returns an error if encryption
 // fails or if writing the credentials to Firestore fails.
-func updateLocked(ctx context.Context, site string, username string, password string) error {
-	encryptedPassword, err := encrypt(password)
+func updateLocked(ctx context.Context, site string, creds Credentials) error {
+	encrypted, err := encryptCredentials(creds)
 	if err != nil {
-		return fmt.Errorf("failed to encrypt password for site %s: %v", site, err)
+		return fmt.Errorf("failed to encrypt credentials for site %s: %v", site, err)
 	}
 
-	creds := Credentials{
-		Username: username,
-		Password: encryptedPassword,
+	encrypted.PasswordChangedAt = time.Now().UTC()
+	if doc, stored, ok := storedLocked(ctx, site); ok {
+		if creds.Tags == nil {
+			encrypted.Tags = stored.Tags
+		}
+		if creds.Notes == "" {
+			encrypted.Notes = stored.Notes
+		}
+		if creds.TOTP == "" {
+			encrypted.TOTP = stored.TOTP
+		}
+		if creds.Fields == nil {
+			encrypted.Fields = stored.Fields
+		}
+		if creds.History == nil {
+			encrypted.History = stored.History
+		}
+		if storedPassword, err := decrypt(stored.Password); err == nil && storedPassword == creds.Password {
+			encrypted.PasswordChangedAt = passwordChangedAt(doc, stored)
+		} else {
+			previous := PasswordVersion{Username: stored.Username, Password: stored.Password, ChangedAt: passwordChangedAt(doc, stored)}
+			encrypted.History = append([]PasswordVersion{previous}, encrypted.History...)
+		}
 	}
-	_, err = firestoreClient.Collection("credentials").Doc(site).Set(ctx, creds)
+	if len(encrypted.History) > maxPasswordHistory {
+		encrypted.History = encrypted.History[:maxPasswordHistory]
+	}
+	_, err = firestoreClient.Collection("credentials").Doc(site).Set(ctx, encrypted)
 	if err != nil {
 		return fmt.Errorf("failed updating credential for site %s: %v", site, err)
 	}
@@ -37,6 +69,82 @@ func updateLocked(ctx context.Context, site string, username string, password st
 	return nil
 }
 
+// maxPasswordHistory bounds the earlier passwords kept for a site, as KeePass bounds entry history.
+const maxPasswordHistory = 10
+
+// encryptCredentials returns a copy of creds with the password, notes, TOTP secret, field values, and earlier
+// passwords encrypted, as they are stored. Empty notes and TOTP secrets stay empty.
+func encryptCredentials(creds Credentials) (Credentials, error) {
+	return transformCredentials(creds, encrypt)
+}
+
+// decryptCredentials reverses encryptCredentials.
+func decryptCredentials(creds Credentials) (Credentials, error) {
+	return transformCredentials(creds, decrypt)
+}
+
+// transformCredentials applies encrypt or decrypt to the secret parts of credentials.
+func transformCredentials(creds Credentials, transform func(string) (string, error)) (Credentials, error) {
+	var err error
+	if creds.Password, err = transform(creds.Password); err != nil {
+		return Credentials{}, fmt.Errorf("password: %w", err)
+	}
+	if creds.Notes != "" {
+		if creds.Notes, err = transform(creds.Notes); err != nil {
+			return Credentials{}, fmt.Errorf("notes: %w", err)
+		}
+	}
+	if creds.TOTP != "" {
+		if creds.TOTP, err = transform(creds.TOTP); err != nil {
+			return Credentials{}, fmt.Errorf("TOTP secret: %w", err)
+		}
+	}
+	if creds.Fields != nil {
+		fields := make([]CustomField, len(creds.Fields))
+		for i, field := range creds.Fields {
+			if field.Value, err = transform(field.Value); err != nil {
+				return Credentials{}, fmt.Errorf("field %q: %w", field.Name, err)
+			}
+			fields[i] = field
+		}
+		creds.Fields = fields
+	}
+	if creds.History != nil {
+		history := make([]PasswordVersion, len(creds.History))
+		for i, version := range creds.History {
+			if version.Password, err = transform(version.Password); err != nil {
+				return Credentials{}, fmt.Errorf("earlier password: %w", err)
+			}
+			history[i] = version
+		}
+		creds.History = history
+	}
+	return creds, nil
+}
+
+// storedLocked reads the credentials currently stored for a site, with their password still encrypted. It reports
+// false if there are none or they cannot be read. The caller must hold credMutex.
+func storedLocked(ctx context.Context, site string) (*firestore.DocumentSnapshot, Credentials, bool) {
+	doc, err := firestoreClient.Collection("credentials").Doc(site).Get(ctx)
+	if err != nil {
+		return nil, Credentials{}, false
+	}
+	var stored Credentials
+	if err := doc.DataTo(&stored); err != nil {
+		return nil, Credentials{}, false
+	}
+	return doc, stored, true
+}
+
+// passwordChangedAt returns when a stored password was last changed, falling back to the document's update time
+// for credentials stored before the change time was recorded.
+func passwordChangedAt(doc *firestore.DocumentSnapshot, creds Credentials) time.Time {
+	if !creds.PasswordChangedAt.IsZero() {
+		return creds.PasswordChangedAt
+	}
+	return doc.UpdateTime
+}
+
 // findSiteDocument attempts to find a document for a given site, trying both with and without a ".com" suffix.
 // findSiteDocument locates the Firestore document reference for a site's credentials.
 // It first attempts the provided site string; if that document is not found it will
@@ -130,3 +238,48 @@ func getAlternativeSite(site string) string {
 
 	return baseDomain + ".com"
 }
+
+// storedCredential is a stored credential with its password and details decrypted, as visited by walkCredentials.
+type storedCredential struct {
+	Site string
+	Credentials
+	ChangedAt time.Time
+}
+
+// walkCredentials decrypts every stored credential in turn, like Retrieve, and passes it to visit. Passwords and
+// details are only held in memory while they are visited.
+//
+// Credentials that cannot be parsed or decrypted, and those for which visit returns an error, are logged and
+// returned as failed sites. It returns an error only if iterating the credentials fails.
+func walkCredentials(ctx context.Context, visit func(storedCredential) error) ([]string, error) {
+	var failed []string
+	iter := firestoreClient.Collection("credentials").Documents(ctx)
+	defer iter.Stop()
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			return failed, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to iterate credentials: %w", err)
+		}
+
+		var creds Credentials
+		if err := doc.DataTo(&creds); err != nil {
+			log.Printf("Failed to parse data for site %s: %v", doc.Ref.ID, err)
+			failed = append(failed, doc.Ref.ID)
+			continue
+		}
+		decrypted, err := decryptCredentials(creds)
+		if err != nil {
+			log.Printf("Failed to decrypt credentials for site %s: %v", doc.Ref.ID, err)
+			failed = append(failed, doc.Ref.ID)
+			continue
+		}
+		err = visit(storedCredential{Site: doc.Ref.ID, Credentials: decrypted, ChangedAt: passwordChangedAt(doc, creds)})
+		if err != nil {
+			log.Printf("Failed to check the password for site %s: %v", doc.Ref.ID, err)
+			failed = append(failed, doc.Ref.ID)
+		}
+	}
+}
@@ -0,0 +1,299 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/rihts-4/pasword-mango/importer"
+	"github.com/rihts-4/pasword-mango/strength"
+)
+
+// Conflict modes for Import, deciding what happens to an entry for a site that already has credentials.
+const (
+	// ImportSkip leaves the stored credentials alone.
+	ImportSkip = "skip"
+	// ImportOverwrite replaces the stored username and password, and the details and history the entry has.
+	ImportOverwrite = "overwrite"
+	// ImportKeepBoth stores the entry under a new site name, "username@site", numbered if that is taken too.
+	ImportKeepBoth = "keep-both"
+)
+
+// Actions taken on imported rows.
+const (
+	ImportCreated = "created"
+	ImportUpdated = "updated"
+	ImportSkipped = "skipped"
+	ImportFailed  = "failed"
+)
+
+// maxKeepBothCopies bounds the numbered site names tried for ImportKeepBoth.
+const maxKeepBothCopies = 100
+
+// ErrInvalidConflictMode is returned by Import for an unknown conflict mode.
+var ErrInvalidConflictMode = errors.New("conflict mode must be skip, overwrite, or keep-both")
+
+// ImportOptions control an import.
+type ImportOptions struct {
+	// Conflict is ImportSkip, ImportOverwrite, or ImportKeepBoth. Empty means ImportSkip.
+	Conflict string
+	// DryRun reports what would happen without storing anything.
+	DryRun bool
+	// Tags are added to the tags of every imported entry. They must be normalized with NormalizeTags.
+	Tags []string
+	// MinScore is the least strength score accepted for imported passwords, as for POST /credentials; 0 accepts any.
+	MinScore int
+}
+
+// ImportRow is the outcome of one row of an export. It never includes the password.
+type ImportRow struct {
+	Row      int    `json:"row"`
+	Site     string `json:"site,omitempty"`
+	Username string `json:"username,omitempty"`
+	// Action is ImportCreated, ImportUpdated, ImportSkipped, or ImportFailed.
+	Action string `json:"action"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// ImportReport summarizes an import.
+type ImportReport struct {
+	Format   string      `json:"format"`
+	Conflict string      `json:"conflict"`
+	DryRun   bool        `json:"dryRun"`
+	Created  int         `json:"created"`
+	Updated  int         `json:"updated"`
+	Skipped  int         `json:"skipped"`
+	Failed   int         `json:"failed"`
+	Rows     []ImportRow `json:"rows"`
+}
+
+// add records the outcome of a row.
+func (r *ImportReport) add(row ImportRow) {
+	switch row.Action {
+	case ImportCreated:
+		r.Created++
+	case ImportUpdated:
+		r.Updated++
+	case ImportSkipped:
+		r.Skipped++
+	case ImportFailed:
+		r.Failed++
+	}
+	r.Rows = append(r.Rows, row)
+}
+
+// importTarget is what an import sees at a site name: nothing, or the credentials stored there, or those an earlier
+// row of a dry run would have stored there.
+type importTarget struct {
+	site   string
+	exists bool
+	creds  Credentials
+}
+
+// holds reports whether the target already holds the imported credentials: the same username and password, and
+// the same details where the import has any.
+func (t importTarget) holds(creds Credentials) bool {
+	return t.exists && t.creds.Username == creds.Username && t.creds.Password == creds.Password &&
+		(creds.Notes == "" || creds.Notes == t.creds.Notes) &&
+		(creds.TOTP == "" || creds.TOTP == t.creds.TOTP) &&
+		(creds.Fields == nil || slices.Equal(creds.Fields, t.creds.Fields))
+}
+
+// importRun holds the state of one import. For a dry run, planned records the credentials that earlier rows would
+// have stored, by site, so that later rows for the same site are reported as they would be in a real import.
+type importRun struct {
+	ctx     context.Context
+	opts    ImportOptions
+	planned map[string]importTarget
+}
+
+// Import stores the entries of a parsed export, resolving conflicts with the credentials already stored as
+// opts.Conflict says. Entries identical to the stored credentials are skipped in every mode, so running the same
+// import twice changes nothing. Rows the parser rejected, and entries with an empty or too long username or password,
+// a password weaker than opts.MinScore, or details that CheckDetails refuses, are reported as failed; a failure never
+// stops the import. The earlier
+// passwords of an entry become its history, dropping any that are empty or too long. Like Store, it logs a warning
+// for passwords that appear in known breaches.
+//
+// Import holds the credentials lock for the whole import. It returns ErrInvalidConflictMode for an unknown mode, and
+// an error only if Firestore cannot be read; failures to write a row are reported in the row.
+func Import(ctx context.Context, parsed importer.Result, opts ImportOptions) (ImportReport, error) {
+	if opts.Conflict == "" {
+		opts.Conflict = ImportSkip
+	}
+	if opts.Conflict != ImportSkip && opts.Conflict != ImportOverwrite && opts.Conflict != ImportKeepBoth {
+		return ImportReport{}, ErrInvalidConflictMode
+	}
+	report := ImportReport{Format: parsed.Format, Conflict: opts.Conflict, DryRun: opts.DryRun, Rows: []ImportRow{}}
+	for _, rejected := range parsed.Rejected {
+		report.add(ImportRow{Row: rejected.Row, Action: ImportFailed, Reason: rejected.Reason})
+	}
+
+	credMutex.Lock()
+	defer credMutex.Unlock()
+
+	run := &importRun{ctx: ctx, opts: opts, planned: make(map[string]importTarget)}
+	for _, entry := range parsed.Entries {
+		row, err := run.importEntry(entry)
+		if err != nil {
+			return ImportReport{}, err
+		}
+		report.add(row)
+	}
+	return report, nil
+}
+
+// importFieldTypes maps the field types of imported entries to those of the vault.
+var importFieldTypes = map[string]string{
+	importer.FieldText:    FieldText,
+	importer.FieldHidden:  FieldHidden,
+	importer.FieldBoolean: FieldBoolean,
+}
+
+// importEntry imports one entry and reports its outcome. It returns an error only if Firestore cannot be read.
+func (run *importRun) importEntry(entry importer.Entry) (ImportRow, error) {
+	site := strings.TrimSpace(entry.Site)
+	username := strings.TrimSpace(entry.Username)
+	password := strings.TrimSpace(entry.Password)
+	row := ImportRow{Row: entry.Row, Site: site, Username: username, Action: ImportFailed}
+	switch {
+	case site == "":
+		row.Reason = "site is empty"
+	case username == "":
+		row.Reason = "username is empty"
+	case password == "":
+		row.Reason = "password is empty"
+	case len(site) > MaxSiteLength:
+		row.Reason = fmt.Sprintf("site exceeds %d characters", MaxSiteLength)
+	case len(username) > MaxUsernameLength:
+		row.Reason = fmt.Sprintf("username exceeds %d characters", MaxUsernameLength)
+	case len(password) > MaxPasswordLength:
+		row.Reason = fmt.Sprintf("password exceeds %d characters", MaxPasswordLength)
+	}
+	if row.Reason != "" {
+		return row, nil
+	}
+	if result := strength.Estimate(password, site, username); result.Score < run.opts.MinScore {
+		row.Reason = fmt.Sprintf("password is too weak (score %d of %d, minimum %d): %s",
+			result.Score, strength.MaxScore, run.opts.MinScore, result.Reason())
+		return row, nil
+	}
+	creds := Credentials{Username: username, Password: password, Notes: entry.Notes, TOTP: entry.TOTP}
+	for _, field := range entry.Fields {
+		fieldType, ok := importFieldTypes[field.Type]
+		if !ok {
+			row.Reason = fmt.Sprintf("field %q has unknown type %q", field.Name, field.Type)
+			return row, nil
+		}
+		creds.Fields = append(creds.Fields, CustomField{Name: field.Name, Value: field.Value, Type: fieldType})
+	}
+	if err := CheckDetails(creds); err != nil {
+		row.Reason = err.Error()
+		return row, nil
+	}
+	for _, version := range entry.History {
+		username, password := strings.TrimSpace(version.Username), strings.TrimSpace(version.Password)
+		if password == "" || len(password) > MaxPasswordLength || len(username) > MaxUsernameLength {
+			continue
+		}
+		if len(creds.History) < maxPasswordHistory {
+			creds.History = append(creds.History, PasswordVersion{Username: username, Password: password, ChangedAt: version.ChangedAt})
+		}
+	}
+	tags, err := NormalizeTags(append(append([]string{}, entry.Tags...), run.opts.Tags...))
+	if err != nil {
+		row.Reason = err.Error()
+		return row, nil
+	}
+	if len(tags) > 0 {
+		creds.Tags = tags
+	}
+
+	target, err := run.lookup(site)
+	if err != nil {
+		return ImportRow{}, err
+	}
+	if target.holds(creds) {
+		row.Site, row.Action, row.Reason = target.site, ImportSkipped, "identical to the stored credentials"
+		return row, nil
+	}
+	if !target.exists {
+		return run.write(row, site, creds, ImportCreated), nil
+	}
+
+	switch run.opts.Conflict {
+	case ImportOverwrite:
+		return run.write(row, target.site, creds, ImportUpdated), nil
+	case ImportKeepBoth:
+		base := username + "@" + site
+		for n := 1; n <= maxKeepBothCopies; n++ {
+			name := base
+			if n > 1 {
+				name = fmt.Sprintf("%s (%d)", base, n)
+			}
+			if len(name) > MaxSiteLength {
+				break
+			}
+			other, err := run.lookup(name)
+			if err != nil {
+				return ImportRow{}, err
+			}
+			if !other.exists {
+				return run.write(row, name, creds, ImportCreated), nil
+			}
+			if other.holds(creds) {
+				row.Site, row.Action, row.Reason = other.site, ImportSkipped, "identical to the stored credentials"
+				return row, nil
+			}
+		}
+		row.Reason = "no free site name to keep both credentials"
+		return row, nil
+	default:
+		row.Site, row.Action, row.Reason = target.site, ImportSkipped, "site already exists"
+		return row, nil
+	}
+}
+
+// lookup finds what is stored at a site, matching it with or without ".com" like findSiteDocument. Credentials that
+// cannot be read or decrypted count as existing but different.
+func (run *importRun) lookup(site string) (importTarget, error) {
+	for _, name := range []string{site, getAlternativeSite(site)} {
+		if target, ok := run.planned[name]; ok && name != "" {
+			return target, nil
+		}
+	}
+	docRef, err := findSiteDocument(run.ctx, site)
+	if errors.Is(err, ErrNotFound) {
+		return importTarget{site: site}, nil
+	}
+	if err != nil {
+		return importTarget{}, err
+	}
+	target := importTarget{site: docRef.ID, exists: true}
+	if _, stored, ok := storedLocked(run.ctx, docRef.ID); ok {
+		if creds, err := decryptCredentials(stored); err == nil {
+			target.creds = creds
+		}
+	}
+	return target, nil
+}
+
+// write stores credentials for a row, or for a dry run only records that it would have, and returns the row with
+// the outcome.
+func (run *importRun) write(row ImportRow, site string, creds Credentials, action string) ImportRow {
+	row.Site = site
+	if run.opts.DryRun {
+		run.planned[site] = importTarget{site: site, exists: true, creds: creds}
+		row.Action = action
+		return row
+	}
+	warnIfBreached(site, creds.Password)
+	if err := updateLocked(run.ctx, site, creds); err != nil {
+		row.Reason = err.Error()
+		return row
+	}
+	row.Action = action
+	return row
+}
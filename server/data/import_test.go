@@ -0,0 +1,155 @@
+package data
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/rihts-4/pasword-mango/importer"
+	"github.com/rihts-4/pasword-mango/strength"
+)
+
+func TestImportRejectsWeakPasswords(t *testing.T) {
+	fakeFirestore.Reset()
+	ctx := context.Background()
+	parsed := importer.Result{Format: importer.FormatKDBX, Entries: []importer.Entry{
+		{Row: 1, Site: "weak.com", Username: "alice", Password: "password1"},
+		{Row: 2, Site: "strong.com", Username: "alice", Password: "correct horse battery staple lamp"},
+	}}
+
+	report, err := Import(ctx, parsed, ImportOptions{MinScore: strength.ScoreSafelyUnguessable})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Created != 1 || report.Failed != 1 {
+		t.Fatalf("created %d and failed %d rows, want 1 and 1: %+v", report.Created, report.Failed, report.Rows)
+	}
+	if row := report.Rows[0]; row.Site != "weak.com" || row.Action != ImportFailed || !strings.Contains(row.Reason, "too weak") {
+		t.Errorf("the weak password's row is %+v, want it failed as too weak", row)
+	}
+	if _, found := Retrieve(ctx, "weak.com"); found {
+		t.Error("the weak password was stored")
+	}
+	if _, found := Retrieve(ctx, "strong.com"); !found {
+		t.Error("the strong password was not stored")
+	}
+}
+
+func TestImportConflicts(t *testing.T) {
+	entries := []importer.Entry{
+		{Row: 2, Site: "example", Username: "alice", Password: "new-alice"},                // example.com, matched without .com
+		{Row: 3, Site: "bank.org", Username: "bob", Password: "same-bob"},                  // identical to the stored credentials
+		{Row: 4, Site: "new.net", Username: "carol", Password: "carol-password"},           // not stored yet
+		{Row: 5, Site: "new.net", Username: "carol", Password: "carol-password-2"},         // the site an earlier row imported
+		{Row: 6, Site: "example.com", Username: "", Password: "no-username"},               // invalid
+		{Row: 7, Site: "example.com", Username: "alice", Password: "   "},                  // invalid
+		{Row: 8, Site: strings.Repeat("x", MaxSiteLength+1), Username: "a", Password: "b"}, // invalid
+	}
+	tests := []struct {
+		conflict string
+		// stored is the password each site holds after the import
+		stored  map[string]string
+		actions []string
+	}{
+		{
+			conflict: ImportSkip,
+			stored:   map[string]string{"example.com": "old-alice", "bank.org": "same-bob", "new.net": "carol-password"},
+			actions:  []string{ImportSkipped, ImportSkipped, ImportCreated, ImportSkipped, ImportFailed, ImportFailed, ImportFailed},
+		},
+		{
+			conflict: ImportOverwrite,
+			stored:   map[string]string{"example.com": "new-alice", "bank.org": "same-bob", "new.net": "carol-password-2"},
+			actions:  []string{ImportUpdated, ImportSkipped, ImportCreated, ImportUpdated, ImportFailed, ImportFailed, ImportFailed},
+		},
+		{
+			conflict: ImportKeepBoth,
+			stored: map[string]string{"example.com": "old-alice", "alice@example": "new-alice", "bank.org": "same-bob",
+				"new.net": "carol-password", "carol@new.net": "carol-password-2"},
+			actions: []string{ImportCreated, ImportSkipped, ImportCreated, ImportCreated, ImportFailed, ImportFailed, ImportFailed},
+		},
+	}
+	ctx := context.Background()
+	for _, tt := range tests {
+		for _, dryRun := range []bool{true, false} {
+			fakeFirestore.Reset()
+			if err := Store(ctx, "example.com", Credentials{Username: "alice", Password: "old-alice"}); err != nil {
+				t.Fatal(err)
+			}
+			if err := Store(ctx, "bank.org", Credentials{Username: "bob", Password: "same-bob"}); err != nil {
+				t.Fatal(err)
+			}
+
+			parsed := importer.Result{Format: importer.FormatGenericCSV, Entries: entries,
+				Rejected: []importer.Rejected{{Row: 9, Reason: "android URL is not a website"}}}
+			report, err := Import(ctx, parsed, ImportOptions{Conflict: tt.conflict, DryRun: dryRun})
+			if err != nil {
+				t.Fatal(err)
+			}
+			actions := make(map[int]string)
+			for _, row := range report.Rows {
+				actions[row.Row] = row.Action
+			}
+			for i, want := range tt.actions {
+				if got := actions[entries[i].Row]; got != want {
+					t.Errorf("%s, dry run %v: row %d was %s, want %s", tt.conflict, dryRun, entries[i].Row, got, want)
+				}
+			}
+			if actions[9] != ImportFailed || report.Failed != 4 || len(report.Rows) != len(entries)+1 {
+				t.Errorf("%s, dry run %v: report %+v, want the rejected row and three invalid ones failed", tt.conflict, dryRun, report)
+			}
+
+			sites, err := Show(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := tt.stored
+			if dryRun {
+				want = map[string]string{"example.com": "old-alice", "bank.org": "same-bob"}
+			}
+			if len(sites) != len(want) {
+				t.Errorf("%s, dry run %v: stored sites %v, want %v", tt.conflict, dryRun, sites, want)
+			}
+			for site, password := range want {
+				if creds, found := Retrieve(ctx, site); !found || creds.Password != password {
+					t.Errorf("%s, dry run %v: %s holds %+v, %v; want password %q", tt.conflict, dryRun, site, creds, found, password)
+				}
+			}
+		}
+	}
+}
+
+func TestImportFieldTypes(t *testing.T) {
+	fakeFirestore.Reset()
+	ctx := context.Background()
+	parsed := importer.Result{Format: importer.FormatBitwarden, Entries: []importer.Entry{
+		{Row: 1, Site: "example.com", Username: "alice", Password: "alice-password", Fields: []importer.Field{
+			{Name: "Account", Value: "12345", Type: importer.FieldText},
+			{Name: "PIN", Value: "1234", Type: importer.FieldHidden},
+			{Name: "Business", Value: "true", Type: importer.FieldBoolean},
+		}},
+		{Row: 2, Site: "example.org", Username: "bob", Password: "bob-password", Fields: []importer.Field{
+			{Name: "Linked", Value: "username", Type: "linked"},
+		}},
+	}}
+
+	report, err := Import(ctx, parsed, ImportOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if row := report.Rows[1]; row.Action != ImportFailed || !strings.Contains(row.Reason, `unknown type "linked"`) {
+		t.Errorf("the row with an unknown field type is %+v, want it failed", row)
+	}
+	creds, found := Retrieve(ctx, "example.com")
+	if !found {
+		t.Fatal("the credentials were not stored")
+	}
+	want := []CustomField{
+		{Name: "Account", Value: "12345", Type: FieldText},
+		{Name: "PIN", Value: "1234", Type: FieldHidden},
+		{Name: "Business", Value: "true", Type: FieldBoolean},
+	}
+	if !slices.Equal(creds.Fields, want) {
+		t.Errorf("fields %+v, want %+v", creds.Fields, want)
+	}
+}
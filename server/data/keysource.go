@@ -0,0 +1,183 @@
+package data
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/rihts-4/pasword-mango/config"
+	"golang.org/x/term"
+)
+
+// keyCommandTimeout bounds how long a key command may run, so that a hung password manager or
+// hardware token prompt does not block startup forever.
+const keyCommandTimeout = 30 * time.Second
+
+// LoadKey reads the AES-256 encryption key from the configured source and returns it together with a
+// description of where it came from, suitable for logging.
+//
+// If no source is selected, the first one configured is used: the key file, the systemd credential
+// (when $CREDENTIALS_DIRECTORY is set and contains it), the key command, and finally the legacy
+// ENCRYPTION_KEY environment variable, which logs a deprecation warning.
+func LoadKey(ctx context.Context, cfg config.Key) ([]byte, string, error) {
+	source := cfg.Source
+	if source == config.KeySourceAuto {
+		source = detectKeySource(cfg)
+	}
+
+	switch source {
+	case config.KeySourceFile:
+		key, err := readKeyFile(cfg.File, true)
+		return key, "file " + cfg.File, err
+	case config.KeySourceSystemd:
+		path, err := credentialPath(cfg.Credential)
+		if err != nil {
+			return nil, "", err
+		}
+		// systemd already restricts the credentials directory to the service, so the mode is not checked
+		key, err := readKeyFile(path, false)
+		return key, "systemd credential " + cfg.Credential, err
+	case config.KeySourceCommand:
+		key, err := runKeyCommand(ctx, cfg.Command)
+		return key, "command " + cfg.Command[0], err
+	case config.KeySourceStdin:
+		key, err := readKeyStdin()
+		return key, "standard input", err
+	case config.KeySourceEnv:
+		log.Println("Warning: reading the encryption key from ENCRYPTION_KEY is deprecated; use a key file, systemd credential, or key command instead.")
+		key, err := parseKey([]byte(cfg.Hex))
+		return key, "environment variable ENCRYPTION_KEY", err
+	case config.KeySourceAuto:
+		return nil, "", fmt.Errorf("no encryption key source configured")
+	default:
+		return nil, "", fmt.Errorf("unknown encryption key source %q", source)
+	}
+}
+
+// detectKeySource picks the first key source that is configured.
+func detectKeySource(cfg config.Key) string {
+	if cfg.File != "" {
+		return config.KeySourceFile
+	}
+	if path, err := credentialPath(cfg.Credential); err == nil {
+		if _, err := os.Stat(path); err == nil {
+			return config.KeySourceSystemd
+		}
+	}
+	if len(cfg.Command) > 0 {
+		return config.KeySourceCommand
+	}
+	if cfg.Hex != "" {
+		return config.KeySourceEnv
+	}
+	return config.KeySourceAuto
+}
+
+// credentialPath returns the path of the named systemd credential.
+func credentialPath(name string) (string, error) {
+	dir := os.Getenv("CREDENTIALS_DIRECTORY")
+	if dir == "" {
+		return "", fmt.Errorf("CREDENTIALS_DIRECTORY is not set; is the server running under systemd with LoadCredential=?")
+	}
+	if name == "" || strings.ContainsRune(name, '/') {
+		return "", fmt.Errorf("invalid systemd credential name %q", name)
+	}
+	return filepath.Join(dir, name), nil
+}
+
+// readKeyFile reads a key from a regular file. If checkMode is set, the file must not be readable
+// or writable by the group or other users.
+func readKeyFile(path string, checkMode bool) ([]byte, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading key file: %v", err)
+	}
+	if !info.Mode().IsRegular() {
+		return nil, fmt.Errorf("key file %s is not a regular file", path)
+	}
+	// Windows does not report Unix permission bits
+	if checkMode && runtime.GOOS != "windows" && info.Mode().Perm()&0o077 != 0 {
+		return nil, fmt.Errorf("key file %s is accessible to other users (mode %04o); run chmod 600 %s", path, info.Mode().Perm(), path)
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading key file: %v", err)
+	}
+	key, err := parseKey(content)
+	if err != nil {
+		return nil, fmt.Errorf("key file %s: %v", path, err)
+	}
+	return key, nil
+}
+
+// runKeyCommand runs the key command and reads the key from its standard output.
+// Its standard error is passed through so that it can prompt or report problems.
+func runKeyCommand(ctx context.Context, args []string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, keyCommandTimeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Stdin = os.Stdin
+	cmd.Stderr = os.Stderr
+	out, err := cmd.Output()
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return nil, fmt.Errorf("key command %s timed out after %s", args[0], keyCommandTimeout)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("key command %s failed: %v", args[0], err)
+	}
+	key, err := parseKey(out)
+	if err != nil {
+		return nil, fmt.Errorf("key command %s: %v", args[0], err)
+	}
+	return key, nil
+}
+
+// readKeyStdin reads a hex-encoded key from standard input. On a terminal it prompts on standard
+// error and does not echo the key; otherwise it reads the first line.
+func readKeyStdin() ([]byte, error) {
+	fd := int(os.Stdin.Fd())
+	if term.IsTerminal(fd) {
+		fmt.Fprint(os.Stderr, "Encryption key: ")
+		line, err := term.ReadPassword(fd)
+		fmt.Fprintln(os.Stderr)
+		if err != nil {
+			return nil, fmt.Errorf("error reading key from terminal: %v", err)
+		}
+		return parseKey(line)
+	}
+
+	line, err := bufio.NewReader(os.Stdin).ReadBytes('\n')
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("error reading key from standard input: %v", err)
+	}
+	return parseKey(line)
+}
+
+// parseKey accepts 64 hex characters, ignoring surrounding whitespace, or exactly 32 raw bytes.
+func parseKey(content []byte) ([]byte, error) {
+	if trimmed := bytes.TrimSpace(content); len(trimmed) == 64 {
+		if key, err := hex.DecodeString(string(trimmed)); err == nil {
+			return key, nil
+		}
+	}
+	if len(content) == 32 {
+		return bytes.Clone(content), nil
+	}
+	if len(bytes.TrimSpace(content)) == 0 {
+		return nil, fmt.Errorf("encryption key is empty")
+	}
+	return nil, fmt.Errorf("encryption key must be 64 hex characters or 32 raw bytes")
+}
@@ -0,0 +1,67 @@
+package data
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"time"
+
+	"google.golang.org/api/iterator"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Lockout records repeated authentication failures from a single client.
+type Lockout struct {
+	Client      string    `firestore:"client"`
+	Failures    int       `firestore:"failures"`
+	LastFailure time.Time `firestore:"lastFailure"`
+	LockedUntil time.Time `firestore:"lockedUntil"`
+}
+
+const lockoutCollection = "lockouts"
+
+// LoadLockouts returns every persisted lockout keyed by client, so that lockouts survive a restart.
+func LoadLockouts(ctx context.Context) (map[string]Lockout, error) {
+	lockouts := make(map[string]Lockout)
+	iter := firestoreClient.Collection(lockoutCollection).Documents(ctx)
+	defer iter.Stop()
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to iterate lockouts: %w", err)
+		}
+		var lockout Lockout
+		if err := doc.DataTo(&lockout); err != nil {
+			return nil, fmt.Errorf("failed to parse lockout %s: %w", doc.Ref.ID, err)
+		}
+		lockouts[lockout.Client] = lockout
+	}
+	return lockouts, nil
+}
+
+// SaveLockout persists the lockout state for a client, replacing any previous state.
+func SaveLockout(ctx context.Context, lockout Lockout) error {
+	_, err := firestoreClient.Collection(lockoutCollection).Doc(lockoutDocID(lockout.Client)).Set(ctx, lockout)
+	if err != nil {
+		return fmt.Errorf("failed to save lockout for %s: %w", lockout.Client, err)
+	}
+	return nil
+}
+
+// DeleteLockout removes the persisted lockout state for a client. Deleting a missing lockout is not an error.
+func DeleteLockout(ctx context.Context, client string) error {
+	_, err := firestoreClient.Collection(lockoutCollection).Doc(lockoutDocID(client)).Delete(ctx)
+	if err != nil && status.Code(err) != codes.NotFound {
+		return fmt.Errorf("failed to delete lockout for %s: %w", client, err)
+	}
+	return nil
+}
+
+// lockoutDocID escapes a client key for use as a document ID, which may not contain slashes.
+func lockoutDocID(client string) string {
+	return url.PathEscape(client)
+}
@@ -1,6 +1,9 @@
 package data
 
-import "errors"
+import (
+	"errors"
+	"time"
+)
 
 // ErrNotFound is returned when a requested credential is not found in the database.
 var ErrNotFound = errors.New("credentials not found")
@@ -9,6 +12,43 @@ var ErrNotFound = errors.New("credentials not found")
 type Credentials struct {
 	Username string `firestore:"username"`
 	Password string `firestore:"password"`
+	// Tags group credentials, e.g. for rotation policies. They are lower case and sorted.
+	Tags []string `firestore:"tags,omitempty" json:"tags,omitempty"`
+	// Notes, TOTP, and Fields are optional details, usually imported from another password manager. TOTP is an
+	// otpauth:// URI or a base32 secret. Like the password, the notes, the TOTP secret, and the values of fields are
+	// stored encrypted.
+	Notes  string        `firestore:"notes,omitempty" json:"notes,omitempty"`
+	TOTP   string        `firestore:"totp,omitempty" json:"totp,omitempty"`
+	Fields []CustomField `firestore:"fields,omitempty" json:"fields,omitempty"`
+	// History holds the earlier passwords, newest first, encrypted like the password. It is recorded when the
+	// password changes, or imported, and is left out of API responses.
+	History []PasswordVersion `firestore:"history,omitempty" json:"-"`
+	// PasswordChangedAt is when the password was last changed. It is zero for credentials stored before it was
+	// recorded, whose documents' update times stand in for it.
+	PasswordChangedAt time.Time `firestore:"passwordChangedAt,omitempty" json:"-"`
+}
+
+// Custom field types.
+const (
+	FieldText    = "text"
+	FieldHidden  = "hidden"
+	FieldBoolean = "boolean"
+)
+
+// CustomField is a named value kept with credentials, such as a PIN or the answer to a security question.
+type CustomField struct {
+	Name  string `firestore:"name" json:"name"`
+	Value string `firestore:"value" json:"value"`
+	// Type is FieldText, FieldHidden, or FieldBoolean. Empty means FieldText.
+	Type string `firestore:"type,omitempty" json:"type,omitempty"`
+}
+
+// PasswordVersion is an earlier password of a site, with the username it went with.
+type PasswordVersion struct {
+	Username string `firestore:"username" json:"username"`
+	Password string `firestore:"password" json:"password"`
+	// ChangedAt is when this password was set.
+	ChangedAt time.Time `firestore:"changedAt" json:"changedAt"`
 }
 
 // SiteCredentials extends Credentials to include the site identifier, used for API responses.
@@ -16,4 +56,10 @@ type SiteCredentials struct {
 	Site     string `json:"site"`
 	Username string `json:"username"`
 	Password string `json:"password"`
+	// Tags, the optional details, and the history are only filled in by Export.
+	Tags    []string          `json:"tags,omitempty"`
+	Notes   string            `json:"notes,omitempty"`
+	TOTP    string            `json:"totp,omitempty"`
+	Fields  []CustomField     `json:"fields,omitempty"`
+	History []PasswordVersion `json:"history,omitempty"`
 }
@@ -0,0 +1,203 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/api/iterator"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Rotation policy scopes: a policy applies to a single site or to every site with a tag.
+const (
+	RotationScopeSite = "site"
+	RotationScopeTag  = "tag"
+)
+
+// ErrInvalidPolicy is returned by SetRotationPolicy for a policy with an unknown scope, no name, or a rotation
+// period that is not positive.
+var ErrInvalidPolicy = errors.New("invalid rotation policy")
+
+// RotationPolicy requires the password of a site, or of every site with a tag, to be changed every Days days.
+type RotationPolicy struct {
+	Scope string `firestore:"scope" json:"scope"`
+	Name  string `firestore:"name" json:"name"`
+	Days  int    `firestore:"days" json:"days"`
+}
+
+// String describes the policy's scope, e.g. "site example.com" or "tag shared".
+func (p RotationPolicy) String() string {
+	return p.Scope + " " + p.Name
+}
+
+// RotationItem is a credential whose password is due for rotation under a policy.
+type RotationItem struct {
+	Site     string `json:"site"`
+	Username string `json:"username"`
+	// Policy is the policy that applies. A site's own policy takes precedence over those of its tags; of several
+	// tag policies, the one with the shortest period applies.
+	Policy    RotationPolicy `json:"policy"`
+	ChangedAt time.Time      `json:"changedAt"`
+	ExpiresAt time.Time      `json:"expiresAt"`
+	// Overdue is set once the password has expired.
+	Overdue bool `json:"overdue"`
+}
+
+// rotationPolicyRef returns the document of the policy for a scope and name, whose ID is "scope:name".
+func rotationPolicyRef(scope, name string) *firestore.DocumentRef {
+	return firestoreClient.Collection("rotation_policies").Doc(scope + ":" + name)
+}
+
+// SetRotationPolicy creates or replaces a rotation policy. A site policy is stored under the name of the site as
+// found by findSiteDocument, so it returns ErrNotFound for a site that is not stored. The stored policy is returned.
+func SetRotationPolicy(ctx context.Context, policy RotationPolicy) (RotationPolicy, error) {
+	if policy.Name == "" || policy.Days <= 0 {
+		return RotationPolicy{}, ErrInvalidPolicy
+	}
+	switch policy.Scope {
+	case RotationScopeSite:
+		docRef, err := findSiteDocument(ctx, policy.Name)
+		if err != nil {
+			return RotationPolicy{}, err
+		}
+		policy.Name = docRef.ID
+	case RotationScopeTag:
+		tags, err := NormalizeTags([]string{policy.Name})
+		if err != nil || len(tags) != 1 {
+			return RotationPolicy{}, ErrInvalidPolicy
+		}
+		policy.Name = tags[0]
+	default:
+		return RotationPolicy{}, ErrInvalidPolicy
+	}
+
+	if _, err := rotationPolicyRef(policy.Scope, policy.Name).Set(ctx, policy); err != nil {
+		return RotationPolicy{}, fmt.Errorf("failed to store rotation policy for %s: %w", policy, err)
+	}
+	return policy, nil
+}
+
+// DeleteRotationPolicy deletes a rotation policy. A site policy is looked up like SetRotationPolicy stores it. It
+// returns ErrNotFound if there is no such policy.
+func DeleteRotationPolicy(ctx context.Context, scope, name string) error {
+	switch scope {
+	case RotationScopeSite:
+		if docRef, err := findSiteDocument(ctx, name); err == nil {
+			name = docRef.ID
+		}
+	case RotationScopeTag:
+		if tags, err := NormalizeTags([]string{name}); err == nil && len(tags) == 1 {
+			name = tags[0]
+		}
+	}
+	_, err := rotationPolicyRef(scope, name).Delete(ctx, firestore.Exists)
+	if status.Code(err) == codes.NotFound {
+		return ErrNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("failed to delete rotation policy for %s %s: %w", scope, name, err)
+	}
+	return nil
+}
+
+// RotationPolicies returns every rotation policy, site policies first, each kind ordered by name.
+func RotationPolicies(ctx context.Context) ([]RotationPolicy, error) {
+	policies := []RotationPolicy{}
+	iter := firestoreClient.Collection("rotation_policies").Documents(ctx)
+	defer iter.Stop()
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to iterate rotation policies: %w", err)
+		}
+		var policy RotationPolicy
+		if err := doc.DataTo(&policy); err != nil {
+			return nil, fmt.Errorf("failed to parse rotation policy %s: %w", doc.Ref.ID, err)
+		}
+		policies = append(policies, policy)
+	}
+	sort.Slice(policies, func(i, j int) bool {
+		if policies[i].Scope != policies[j].Scope {
+			return policies[i].Scope == RotationScopeSite
+		}
+		return policies[i].Name < policies[j].Name
+	})
+	return policies, nil
+}
+
+// RotationDue returns the credentials whose passwords expire within the given duration from now, or have already
+// expired, soonest first. A password expires its policy's period after it was last changed, as recorded by
+// updateLocked. Credentials without a policy never expire. Passwords are not decrypted.
+func RotationDue(ctx context.Context, within time.Duration) ([]RotationItem, error) {
+	policies, err := RotationPolicies(ctx)
+	if err != nil {
+		return nil, err
+	}
+	sitePolicies := make(map[string]RotationPolicy)
+	tagPolicies := make(map[string]RotationPolicy)
+	for _, policy := range policies {
+		if policy.Scope == RotationScopeSite {
+			sitePolicies[policy.Name] = policy
+		} else {
+			tagPolicies[policy.Name] = policy
+		}
+	}
+
+	items := []RotationItem{}
+	if len(policies) == 0 {
+		return items, nil
+	}
+	now := time.Now().UTC()
+	iter := firestoreClient.Collection("credentials").Documents(ctx)
+	defer iter.Stop()
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to iterate credentials: %w", err)
+		}
+		var creds Credentials
+		if err := doc.DataTo(&creds); err != nil {
+			return nil, fmt.Errorf("failed to parse data for site %s: %w", doc.Ref.ID, err)
+		}
+
+		policy, ok := sitePolicies[doc.Ref.ID]
+		if !ok {
+			for _, tag := range creds.Tags {
+				if tagPolicy, found := tagPolicies[tag]; found && (!ok || tagPolicy.Days < policy.Days) {
+					policy, ok = tagPolicy, true
+				}
+			}
+		}
+		if !ok {
+			continue
+		}
+		changedAt := passwordChangedAt(doc, creds)
+		expiresAt := changedAt.AddDate(0, 0, policy.Days)
+		if expiresAt.Sub(now) > within {
+			continue
+		}
+		items = append(items, RotationItem{
+			Site:      doc.Ref.ID,
+			Username:  creds.Username,
+			Policy:    policy,
+			ChangedAt: changedAt,
+			ExpiresAt: expiresAt,
+			Overdue:   !expiresAt.After(now),
+		})
+	}
+	sort.SliceStable(items, func(i, j int) bool {
+		return items[i].ExpiresAt.Before(items[j].ExpiresAt)
+	})
+	return items, nil
+}
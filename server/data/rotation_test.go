@@ -0,0 +1,159 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// storeChangedAt stores credentials whose password was last changed at the given time. Their password is not
+// encrypted, which RotationDue never notices since it does not decrypt passwords.
+func storeChangedAt(t *testing.T, site string, tags []string, changedAt time.Time) {
+	t.Helper()
+	creds := Credentials{Username: "alice", Password: "unused", Tags: tags, PasswordChangedAt: changedAt}
+	if _, err := firestoreClient.Collection("credentials").Doc(site).Set(context.Background(), creds); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func setPolicy(t *testing.T, scope, name string, days int) RotationPolicy {
+	t.Helper()
+	policy, err := SetRotationPolicy(context.Background(), RotationPolicy{Scope: scope, Name: name, Days: days})
+	if err != nil {
+		t.Fatalf("SetRotationPolicy(%s %s): %v", scope, name, err)
+	}
+	return policy
+}
+
+func TestRotationPolicyPrecedence(t *testing.T) {
+	fakeFirestore.Reset()
+	ctx := context.Background()
+	changedAt := time.Now().UTC().AddDate(0, 0, -10)
+	storeChangedAt(t, "bank.com", []string{"finance", "shared"}, changedAt)
+	storeChangedAt(t, "mail.net", []string{"finance", "shared"}, changedAt)
+	storeChangedAt(t, "broker.io", []string{"finance"}, changedAt)
+	storeChangedAt(t, "forum.org", []string{"social"}, changedAt)
+	storeChangedAt(t, "untagged.dev", nil, changedAt)
+
+	// The site's own policy applies although its tags' policies are shorter
+	sitePolicy := setPolicy(t, RotationScopeSite, "bank", 365)
+	if sitePolicy.Name != "bank.com" {
+		t.Errorf("the site policy for bank is stored for %q, want bank.com", sitePolicy.Name)
+	}
+	finance := setPolicy(t, RotationScopeTag, " Finance ", 90)
+	shared := setPolicy(t, RotationScopeTag, "shared", 30)
+	if finance.Name != "finance" {
+		t.Errorf("the tag policy for Finance is stored for %q, want finance", finance.Name)
+	}
+
+	items, err := RotationDue(ctx, 100*365*24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]RotationPolicy{
+		"mail.net":  shared, // the shortest of its tags' periods
+		"broker.io": finance,
+		"bank.com":  sitePolicy,
+	}
+	if len(items) != len(want) {
+		t.Fatalf("due %+v, want %d items", items, len(want))
+	}
+	for _, item := range items {
+		if item.Policy != want[item.Site] {
+			t.Errorf("%s is due under %+v, want %+v", item.Site, item.Policy, want[item.Site])
+		}
+		if wantExpiry := changedAt.AddDate(0, 0, item.Policy.Days); !item.ExpiresAt.Equal(wantExpiry) {
+			t.Errorf("%s expires at %s, want %s", item.Site, item.ExpiresAt, wantExpiry)
+		}
+	}
+	if items[0].Site != "mail.net" || items[1].Site != "broker.io" || items[2].Site != "bank.com" {
+		t.Errorf("due %s, %s, %s; want the soonest first", items[0].Site, items[1].Site, items[2].Site)
+	}
+
+	// Without its own policy, a site falls back to its tags'
+	if err := DeleteRotationPolicy(ctx, RotationScopeSite, "bank"); err != nil {
+		t.Fatal(err)
+	}
+	items, err = RotationDue(ctx, 100*365*24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, item := range items {
+		if item.Site == "bank.com" && item.Policy != shared {
+			t.Errorf("bank.com is due under %+v after its policy was deleted, want %+v", item.Policy, shared)
+		}
+	}
+}
+
+func TestRotationDue(t *testing.T) {
+	fakeFirestore.Reset()
+	ctx := context.Background()
+	now := time.Now().UTC()
+	storeChangedAt(t, "expired.com", []string{"work"}, now.AddDate(0, 0, -40))
+	storeChangedAt(t, "expiring.com", []string{"work"}, now.AddDate(0, 0, -27))
+	storeChangedAt(t, "later.com", []string{"work"}, now.AddDate(0, 0, -10))
+	storeChangedAt(t, "long-expired.com", []string{"work"}, now.AddDate(0, 0, -400))
+	// Credentials stored before the change time was recorded fall back to the document's update time, just now
+	storeChangedAt(t, "legacy.com", []string{"work"}, time.Time{})
+
+	if items, err := RotationDue(ctx, 365*24*time.Hour); err != nil || len(items) != 0 {
+		t.Errorf("due %+v, %v without any policy, want none", items, err)
+	}
+	setPolicy(t, RotationScopeTag, "work", 30)
+
+	items, err := RotationDue(ctx, 7*24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	type due struct {
+		site    string
+		overdue bool
+	}
+	var got []due
+	for _, item := range items {
+		got = append(got, due{item.Site, item.Overdue})
+	}
+	want := []due{{"long-expired.com", true}, {"expired.com", true}, {"expiring.com", false}}
+	if len(got) != len(want) {
+		t.Fatalf("due %+v, want %+v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("due %+v, want %+v", got, want)
+			break
+		}
+	}
+
+	// Only what has expired, with a notice period of 0
+	items, err = RotationDue(ctx, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 2 || !items[0].Overdue || !items[1].Overdue {
+		t.Errorf("due %+v with no notice, want the two expired sites", items)
+	}
+}
+
+func TestSetRotationPolicyErrors(t *testing.T) {
+	fakeFirestore.Reset()
+	ctx := context.Background()
+	storeChangedAt(t, "example.com", nil, time.Now())
+	for _, policy := range []RotationPolicy{
+		{Scope: RotationScopeTag, Name: "work", Days: 0},
+		{Scope: RotationScopeTag, Name: "work", Days: -30},
+		{Scope: RotationScopeTag, Name: "", Days: 30},
+		{Scope: RotationScopeTag, Name: "a/b", Days: 30},
+		{Scope: "user", Name: "alice", Days: 30},
+	} {
+		if _, err := SetRotationPolicy(ctx, policy); !errors.Is(err, ErrInvalidPolicy) {
+			t.Errorf("SetRotationPolicy(%+v) returned %v, want ErrInvalidPolicy", policy, err)
+		}
+	}
+	if _, err := SetRotationPolicy(ctx, RotationPolicy{Scope: RotationScopeSite, Name: "missing.org", Days: 30}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("SetRotationPolicy for a site that is not stored returned %v, want ErrNotFound", err)
+	}
+	if err := DeleteRotationPolicy(ctx, RotationScopeTag, "work"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("DeleteRotationPolicy of a missing policy returned %v, want ErrNotFound", err)
+	}
+}
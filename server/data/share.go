@@ -0,0 +1,114 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/api/iterator"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// shareCollection holds the one-time shares.
+const shareCollection = "shares"
+
+// ErrShareNotFound is returned for a share that does not exist, has expired, or has been opened as often as it
+// allows. The three are not told apart, so that a link reveals nothing once it is spent.
+var ErrShareNotFound = errors.New("share not found")
+
+// Share is a one-time share as stored. Its content is encrypted with a key the server never sees (see the share
+// package), so it is stored as it arrives, without the vault's encryption.
+type Share struct {
+	Ciphertext []byte `firestore:"ciphertext"`
+	// Site is the site whose credentials were shared, for the audit log, or empty for free text.
+	Site      string    `firestore:"site"`
+	MaxViews  int       `firestore:"maxViews"`
+	Views     int       `firestore:"views"`
+	CreatedAt time.Time `firestore:"createdAt"`
+	ExpiresAt time.Time `firestore:"expiresAt"`
+}
+
+// CreateShare stores a new share under the given ID.
+func CreateShare(ctx context.Context, id string, share Share) error {
+	if _, err := firestoreClient.Collection(shareCollection).Doc(id).Create(ctx, share); err != nil {
+		return fmt.Errorf("failed to store share: %w", err)
+	}
+	return nil
+}
+
+// OpenShare counts a view of a share and returns it, with Views including this one. The view that reaches MaxViews
+// deletes the share, and so does an attempt to open an expired one, which returns ErrShareNotFound. Views are
+// counted in a transaction, so concurrent opens cannot exceed MaxViews.
+func OpenShare(ctx context.Context, id string) (Share, error) {
+	docRef := firestoreClient.Collection(shareCollection).Doc(id)
+	var share Share
+	expired := false
+	err := firestoreClient.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		doc, err := tx.Get(docRef)
+		if status.Code(err) == codes.NotFound {
+			return ErrShareNotFound
+		}
+		if err != nil {
+			return err
+		}
+		share = Share{}
+		if err := doc.DataTo(&share); err != nil {
+			return err
+		}
+		expired = !time.Now().Before(share.ExpiresAt)
+		if expired {
+			return tx.Delete(docRef)
+		}
+		share.Views++
+		if share.Views >= share.MaxViews {
+			return tx.Delete(docRef)
+		}
+		return tx.Update(docRef, []firestore.Update{{Path: "views", Value: share.Views}})
+	})
+	if errors.Is(err, ErrShareNotFound) {
+		return Share{}, err
+	}
+	if err != nil {
+		return Share{}, fmt.Errorf("failed to open share: %w", err)
+	}
+	if expired {
+		return Share{}, ErrShareNotFound
+	}
+	return share, nil
+}
+
+// DeleteShare deletes a share before it is spent. It returns ErrShareNotFound if there is no such share.
+func DeleteShare(ctx context.Context, id string) error {
+	_, err := firestoreClient.Collection(shareCollection).Doc(id).Delete(ctx, firestore.Exists)
+	if status.Code(err) == codes.NotFound {
+		return ErrShareNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("failed to delete share: %w", err)
+	}
+	return nil
+}
+
+// PurgeExpiredShares deletes every share that expired at or before now, and returns how many it deleted.
+func PurgeExpiredShares(ctx context.Context, now time.Time) (int, error) {
+	iter := firestoreClient.Collection(shareCollection).Where("expiresAt", "<=", now).Documents(ctx)
+	defer iter.Stop()
+	purged := 0
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			return purged, nil
+		}
+		if err != nil {
+			return purged, fmt.Errorf("failed to iterate expired shares: %w", err)
+		}
+		// A share opened for the last time in the meantime is already gone, which is fine
+		if _, err := doc.Ref.Delete(ctx); err != nil && status.Code(err) != codes.NotFound {
+			return purged, fmt.Errorf("failed to delete share: %w", err)
+		}
+		purged++
+	}
+}
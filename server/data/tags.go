@@ -0,0 +1,37 @@
+package data
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// maxTagLength bounds the length of a tag.
+const maxTagLength = 64
+
+// NormalizeTags trims and lower-cases tags, drops empty and repeated ones, and sorts them. It returns an error for a
+// tag that is too long or contains a slash, which would make it unusable in a URL path. nil stays nil, so that
+// Update can tell "keep the tags" from "no tags".
+func NormalizeTags(tags []string) ([]string, error) {
+	if tags == nil {
+		return nil, nil
+	}
+	seen := make(map[string]bool, len(tags))
+	normalized := []string{}
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag == "" || seen[tag] {
+			continue
+		}
+		if len(tag) > maxTagLength {
+			return nil, fmt.Errorf("tag %q must not exceed %d characters", tag, maxTagLength)
+		}
+		if strings.Contains(tag, "/") {
+			return nil, fmt.Errorf("tag %q must not contain a slash", tag)
+		}
+		seen[tag] = true
+		normalized = append(normalized, tag)
+	}
+	sort.Strings(normalized)
+	return normalized, nil
+}
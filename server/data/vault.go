@@ -0,0 +1,120 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"google.golang.org/api/iterator"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// VaultFormatVersion is the version of the stored data format, recorded in the vault metadata so that
+// future releases can tell which migrations an existing vault needs.
+const VaultFormatVersion = 1
+
+// ErrVaultExists is returned when creating vault metadata for a vault that already has it.
+var ErrVaultExists = errors.New("vault is already initialized")
+
+// ErrVaultNotInitialized is returned when a vault has no metadata, either because it was never set up
+// with `init` or because it predates vault metadata.
+var ErrVaultNotInitialized = errors.New("vault is not initialized")
+
+// ErrWrongKey is returned when the vault's canary cannot be decrypted with the loaded encryption key.
+var ErrWrongKey = errors.New("encryption key does not match the vault")
+
+// VaultMeta describes a vault as a whole.
+type VaultMeta struct {
+	CreatedAt     time.Time `firestore:"createdAt" json:"createdAt"`
+	FormatVersion int       `firestore:"formatVersion" json:"formatVersion"`
+	// Canary is a known plaintext encrypted with the vault's key, so that a wrong key is detected before
+	// any credentials are written with it.
+	Canary string `firestore:"canary" json:"-"`
+}
+
+const (
+	metaCollection = "meta"
+	vaultMetaDoc   = "vault"
+	vaultCanary    = "pasword-mango vault canary"
+)
+
+// CreateVaultMeta records the metadata of a new vault, including a canary encrypted with the current key.
+// It returns ErrVaultExists if the vault already has metadata, so that an existing vault is never re-keyed by accident.
+func CreateVaultMeta(ctx context.Context) (VaultMeta, error) {
+	return createVaultMeta(ctx, time.Now().UTC())
+}
+
+// createVaultMeta is CreateVaultMeta with the creation time given, so that a restored vault keeps the creation time
+// of the vault it was backed up from.
+func createVaultMeta(ctx context.Context, createdAt time.Time) (VaultMeta, error) {
+	canary, err := encrypt(vaultCanary)
+	if err != nil {
+		return VaultMeta{}, fmt.Errorf("failed to encrypt vault canary: %w", err)
+	}
+	meta := VaultMeta{
+		CreatedAt:     createdAt,
+		FormatVersion: VaultFormatVersion,
+		Canary:        canary,
+	}
+	_, err = firestoreClient.Collection(metaCollection).Doc(vaultMetaDoc).Create(ctx, meta)
+	if status.Code(err) == codes.AlreadyExists {
+		return VaultMeta{}, ErrVaultExists
+	}
+	if err != nil {
+		return VaultMeta{}, fmt.Errorf("failed to create vault metadata: %w", err)
+	}
+	return meta, nil
+}
+
+// GetVaultMeta returns the vault's metadata, or ErrVaultNotInitialized if it has none.
+func GetVaultMeta(ctx context.Context) (VaultMeta, error) {
+	doc, err := firestoreClient.Collection(metaCollection).Doc(vaultMetaDoc).Get(ctx)
+	if status.Code(err) == codes.NotFound {
+		return VaultMeta{}, ErrVaultNotInitialized
+	}
+	if err != nil {
+		return VaultMeta{}, fmt.Errorf("failed to get vault metadata: %w", err)
+	}
+	var meta VaultMeta
+	if err := doc.DataTo(&meta); err != nil {
+		return VaultMeta{}, fmt.Errorf("failed to parse vault metadata: %w", err)
+	}
+	return meta, nil
+}
+
+// CheckKey verifies that the loaded encryption key is the vault's key. It decrypts the canary in the vault
+// metadata or, for a vault without metadata, the first stored password. It returns a description of the record
+// that was checked, ErrWrongKey if decryption fails, and ErrVaultNotInitialized if there is nothing to check.
+func CheckKey(ctx context.Context) (string, error) {
+	meta, err := GetVaultMeta(ctx)
+	if err == nil {
+		if plaintext, err := decrypt(meta.Canary); err != nil || plaintext != vaultCanary {
+			return "vault canary", ErrWrongKey
+		}
+		return "vault canary", nil
+	}
+	if !errors.Is(err, ErrVaultNotInitialized) {
+		return "", err
+	}
+
+	iter := firestoreClient.Collection("credentials").Limit(1).Documents(ctx)
+	defer iter.Stop()
+	doc, err := iter.Next()
+	if err == iterator.Done {
+		return "", ErrVaultNotInitialized
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to read credentials: %w", err)
+	}
+	var creds Credentials
+	if err := doc.DataTo(&creds); err != nil {
+		return "", fmt.Errorf("failed to parse credentials for %s: %w", doc.Ref.ID, err)
+	}
+	target := "credentials for " + doc.Ref.ID
+	if _, err := decrypt(creds.Password); err != nil {
+		return target, ErrWrongKey
+	}
+	return target, nil
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"filippo.io/age"
+	"github.com/rihts-4/pasword-mango/agecrypt"
+	"github.com/rihts-4/pasword-mango/data"
+	"github.com/rihts-4/pasword-mango/importer"
+)
+
+// exportHandler serves POST /export, which returns the vault, or part of it, as another password manager's export,
+// so that it can be moved out as easily as it was moved in. The JSON body names the `format`, which is `bitwarden`
+// (the default) for Bitwarden's JSON export or `kdbx` for a KeePass database, and a `password`, which makes a
+// Bitwarden export password protected and is required for a KeePass database. It is sent as a POST so that the
+// password never appears in a URL.
+//
+// `sites`, `tags`, and `query` select the credentials to export, as described by data.ExportFilter; without them
+// the whole vault is exported. With `recipients`, age X25519 or SSH public keys, the export is encrypted for them
+// with age (see the agecrypt package), so that it can be handed to someone without being written down in plaintext
+// anywhere on the way; `armor` makes the age file ASCII-armored.
+//
+// The export is the response body; a KeePass database and an age file are sent as application/octet-stream, an
+// armored age file as text/plain. Sites whose credentials cannot be decrypted are logged and left out, and their
+// number is sent in the X-Export-Failed header. The handler returns 400 for malformed requests, unsupported formats,
+// KeePass databases without a password, and invalid tags or recipients, 404 for a selected site that does not exist
+// or a selection that matches nothing, 500 for data errors, and 405 for methods other than POST.
+func exportHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	recordAudit(r, data.AuditExport, "")
+	var payload struct {
+		Format     string   `json:"format"`
+		Password   string   `json:"password"`
+		Sites      []string `json:"sites"`
+		Tags       []string `json:"tags"`
+		Query      string   `json:"query"`
+		Recipients []string `json:"recipients"`
+		Armor      bool     `json:"armor"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	format := strings.ToLower(payload.Format)
+	if format == "" {
+		format = importer.FormatBitwarden
+	}
+	if format == "keepass" {
+		format = importer.FormatKDBX
+	}
+	if format != importer.FormatBitwarden && format != importer.FormatKDBX {
+		http.Error(w, "Unsupported export format "+strconv.Quote(payload.Format), http.StatusBadRequest)
+		return
+	}
+	if format == importer.FormatKDBX && payload.Password == "" {
+		http.Error(w, "A password is required to export a KeePass database", http.StatusBadRequest)
+		return
+	}
+	tags, err := data.NormalizeTags(payload.Tags)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid tags: %v", err), http.StatusBadRequest)
+		return
+	}
+	filter := data.ExportFilter{Sites: payload.Sites, Tags: tags, Query: strings.TrimSpace(payload.Query)}
+	var recipients []age.Recipient
+	if len(payload.Recipients) > 0 {
+		if recipients, err = agecrypt.ParseRecipients(payload.Recipients); err != nil {
+			http.Error(w, fmt.Sprintf("Invalid recipients: %v", err), http.StatusBadRequest)
+			return
+		}
+	} else if payload.Armor {
+		http.Error(w, "armor requires recipients", http.StatusBadRequest)
+		return
+	}
+	target := format
+	if recipients != nil {
+		target += "+age"
+	}
+	recordAudit(r, data.AuditExport, target)
+
+	exported, failed, err := data.Export(r.Context(), filter)
+	if errors.Is(err, data.ErrNotFound) {
+		http.Error(w, fmt.Sprintf("Invalid selection: %v", err), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to export credentials: %v", err)
+		http.Error(w, "Failed to export credentials", http.StatusInternalServerError)
+		return
+	}
+	if len(exported) == 0 && !filter.IsZero() {
+		http.Error(w, "No credentials match the selection", http.StatusNotFound)
+		return
+	}
+	entries := make([]importer.Entry, 0, len(exported))
+	for _, creds := range exported {
+		entry := importer.Entry{
+			Site:     creds.Site,
+			Username: creds.Username,
+			Password: creds.Password,
+			Tags:     creds.Tags,
+			Notes:    creds.Notes,
+			TOTP:     creds.TOTP,
+		}
+		for _, field := range creds.Fields {
+			entry.Fields = append(entry.Fields, importer.Field{Name: field.Name, Value: field.Value, Type: exportFieldType(field.Type)})
+		}
+		for _, version := range creds.History {
+			entry.History = append(entry.History, importer.Version{Username: version.Username, Password: version.Password, ChangedAt: version.ChangedAt})
+		}
+		entries = append(entries, entry)
+	}
+	log.Printf("Exported %d credentials as a %s export, %d failed", len(entries), target, len(failed))
+
+	write, contentType := importer.WriteBitwarden, "application/json"
+	if format == importer.FormatKDBX {
+		write, contentType = importer.WriteKDBX, "application/octet-stream"
+	}
+	switch {
+	case payload.Armor:
+		contentType = "text/plain; charset=utf-8"
+	case recipients != nil:
+		contentType = "application/octet-stream"
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Cache-Control", "no-store")
+	if len(failed) > 0 {
+		w.Header().Set("X-Export-Failed", strconv.Itoa(len(failed)))
+	}
+	if recipients == nil {
+		if err := write(w, entries, payload.Password); err != nil {
+			log.Printf("Failed to write a %s export: %v", target, err)
+		}
+		return
+	}
+	// The export is encrypted as it is written, so that it is never held in plaintext beyond the entries
+	encrypted, err := agecrypt.Encrypt(w, recipients, payload.Armor)
+	if err == nil {
+		err = write(encrypted, entries, payload.Password)
+	}
+	if err == nil {
+		err = encrypted.Close()
+	}
+	if err != nil {
+		log.Printf("Failed to write a %s export: %v", target, err)
+	}
+}
+
+// exportFieldType maps the type of a stored custom field to that of an exported one.
+func exportFieldType(fieldType string) string {
+	switch fieldType {
+	case data.FieldHidden:
+		return importer.FieldHidden
+	case data.FieldBoolean:
+		return importer.FieldBoolean
+	default:
+		return importer.FieldText
+	}
+}
@@ -0,0 +1,476 @@
+// Package firestoretest runs an in-memory stand-in for the Firestore API, for tests of code that talks to
+// Firestore through the Go client. It listens on a local port like the Firestore emulator, so a client reaches it
+// through FIRESTORE_EMULATOR_HOST or the storage configuration's emulator host:
+//
+//	srv, err := firestoretest.NewServer()
+//	if err != nil { ... }
+//	defer srv.Close()
+//	err = data.OpenDB(ctx, config.Storage{Backend: "firestore", ProjectID: "test", EmulatorHost: srv.Addr}, key)
+//
+// It implements what the vault uses: getting documents, commits with preconditions and field masks, queries on a
+// single collection with field filters, ordering, and limits, and transactions, which it serializes rather than
+// isolates. Field transforms, cursors, and listeners are not supported.
+package firestoretest
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"fmt"
+	"net"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	pb "cloud.google.com/go/firestore/apiv1/firestorepb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// Server is an in-memory Firestore. It is safe for concurrent use.
+type Server struct {
+	pb.UnimplementedFirestoreServer
+
+	// Addr is the host:port the server listens on.
+	Addr string
+
+	grpc *grpc.Server
+
+	mu   sync.Mutex
+	docs map[string]*pb.Document // by full resource name
+}
+
+// NewServer starts a server on a random local port.
+func NewServer() (*Server, error) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	s := &Server{Addr: lis.Addr().String(), grpc: grpc.NewServer(), docs: make(map[string]*pb.Document)}
+	pb.RegisterFirestoreServer(s.grpc, s)
+	go s.grpc.Serve(lis)
+	return s, nil
+}
+
+// Close stops the server.
+func (s *Server) Close() {
+	s.grpc.Stop()
+}
+
+// Reset deletes every document.
+func (s *Server) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	clear(s.docs)
+}
+
+// now returns the time of a read or commit.
+func now() *timestamppb.Timestamp {
+	return timestamppb.New(time.Now())
+}
+
+// GetDocument returns a single document.
+func (s *Server) GetDocument(ctx context.Context, req *pb.GetDocumentRequest) (*pb.Document, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	doc, ok := s.docs[req.GetName()]
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "no document %s", req.GetName())
+	}
+	return proto.Clone(doc).(*pb.Document), nil
+}
+
+// BatchGetDocuments returns documents by name, in the order asked for.
+func (s *Server) BatchGetDocuments(req *pb.BatchGetDocumentsRequest, stream pb.Firestore_BatchGetDocumentsServer) error {
+	s.mu.Lock()
+	var responses []*pb.BatchGetDocumentsResponse
+	readTime := now()
+	for _, name := range req.GetDocuments() {
+		resp := &pb.BatchGetDocumentsResponse{ReadTime: readTime}
+		if doc, ok := s.docs[name]; ok {
+			resp.Result = &pb.BatchGetDocumentsResponse_Found{Found: proto.Clone(doc).(*pb.Document)}
+		} else {
+			resp.Result = &pb.BatchGetDocumentsResponse_Missing{Missing: name}
+		}
+		responses = append(responses, resp)
+	}
+	s.mu.Unlock()
+	if req.GetNewTransaction() != nil && len(responses) > 0 {
+		responses[0].Transaction = newTransactionID()
+	}
+	for _, resp := range responses {
+		if err := stream.Send(resp); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// BeginTransaction returns a new transaction ID.
+func (s *Server) BeginTransaction(ctx context.Context, req *pb.BeginTransactionRequest) (*pb.BeginTransactionResponse, error) {
+	return &pb.BeginTransactionResponse{Transaction: newTransactionID()}, nil
+}
+
+// Rollback abandons a transaction, which never wrote anything before its commit.
+func (s *Server) Rollback(ctx context.Context, req *pb.RollbackRequest) (*emptypb.Empty, error) {
+	return &emptypb.Empty{}, nil
+}
+
+func newTransactionID() []byte {
+	id := make([]byte, 16)
+	rand.Read(id)
+	return id
+}
+
+// Commit applies writes atomically: if any precondition fails, nothing is written.
+func (s *Server) Commit(ctx context.Context, req *pb.CommitRequest) (*pb.CommitResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	commitTime := now()
+	staged := make(map[string]*pb.Document)
+	lookup := func(name string) (*pb.Document, bool) {
+		if doc, ok := staged[name]; ok {
+			return doc, doc != nil
+		}
+		doc, ok := s.docs[name]
+		return doc, ok
+	}
+
+	resp := &pb.CommitResponse{CommitTime: commitTime}
+	for _, write := range req.GetWrites() {
+		var name string
+		switch op := write.GetOperation().(type) {
+		case *pb.Write_Update:
+			name = op.Update.GetName()
+		case *pb.Write_Delete:
+			name = op.Delete
+		default:
+			return nil, status.Errorf(codes.Unimplemented, "write %T is not supported", op)
+		}
+		if len(write.GetUpdateTransforms()) > 0 {
+			return nil, status.Error(codes.Unimplemented, "field transforms are not supported")
+		}
+		existing, exists := lookup(name)
+		if pre := write.GetCurrentDocument(); pre != nil {
+			switch cond := pre.GetConditionType().(type) {
+			case *pb.Precondition_Exists:
+				if cond.Exists && !exists {
+					return nil, status.Errorf(codes.NotFound, "no document %s", name)
+				}
+				if !cond.Exists && exists {
+					return nil, status.Errorf(codes.AlreadyExists, "document %s already exists", name)
+				}
+			case *pb.Precondition_UpdateTime:
+				if !exists || !proto.Equal(existing.GetUpdateTime(), cond.UpdateTime) {
+					return nil, status.Errorf(codes.FailedPrecondition, "document %s was changed", name)
+				}
+			}
+		}
+
+		if _, ok := write.GetOperation().(*pb.Write_Delete); ok {
+			staged[name] = nil
+			resp.WriteResults = append(resp.WriteResults, &pb.WriteResult{UpdateTime: commitTime})
+			continue
+		}
+		update := write.GetUpdate()
+		doc := &pb.Document{Name: name, Fields: map[string]*pb.Value{}, CreateTime: commitTime, UpdateTime: commitTime}
+		if exists {
+			doc.CreateTime = existing.GetCreateTime()
+		}
+		if mask := write.GetUpdateMask(); mask != nil {
+			if exists {
+				doc.Fields = proto.Clone(&pb.MapValue{Fields: existing.GetFields()}).(*pb.MapValue).Fields
+				if doc.Fields == nil {
+					doc.Fields = map[string]*pb.Value{}
+				}
+			}
+			for _, path := range mask.GetFieldPaths() {
+				setField(doc.Fields, splitPath(path), lookupField(update.GetFields(), splitPath(path)))
+			}
+		} else {
+			for key, value := range update.GetFields() {
+				doc.Fields[key] = proto.Clone(value).(*pb.Value)
+			}
+		}
+		staged[name] = doc
+		resp.WriteResults = append(resp.WriteResults, &pb.WriteResult{UpdateTime: commitTime})
+	}
+
+	for name, doc := range staged {
+		if doc == nil {
+			delete(s.docs, name)
+		} else {
+			s.docs[name] = doc
+		}
+	}
+	return resp, nil
+}
+
+// splitPath splits a field path such as a.b or `a.b`.c into its segments.
+func splitPath(path string) []string {
+	var segments []string
+	var current strings.Builder
+	quoted := false
+	for _, r := range path {
+		switch {
+		case r == '`':
+			quoted = !quoted
+		case r == '.' && !quoted:
+			segments = append(segments, current.String())
+			current.Reset()
+		default:
+			current.WriteRune(r)
+		}
+	}
+	return append(segments, current.String())
+}
+
+// lookupField returns the value at a field path, or nil if there is none.
+func lookupField(fields map[string]*pb.Value, path []string) *pb.Value {
+	value, ok := fields[path[0]]
+	if !ok {
+		return nil
+	}
+	if len(path) == 1 {
+		return value
+	}
+	return lookupField(value.GetMapValue().GetFields(), path[1:])
+}
+
+// setField sets the value at a field path, creating maps on the way, or deletes it if value is nil.
+func setField(fields map[string]*pb.Value, path []string, value *pb.Value) {
+	if len(path) == 1 {
+		if value == nil {
+			delete(fields, path[0])
+		} else {
+			fields[path[0]] = proto.Clone(value).(*pb.Value)
+		}
+		return
+	}
+	next := fields[path[0]].GetMapValue()
+	if next == nil {
+		next = &pb.MapValue{}
+		fields[path[0]] = &pb.Value{ValueType: &pb.Value_MapValue{MapValue: next}}
+	}
+	if next.Fields == nil {
+		next.Fields = map[string]*pb.Value{}
+	}
+	setField(next.Fields, path[1:], value)
+}
+
+// RunQuery runs a structured query over the documents of one collection.
+func (s *Server) RunQuery(req *pb.RunQueryRequest, stream pb.Firestore_RunQueryServer) error {
+	query := req.GetStructuredQuery()
+	if query == nil || len(query.GetFrom()) != 1 || query.GetFrom()[0].GetAllDescendants() {
+		return status.Error(codes.Unimplemented, "only queries on a single collection are supported")
+	}
+	if query.GetStartAt() != nil || query.GetEndAt() != nil {
+		return status.Error(codes.Unimplemented, "cursors are not supported")
+	}
+	prefix := req.GetParent() + "/" + query.GetFrom()[0].GetCollectionId() + "/"
+
+	s.mu.Lock()
+	var matched []*pb.Document
+	for name, doc := range s.docs {
+		if !strings.HasPrefix(name, prefix) || strings.Contains(name[len(prefix):], "/") {
+			continue
+		}
+		ok, err := matches(doc, query.GetWhere())
+		if err != nil {
+			s.mu.Unlock()
+			return err
+		}
+		if ok {
+			matched = append(matched, proto.Clone(doc).(*pb.Document))
+		}
+	}
+	s.mu.Unlock()
+
+	orders := query.GetOrderBy()
+	slices.SortFunc(matched, func(a, b *pb.Document) int {
+		for _, order := range orders {
+			c := compareValues(fieldOf(a, order.GetField().GetFieldPath()), fieldOf(b, order.GetField().GetFieldPath()))
+			if order.GetDirection() == pb.StructuredQuery_DESCENDING {
+				c = -c
+			}
+			if c != 0 {
+				return c
+			}
+		}
+		return strings.Compare(a.GetName(), b.GetName())
+	})
+	matched = matched[min(int(query.GetOffset()), len(matched)):]
+	if limit := query.GetLimit(); limit != nil && int(limit.GetValue()) < len(matched) {
+		matched = matched[:limit.GetValue()]
+	}
+
+	readTime := now()
+	var transaction []byte
+	if req.GetNewTransaction() != nil {
+		transaction = newTransactionID()
+	}
+	if len(matched) == 0 {
+		return stream.Send(&pb.RunQueryResponse{ReadTime: readTime, Transaction: transaction})
+	}
+	for i, doc := range matched {
+		resp := &pb.RunQueryResponse{Document: doc, ReadTime: readTime}
+		if i == 0 {
+			resp.Transaction = transaction
+		}
+		if err := stream.Send(resp); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// fieldOf returns the value of a field of a document, with __name__ standing for the document's name.
+func fieldOf(doc *pb.Document, path string) *pb.Value {
+	if path == "__name__" {
+		return &pb.Value{ValueType: &pb.Value_ReferenceValue{ReferenceValue: doc.GetName()}}
+	}
+	return lookupField(doc.GetFields(), splitPath(path))
+}
+
+// matches reports whether a document passes a filter.
+func matches(doc *pb.Document, filter *pb.StructuredQuery_Filter) (bool, error) {
+	if filter == nil {
+		return true, nil
+	}
+	switch f := filter.GetFilterType().(type) {
+	case *pb.StructuredQuery_Filter_CompositeFilter:
+		and := f.CompositeFilter.GetOp() != pb.StructuredQuery_CompositeFilter_OR
+		for _, sub := range f.CompositeFilter.GetFilters() {
+			ok, err := matches(doc, sub)
+			if err != nil {
+				return false, err
+			}
+			if ok != and {
+				return ok, nil
+			}
+		}
+		return and, nil
+	case *pb.StructuredQuery_Filter_FieldFilter:
+		value := fieldOf(doc, f.FieldFilter.GetField().GetFieldPath())
+		if value == nil {
+			return false, nil
+		}
+		want := f.FieldFilter.GetValue()
+		// Range filters only match values of the same type
+		sameType := typeOrder(value) == typeOrder(want)
+		c := compareValues(value, want)
+		switch f.FieldFilter.GetOp() {
+		case pb.StructuredQuery_FieldFilter_EQUAL:
+			return c == 0, nil
+		case pb.StructuredQuery_FieldFilter_NOT_EQUAL:
+			return c != 0, nil
+		case pb.StructuredQuery_FieldFilter_LESS_THAN:
+			return sameType && c < 0, nil
+		case pb.StructuredQuery_FieldFilter_LESS_THAN_OR_EQUAL:
+			return sameType && c <= 0, nil
+		case pb.StructuredQuery_FieldFilter_GREATER_THAN:
+			return sameType && c > 0, nil
+		case pb.StructuredQuery_FieldFilter_GREATER_THAN_OR_EQUAL:
+			return sameType && c >= 0, nil
+		case pb.StructuredQuery_FieldFilter_ARRAY_CONTAINS:
+			return slices.ContainsFunc(value.GetArrayValue().GetValues(), func(v *pb.Value) bool { return compareValues(v, want) == 0 }), nil
+		case pb.StructuredQuery_FieldFilter_IN:
+			return slices.ContainsFunc(want.GetArrayValue().GetValues(), func(v *pb.Value) bool { return compareValues(value, v) == 0 }), nil
+		}
+		return false, status.Errorf(codes.Unimplemented, "operator %v is not supported", f.FieldFilter.GetOp())
+	case *pb.StructuredQuery_Filter_UnaryFilter:
+		value := fieldOf(doc, f.UnaryFilter.GetField().GetFieldPath())
+		switch f.UnaryFilter.GetOp() {
+		case pb.StructuredQuery_UnaryFilter_IS_NULL:
+			return value != nil && typeOrder(value) == 0, nil
+		case pb.StructuredQuery_UnaryFilter_IS_NOT_NULL:
+			return value != nil && typeOrder(value) != 0, nil
+		}
+		return false, status.Errorf(codes.Unimplemented, "operator %v is not supported", f.UnaryFilter.GetOp())
+	}
+	return false, status.Errorf(codes.Unimplemented, "filter %T is not supported", filter.GetFilterType())
+}
+
+// typeOrder ranks value types in Firestore's sort order.
+func typeOrder(v *pb.Value) int {
+	switch v.GetValueType().(type) {
+	case nil, *pb.Value_NullValue:
+		return 0
+	case *pb.Value_BooleanValue:
+		return 1
+	case *pb.Value_IntegerValue, *pb.Value_DoubleValue:
+		return 2
+	case *pb.Value_TimestampValue:
+		return 3
+	case *pb.Value_StringValue:
+		return 4
+	case *pb.Value_BytesValue:
+		return 5
+	case *pb.Value_ReferenceValue:
+		return 6
+	case *pb.Value_GeoPointValue:
+		return 7
+	case *pb.Value_ArrayValue:
+		return 8
+	default:
+		return 9
+	}
+}
+
+// compareValues orders two values as Firestore does. A missing value sorts first.
+func compareValues(a, b *pb.Value) int {
+	if ta, tb := typeOrder(a), typeOrder(b); ta != tb {
+		return ta - tb
+	}
+	switch av := a.GetValueType().(type) {
+	case *pb.Value_BooleanValue:
+		return boolRank(av.BooleanValue) - boolRank(b.GetBooleanValue())
+	case *pb.Value_IntegerValue, *pb.Value_DoubleValue:
+		x, y := number(a), number(b)
+		switch {
+		case x < y:
+			return -1
+		case x > y:
+			return 1
+		}
+		return 0
+	case *pb.Value_TimestampValue:
+		return av.TimestampValue.AsTime().Compare(b.GetTimestampValue().AsTime())
+	case *pb.Value_StringValue:
+		return strings.Compare(av.StringValue, b.GetStringValue())
+	case *pb.Value_BytesValue:
+		return bytes.Compare(av.BytesValue, b.GetBytesValue())
+	case *pb.Value_ReferenceValue:
+		return strings.Compare(av.ReferenceValue, b.GetReferenceValue())
+	case *pb.Value_ArrayValue:
+		x, y := av.ArrayValue.GetValues(), b.GetArrayValue().GetValues()
+		for i := range min(len(x), len(y)) {
+			if c := compareValues(x[i], y[i]); c != 0 {
+				return c
+			}
+		}
+		return len(x) - len(y)
+	case nil, *pb.Value_NullValue:
+		return 0
+	}
+	return strings.Compare(fmt.Sprint(a), fmt.Sprint(b))
+}
+
+func boolRank(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func number(v *pb.Value) float64 {
+	if i, ok := v.GetValueType().(*pb.Value_IntegerValue); ok {
+		return float64(i.IntegerValue)
+	}
+	return v.GetDoubleValue()
+}
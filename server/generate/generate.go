@@ -0,0 +1,310 @@
+// Package generate produces random passwords and diceware-style passphrases. Every random choice is made with
+// crypto/rand and is uniform: indexes are drawn with rand.Int, which rejects out-of-range values instead of
+// reducing modulo the alphabet size.
+//
+// It is shared by the server's POST /generate endpoint, server-side generation on POST /credentials, and the mango
+// client, so that they all accept the same options.
+package generate
+
+import (
+	"bufio"
+	"crypto/rand"
+	_ "embed"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"slices"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// Types of secret a Request can generate.
+const (
+	TypePassword   = "password"
+	TypePassphrase = "passphrase"
+)
+
+// Character classes for passwords.
+const (
+	ClassLower   = "lower"
+	ClassUpper   = "upper"
+	ClassDigits  = "digits"
+	ClassSymbols = "symbols"
+)
+
+// classChars lists the characters of each class, in the order classes are applied.
+var classChars = []struct {
+	name  string
+	chars string
+}{
+	{ClassLower, "abcdefghijklmnopqrstuvwxyz"},
+	{ClassUpper, "ABCDEFGHIJKLMNOPQRSTUVWXYZ"},
+	{ClassDigits, "0123456789"},
+	{ClassSymbols, "!#$%&()*+,-./:;<=>?@[]^_{|}~"},
+}
+
+// ambiguousChars are left out with ExcludeAmbiguous because they are easily confused when read or typed.
+const ambiguousChars = "Il1|O0o"
+
+// Defaults and limits. MaxLength matches the longest password the server stores.
+const (
+	DefaultLength = 20
+	MinLength     = 4
+	MaxLength     = 1000
+
+	DefaultWords     = 6
+	MinWords         = 3
+	MaxWords         = 20
+	DefaultSeparator = "-"
+)
+
+// Request describes the secret to generate. The zero value asks for a DefaultLength-character password using every
+// character class.
+type Request struct {
+	// Type is TypePassword (the default) or TypePassphrase.
+	Type string `json:"type,omitempty"`
+
+	// Length is the number of characters in a password. Defaults to DefaultLength.
+	Length int `json:"length,omitempty"`
+	// Classes lists the character classes a password draws from. Empty means all of them.
+	Classes []string `json:"classes,omitempty"`
+	// ExcludeAmbiguous leaves out characters that are easily confused, such as l, 1, O, and 0.
+	ExcludeAmbiguous bool `json:"excludeAmbiguous,omitempty"`
+	// Min maps character classes to the least number of characters the password must contain from each.
+	Min map[string]int `json:"min,omitempty"`
+
+	// Words is the number of words in a passphrase. Defaults to DefaultWords.
+	Words int `json:"words,omitempty"`
+	// Separator joins the words of a passphrase. Defaults to DefaultSeparator.
+	Separator string `json:"separator,omitempty"`
+	// Capitalize capitalizes each word of a passphrase.
+	Capitalize bool `json:"capitalize,omitempty"`
+	// Number appends a random digit to one randomly chosen word of a passphrase.
+	Number bool `json:"number,omitempty"`
+}
+
+// Result is a generated secret with an estimate of its strength.
+type Result struct {
+	Password string `json:"password"`
+	// EntropyBits is log2 of the number of equally likely secrets the request could have produced, assuming the
+	// attacker knows the options used.
+	EntropyBits float64 `json:"entropyBits"`
+}
+
+// Generate produces the secret described by the request. It returns an error describing the first invalid option.
+func (r Request) Generate() (Result, error) {
+	switch r.Type {
+	case "", TypePassword:
+		return r.password()
+	case TypePassphrase:
+		return r.passphrase()
+	default:
+		return Result{}, fmt.Errorf("type must be %q or %q", TypePassword, TypePassphrase)
+	}
+}
+
+// Password returns a password of the given length drawn from every character class, for callers that need no other options.
+func Password(length int) (string, error) {
+	result, err := Request{Length: length}.Generate()
+	return result.Password, err
+}
+
+// password generates a random password. The required minimum of each class is drawn from that class, the rest from
+// the combined alphabet, and the result is shuffled so that the required characters are not in predictable places.
+func (r Request) password() (Result, error) {
+	length := r.Length
+	if length == 0 {
+		length = DefaultLength
+	}
+	if length < MinLength || length > MaxLength {
+		return Result{}, fmt.Errorf("length must be between %d and %d", MinLength, MaxLength)
+	}
+
+	selected := r.Classes
+	if len(selected) == 0 {
+		for _, class := range classChars {
+			selected = append(selected, class.name)
+		}
+	}
+	for _, name := range selected {
+		if !knownClass(name) {
+			return Result{}, fmt.Errorf("unknown character class %q", name)
+		}
+	}
+	for name, n := range r.Min {
+		if !knownClass(name) {
+			return Result{}, fmt.Errorf("unknown character class %q in min", name)
+		}
+		if n < 0 {
+			return Result{}, fmt.Errorf("min.%s must not be negative", name)
+		}
+	}
+
+	var alphabet strings.Builder
+	var required []string
+	requiredCount := 0
+	for _, class := range classChars {
+		if !slices.Contains(selected, class.name) {
+			if r.Min[class.name] > 0 {
+				return Result{}, fmt.Errorf("min.%s requires the %s class", class.name, class.name)
+			}
+			continue
+		}
+		chars := class.chars
+		if r.ExcludeAmbiguous {
+			chars = strings.Map(func(c rune) rune {
+				if strings.ContainsRune(ambiguousChars, c) {
+					return -1
+				}
+				return c
+			}, chars)
+		}
+		alphabet.WriteString(chars)
+		for range r.Min[class.name] {
+			required = append(required, chars)
+		}
+		requiredCount += r.Min[class.name]
+	}
+	if requiredCount > length {
+		return Result{}, fmt.Errorf("the minimums add up to %d characters, more than the length %d", requiredCount, length)
+	}
+
+	all := alphabet.String()
+	password := make([]byte, 0, length)
+	for _, chars := range required {
+		c, err := pick(chars)
+		if err != nil {
+			return Result{}, err
+		}
+		password = append(password, c)
+	}
+	for len(password) < length {
+		c, err := pick(all)
+		if err != nil {
+			return Result{}, err
+		}
+		password = append(password, c)
+	}
+	if err := shuffle(password); err != nil {
+		return Result{}, err
+	}
+
+	return Result{
+		Password:    string(password),
+		EntropyBits: round(float64(length) * math.Log2(float64(len(all)))),
+	}, nil
+}
+
+// passphrase generates a diceware-style passphrase from the EFF large wordlist.
+func (r Request) passphrase() (Result, error) {
+	count := r.Words
+	if count == 0 {
+		count = DefaultWords
+	}
+	if count < MinWords || count > MaxWords {
+		return Result{}, fmt.Errorf("words must be between %d and %d", MinWords, MaxWords)
+	}
+	separator := r.Separator
+	if separator == "" {
+		separator = DefaultSeparator
+	}
+
+	list := words()
+	chosen := make([]string, count)
+	for i := range chosen {
+		n, err := randomIndex(len(list))
+		if err != nil {
+			return Result{}, err
+		}
+		chosen[i] = list[n]
+		if r.Capitalize {
+			runes := []rune(chosen[i])
+			runes[0] = unicode.ToUpper(runes[0])
+			chosen[i] = string(runes)
+		}
+	}
+	entropy := float64(count) * math.Log2(float64(len(list)))
+
+	if r.Number {
+		word, err := randomIndex(count)
+		if err != nil {
+			return Result{}, err
+		}
+		digit, err := randomIndex(10)
+		if err != nil {
+			return Result{}, err
+		}
+		chosen[word] += fmt.Sprint(digit)
+		entropy += math.Log2(float64(count * 10))
+	}
+
+	return Result{Password: strings.Join(chosen, separator), EntropyBits: round(entropy)}, nil
+}
+
+// knownClass reports whether name is one of the character classes.
+func knownClass(name string) bool {
+	for _, class := range classChars {
+		if class.name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// pick returns a uniformly random character of chars.
+func pick(chars string) (byte, error) {
+	n, err := randomIndex(len(chars))
+	if err != nil {
+		return 0, err
+	}
+	return chars[n], nil
+}
+
+// shuffle permutes b uniformly with the Fisher-Yates algorithm.
+func shuffle(b []byte) error {
+	for i := len(b) - 1; i > 0; i-- {
+		j, err := randomIndex(i + 1)
+		if err != nil {
+			return err
+		}
+		b[i], b[j] = b[j], b[i]
+	}
+	return nil
+}
+
+// randomIndex returns a uniformly random integer in [0, n).
+func randomIndex(n int) (int, error) {
+	if n <= 0 {
+		return 0, errors.New("nothing to choose from")
+	}
+	i, err := rand.Int(rand.Reader, big.NewInt(int64(n)))
+	if err != nil {
+		return 0, fmt.Errorf("failed to read random numbers: %w", err)
+	}
+	return int(i.Int64()), nil
+}
+
+// round rounds entropy to one decimal place for display.
+func round(bits float64) float64 {
+	return math.Round(bits*10) / 10
+}
+
+// effLargeWordlist is the EFF large wordlist for dice-generated passphrases, by the Electronic Frontier Foundation,
+// licensed under CC BY 3.0 US: https://www.eff.org/deeplinks/2016/07/new-wordlists-random-passphrases
+//
+//go:embed eff_large_wordlist.txt
+var effLargeWordlist string
+
+// words returns the words of the embedded wordlist, whose lines are a dice roll and a word separated by a tab.
+var words = sync.OnceValue(func() []string {
+	var list []string
+	scanner := bufio.NewScanner(strings.NewReader(effLargeWordlist))
+	for scanner.Scan() {
+		if _, word, ok := strings.Cut(scanner.Text(), "\t"); ok {
+			list = append(list, word)
+		}
+	}
+	return list
+})
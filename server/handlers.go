@@ -4,22 +4,84 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"log"
 	"net/http"
+	"strconv"
 	"strings"
+	"time"
 
+	"github.com/rihts-4/pasword-mango/config"
 	"github.com/rihts-4/pasword-mango/data"
+	"github.com/rihts-4/pasword-mango/generate"
+	"github.com/rihts-4/pasword-mango/strength"
 )
 
+// warnScore is the strength score below which stored passwords are returned with a warning.
+const warnScore = strength.ScoreSafelyUnguessable
+
+// credentialsAPI holds the configuration of the credentials endpoints.
+type credentialsAPI struct {
+	// minScore is the least strength score accepted for new passwords; 0 accepts any password.
+	minScore int
+	// checkBreaches is set when a Pwned Passwords file is configured.
+	checkBreaches bool
+}
+
+// newCredentialsAPI configures the credentials endpoints.
+func newCredentialsAPI(cfg config.Passwords) *credentialsAPI {
+	return &credentialsAPI{minScore: cfg.MinScore, checkBreaches: cfg.BreachFile != ""}
+}
+
+// checkPassword checks a new password before it is stored. Its strength is estimated, guessing that it may be
+// built from the site and username; if it is below the minimum score, checkPassword writes a 422 response and
+// returns false. Otherwise it sets the X-Password-Score header and, for a weak password, X-Password-Warning. If a
+// Pwned Passwords file is configured, the password is also looked up in it; a breached password is logged and
+// X-Password-Breaches is set to the number of times it appears in known breaches. This is the only breach check
+// of passwords stored through the handlers, as data.Store and data.Update leave it to their callers. The warnings
+// to show in the response body are returned.
+func (a *credentialsAPI) checkPassword(w http.ResponseWriter, password, site, username string) ([]string, bool) {
+	result := strength.Estimate(password, site, username)
+	if result.Score < a.minScore {
+		http.Error(w, fmt.Sprintf("Password is too weak (score %d of %d, minimum %d): %s",
+			result.Score, strength.MaxScore, a.minScore, result.Reason()), http.StatusUnprocessableEntity)
+		return nil, false
+	}
+	var warnings []string
+	w.Header().Set("X-Password-Score", strconv.Itoa(result.Score))
+	if result.Score < warnScore {
+		warning := fmt.Sprintf("Weak password (score %d of %d): %s", result.Score, strength.MaxScore, result.Reason())
+		w.Header().Set("X-Password-Warning", warning)
+		warnings = append(warnings, warning)
+	}
+
+	breaches, err := data.BreachCount(password)
+	if err != nil {
+		log.Printf("Failed to check the password for site %s against known breaches: %v", site, err)
+	} else if breaches > 0 {
+		log.Printf("Warning: the new password for site %s appears %d times in known data breaches", site, breaches)
+		w.Header().Set("X-Password-Breaches", strconv.Itoa(breaches))
+		warnings = append(warnings, fmt.Sprintf("This password has appeared %d times in known data breaches", breaches))
+	}
+	return warnings, true
+}
+
 // credentialsHandler handles HTTP CRUD operations for credentials under the /credentials/ path.
 //
 // It supports the following methods:
-// - POST: creates credentials from a JSON body containing `site`, `username`, and `password` (returns 201 on success).
-// - GET: without a site lists all credentials as JSON; with a site returns that site's credentials as JSON (returns 404 if not found).
-// - PUT: updates credentials for the site in the URL using a JSON body with `username` and `password` (site must be present in the path).
+// - POST: creates credentials from a JSON body containing `site`, `username`, `password`, and optional `tags`, `notes`, `totp`, and `fields` (returns 201 on success).
+// - GET: without a site lists all credentials as JSON; with a site returns that site's credentials, including any notes, TOTP secret, and fields, as JSON with the `site` they are stored under (returns 404 if not found).
+// - PUT: updates credentials for the site in the URL using a JSON body with `username`, `password`, and optional `tags`, `notes`, `totp`, and `fields`, which replace the stored ones when present (site must be present in the path; returns 404 if not found).
 // - DELETE: deletes credentials for the site in the URL (site must be present in the path).
 //
+// If a POST body has an empty `password` and a `generate` request (see generate.Request), the password is generated
+// server-side and the stored credentials are returned as JSON.
+//
+// New passwords on POST and PUT are checked with the strength estimator. Passwords scoring below the configured
+// minimum are rejected with 422; weak or breached ones that are accepted are stored with warnings in the
+// X-Password-Warning and X-Password-Breaches headers and the response body.
+//
 // The handler returns 400 for malformed requests, 500 for internal/data errors, and 405 for unsupported methods.
-func credentialsHandler(w http.ResponseWriter, r *http.Request) {
+func (a *credentialsAPI) credentialsHandler(w http.ResponseWriter, r *http.Request) {
 	// Extract the site from the URL path, e.g., "/credentials/google.com" -> "google.com"
 	path := strings.TrimPrefix(r.URL.Path, "/credentials")
 	path = strings.Trim(path, "/")
@@ -28,10 +90,16 @@ func credentialsHandler(w http.ResponseWriter, r *http.Request) {
 
 	switch r.Method {
 	case http.MethodPost: // Create new credentials
+		recordAudit(r, data.AuditCreate, "")
 		var payload struct {
-			Site     string `json:"site"`
-			Username string `json:"username"`
-			Password string `json:"password"`
+			Site     string             `json:"site"`
+			Username string             `json:"username"`
+			Password string             `json:"password"`
+			Tags     []string           `json:"tags"`
+			Notes    string             `json:"notes"`
+			TOTP     string             `json:"totp"`
+			Fields   []data.CustomField `json:"fields"`
+			Generate *generate.Request  `json:"generate"`
 		}
 
 		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
@@ -43,6 +111,19 @@ func credentialsHandler(w http.ResponseWriter, r *http.Request) {
 		payload.Site = strings.TrimSpace(payload.Site)
 		payload.Username = strings.TrimSpace(payload.Username)
 		payload.Password = strings.TrimSpace(payload.Password)
+		recordAudit(r, data.AuditCreate, payload.Site)
+
+		// Generate the password if asked to and none was supplied
+		generated := false
+		if payload.Password == "" && payload.Generate != nil {
+			result, err := payload.Generate.Generate()
+			if err != nil {
+				http.Error(w, fmt.Sprintf("Invalid generate request: %v", err), http.StatusBadRequest)
+				return
+			}
+			payload.Password = result.Password
+			generated = true
+		}
 
 		// Validate that all fields are non-empty
 		if payload.Site == "" {
@@ -59,24 +140,41 @@ func credentialsHandler(w http.ResponseWriter, r *http.Request) {
 		}
 
 		// Enforce reasonable length limits
-		const maxSiteLength = 255
-		const maxUsernameLength = 255
-		const maxPasswordLength = 1000
-
-		if len(payload.Site) > maxSiteLength {
-			http.Error(w, fmt.Sprintf("Site must not exceed %d characters", maxSiteLength), http.StatusBadRequest)
+		if len(payload.Site) > data.MaxSiteLength {
+			http.Error(w, fmt.Sprintf("Site must not exceed %d characters", data.MaxSiteLength), http.StatusBadRequest)
+			return
+		}
+		if len(payload.Username) > data.MaxUsernameLength {
+			http.Error(w, fmt.Sprintf("Username must not exceed %d characters", data.MaxUsernameLength), http.StatusBadRequest)
+			return
+		}
+		if len(payload.Password) > data.MaxPasswordLength {
+			http.Error(w, fmt.Sprintf("Password must not exceed %d characters", data.MaxPasswordLength), http.StatusBadRequest)
 			return
 		}
-		if len(payload.Username) > maxUsernameLength {
-			http.Error(w, fmt.Sprintf("Username must not exceed %d characters", maxUsernameLength), http.StatusBadRequest)
+		tags, err := data.NormalizeTags(payload.Tags)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Invalid tags: %v", err), http.StatusBadRequest)
 			return
 		}
-		if len(payload.Password) > maxPasswordLength {
-			http.Error(w, fmt.Sprintf("Password must not exceed %d characters", maxPasswordLength), http.StatusBadRequest)
+		creds := data.Credentials{
+			Username: payload.Username,
+			Password: payload.Password,
+			Tags:     tags,
+			Notes:    payload.Notes,
+			TOTP:     payload.TOTP,
+			Fields:   payload.Fields,
+		}
+		if err := data.CheckDetails(creds); err != nil {
+			http.Error(w, fmt.Sprintf("Invalid details: %v", err), http.StatusBadRequest)
+			return
+		}
+		warnings, ok := a.checkPassword(w, payload.Password, payload.Site, payload.Username)
+		if !ok {
 			return
 		}
 
-		if err := data.Store(ctx, payload.Site, payload.Username, payload.Password); err != nil {
+		if err := data.Store(ctx, payload.Site, creds); err != nil {
 			if errors.Is(err, data.ErrAlreadyExists) {
 				http.Error(w, "Site already exists. Use PUT to update.", http.StatusConflict)
 				return
@@ -84,11 +182,23 @@ func credentialsHandler(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, fmt.Sprintf("Failed to store credentials: %v", err), http.StatusInternalServerError)
 			return
 		}
+		if generated {
+			// The caller has not seen the generated password yet
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("Cache-Control", "no-store")
+			w.WriteHeader(http.StatusCreated)
+			json.NewEncoder(w).Encode(data.SiteCredentials{Site: payload.Site, Username: payload.Username, Password: payload.Password})
+			return
+		}
 		w.WriteHeader(http.StatusCreated)
 		fmt.Fprintln(w, "Credentials stored successfully.")
+		for _, warning := range warnings {
+			fmt.Fprintf(w, "Warning: %s\n", warning)
+		}
 
 	case http.MethodGet:
 		if site == "" { // Show all credentials
+			recordAudit(r, data.AuditList, "")
 			sites, err := data.Show(ctx)
 			if err != nil {
 				http.Error(w, "Failed to retrieve credentials", http.StatusInternalServerError)
@@ -98,16 +208,23 @@ func credentialsHandler(w http.ResponseWriter, r *http.Request) {
 			w.WriteHeader(http.StatusOK)
 			json.NewEncoder(w).Encode(sites)
 		} else { // Retrieve specific credentials
-			creds, found := data.Retrieve(ctx, site)
+			recordAudit(r, data.AuditReveal, site)
+			name, creds, found := data.Lookup(ctx, site)
 			if !found {
 				http.Error(w, "Credentials not found", http.StatusNotFound)
 				return
 			}
+			// The site is the name the credentials are stored under, which the lookup may have found with or
+			// without ".com"
 			w.Header().Set("Content-Type", "application/json")
-			json.NewEncoder(w).Encode(creds)
+			json.NewEncoder(w).Encode(struct {
+				Site string `json:"site"`
+				data.Credentials
+			}{name, creds})
 		}
 
 	case http.MethodPut: // Update credentials
+		recordAudit(r, data.AuditUpdate, site)
 		if site == "" {
 			http.Error(w, "Missing site in URL path for update", http.StatusBadRequest)
 			return
@@ -124,14 +241,35 @@ func credentialsHandler(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, "username and password required", http.StatusBadRequest)
 			return
 		}
-		if err := data.Update(ctx, site, creds.Username, creds.Password); err != nil {
+		var err error
+		if creds.Tags, err = data.NormalizeTags(creds.Tags); err != nil {
+			http.Error(w, fmt.Sprintf("Invalid tags: %v", err), http.StatusBadRequest)
+			return
+		}
+		if err := data.CheckDetails(creds); err != nil {
+			http.Error(w, fmt.Sprintf("Invalid details: %v", err), http.StatusBadRequest)
+			return
+		}
+		warnings, ok := a.checkPassword(w, creds.Password, site, creds.Username)
+		if !ok {
+			return
+		}
+		if err := data.Update(ctx, site, creds); err != nil {
+			if errors.Is(err, data.ErrNotFound) {
+				http.Error(w, "Credentials not found", http.StatusNotFound)
+				return
+			}
 			http.Error(w, "Failed to update credentials", http.StatusInternalServerError)
 			return
 		}
 		w.WriteHeader(http.StatusOK)
 		fmt.Fprintln(w, "Credentials updated successfully.")
+		for _, warning := range warnings {
+			fmt.Fprintf(w, "Warning: %s\n", warning)
+		}
 
 	case http.MethodDelete: // Delete credentials
+		recordAudit(r, data.AuditDelete, site)
 		if site == "" {
 			http.Error(w, "Missing site in URL path for delete", http.StatusBadRequest)
 			return
@@ -151,3 +289,151 @@ func credentialsHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 	}
 }
+
+// generateHandler serves POST /generate, which returns a random password or passphrase described by an optional
+// JSON body (see generate.Request) as `{"password": "...", "entropyBits": 131.1}`. Nothing is stored.
+//
+// The handler returns 400 for malformed or invalid requests and 405 for methods other than POST.
+func generateHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req generate.Request
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+	}
+	result, err := req.Generate()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(result)
+}
+
+// strengthHandler serves POST /strength, which estimates the strength of the password in a JSON body of the form
+// `{"password": "...", "userInputs": ["example.com", "alice"]}`, where the optional userInputs are words the password
+// should not be built from, such as the site and username. It responds with a strength.Result, the configured
+// minimum score, whether the password meets it, and, if a Pwned Passwords file is configured, the number of times
+// the password appears in known breaches. Nothing is stored.
+//
+// The handler returns 400 for malformed requests, 500 if the breach file cannot be read, and 405 for methods other
+// than POST.
+func (a *credentialsAPI) strengthHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var payload struct {
+		Password   string   `json:"password"`
+		UserInputs []string `json:"userInputs"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if payload.Password == "" {
+		http.Error(w, "Password is required and cannot be empty", http.StatusBadRequest)
+		return
+	}
+	result := strength.Estimate(payload.Password, payload.UserInputs...)
+	var breaches *int
+	if a.checkBreaches {
+		count, err := data.BreachCount(payload.Password)
+		if err != nil {
+			log.Printf("Failed to check a password against known breaches: %v", err)
+			http.Error(w, "Failed to check the password against known breaches", http.StatusInternalServerError)
+			return
+		}
+		breaches = &count
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(struct {
+		strength.Result
+		MinScore   int  `json:"minScore"`
+		Acceptable bool `json:"acceptable"`
+		Breaches   *int `json:"breaches,omitempty"`
+	}{result, a.minScore, result.Score >= a.minScore, breaches})
+}
+
+// auditHandler serves the admin audit log API under /admin/audit.
+//
+// It supports the following requests:
+// - GET /admin/audit: lists entries newest first, filtered by the optional `actor`, `action`, `target`, `since`, and `until` (RFC 3339) and `limit` query parameters.
+// - POST /admin/audit/verify: checks the hash chain of the whole log and reports whether it is intact and, if not, where it breaks.
+//
+// The handler returns 400 for malformed query parameters, 404 for unknown paths, 500 for data errors, and 405 for unsupported methods.
+func auditHandler(w http.ResponseWriter, r *http.Request) {
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, "/admin/audit"), "/")
+	ctx := r.Context()
+
+	switch path {
+	case "":
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		filter, err := parseAuditFilter(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		entries, err := data.QueryAudit(ctx, filter)
+		if err != nil {
+			http.Error(w, "Failed to query audit log", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(entries)
+
+	case "verify":
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		result, err := data.VerifyAudit(ctx)
+		if err != nil {
+			http.Error(w, "Failed to verify audit log", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(result)
+
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// parseAuditFilter builds an audit filter from the request's query parameters.
+func parseAuditFilter(r *http.Request) (data.AuditFilter, error) {
+	query := r.URL.Query()
+	filter := data.AuditFilter{
+		Actor:  query.Get("actor"),
+		Action: data.AuditAction(query.Get("action")),
+		Target: query.Get("target"),
+	}
+
+	var err error
+	if v := query.Get("since"); v != "" {
+		if filter.Since, err = time.Parse(time.RFC3339, v); err != nil {
+			return data.AuditFilter{}, fmt.Errorf("since must be an RFC 3339 timestamp")
+		}
+	}
+	if v := query.Get("until"); v != "" {
+		if filter.Until, err = time.Parse(time.RFC3339, v); err != nil {
+			return data.AuditFilter{}, fmt.Errorf("until must be an RFC 3339 timestamp")
+		}
+	}
+	if v := query.Get("limit"); v != "" {
+		if filter.Limit, err = strconv.Atoi(v); err != nil || filter.Limit <= 0 {
+			return data.AuditFilter{}, fmt.Errorf("limit must be a positive integer")
+		}
+	}
+	return filter, nil
+}
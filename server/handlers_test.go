@@ -0,0 +1,56 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/rihts-4/pasword-mango/config"
+	"github.com/rihts-4/pasword-mango/data"
+	"github.com/rihts-4/pasword-mango/pwned"
+)
+
+// TestBreachedPasswordWarning checks that a breached password is stored with its breach count in a header that
+// scripts of an allowed origin can read, and in the response body.
+func TestBreachedPasswordWarning(t *testing.T) {
+	firestore.Reset()
+	const password = "correct horse battery staple"
+	path := filepath.Join(t.TempDir(), "pwned-passwords.txt")
+	if err := os.WriteFile(path, []byte(pwned.Hash(password, pwned.FormatSHA1)+":7\r\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	f, err := pwned.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	data.SetBreachFile(f)
+	defer data.SetBreachFile(nil)
+
+	api := newCredentialsAPI(config.Passwords{BreachFile: path})
+	policy := newAccessPolicy(config.Access{CORS: config.CORS{AllowedOrigins: []string{"https://vault.example.com"}}})
+	handler := corsMiddleware(policy, http.HandlerFunc(api.credentialsHandler))
+
+	r := httptest.NewRequest(http.MethodPost, "/credentials",
+		strings.NewReader(`{"site": "example.com", "username": "alice", "password": "`+password+`"}`))
+	r.Header.Set("Content-Type", "application/json")
+	r.Header.Set("Origin", "https://vault.example.com")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+
+	if w.Code != http.StatusCreated {
+		t.Fatalf("status %d: %s", w.Code, w.Body)
+	}
+	if got := w.Header().Get("X-Password-Breaches"); got != "7" {
+		t.Errorf("X-Password-Breaches %q, want 7", got)
+	}
+	if exposed := w.Header().Get("Access-Control-Expose-Headers"); !strings.Contains(exposed, "X-Password-Breaches") {
+		t.Errorf("Access-Control-Expose-Headers %q does not expose X-Password-Breaches", exposed)
+	}
+	if !strings.Contains(w.Body.String(), "Warning: This password has appeared 7 times in known data breaches") {
+		t.Errorf("body %q has no breach warning", w.Body)
+	}
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/rihts-4/pasword-mango/config"
+	"github.com/rihts-4/pasword-mango/data"
+)
+
+// healthReporter serves the password health report.
+type healthReporter struct {
+	policy data.HealthPolicy
+}
+
+// newHealthReporter configures the health report. Passwords are reported as weak below the score at which the
+// credentials endpoints start warning about them, and as old after the configured maximum age.
+func newHealthReporter(cfg config.Passwords) *healthReporter {
+	return &healthReporter{policy: data.HealthPolicy{MinScore: warnScore, MaxAge: cfg.MaxAge.Std()}}
+}
+
+// healthHandler serves GET /reports/health, which decrypts every stored password and returns a data.HealthReport
+// of reused, weak, old, and breached passwords and of sites stored under both a ".com" and a non-".com" name. No
+// password is included in the report. The maxAge query parameter, a Go duration such as "2160h", overrides the
+// configured maximum age for one report.
+//
+// The handler returns 400 for an invalid maxAge, 500 for data errors, and 405 for methods other than GET.
+func (h *healthReporter) healthHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	policy := h.policy
+	if value := r.URL.Query().Get("maxAge"); value != "" {
+		maxAge, err := time.ParseDuration(value)
+		if err != nil || maxAge < 0 {
+			http.Error(w, "maxAge must be a non-negative duration such as 2160h", http.StatusBadRequest)
+			return
+		}
+		policy.MaxAge = maxAge
+	}
+	recordAudit(r, data.AuditScan, "health")
+	report, err := data.CheckHealth(r.Context(), policy)
+	if err != nil {
+		http.Error(w, "Failed to check credentials", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
+
+// healthCommand implements `health`, which prints the password health report without starting the server. It
+// returns 1 if the report has any findings, so that it can run from cron or CI.
+func healthCommand(ctx context.Context, cfg config.Config, args []string) int {
+	flags := flag.NewFlagSet("health", flag.ContinueOnError)
+	asJSON := flags.Bool("json", false, "print the report as JSON")
+	maxAge := flags.Duration("max-age", cfg.Passwords.MaxAge.Std(), "report passwords unchanged for longer than this; 0 disables the check")
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: pasword-mango [flags] health [-json] [-max-age duration]")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil || flags.NArg() != 0 {
+		return 2
+	}
+	if *maxAge < 0 {
+		log.Print("-max-age must not be negative")
+		return 2
+	}
+
+	if err := data.InitDB(ctx, cfg.Storage, cfg.Key); err != nil {
+		log.Printf("Failed to initialize database: %v", err)
+		return 1
+	}
+	defer data.CloseDB()
+	closeBreachFile, err := openBreachFile(cfg.Passwords)
+	if err != nil {
+		log.Print(err)
+		return 1
+	}
+	defer closeBreachFile()
+
+	policy := newHealthReporter(cfg.Passwords).policy
+	policy.MaxAge = *maxAge
+	report, err := data.CheckHealth(ctx, policy)
+	if err != nil {
+		log.Printf("Failed to check credentials: %v", err)
+		return 1
+	}
+	if *asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		encoder.Encode(report)
+	} else {
+		printHealthReport(report)
+	}
+	if report.Findings() > 0 {
+		return 1
+	}
+	return 0
+}
+
+// printHealthReport prints a health report as a table per kind of finding, followed by a summary.
+func printHealthReport(report data.HealthReport) {
+	section := func(title string, header string, rows [][]any) {
+		if len(rows) == 0 {
+			return
+		}
+		fmt.Printf("%s:\n", title)
+		tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "  "+header)
+		for _, row := range rows {
+			format := "  " + strings.TrimSuffix(strings.Repeat("%v\t", len(row)), "\t") + "\n"
+			fmt.Fprintf(tw, format, row...)
+		}
+		tw.Flush()
+		fmt.Println()
+	}
+
+	var rows [][]any
+	for _, p := range report.Reused {
+		rows = append(rows, []any{p.ID, len(p.Sites), strings.Join(p.Sites, ", ")})
+	}
+	section("Reused passwords", "ID\tSITES\tUSED BY", rows)
+
+	rows = nil
+	for _, c := range report.Weak {
+		rows = append(rows, []any{c.Site, c.Username, c.Score, c.Reason})
+	}
+	section("Weak passwords", "SITE\tUSERNAME\tSCORE\tREASON", rows)
+
+	rows = nil
+	for _, c := range report.Old {
+		rows = append(rows, []any{c.Site, c.Username, c.ChangedAt.Format(time.DateOnly), c.AgeDays})
+	}
+	section("Old passwords", "SITE\tUSERNAME\tCHANGED\tDAYS", rows)
+
+	rows = nil
+	for _, c := range report.Breached {
+		rows = append(rows, []any{c.Site, c.Username, c.Count})
+	}
+	section("Breached passwords", "SITE\tUSERNAME\tBREACHES", rows)
+
+	rows = nil
+	for _, d := range report.Duplicates {
+		rows = append(rows, []any{strings.Join(d.Sites, ", ")})
+	}
+	section("Duplicate sites", "SITES", rows)
+
+	fmt.Printf("Checked %d passwords: %d reused, %d weak, %d old", report.Checked, len(report.Reused), len(report.Weak), len(report.Old))
+	if report.Breached != nil {
+		fmt.Printf(", %d breached", len(report.Breached))
+	}
+	fmt.Printf("; %d duplicate sites; %d could not be checked.\n", len(report.Duplicates), len(report.Failed))
+	for _, site := range report.Failed {
+		fmt.Printf("Could not check %s\n", site)
+	}
+}
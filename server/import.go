@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/rihts-4/pasword-mango/agecrypt"
+	"github.com/rihts-4/pasword-mango/data"
+	"github.com/rihts-4/pasword-mango/importer"
+)
+
+// maxImportSize bounds the body of an import request. A browser export of a few thousand logins is well below it.
+const maxImportSize = 16 << 20
+
+// errUnsupportedFormat is returned by parseExport for a format it does not read.
+var errUnsupportedFormat = errors.New("unsupported format")
+
+// kdbxSignature starts every KeePass database.
+var kdbxSignature = []byte{0x03, 0xD9, 0xA2, 0x9A}
+
+// parseExport parses an export in the named format, decrypting it with password if it is a password-protected
+// Bitwarden export or a KeePass database. CSV exports may name the browser they come from, which is detected from
+// the header anyway. KeePass databases are binary, so they are sent base64-encoded. Without a format, a JSON export
+// is taken for Bitwarden's, base64 that decodes to a KeePass database for one, and anything else for CSV.
+//
+// Exports encrypted with age are rejected: they are decrypted by the client, so that the identity never leaves it.
+func parseExport(format, export, password string) (importer.Result, error) {
+	if agecrypt.IsEncrypted([]byte(export)) {
+		return importer.Result{}, errors.New("the export is encrypted with age; decrypt it first, as mango import -identity does")
+	}
+	format = strings.ToLower(format)
+	if format == "" && strings.HasPrefix(strings.TrimSpace(export), "{") {
+		format = importer.FormatBitwarden
+	}
+	database, decodeErr := base64.StdEncoding.DecodeString(strings.TrimSpace(export))
+	if format == "" && decodeErr == nil && bytes.HasPrefix(database, kdbxSignature) {
+		format = importer.FormatKDBX
+	}
+	switch format {
+	case "", importer.FormatGenericCSV, importer.FormatChrome, "edge", importer.FormatFirefox, importer.FormatSafari:
+		return importer.ParseCSV(strings.NewReader(export))
+	case importer.FormatBitwarden:
+		return importer.ParseBitwarden(strings.NewReader(export), password)
+	case importer.FormatKDBX, "keepass":
+		if decodeErr != nil {
+			return importer.Result{}, errors.New("a KeePass database must be base64-encoded")
+		}
+		return importer.ParseKDBX(bytes.NewReader(database), password)
+	default:
+		return importer.Result{}, errUnsupportedFormat
+	}
+}
+
+// importHandler serves POST /import, which stores the logins of another password manager's or a browser's export.
+// The JSON body holds the export itself in `data`, its `format` (`csv` for Chrome, Edge, Firefox, and Safari
+// exports, `bitwarden` for Bitwarden's JSON export, or `kdbx` for a base64-encoded KeePass database, detected if
+// omitted), the `password` of a password-protected Bitwarden export or KeePass database, the `conflict` mode for sites that already have credentials (data.ImportSkip by default,
+// data.ImportOverwrite, or data.ImportKeepBoth), `dryRun` to only preview the result, and `tags` to add to every
+// imported entry. The export is sent as JSON rather than as text/csv so that browsers must preflight the request.
+//
+// It responds with a data.ImportReport listing the outcome of every row, without passwords. The handler returns
+// 400 for malformed requests and exports, 413 for bodies over maxImportSize, 500 for data errors, and 405 for
+// methods other than POST. Passwords below the minimum score of POST /credentials are reported as failed rows.
+func (a *credentialsAPI) importHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	recordAudit(r, data.AuditImport, "")
+	var payload struct {
+		Format   string   `json:"format"`
+		Data     string   `json:"data"`
+		Password string   `json:"password"`
+		Conflict string   `json:"conflict"`
+		DryRun   bool     `json:"dryRun"`
+		Tags     []string `json:"tags"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxImportSize)).Decode(&payload); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			http.Error(w, fmt.Sprintf("Import must not exceed %d bytes", maxImportSize), http.StatusRequestEntityTooLarge)
+			return
+		}
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	tags, err := data.NormalizeTags(payload.Tags)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid tags: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	parsed, err := parseExport(payload.Format, payload.Data, payload.Password)
+	if errors.Is(err, errUnsupportedFormat) {
+		http.Error(w, fmt.Sprintf("Unsupported import format %q", payload.Format), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid export: %v", err), http.StatusBadRequest)
+		return
+	}
+	recordAudit(r, data.AuditImport, parsed.Format)
+
+	report, err := data.Import(r.Context(), parsed, data.ImportOptions{
+		Conflict: payload.Conflict, DryRun: payload.DryRun, Tags: tags, MinScore: a.minScore,
+	})
+	if errors.Is(err, data.ErrInvalidConflictMode) {
+		http.Error(w, "conflict must be skip, overwrite, or keep-both", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to import credentials: %v", err)
+		http.Error(w, "Failed to import credentials", http.StatusInternalServerError)
+		return
+	}
+	if !report.DryRun {
+		log.Printf("Imported a %s export: %d created, %d updated, %d skipped, %d failed",
+			report.Format, report.Created, report.Updated, report.Skipped, report.Failed)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
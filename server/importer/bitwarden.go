@@ -0,0 +1,486 @@
+package importer
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hkdf"
+	"crypto/hmac"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/argon2"
+)
+
+// FormatBitwarden is Bitwarden's JSON export, unencrypted or password protected.
+const FormatBitwarden = "bitwarden"
+
+// Bitwarden item types. Only logins can be stored in the vault.
+const (
+	bitwardenLogin      = 1
+	bitwardenSecureNote = 2
+	bitwardenCard       = 3
+	bitwardenIdentity   = 4
+	bitwardenSSHKey     = 5
+)
+
+// Bitwarden custom field types. Linked fields only point at the username or password, so they carry no value.
+const (
+	bitwardenFieldText    = 0
+	bitwardenFieldHidden  = 1
+	bitwardenFieldBoolean = 2
+	bitwardenFieldLinked  = 3
+)
+
+// Key derivation functions of password-protected Bitwarden exports.
+const (
+	bitwardenPBKDF2   = 0
+	bitwardenArgon2id = 1
+)
+
+// Limits on the key derivation parameters of password-protected exports, which Bitwarden enforces as well. They
+// keep a crafted export from making the server derive keys for minutes.
+const (
+	maxBitwardenPBKDF2Iterations    = 2_000_000
+	maxBitwardenArgon2Iterations    = 10
+	maxBitwardenArgon2MemoryMiB     = 1024
+	maxBitwardenArgon2Parallelism   = 16
+	bitwardenExportPBKDF2Iterations = 600_000
+)
+
+// bitwardenProtected is the envelope of a password-protected export. Data holds the unencrypted export, encrypted
+// with keys derived from the password; EncKeyValidation holds a random value encrypted with the same keys, so that a
+// wrong password is told apart from a corrupted export. For unencrypted exports only Encrypted is set.
+type bitwardenProtected struct {
+	Encrypted         bool   `json:"encrypted"`
+	PasswordProtected bool   `json:"passwordProtected"`
+	Salt              string `json:"salt"`
+	KdfType           int    `json:"kdfType"`
+	KdfIterations     int    `json:"kdfIterations"`
+	KdfMemory         *int   `json:"kdfMemory"`
+	KdfParallelism    *int   `json:"kdfParallelism"`
+	EncKeyValidation  string `json:"encKeyValidation_DO_NOT_EDIT"`
+	Data              string `json:"data"`
+}
+
+// bitwardenVault is an unencrypted export.
+type bitwardenVault struct {
+	Encrypted bool              `json:"encrypted"`
+	Folders   []bitwardenFolder `json:"folders"`
+	Items     []bitwardenItem   `json:"items"`
+}
+
+type bitwardenFolder struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+type bitwardenItem struct {
+	ID              string                    `json:"id"`
+	OrganizationID  *string                   `json:"organizationId"`
+	FolderID        *string                   `json:"folderId"`
+	Type            int                       `json:"type"`
+	Reprompt        int                       `json:"reprompt"`
+	Name            string                    `json:"name"`
+	Notes           *string                   `json:"notes"`
+	Favorite        bool                      `json:"favorite"`
+	Fields          []bitwardenField          `json:"fields,omitempty"`
+	Login           *bitwardenLoginData       `json:"login,omitempty"`
+	CollectionIDs   []string                  `json:"collectionIds"`
+	PasswordHistory []bitwardenPasswordChange `json:"passwordHistory"`
+}
+
+// bitwardenPasswordChange is an earlier password of an item, newest first. LastUsedDate is when it was replaced.
+type bitwardenPasswordChange struct {
+	LastUsedDate time.Time `json:"lastUsedDate"`
+	Password     string    `json:"password"`
+}
+
+type bitwardenField struct {
+	Name     string  `json:"name"`
+	Value    *string `json:"value"`
+	Type     int     `json:"type"`
+	LinkedID *int    `json:"linkedId"`
+}
+
+type bitwardenLoginData struct {
+	URIs     []bitwardenURI `json:"uris"`
+	Username *string        `json:"username"`
+	Password *string        `json:"password"`
+	TOTP     *string        `json:"totp"`
+}
+
+type bitwardenURI struct {
+	Match *int   `json:"match"`
+	URI   string `json:"uri"`
+}
+
+// ParseBitwarden reads a Bitwarden JSON export. Password-protected exports are decrypted with password; it returns
+// ErrPasswordRequired if none is given and ErrWrongPassword if it does not match. Exports encrypted with the
+// Bitwarden account's key cannot be read outside Bitwarden and are refused.
+//
+// Each login item becomes an entry with its username, password, notes, TOTP, custom fields, and password history.
+// Its site is derived from the first of its URLs that NormalizeSite accepts, or else is the item's name, and its
+// folder becomes a tag, with a nested folder's "/" written as ":" since tags cannot contain "/". Secure notes, cards,
+// identities, SSH keys, and linked custom fields have no place in the vault and are rejected or dropped.
+//
+// Bitwarden records when each earlier password was replaced rather than when it was set, so each version's change
+// time is the time the next older one was replaced, and the oldest has none. Bitwarden keeps no usernames for them.
+func ParseBitwarden(r io.Reader, password string) (Result, error) {
+	export, err := io.ReadAll(r)
+	if err != nil {
+		return Result{}, err
+	}
+	var envelope bitwardenProtected
+	if err := json.Unmarshal(export, &envelope); err != nil {
+		return Result{}, fmt.Errorf("invalid JSON: %w", err)
+	}
+	if envelope.Encrypted {
+		if !envelope.PasswordProtected {
+			return Result{}, errors.New("exports encrypted with the Bitwarden account key can only be read by Bitwarden; export unencrypted or password protected instead")
+		}
+		if export, err = envelope.decrypt(password); err != nil {
+			return Result{}, err
+		}
+	}
+
+	var vault bitwardenVault
+	if err := json.Unmarshal(export, &vault); err != nil {
+		return Result{}, fmt.Errorf("invalid JSON: %w", err)
+	}
+	if vault.Encrypted {
+		return Result{}, errors.New("the export's items are encrypted")
+	}
+	if vault.Items == nil {
+		return Result{}, ErrUnknownFormat
+	}
+
+	folders := make(map[string]string, len(vault.Folders))
+	for _, folder := range vault.Folders {
+		folders[folder.ID] = folder.Name
+	}
+	result := Result{Format: FormatBitwarden}
+	for i, item := range vault.Items {
+		entry, reason := item.entry(folders)
+		entry.Row = i + 1
+		if reason != "" {
+			result.Rejected = append(result.Rejected, Rejected{Row: entry.Row, Reason: reason})
+			continue
+		}
+		result.Entries = append(result.Entries, entry)
+	}
+	return result, nil
+}
+
+// entry turns a login item into an entry, or returns why it cannot be imported.
+func (item bitwardenItem) entry(folders map[string]string) (Entry, string) {
+	switch {
+	case item.Type == bitwardenSecureNote:
+		return Entry{}, "secure notes are not supported"
+	case item.Type == bitwardenCard:
+		return Entry{}, "cards are not supported"
+	case item.Type == bitwardenIdentity:
+		return Entry{}, "identities are not supported"
+	case item.Type == bitwardenSSHKey:
+		return Entry{}, "SSH keys are not supported"
+	case item.Type != bitwardenLogin || item.Login == nil:
+		return Entry{}, fmt.Sprintf("item type %d is not supported", item.Type)
+	}
+
+	entry := Entry{
+		Username: deref(item.Login.Username),
+		Password: deref(item.Login.Password),
+		Notes:    deref(item.Notes),
+		TOTP:     deref(item.Login.TOTP),
+	}
+	for _, uri := range item.Login.URIs {
+		if site, err := NormalizeSite(uri.URI); err == nil {
+			entry.Site = site
+			break
+		}
+	}
+	if entry.Site == "" {
+		entry.Site = strings.ReplaceAll(strings.TrimSpace(item.Name), "/", "-")
+	}
+	if entry.Site == "" {
+		return Entry{}, "item has neither a website URL nor a name"
+	}
+	if item.FolderID != nil {
+		if name := strings.TrimSpace(folders[*item.FolderID]); name != "" {
+			entry.Tags = []string{strings.ReplaceAll(name, "/", ":")}
+		}
+	}
+	for _, field := range item.Fields {
+		f := Field{Name: field.Name, Value: deref(field.Value)}
+		switch field.Type {
+		case bitwardenFieldText:
+			f.Type = FieldText
+		case bitwardenFieldHidden:
+			f.Type = FieldHidden
+		case bitwardenFieldBoolean:
+			f.Type = FieldBoolean
+		default:
+			continue
+		}
+		entry.Fields = append(entry.Fields, f)
+	}
+	for i, change := range item.PasswordHistory {
+		if change.Password == "" {
+			continue
+		}
+		version := Version{Password: change.Password}
+		if i+1 < len(item.PasswordHistory) {
+			version.ChangedAt = item.PasswordHistory[i+1].LastUsedDate
+		}
+		entry.History = append(entry.History, version)
+	}
+	return entry, ""
+}
+
+// WriteBitwarden writes entries as an unencrypted Bitwarden JSON export, or, with a password, as a password-protected
+// one whose keys are derived from it with PBKDF2. Bitwarden and ParseBitwarden both import it.
+//
+// Each entry becomes a login item named after its site, with "https://" and the site as its URL if the site is a
+// host name. Its first tag becomes its folder, with ":" written as "/" as ParseBitwarden reads it; Bitwarden items
+// are in at most one folder, so further tags are not exported. Its earlier passwords become its password history
+// without their usernames, each replaced when the next was set; the newest, and any whose successor has no change
+// time, are dated with the time of the export.
+func WriteBitwarden(w io.Writer, entries []Entry, password string) error {
+	now := time.Now().UTC()
+	vault := bitwardenVault{Folders: []bitwardenFolder{}, Items: []bitwardenItem{}}
+	folderIDs := make(map[string]string)
+	for _, entry := range entries {
+		item := bitwardenItem{
+			ID:   randomUUID(),
+			Type: bitwardenLogin,
+			Name: entry.Site,
+			Login: &bitwardenLoginData{
+				URIs:     []bitwardenURI{},
+				Username: &entry.Username,
+				Password: &entry.Password,
+			},
+		}
+		if site, err := NormalizeSite(entry.Site); err == nil && site == entry.Site {
+			item.Login.URIs = append(item.Login.URIs, bitwardenURI{URI: "https://" + entry.Site})
+		}
+		if entry.Notes != "" {
+			item.Notes = &entry.Notes
+		}
+		if entry.TOTP != "" {
+			item.Login.TOTP = &entry.TOTP
+		}
+		if len(entry.Tags) > 0 {
+			name := strings.ReplaceAll(entry.Tags[0], ":", "/")
+			id, ok := folderIDs[name]
+			if !ok {
+				id = randomUUID()
+				folderIDs[name] = id
+				vault.Folders = append(vault.Folders, bitwardenFolder{ID: id, Name: name})
+			}
+			item.FolderID = &id
+		}
+		for _, field := range entry.Fields {
+			exported := bitwardenField{Name: field.Name, Value: &field.Value, Type: bitwardenFieldText}
+			switch field.Type {
+			case FieldHidden:
+				exported.Type = bitwardenFieldHidden
+			case FieldBoolean:
+				exported.Type = bitwardenFieldBoolean
+			}
+			item.Fields = append(item.Fields, exported)
+		}
+		for i, version := range entry.History {
+			replaced := now
+			if i > 0 && !entry.History[i-1].ChangedAt.IsZero() {
+				replaced = entry.History[i-1].ChangedAt
+			}
+			item.PasswordHistory = append(item.PasswordHistory, bitwardenPasswordChange{LastUsedDate: replaced, Password: version.Password})
+		}
+		vault.Items = append(vault.Items, item)
+	}
+
+	var out any = vault
+	if password != "" {
+		plaintext, err := json.Marshal(vault)
+		if err != nil {
+			return err
+		}
+		if out, err = protectBitwarden(plaintext, password); err != nil {
+			return err
+		}
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(out)
+}
+
+// protectBitwarden encrypts an unencrypted export into a password-protected one.
+func protectBitwarden(plaintext []byte, password string) (bitwardenProtected, error) {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return bitwardenProtected{}, err
+	}
+	envelope := bitwardenProtected{
+		Encrypted:         true,
+		PasswordProtected: true,
+		Salt:              base64.StdEncoding.EncodeToString(salt),
+		KdfType:           bitwardenPBKDF2,
+		KdfIterations:     bitwardenExportPBKDF2Iterations,
+	}
+	encKey, macKey, err := envelope.keys(password)
+	if err != nil {
+		return bitwardenProtected{}, err
+	}
+	if envelope.EncKeyValidation, err = bitwardenEncrypt([]byte(randomUUID()), encKey, macKey); err != nil {
+		return bitwardenProtected{}, err
+	}
+	if envelope.Data, err = bitwardenEncrypt(plaintext, encKey, macKey); err != nil {
+		return bitwardenProtected{}, err
+	}
+	return envelope, nil
+}
+
+// decrypt returns the unencrypted export inside a password-protected one.
+func (e bitwardenProtected) decrypt(password string) ([]byte, error) {
+	if password == "" {
+		return nil, ErrPasswordRequired
+	}
+	encKey, macKey, err := e.keys(password)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := bitwardenDecrypt(e.EncKeyValidation, encKey, macKey); err != nil {
+		return nil, ErrWrongPassword
+	}
+	plaintext, err := bitwardenDecrypt(e.Data, encKey, macKey)
+	if err != nil {
+		return nil, fmt.Errorf("the export's data cannot be decrypted: %w", err)
+	}
+	return plaintext, nil
+}
+
+// keys derives the encryption and MAC keys of a password-protected export the way Bitwarden does: the password is
+// stretched with the export's KDF, using the salt string's bytes for PBKDF2 and their SHA-256 hash for Argon2id,
+// and the result is expanded with HKDF into the two keys.
+func (e bitwardenProtected) keys(password string) (encKey, macKey []byte, err error) {
+	var key []byte
+	switch e.KdfType {
+	case bitwardenPBKDF2:
+		if e.KdfIterations < 1 || e.KdfIterations > maxBitwardenPBKDF2Iterations {
+			return nil, nil, fmt.Errorf("PBKDF2 iterations must be between 1 and %d", maxBitwardenPBKDF2Iterations)
+		}
+		if key, err = pbkdf2.Key(sha256.New, password, []byte(e.Salt), e.KdfIterations, 32); err != nil {
+			return nil, nil, err
+		}
+	case bitwardenArgon2id:
+		if e.KdfMemory == nil || e.KdfParallelism == nil {
+			return nil, nil, errors.New("Argon2id memory and parallelism are missing")
+		}
+		memory, parallelism := *e.KdfMemory, *e.KdfParallelism
+		switch {
+		case e.KdfIterations < 1 || e.KdfIterations > maxBitwardenArgon2Iterations:
+			return nil, nil, fmt.Errorf("Argon2id iterations must be between 1 and %d", maxBitwardenArgon2Iterations)
+		case memory < 1 || memory > maxBitwardenArgon2MemoryMiB:
+			return nil, nil, fmt.Errorf("Argon2id memory must be between 1 and %d MiB", maxBitwardenArgon2MemoryMiB)
+		case parallelism < 1 || parallelism > maxBitwardenArgon2Parallelism:
+			return nil, nil, fmt.Errorf("Argon2id parallelism must be between 1 and %d", maxBitwardenArgon2Parallelism)
+		}
+		salt := sha256.Sum256([]byte(e.Salt))
+		key = argon2.IDKey([]byte(password), salt[:], uint32(e.KdfIterations), uint32(memory)*1024, uint8(parallelism), 32)
+	default:
+		return nil, nil, fmt.Errorf("unknown key derivation function %d", e.KdfType)
+	}
+	if encKey, err = hkdf.Expand(sha256.New, key, "enc", 32); err != nil {
+		return nil, nil, err
+	}
+	if macKey, err = hkdf.Expand(sha256.New, key, "mac", 32); err != nil {
+		return nil, nil, err
+	}
+	return encKey, macKey, nil
+}
+
+// bitwardenEncrypt encrypts plaintext into a Bitwarden encrypted string of type 2, "2.IV|CIPHERTEXT|MAC" in base64:
+// AES-256-CBC with PKCS #7 padding, authenticated by HMAC-SHA256 over the IV and ciphertext.
+func bitwardenEncrypt(plaintext, encKey, macKey []byte) (string, error) {
+	block, err := aes.NewCipher(encKey)
+	if err != nil {
+		return "", err
+	}
+	iv := make([]byte, aes.BlockSize)
+	if _, err := rand.Read(iv); err != nil {
+		return "", err
+	}
+	padding := aes.BlockSize - len(plaintext)%aes.BlockSize
+	ciphertext := append(bytes.Clone(plaintext), bytes.Repeat([]byte{byte(padding)}, padding)...)
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(ciphertext, ciphertext)
+	mac := hmac.New(sha256.New, macKey)
+	mac.Write(iv)
+	mac.Write(ciphertext)
+	return "2." + base64.StdEncoding.EncodeToString(iv) + "|" + base64.StdEncoding.EncodeToString(ciphertext) + "|" +
+		base64.StdEncoding.EncodeToString(mac.Sum(nil)), nil
+}
+
+// bitwardenDecrypt decrypts a Bitwarden encrypted string of type 2, checking its MAC first.
+func bitwardenDecrypt(encrypted string, encKey, macKey []byte) ([]byte, error) {
+	rest, ok := strings.CutPrefix(encrypted, "2.")
+	if !ok {
+		return nil, errors.New("unsupported encryption type")
+	}
+	parts := strings.Split(rest, "|")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed encrypted string")
+	}
+	var decoded [3][]byte
+	for i, part := range parts {
+		var err error
+		if decoded[i], err = base64.StdEncoding.DecodeString(part); err != nil {
+			return nil, errors.New("malformed encrypted string")
+		}
+	}
+	iv, ciphertext, sum := decoded[0], decoded[1], decoded[2]
+	mac := hmac.New(sha256.New, macKey)
+	mac.Write(iv)
+	mac.Write(ciphertext)
+	if !hmac.Equal(mac.Sum(nil), sum) {
+		return nil, errors.New("MAC mismatch")
+	}
+	if len(iv) != aes.BlockSize || len(ciphertext) == 0 || len(ciphertext)%aes.BlockSize != 0 {
+		return nil, errors.New("malformed ciphertext")
+	}
+	block, err := aes.NewCipher(encKey)
+	if err != nil {
+		return nil, err
+	}
+	plaintext := make([]byte, len(ciphertext))
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(plaintext, ciphertext)
+	padding := int(plaintext[len(plaintext)-1])
+	if padding < 1 || padding > aes.BlockSize || !bytes.Equal(plaintext[len(plaintext)-padding:], bytes.Repeat([]byte{byte(padding)}, padding)) {
+		return nil, errors.New("invalid padding")
+	}
+	return plaintext[:len(plaintext)-padding], nil
+}
+
+// randomUUID returns a random version 4 UUID, as Bitwarden uses for item and folder IDs.
+func randomUUID() string {
+	var b [16]byte
+	rand.Read(b[:])
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
+
+// deref returns the string s points at, or "" for nil, as Bitwarden writes null for missing values.
+func deref(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
@@ -0,0 +1,69 @@
+package importer
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestBitwardenRoundTrip(t *testing.T) {
+	changedAt := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	entries := []Entry{
+		{
+			Site:     "example.com",
+			Username: "alice",
+			Password: "current-password",
+			Tags:     []string{"work:email"},
+			Notes:    "first line\nsecond line",
+			TOTP:     "otpauth://totp/example.com:alice?secret=JBSWY3DPEHPK3PXP",
+			Fields: []Field{
+				{Name: "PIN", Value: "1234", Type: FieldHidden},
+				{Name: "Account", Value: "42", Type: FieldText},
+				{Name: "Verified", Value: "true", Type: FieldBoolean},
+			},
+			// Bitwarden cannot date the oldest password or keep the usernames
+			History: []Version{
+				{Password: "previous-password", ChangedAt: changedAt},
+				{Password: "first-password"},
+			},
+		},
+		{Site: "Home router", Username: "admin", Password: "router-password"},
+		{Site: "mail.example.org", Username: "bob", Password: "bob-password", Tags: []string{"work:email"}},
+	}
+
+	for _, password := range []string{"", "export password"} {
+		var out bytes.Buffer
+		if err := WriteBitwarden(&out, entries, password); err != nil {
+			t.Fatalf("WriteBitwarden with password %q: %v", password, err)
+		}
+		export := out.Bytes()
+		result, err := ParseBitwarden(bytes.NewReader(export), password)
+		if err != nil {
+			t.Fatalf("ParseBitwarden with password %q: %v", password, err)
+		}
+		if result.Format != FormatBitwarden || len(result.Rejected) != 0 {
+			t.Errorf("parsed format %q with rejected rows %v", result.Format, result.Rejected)
+		}
+		for i := range result.Entries {
+			result.Entries[i].Row = 0
+		}
+		if !reflect.DeepEqual(result.Entries, entries) {
+			t.Errorf("with password %q, parsed\n%+v\nwant\n%+v", password, result.Entries, entries)
+		}
+
+		if password == "" {
+			continue
+		}
+		if _, err := ParseBitwarden(bytes.NewReader(export), ""); !errors.Is(err, ErrPasswordRequired) {
+			t.Errorf("without the password, got %v; want ErrPasswordRequired", err)
+		}
+		if _, err := ParseBitwarden(bytes.NewReader(export), "wrong password"); !errors.Is(err, ErrWrongPassword) {
+			t.Errorf("with a wrong password, got %v; want ErrWrongPassword", err)
+		}
+		if bytes.Contains(export, []byte("current-password")) {
+			t.Error("the password-protected export contains a password in plaintext")
+		}
+	}
+}
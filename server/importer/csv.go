@@ -0,0 +1,119 @@
+package importer
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// CSV export formats. Edge and other Chromium-based browsers export the same columns as Chrome.
+const (
+	FormatChrome  = "chrome"
+	FormatFirefox = "firefox"
+	FormatSafari  = "safari"
+	// FormatGenericCSV is any other CSV file with url, username, and password columns.
+	FormatGenericCSV = "csv"
+)
+
+// csvColumns are the columns read from a CSV export, by their lower-cased header.
+type csvColumns struct {
+	url, username, password int
+}
+
+// ParseCSV reads a browser's password export:
+//
+//   - Chrome and Edge: name,url,username,password[,note]
+//   - Firefox: url,username,password,httpRealm,formActionOrigin,guid,timeCreated,timeLastUsed,timePasswordChanged
+//   - Safari: Title,URL,Username,Password,Notes,OTPAuth
+//
+// The format is detected from the header row; other CSV files with url, username, and password columns are read too.
+// Each login's URL is turned into a site with NormalizeSite. Rows without a usable URL, username, or password are
+// rejected rather than failing the whole export. It returns ErrUnknownFormat if the header lacks the needed columns,
+// and an error if the file is not valid CSV.
+func ParseCSV(r io.Reader) (Result, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	header, err := reader.Read()
+	if err == io.EOF {
+		return Result{}, errors.New("the export is empty")
+	}
+	if err != nil {
+		return Result{}, fmt.Errorf("invalid CSV: %w", err)
+	}
+
+	// Columns are matched by name, ignoring case and a UTF-8 byte order mark
+	positions := make(map[string]int, len(header))
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))
+		if _, seen := positions[name]; !seen {
+			positions[name] = i
+		}
+	}
+	columns := csvColumns{url: -1, username: -1, password: -1}
+	for name, column := range map[string]*int{"url": &columns.url, "username": &columns.username, "password": &columns.password} {
+		if i, ok := positions[name]; ok {
+			*column = i
+		}
+	}
+	if columns.url < 0 || columns.username < 0 || columns.password < 0 {
+		return Result{}, fmt.Errorf("%w: expected a CSV header with url, username, and password columns", ErrUnknownFormat)
+	}
+	result := Result{Format: detectCSVFormat(positions), Entries: []Entry{}, Rejected: []Rejected{}}
+
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return Result{}, fmt.Errorf("invalid CSV: %w", err)
+		}
+		row, _ := reader.FieldPos(0)
+		field := func(i int) string {
+			if i < len(record) {
+				return record[i]
+			}
+			return ""
+		}
+		if strings.TrimSpace(strings.Join(record, "")) == "" {
+			continue // blank line
+		}
+
+		site, err := NormalizeSite(field(columns.url))
+		if err != nil {
+			result.Rejected = append(result.Rejected, Rejected{Row: row, Reason: err.Error()})
+			continue
+		}
+		result.Entries = append(result.Entries, Entry{
+			Row:      row,
+			Site:     site,
+			Username: field(columns.username),
+			Password: field(columns.password),
+		})
+	}
+	return result, nil
+}
+
+// detectCSVFormat names the browser that wrote a CSV header, given the positions of its lower-cased columns.
+func detectCSVFormat(positions map[string]int) string {
+	has := func(names ...string) bool {
+		for _, name := range names {
+			if _, ok := positions[name]; !ok {
+				return false
+			}
+		}
+		return true
+	}
+	switch {
+	case has("httprealm", "formactionorigin", "guid"):
+		return FormatFirefox
+	case has("title", "otpauth"):
+		return FormatSafari
+	case has("name") && len(positions) <= 5:
+		return FormatChrome
+	default:
+		return FormatGenericCSV
+	}
+}
@@ -0,0 +1,111 @@
+// Package importer reads the credentials exported by other password managers and browsers, so that they can be
+// moved into the vault in one request instead of one POST /credentials call per login. It only parses: the entries
+// it returns are stored by data.Import, which resolves conflicts with the credentials already stored. It also writes
+// Bitwarden exports and KeePass databases, so that the vault can be moved back out just as easily.
+package importer
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Custom field types of an entry. data.Import maps them to the vault's field types, and the export handler maps
+// those back.
+const (
+	FieldText    = "text"
+	FieldHidden  = "hidden"
+	FieldBoolean = "boolean"
+)
+
+// Entry is a login read from an export.
+type Entry struct {
+	// Row is the line of a CSV export the entry starts on, or the position of the item in a JSON export, for
+	// reporting.
+	Row      int
+	Site     string
+	Username string
+	Password string
+	// Tags are the tags the entry should be stored with, such as a folder name. They are not normalized.
+	Tags []string
+	// Notes, TOTP, and Fields are optional details. TOTP is an otpauth:// URI or a base32 secret.
+	Notes  string
+	TOTP   string
+	Fields []Field
+	// History holds the earlier passwords of the entry, newest first.
+	History []Version
+}
+
+// Version is an earlier password of an entry.
+type Version struct {
+	Username string
+	Password string
+	// ChangedAt is when the password was set, or zero if unknown.
+	ChangedAt time.Time
+}
+
+// Field is a custom field of an entry, such as a PIN or the answer to a security question.
+type Field struct {
+	Name  string
+	Value string
+	// Type is FieldText, FieldHidden, or FieldBoolean.
+	Type string
+}
+
+// Rejected is a row of an export that could not be turned into an entry.
+type Rejected struct {
+	Row    int    `json:"row"`
+	Reason string `json:"reason"`
+}
+
+// Result is a parsed export.
+type Result struct {
+	// Format names the detected format, such as FormatChrome.
+	Format   string
+	Entries  []Entry
+	Rejected []Rejected
+}
+
+// ErrUnknownFormat is returned for an export whose format is not recognized.
+var ErrUnknownFormat = errors.New("unrecognized export format")
+
+// Errors for password-protected exports, such as Bitwarden's and KeePass databases.
+var (
+	ErrPasswordRequired = errors.New("the export is password protected, so its password is required")
+	ErrWrongPassword    = errors.New("wrong password for the export")
+)
+
+// NormalizeSite turns a login URL into a site name as the vault stores them: the lower-case host name without
+// "www.", a port, or a trailing dot, such as "example.com" for "https://www.Example.com:443/login". A URL without a
+// scheme is taken as a host name. Other schemes than http and https, such as the android:// URLs of app logins,
+// are rejected, because no site name can be derived from them.
+func NormalizeSite(rawURL string) (string, error) {
+	rawURL = strings.TrimSpace(rawURL)
+	if rawURL == "" {
+		return "", errors.New("URL is empty")
+	}
+	if !strings.Contains(rawURL, "://") {
+		rawURL = "https://" + rawURL
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", fmt.Errorf("invalid URL %q", rawURL)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return "", fmt.Errorf("%s URL is not a website", u.Scheme)
+	}
+	host := strings.TrimSuffix(strings.ToLower(u.Hostname()), ".")
+	if host == "" {
+		return "", fmt.Errorf("URL %q has no host", rawURL)
+	}
+	if net.ParseIP(host) == nil {
+		host = strings.TrimPrefix(host, "www.")
+	}
+	if strings.ContainsAny(host, "/ ") {
+		return "", fmt.Errorf("invalid host %q", host)
+	}
+	return host, nil
+}
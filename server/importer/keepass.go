@@ -0,0 +1,201 @@
+package importer
+
+import (
+	"errors"
+	"io"
+	"slices"
+	"strings"
+
+	"github.com/rihts-4/pasword-mango/kdbx"
+)
+
+// FormatKDBX is a KeePass database in the KDBX 4 format, as KeePass 2.35 and later and KeePassXC save them.
+const FormatKDBX = "kdbx"
+
+// Strings of KeePass entries that hold a TOTP secret: the otpauth:// URI of KeePassXC and the base32 secret of
+// KeePass 2.47 and later.
+const (
+	keepassOTP          = "otp"
+	keepassTOTPSecret32 = "TimeOtp-Secret-Base32"
+)
+
+// kdbxFieldTypePrefix starts the keys of the entry custom data that record a field's type where KeePass strings
+// cannot, followed by the field's name. Only boolean fields need it; KeePass shows them as text.
+const kdbxFieldTypePrefix = "PaswordMango.FieldType."
+
+// kdbxDatabaseName is the name of the databases WriteKDBX writes, and of their top-level group.
+const kdbxDatabaseName = "Pasword Mango"
+
+// ParseKDBX reads a KeePass database, opened with password. It returns ErrPasswordRequired if none is given and
+// ErrWrongPassword if it does not open the database.
+//
+// Each entry becomes an entry with its username, password, and notes. Its site is derived from its URL if
+// NormalizeSite accepts it, or else is its title. Its groups below the top-level group become a tag, with the group
+// names joined by ":" as for nested Bitwarden folders, and its KeePass tags are kept. A TOTP secret in an "otp" or
+// "TimeOtp-Secret-Base32" string becomes the TOTP, and the other custom strings become fields, hidden if KeePass
+// protects them and boolean if WriteKDBX recorded them as such. The earlier passwords in the entry's history are kept; versions that only changed something else
+// are dropped. Entries in the recycle bin are not imported.
+func ParseKDBX(r io.Reader, password string) (Result, error) {
+	if password == "" {
+		return Result{}, ErrPasswordRequired
+	}
+	db, err := kdbx.Read(r, password)
+	if errors.Is(err, kdbx.ErrWrongPassword) {
+		return Result{}, ErrWrongPassword
+	}
+	if err != nil {
+		return Result{}, err
+	}
+
+	result := Result{Format: FormatKDBX}
+	var walk func(group kdbx.Group, path []string)
+	walk = func(group kdbx.Group, path []string) {
+		for _, e := range group.Entries {
+			entry, reason := kdbxEntry(e, path)
+			entry.Row = len(result.Entries) + len(result.Rejected) + 1
+			if reason != "" {
+				result.Rejected = append(result.Rejected, Rejected{Row: entry.Row, Reason: reason})
+				continue
+			}
+			result.Entries = append(result.Entries, entry)
+		}
+		for _, sub := range group.Groups {
+			walk(sub, append(slices.Clone(path), strings.ReplaceAll(strings.TrimSpace(sub.Name), "/", "-")))
+		}
+	}
+	walk(db.Root, nil)
+	return result, nil
+}
+
+// kdbxEntry turns a KeePass entry in the groups of path into an entry, or returns why it cannot be imported.
+func kdbxEntry(e kdbx.Entry, path []string) (Entry, string) {
+	entry := Entry{
+		Username: e.Get(kdbx.KeyUserName),
+		Password: e.Get(kdbx.KeyPassword),
+		Notes:    e.Get(kdbx.KeyNotes),
+		Tags:     e.Tags,
+	}
+	if site, err := NormalizeSite(e.Get(kdbx.KeyURL)); err == nil {
+		entry.Site = site
+	} else {
+		entry.Site = strings.ReplaceAll(strings.TrimSpace(e.Get(kdbx.KeyTitle)), "/", "-")
+	}
+	if entry.Site == "" {
+		return Entry{}, "entry has neither a website URL nor a title"
+	}
+	path = slices.DeleteFunc(path, func(name string) bool { return name == "" })
+	if group := strings.Join(path, ":"); group != "" && !slices.ContainsFunc(entry.Tags, func(tag string) bool { return strings.EqualFold(tag, group) }) {
+		entry.Tags = append([]string{group}, entry.Tags...)
+	}
+
+	for _, s := range e.Strings {
+		switch s.Key {
+		case kdbx.KeyTitle, kdbx.KeyUserName, kdbx.KeyPassword, kdbx.KeyURL, kdbx.KeyNotes:
+		case keepassOTP, keepassTOTPSecret32:
+			if entry.TOTP == "" {
+				entry.TOTP = s.Value
+			}
+		default:
+			field := Field{Name: s.Key, Value: s.Value, Type: FieldText}
+			if s.Protected {
+				field.Type = FieldHidden
+			} else if e.CustomData[kdbxFieldTypePrefix+s.Key] == FieldBoolean {
+				field.Type = FieldBoolean
+			}
+			entry.Fields = append(entry.Fields, field)
+		}
+	}
+
+	// The history holds a version for every edit, oldest first, the last ones with the current password. Keep the
+	// first version of each earlier password, which is when it was set.
+	versions := append(slices.Clone(e.History), e)
+	current := len(versions) - 1
+	for current > 0 && versions[current-1].Get(kdbx.KeyPassword) == entry.Password {
+		current--
+	}
+	for i, v := range versions[:current] {
+		password := v.Get(kdbx.KeyPassword)
+		if password == "" || (i > 0 && password == versions[i-1].Get(kdbx.KeyPassword)) {
+			continue
+		}
+		entry.History = append(entry.History, Version{Username: v.Get(kdbx.KeyUserName), Password: password, ChangedAt: v.Modified})
+	}
+	slices.Reverse(entry.History)
+	return entry, ""
+}
+
+// WriteKDBX writes entries as a KeePass database encrypted with password, which KeePass 2.35 and later, KeePassXC,
+// and ParseKDBX open. It returns ErrPasswordRequired if the password is empty, since KeePass databases are always
+// encrypted.
+//
+// Each entry is titled after its site, with "https://" and the site as its URL if the site is a host name, and
+// keeps its tags as KeePass tags. Its first tag also places it in a group, nested at each ":" as ParseKDBX reads
+// it. A TOTP secret is written as KeePassXC's "otp" string if it is an otpauth:// URI, or else as KeePass's
+// "TimeOtp-Secret-Base32"; hidden fields are protected strings, and boolean fields are text strings whose type is
+// kept in the entry's custom data for ParseKDBX. The earlier passwords become the entry's history.
+func WriteKDBX(w io.Writer, entries []Entry, password string) error {
+	if password == "" {
+		return ErrPasswordRequired
+	}
+	db := &kdbx.Database{Name: kdbxDatabaseName, Root: kdbx.Group{Name: kdbxDatabaseName}}
+	for _, entry := range entries {
+		group := &db.Root
+		if len(entry.Tags) > 0 {
+			for _, name := range strings.Split(entry.Tags[0], ":") {
+				group = kdbxSubgroup(group, name)
+			}
+		}
+		group.Entries = append(group.Entries, kdbxEntryOf(entry))
+	}
+	return kdbx.Write(w, db, password, kdbx.Options{})
+}
+
+// kdbxSubgroup returns the subgroup of group with the name, adding it if there is none. The pointer is only valid
+// until the next subgroup is added to group.
+func kdbxSubgroup(group *kdbx.Group, name string) *kdbx.Group {
+	for i := range group.Groups {
+		if group.Groups[i].Name == name {
+			return &group.Groups[i]
+		}
+	}
+	group.Groups = append(group.Groups, kdbx.Group{Name: name})
+	return &group.Groups[len(group.Groups)-1]
+}
+
+// kdbxEntryOf turns an entry into a KeePass entry.
+func kdbxEntryOf(entry Entry) kdbx.Entry {
+	url := ""
+	if site, err := NormalizeSite(entry.Site); err == nil && site == entry.Site {
+		url = "https://" + entry.Site
+	}
+	standard := func(username, password string) []kdbx.String {
+		return []kdbx.String{
+			{Key: kdbx.KeyTitle, Value: entry.Site},
+			{Key: kdbx.KeyUserName, Value: username},
+			{Key: kdbx.KeyPassword, Value: password, Protected: true},
+			{Key: kdbx.KeyURL, Value: url},
+			{Key: kdbx.KeyNotes, Value: entry.Notes},
+		}
+	}
+
+	e := kdbx.Entry{Strings: standard(entry.Username, entry.Password), Tags: entry.Tags}
+	switch {
+	case strings.HasPrefix(strings.ToLower(entry.TOTP), "otpauth://"):
+		e.Strings = append(e.Strings, kdbx.String{Key: keepassOTP, Value: entry.TOTP, Protected: true})
+	case entry.TOTP != "":
+		e.Strings = append(e.Strings, kdbx.String{Key: keepassTOTPSecret32, Value: entry.TOTP, Protected: true})
+	}
+	for _, field := range entry.Fields {
+		e.Strings = append(e.Strings, kdbx.String{Key: field.Name, Value: field.Value, Protected: field.Type == FieldHidden})
+		if field.Type == FieldBoolean {
+			if e.CustomData == nil {
+				e.CustomData = make(map[string]string)
+			}
+			e.CustomData[kdbxFieldTypePrefix+field.Name] = FieldBoolean
+		}
+	}
+	for _, v := range slices.Backward(entry.History) {
+		e.History = append(e.History, kdbx.Entry{Strings: standard(v.Username, v.Password), Modified: v.ChangedAt})
+	}
+	return e
+}
@@ -0,0 +1,56 @@
+package importer
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestKDBXRoundTrip(t *testing.T) {
+	// Entries in the top-level group come before those in subgroups
+	entries := []Entry{
+		{Site: "Home router", Username: "admin", Password: "router-password", TOTP: "JBSWY3DPEHPK3PXP"},
+		{
+			Site:     "example.com",
+			Username: "alice",
+			Password: "current-password",
+			Tags:     []string{"work:email", "shared"},
+			Notes:    "first line\nsecond line",
+			TOTP:     "otpauth://totp/example.com:alice?secret=JBSWY3DPEHPK3PXP",
+			Fields: []Field{
+				{Name: "PIN", Value: "1234", Type: FieldHidden},
+				{Name: "Account", Value: "42", Type: FieldText},
+				{Name: "Verified", Value: "true", Type: FieldBoolean},
+			},
+			History: []Version{
+				{Username: "alice", Password: "previous-password", ChangedAt: time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)},
+				{Username: "alice.old", Password: "first-password", ChangedAt: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)},
+			},
+		},
+	}
+
+	var out bytes.Buffer
+	if err := WriteKDBX(&out, entries, "export password"); err != nil {
+		t.Fatal(err)
+	}
+	export := out.Bytes()
+	result, err := ParseKDBX(bytes.NewReader(export), "export password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range result.Entries {
+		result.Entries[i].Row = 0
+	}
+	if !reflect.DeepEqual(result.Entries, entries) || len(result.Rejected) != 0 {
+		t.Errorf("parsed\n%+v\nrejected %v; want\n%+v", result.Entries, result.Rejected, entries)
+	}
+
+	if _, err := ParseKDBX(bytes.NewReader(export), "wrong password"); !errors.Is(err, ErrWrongPassword) {
+		t.Errorf("with a wrong password, got %v; want ErrWrongPassword", err)
+	}
+	if err := WriteKDBX(&out, entries, ""); !errors.Is(err, ErrPasswordRequired) {
+		t.Errorf("WriteKDBX without a password returned %v, want ErrPasswordRequired", err)
+	}
+}
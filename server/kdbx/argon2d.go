@@ -0,0 +1,237 @@
+// Copyright 2017 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package kdbx
+
+import (
+	"encoding/binary"
+	"sync"
+
+	"golang.org/x/crypto/blake2b"
+)
+
+// This file is an Argon2d implementation adapted from golang.org/x/crypto/argon2, which only exports Argon2i and
+// Argon2id. KeePass derives the keys of KDBX 4 databases with Argon2d by default.
+
+const (
+	argon2Version     = 0x13
+	argon2dMode       = 0
+	argon2BlockLength = 128
+	argon2SyncPoints  = 4
+)
+
+type argon2Block [argon2BlockLength]uint64
+
+// argon2dKey derives a key with Argon2d. memory is in KiB.
+func argon2dKey(password, salt []byte, time, memory uint32, threads uint8, keyLen uint32) []byte {
+	return argon2dDeriveKey(password, salt, nil, nil, time, memory, threads, keyLen)
+}
+
+// argon2dDeriveKey is argon2dKey with Argon2's optional secret and associated data, which KeePass leaves empty.
+func argon2dDeriveKey(password, salt, secret, data []byte, time, memory uint32, threads uint8, keyLen uint32) []byte {
+	h0 := argon2InitHash(password, salt, secret, data, time, memory, uint32(threads), keyLen)
+	memory = memory / (argon2SyncPoints * uint32(threads)) * (argon2SyncPoints * uint32(threads))
+	if memory < 2*argon2SyncPoints*uint32(threads) {
+		memory = 2 * argon2SyncPoints * uint32(threads)
+	}
+	B := argon2InitBlocks(&h0, memory, uint32(threads))
+	argon2ProcessBlocks(B, time, memory, uint32(threads))
+	return argon2ExtractKey(B, memory, uint32(threads), keyLen)
+}
+
+func argon2InitHash(password, salt, secret, data []byte, time, memory, threads, keyLen uint32) [blake2b.Size + 8]byte {
+	var (
+		h0     [blake2b.Size + 8]byte
+		params [24]byte
+		tmp    [4]byte
+	)
+	b2, _ := blake2b.New512(nil)
+	binary.LittleEndian.PutUint32(params[0:4], threads)
+	binary.LittleEndian.PutUint32(params[4:8], keyLen)
+	binary.LittleEndian.PutUint32(params[8:12], memory)
+	binary.LittleEndian.PutUint32(params[12:16], time)
+	binary.LittleEndian.PutUint32(params[16:20], argon2Version)
+	binary.LittleEndian.PutUint32(params[20:24], argon2dMode)
+	b2.Write(params[:])
+	for _, input := range [][]byte{password, salt, secret, data} {
+		binary.LittleEndian.PutUint32(tmp[:], uint32(len(input)))
+		b2.Write(tmp[:])
+		b2.Write(input)
+	}
+	b2.Sum(h0[:0])
+	return h0
+}
+
+func argon2InitBlocks(h0 *[blake2b.Size + 8]byte, memory, threads uint32) []argon2Block {
+	var block0 [1024]byte
+	B := make([]argon2Block, memory)
+	for lane := uint32(0); lane < threads; lane++ {
+		j := lane * (memory / threads)
+		binary.LittleEndian.PutUint32(h0[blake2b.Size+4:], lane)
+		for i := uint32(0); i < 2; i++ {
+			binary.LittleEndian.PutUint32(h0[blake2b.Size:], i)
+			argon2Hash(block0[:], h0[:])
+			for k := range B[j+i] {
+				B[j+i][k] = binary.LittleEndian.Uint64(block0[k*8:])
+			}
+		}
+	}
+	return B
+}
+
+func argon2ProcessBlocks(B []argon2Block, time, memory, threads uint32) {
+	lanes := memory / threads
+	segments := lanes / argon2SyncPoints
+
+	processSegment := func(n, slice, lane uint32, wg *sync.WaitGroup) {
+		defer wg.Done()
+		index := uint32(0)
+		if n == 0 && slice == 0 {
+			index = 2 // the first two blocks are already generated
+		}
+		offset := lane*lanes + slice*segments + index
+		for index < segments {
+			prev := offset - 1
+			if index == 0 && slice == 0 {
+				prev += lanes // last block in lane
+			}
+			newOffset := argon2IndexAlpha(B[prev][0], lanes, segments, threads, n, slice, lane, index)
+			argon2Compress(&B[offset], &B[prev], &B[newOffset])
+			index, offset = index+1, offset+1
+		}
+	}
+
+	for n := uint32(0); n < time; n++ {
+		for slice := uint32(0); slice < argon2SyncPoints; slice++ {
+			var wg sync.WaitGroup
+			for lane := uint32(0); lane < threads; lane++ {
+				wg.Add(1)
+				go processSegment(n, slice, lane, &wg)
+			}
+			wg.Wait()
+		}
+	}
+}
+
+func argon2ExtractKey(B []argon2Block, memory, threads, keyLen uint32) []byte {
+	lanes := memory / threads
+	for lane := uint32(0); lane < threads-1; lane++ {
+		for i, v := range B[(lane*lanes)+lanes-1] {
+			B[memory-1][i] ^= v
+		}
+	}
+	var block [1024]byte
+	for i, v := range B[memory-1] {
+		binary.LittleEndian.PutUint64(block[i*8:], v)
+	}
+	key := make([]byte, keyLen)
+	argon2Hash(key, block[:])
+	return key
+}
+
+func argon2IndexAlpha(rand uint64, lanes, segments, threads, n, slice, lane, index uint32) uint32 {
+	refLane := uint32(rand>>32) % threads
+	if n == 0 && slice == 0 {
+		refLane = lane
+	}
+	m, s := 3*segments, ((slice+1)%argon2SyncPoints)*segments
+	if lane == refLane {
+		m += index
+	}
+	if n == 0 {
+		m, s = slice*segments, 0
+		if slice == 0 || lane == refLane {
+			m += index
+		}
+	}
+	if index == 0 || lane == refLane {
+		m--
+	}
+	p := rand & 0xFFFFFFFF
+	p = (p * p) >> 32
+	p = (p * uint64(m)) >> 32
+	return refLane*lanes + uint32((uint64(s)+uint64(m)-(p+1))%uint64(lanes))
+}
+
+// argon2Compress XORs the compression G of in1 and in2 into out, as every pass after the first requires and the
+// first tolerates, since out is still zero then.
+func argon2Compress(out, in1, in2 *argon2Block) {
+	var t argon2Block
+	for i := range t {
+		t[i] = in1[i] ^ in2[i]
+	}
+	for i := 0; i < argon2BlockLength; i += 16 {
+		argon2Round(&t[i+0], &t[i+1], &t[i+2], &t[i+3], &t[i+4], &t[i+5], &t[i+6], &t[i+7],
+			&t[i+8], &t[i+9], &t[i+10], &t[i+11], &t[i+12], &t[i+13], &t[i+14], &t[i+15])
+	}
+	for i := 0; i < argon2BlockLength/8; i += 2 {
+		argon2Round(&t[i], &t[i+1], &t[16+i], &t[16+i+1], &t[32+i], &t[32+i+1], &t[48+i], &t[48+i+1],
+			&t[64+i], &t[64+i+1], &t[80+i], &t[80+i+1], &t[96+i], &t[96+i+1], &t[112+i], &t[112+i+1])
+	}
+	for i := range t {
+		out[i] ^= in1[i] ^ in2[i] ^ t[i]
+	}
+}
+
+// argon2Round is the BlaMka permutation of sixteen words.
+func argon2Round(v0, v1, v2, v3, v4, v5, v6, v7, v8, v9, v10, v11, v12, v13, v14, v15 *uint64) {
+	argon2G(v0, v4, v8, v12)
+	argon2G(v1, v5, v9, v13)
+	argon2G(v2, v6, v10, v14)
+	argon2G(v3, v7, v11, v15)
+	argon2G(v0, v5, v10, v15)
+	argon2G(v1, v6, v11, v12)
+	argon2G(v2, v7, v8, v13)
+	argon2G(v3, v4, v9, v14)
+}
+
+func argon2G(a, b, c, d *uint64) {
+	*a += *b + 2*uint64(uint32(*a))*uint64(uint32(*b))
+	*d ^= *a
+	*d = *d>>32 | *d<<32
+	*c += *d + 2*uint64(uint32(*c))*uint64(uint32(*d))
+	*b ^= *c
+	*b = *b>>24 | *b<<40
+	*a += *b + 2*uint64(uint32(*a))*uint64(uint32(*b))
+	*d ^= *a
+	*d = *d>>16 | *d<<48
+	*c += *d + 2*uint64(uint32(*c))*uint64(uint32(*d))
+	*b ^= *c
+	*b = *b>>63 | *b<<1
+}
+
+// argon2Hash computes the variable-length BLAKE2b hash H' of in into out.
+func argon2Hash(out []byte, in []byte) {
+	var buffer [blake2b.Size]byte
+	binary.LittleEndian.PutUint32(buffer[:4], uint32(len(out)))
+	if len(out) <= blake2b.Size {
+		b2, _ := blake2b.New(len(out), nil)
+		b2.Write(buffer[:4])
+		b2.Write(in)
+		b2.Sum(out[:0])
+		return
+	}
+
+	outLen := len(out)
+	b2, _ := blake2b.New512(nil)
+	b2.Write(buffer[:4])
+	b2.Write(in)
+	b2.Sum(buffer[:0])
+	b2.Reset()
+	copy(out, buffer[:32])
+	out = out[32:]
+	for len(out) > blake2b.Size {
+		b2.Write(buffer[:])
+		b2.Sum(buffer[:0])
+		copy(out, buffer[:32])
+		out = out[32:]
+		b2.Reset()
+	}
+	if outLen%blake2b.Size > 0 {
+		r := ((outLen + 31) / 32) - 2
+		b2, _ = blake2b.New(outLen-32*r, nil)
+	}
+	b2.Write(buffer[:])
+	b2.Sum(out[:0])
+}
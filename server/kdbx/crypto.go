@@ -0,0 +1,291 @@
+package kdbx
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"slices"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/chacha20"
+	"golang.org/x/crypto/salsa20/salsa"
+)
+
+// UUIDs of the ciphers and key derivation functions, as stored in the header.
+var (
+	cipherAES256ID   = uuid("31c1f2e6bf714350be5805216afc5aff")
+	cipherChaCha20ID = uuid("d6038a2b8b6f4cb5a524339a31dbb59a")
+	cipherTwofishID  = uuid("ad68f29f576f4bb9a36ad47af965346c")
+	kdfAESID         = uuid("c9d9f39a628a4460bf740d08c18a4fea")
+	kdfArgon2dID     = uuid("ef636ddf8c29444b91f7a9a403e30a0c")
+	kdfArgon2idID    = uuid("9e298b1956db4773b23dfc3ec6f0a1e6")
+)
+
+// Parameters of the key derivation functions Write uses: 64 MiB and three passes for Argon2, as KeePassXC
+// benchmarks to about a second on a laptop, and the AES-KDF rounds that take about as long.
+const (
+	argon2Memory      = 64 << 20
+	argon2Iterations  = 3
+	argon2Parallelism = 2
+	aesKDFRounds      = 20_000_000
+	kdfSeedSize       = 32
+)
+
+// Limits on the KDF parameters Read accepts, so that a crafted database, such as one uploaded to POST /import, cannot
+// make the server allocate much memory or spin for long. They allow a few times what KeePass and KeePassXC choose by
+// default: KeePassXC benchmarks Argon2 with 64 MiB and AES-KDF to take a second. maxArgon2Work bounds the memory
+// times the iterations, which the time taken grows with, to a few seconds.
+const (
+	maxArgon2Memory      = 256 << 20
+	maxArgon2Iterations  = 64
+	maxArgon2Work        = 2 << 30
+	maxArgon2Parallelism = 64
+	maxAESKDFRounds      = 50_000_000
+)
+
+// kdfSlots bounds the key derivations running at once, so that concurrent reads cannot multiply the memory and CPU
+// a single one may take.
+var kdfSlots = make(chan struct{}, 2)
+
+// Inner stream IDs, which select the stream that protects values within the XML.
+const (
+	innerStreamSalsa20  = 2
+	innerStreamChaCha20 = 3
+)
+
+// salsa20Nonce is the fixed nonce of the Salsa20 inner stream.
+var salsa20Nonce = []byte{0xE8, 0x30, 0x09, 0x4B, 0x97, 0x20, 0x5D, 0x2A}
+
+func uuid(s string) []byte {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+// compositeKey returns the composite key of a database protected by a password alone.
+func compositeKey(password string) []byte {
+	inner := sha256.Sum256([]byte(password))
+	outer := sha256.Sum256(inner[:])
+	return outer[:]
+}
+
+// databaseKeys returns the key of the cipher and the base key of the HMACs, derived from the master seed and the
+// transformed composite key.
+func databaseKeys(masterSeed, transformed []byte) (encKey, macKey []byte) {
+	enc := sha256.Sum256(slices.Concat(masterSeed, transformed))
+	mac := sha512.Sum512(slices.Concat(masterSeed, transformed, []byte{1}))
+	return enc[:], mac[:]
+}
+
+// newKDFParameters returns the parameters, with a new random seed, of the KDF Write uses.
+func newKDFParameters(kdf KDF) (variantDictionary, error) {
+	seed := make([]byte, kdfSeedSize)
+	if _, err := rand.Read(seed); err != nil {
+		return nil, err
+	}
+	switch kdf {
+	case "", KDFArgon2id, KDFArgon2d:
+		id := kdfArgon2idID
+		if kdf == KDFArgon2d {
+			id = kdfArgon2dID
+		}
+		return variantDictionary{
+			{"$UUID", id},
+			{"S", seed},
+			{"P", uint32(argon2Parallelism)},
+			{"M", uint64(argon2Memory)},
+			{"I", uint64(argon2Iterations)},
+			{"V", uint32(argon2Version)},
+		}, nil
+	case KDFAES:
+		return variantDictionary{
+			{"$UUID", kdfAESID},
+			{"R", uint64(aesKDFRounds)},
+			{"S", seed},
+		}, nil
+	default:
+		return nil, fmt.Errorf("unsupported KDF %q", kdf)
+	}
+}
+
+// deriveKey transforms the composite key with the KDF the parameters describe.
+func deriveKey(params map[string]any, composite []byte) ([]byte, error) {
+	id, _ := params["$UUID"].([]byte)
+	switch {
+	case bytes.Equal(id, kdfAESID):
+		rounds, ok := params["R"].(uint64)
+		seed, _ := params["S"].([]byte)
+		if !ok || len(seed) != kdfSeedSize {
+			return nil, fmt.Errorf("%w: invalid AES-KDF parameters", ErrCorrupt)
+		}
+		if rounds > maxAESKDFRounds {
+			return nil, fmt.Errorf("AES-KDF rounds must not exceed %d", maxAESKDFRounds)
+		}
+		kdfSlots <- struct{}{}
+		defer func() { <-kdfSlots }()
+		return aesKDF(composite, seed, rounds), nil
+
+	case bytes.Equal(id, kdfArgon2dID), bytes.Equal(id, kdfArgon2idID):
+		salt, _ := params["S"].([]byte)
+		parallelism, ok1 := params["P"].(uint32)
+		memory, ok2 := params["M"].(uint64)
+		iterations, ok3 := params["I"].(uint64)
+		version, ok4 := params["V"].(uint32)
+		if !ok1 || !ok2 || !ok3 || !ok4 || len(salt) == 0 || parallelism == 0 || iterations == 0 {
+			return nil, fmt.Errorf("%w: invalid Argon2 parameters", ErrCorrupt)
+		}
+		if version != argon2Version {
+			return nil, fmt.Errorf("unsupported Argon2 version %#x; change the database's encryption settings in KeePass to resave it", version)
+		}
+		if memory > maxArgon2Memory || iterations > maxArgon2Iterations || memory*iterations > maxArgon2Work ||
+			parallelism > maxArgon2Parallelism {
+			return nil, fmt.Errorf("Argon2 parameters exceed the limits of %d MiB, %d iterations, %d MiB times the iterations, and %d threads",
+				maxArgon2Memory>>20, maxArgon2Iterations, maxArgon2Work>>20, maxArgon2Parallelism)
+		}
+		kdfSlots <- struct{}{}
+		defer func() { <-kdfSlots }()
+		if bytes.Equal(id, kdfArgon2dID) {
+			return argon2dKey(composite, salt, uint32(iterations), uint32(memory/1024), uint8(parallelism), 32), nil
+		}
+		return argon2.IDKey(composite, salt, uint32(iterations), uint32(memory/1024), uint8(parallelism), 32), nil
+
+	default:
+		return nil, fmt.Errorf("unsupported KDF %x", id)
+	}
+}
+
+// aesKDF is the AES-KDF of KeePass: both halves of the key are encrypted with AES-256 in ECB mode, keyed by the
+// seed, for the given number of rounds, and the result is hashed.
+func aesKDF(composite, seed []byte, rounds uint64) []byte {
+	block, _ := aes.NewCipher(seed)
+	key := slices.Clone(composite)
+	for range rounds {
+		block.Encrypt(key[:aes.BlockSize], key[:aes.BlockSize])
+		block.Encrypt(key[aes.BlockSize:], key[aes.BlockSize:])
+	}
+	sum := sha256.Sum256(key)
+	return sum[:]
+}
+
+// cipherFor returns the UUID and IV size of a cipher Write supports.
+func cipherFor(c Cipher) (id []byte, ivSize int, err error) {
+	switch c {
+	case "", CipherAES256:
+		return cipherAES256ID, aes.BlockSize, nil
+	case CipherChaCha20:
+		return cipherChaCha20ID, chacha20.NonceSize, nil
+	default:
+		return nil, 0, fmt.Errorf("unsupported cipher %q", c)
+	}
+}
+
+// encrypt encrypts the payload with the cipher the UUID names.
+func encrypt(id, key, iv, plaintext []byte) ([]byte, error) {
+	switch {
+	case bytes.Equal(id, cipherAES256ID):
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, err
+		}
+		padding := aes.BlockSize - len(plaintext)%aes.BlockSize
+		ciphertext := append(slices.Clone(plaintext), bytes.Repeat([]byte{byte(padding)}, padding)...)
+		cipher.NewCBCEncrypter(block, iv).CryptBlocks(ciphertext, ciphertext)
+		return ciphertext, nil
+	case bytes.Equal(id, cipherChaCha20ID):
+		return chacha20XOR(key, iv, plaintext)
+	default:
+		return nil, fmt.Errorf("unsupported cipher %x", id)
+	}
+}
+
+// decrypt decrypts the payload with the cipher the UUID names.
+func decrypt(id, key, iv, ciphertext []byte) ([]byte, error) {
+	switch {
+	case bytes.Equal(id, cipherAES256ID):
+		if len(iv) != aes.BlockSize || len(ciphertext) == 0 || len(ciphertext)%aes.BlockSize != 0 {
+			return nil, ErrCorrupt
+		}
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, err
+		}
+		plaintext := make([]byte, len(ciphertext))
+		cipher.NewCBCDecrypter(block, iv).CryptBlocks(plaintext, ciphertext)
+		padding := int(plaintext[len(plaintext)-1])
+		if padding == 0 || padding > aes.BlockSize || !bytes.Equal(plaintext[len(plaintext)-padding:], bytes.Repeat([]byte{byte(padding)}, padding)) {
+			return nil, ErrCorrupt
+		}
+		return plaintext[:len(plaintext)-padding], nil
+	case bytes.Equal(id, cipherChaCha20ID):
+		if len(iv) != chacha20.NonceSize {
+			return nil, ErrCorrupt
+		}
+		return chacha20XOR(key, iv, ciphertext)
+	case bytes.Equal(id, cipherTwofishID):
+		return nil, fmt.Errorf("the Twofish cipher is not supported; change the database's encryption settings in KeePass to AES-256 or ChaCha20")
+	default:
+		return nil, fmt.Errorf("unsupported cipher %x", id)
+	}
+}
+
+func chacha20XOR(key, nonce, in []byte) ([]byte, error) {
+	c, err := chacha20.NewUnauthenticatedCipher(key, nonce)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]byte, len(in))
+	c.XORKeyStream(out, in)
+	return out, nil
+}
+
+// innerStream is the key stream that protected values are XORed with, in the order they appear in the XML.
+type innerStream interface {
+	XORKeyStream(dst, src []byte)
+}
+
+// newInnerStream returns the inner stream with the ID, keyed by key.
+func newInnerStream(id uint32, key []byte) (innerStream, error) {
+	if len(key) == 0 {
+		return nil, fmt.Errorf("%w: inner stream key is missing", ErrCorrupt)
+	}
+	switch id {
+	case innerStreamChaCha20:
+		h := sha512.Sum512(key)
+		return chacha20.NewUnauthenticatedCipher(h[:chacha20.KeySize], h[chacha20.KeySize:chacha20.KeySize+chacha20.NonceSize])
+	case innerStreamSalsa20:
+		s := &salsa20Stream{key: sha256.Sum256(key), used: len(salsa20Stream{}.block)}
+		copy(s.counter[:], salsa20Nonce)
+		return s, nil
+	default:
+		return nil, fmt.Errorf("unsupported inner stream %d", id)
+	}
+}
+
+// salsa20Stream is the Salsa20 inner stream, which x/crypto only offers as a function of the block counter.
+type salsa20Stream struct {
+	key     [32]byte
+	counter [16]byte // the nonce, then the little-endian block counter
+	block   [64]byte
+	used    int
+}
+
+func (s *salsa20Stream) XORKeyStream(dst, src []byte) {
+	for i := range src {
+		if s.used == len(s.block) {
+			var zero [64]byte
+			salsa.XORKeyStream(s.block[:], zero[:], &s.counter, &s.key)
+			binary.LittleEndian.PutUint64(s.counter[8:], binary.LittleEndian.Uint64(s.counter[8:])+1)
+			s.used = 0
+		}
+		dst[i] = src[i] ^ s.block[s.used]
+		s.used++
+	}
+}
@@ -0,0 +1,388 @@
+package kdbx
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"slices"
+)
+
+// The signatures and version at the start of every KDBX 4 database. Signature 2 tells KeePass 1 databases and
+// KeePass 2 pre-releases apart.
+const (
+	signature1      = 0x9AA2D903
+	signature2      = 0xB54BFB67
+	version4        = 0x00040000
+	fileHeaderSize  = 12
+	fieldHeaderSize = 5
+)
+
+// Outer header fields of KDBX 4. The fields of KDBX 3 that moved to the inner header or to the KDF parameters are
+// not listed, and are ignored like any other unknown field.
+const (
+	fieldEndOfHeader    = 0
+	fieldCipherID       = 2
+	fieldCompression    = 3
+	fieldMasterSeed     = 4
+	fieldEncryptionIV   = 7
+	fieldKDFParameters  = 11
+	compressionNone     = 0
+	compressionGzip     = 1
+	masterSeedSize      = 32
+	endOfHeaderContents = "\r\n\r\n"
+)
+
+// Inner header fields, which precede the XML within the encrypted payload.
+const (
+	innerFieldEnd        = 0
+	innerFieldStreamID   = 1
+	innerFieldStreamKey  = 2
+	innerFieldAttachment = 3
+)
+
+// Value types of a variant dictionary, the format of the KDF parameters.
+const (
+	variantDictionaryVersion = 0x0100
+	variantEnd               = 0x00
+	variantUint32            = 0x04
+	variantUint64            = 0x05
+	variantBool              = 0x08
+	variantInt32             = 0x0C
+	variantInt64             = 0x0D
+	variantString            = 0x18
+	variantBytes             = 0x42
+)
+
+// blockSize is the size of the HMAC-authenticated blocks Write splits the encrypted payload into, as KeePass does.
+const blockSize = 1 << 20
+
+// header is the outer, unencrypted header of a database.
+type header struct {
+	// length is the size of the header in bytes, from the signature to the end-of-header field.
+	length     int
+	cipher     []byte
+	compressed bool
+	masterSeed []byte
+	iv         []byte
+	kdf        map[string]any
+}
+
+// readHeader parses the outer header at the start of file.
+func readHeader(file []byte) (header, error) {
+	var h header
+	if len(file) < fileHeaderSize || binary.LittleEndian.Uint32(file[0:4]) != signature1 {
+		return h, ErrNotKDBX
+	}
+	switch binary.LittleEndian.Uint32(file[4:8]) {
+	case signature2:
+	case 0xB54BFB65, 0xB54BFB66: // KeePass 1 and KeePass 2 pre-releases
+		return h, ErrUnsupportedVersion
+	default:
+		return h, ErrNotKDBX
+	}
+	if binary.LittleEndian.Uint32(file[8:12])>>16 != version4>>16 {
+		return h, ErrUnsupportedVersion
+	}
+
+	rest := file[fileHeaderSize:]
+	for {
+		if len(rest) < fieldHeaderSize {
+			return h, ErrCorrupt
+		}
+		id, size := rest[0], binary.LittleEndian.Uint32(rest[1:fieldHeaderSize])
+		rest = rest[fieldHeaderSize:]
+		if uint64(size) > uint64(len(rest)) {
+			return h, ErrCorrupt
+		}
+		value := rest[:size]
+		rest = rest[size:]
+
+		switch id {
+		case fieldEndOfHeader:
+			h.length = len(file) - len(rest)
+			if h.cipher == nil || len(h.masterSeed) != masterSeedSize || h.kdf == nil {
+				return h, fmt.Errorf("%w: header is incomplete", ErrCorrupt)
+			}
+			return h, nil
+		case fieldCipherID:
+			h.cipher = value
+		case fieldCompression:
+			if len(value) != 4 {
+				return h, ErrCorrupt
+			}
+			switch binary.LittleEndian.Uint32(value) {
+			case compressionNone:
+			case compressionGzip:
+				h.compressed = true
+			default:
+				return h, fmt.Errorf("%w: unknown compression", ErrCorrupt)
+			}
+		case fieldMasterSeed:
+			h.masterSeed = value
+		case fieldEncryptionIV:
+			h.iv = value
+		case fieldKDFParameters:
+			kdf, err := readVariantDictionary(value)
+			if err != nil {
+				return h, err
+			}
+			h.kdf = kdf
+		}
+	}
+}
+
+// writeHeader returns the outer header of a gzip-compressed database.
+func writeHeader(cipherID, masterSeed, iv []byte, kdf variantDictionary) []byte {
+	var b bytes.Buffer
+	binary.Write(&b, binary.LittleEndian, [3]uint32{signature1, signature2, version4})
+	writeField := func(id byte, value []byte) {
+		b.WriteByte(id)
+		binary.Write(&b, binary.LittleEndian, uint32(len(value)))
+		b.Write(value)
+	}
+	writeField(fieldCipherID, cipherID)
+	writeField(fieldCompression, binary.LittleEndian.AppendUint32(nil, compressionGzip))
+	writeField(fieldMasterSeed, masterSeed)
+	writeField(fieldEncryptionIV, iv)
+	writeField(fieldKDFParameters, kdf.encode())
+	writeField(fieldEndOfHeader, []byte(endOfHeaderContents))
+	return b.Bytes()
+}
+
+// variant is an entry of a variantDictionary. Its value is a uint32, uint64, bool, int32, int64, string, or
+// []byte.
+type variant struct {
+	key   string
+	value any
+}
+
+// variantDictionary is a variant dictionary to write, in order.
+type variantDictionary []variant
+
+// values returns the dictionary as readVariantDictionary does.
+func (d variantDictionary) values() map[string]any {
+	values := make(map[string]any, len(d))
+	for _, v := range d {
+		values[v.key] = v.value
+	}
+	return values
+}
+
+// encode returns the serialized dictionary.
+func (d variantDictionary) encode() []byte {
+	b := binary.LittleEndian.AppendUint16(nil, variantDictionaryVersion)
+	for _, v := range d {
+		var typ byte
+		var value []byte
+		switch x := v.value.(type) {
+		case uint32:
+			typ, value = variantUint32, binary.LittleEndian.AppendUint32(nil, x)
+		case uint64:
+			typ, value = variantUint64, binary.LittleEndian.AppendUint64(nil, x)
+		case bool:
+			typ, value = variantBool, []byte{0}
+			if x {
+				value[0] = 1
+			}
+		case int32:
+			typ, value = variantInt32, binary.LittleEndian.AppendUint32(nil, uint32(x))
+		case int64:
+			typ, value = variantInt64, binary.LittleEndian.AppendUint64(nil, uint64(x))
+		case string:
+			typ, value = variantString, []byte(x)
+		case []byte:
+			typ, value = variantBytes, x
+		default:
+			panic(fmt.Sprintf("kdbx: unsupported variant type %T", v.value))
+		}
+		b = append(b, typ)
+		b = binary.LittleEndian.AppendUint32(b, uint32(len(v.key)))
+		b = append(b, v.key...)
+		b = binary.LittleEndian.AppendUint32(b, uint32(len(value)))
+		b = append(b, value...)
+	}
+	return append(b, variantEnd)
+}
+
+// readVariantDictionary parses a serialized variant dictionary.
+func readVariantDictionary(b []byte) (map[string]any, error) {
+	if len(b) < 2 || binary.LittleEndian.Uint16(b)>>8 != variantDictionaryVersion>>8 {
+		return nil, fmt.Errorf("%w: unsupported KDF parameters version", ErrCorrupt)
+	}
+	b = b[2:]
+	values := make(map[string]any)
+	for {
+		if len(b) == 0 {
+			return nil, ErrCorrupt
+		}
+		typ := b[0]
+		b = b[1:]
+		if typ == variantEnd {
+			return values, nil
+		}
+		var key, value []byte
+		var ok bool
+		if key, b, ok = cutSized(b); !ok {
+			return nil, ErrCorrupt
+		}
+		if value, b, ok = cutSized(b); !ok {
+			return nil, ErrCorrupt
+		}
+
+		size := map[byte]int{variantUint32: 4, variantUint64: 8, variantBool: 1, variantInt32: 4, variantInt64: 8}
+		if want, fixed := size[typ]; fixed && len(value) != want {
+			return nil, ErrCorrupt
+		}
+		switch typ {
+		case variantUint32:
+			values[string(key)] = binary.LittleEndian.Uint32(value)
+		case variantUint64:
+			values[string(key)] = binary.LittleEndian.Uint64(value)
+		case variantBool:
+			values[string(key)] = value[0] != 0
+		case variantInt32:
+			values[string(key)] = int32(binary.LittleEndian.Uint32(value))
+		case variantInt64:
+			values[string(key)] = int64(binary.LittleEndian.Uint64(value))
+		case variantString:
+			values[string(key)] = string(value)
+		case variantBytes:
+			values[string(key)] = value
+		default:
+			return nil, fmt.Errorf("%w: unknown KDF parameter type", ErrCorrupt)
+		}
+	}
+}
+
+// cutSized splits a value with a 32-bit little-endian length prefix off b.
+func cutSized(b []byte) (value, rest []byte, ok bool) {
+	if len(b) < 4 {
+		return nil, nil, false
+	}
+	size := binary.LittleEndian.Uint32(b)
+	if uint64(size) > uint64(len(b)-4) {
+		return nil, nil, false
+	}
+	return b[4 : 4+size], b[4+size:], true
+}
+
+// blockKey returns the HMAC key of the block with the index.
+func blockKey(macKey []byte, index uint64) []byte {
+	h := sha512.New()
+	h.Write(binary.LittleEndian.AppendUint64(nil, index))
+	h.Write(macKey)
+	return h.Sum(nil)
+}
+
+// blockMAC returns the HMAC of a block of the encrypted payload, which covers its index and size too, so that
+// blocks cannot be reordered or truncated.
+func blockMAC(macKey []byte, index uint64, block []byte) []byte {
+	mac := hmac.New(sha256.New, blockKey(macKey, index))
+	mac.Write(binary.LittleEndian.AppendUint64(nil, index))
+	mac.Write(binary.LittleEndian.AppendUint32(nil, uint32(len(block))))
+	mac.Write(block)
+	return mac.Sum(nil)
+}
+
+// headerMAC returns the HMAC of the outer header, which is what tells a wrong password from a corrupt database.
+func headerMAC(macKey, header []byte) []byte {
+	mac := hmac.New(sha256.New, blockKey(macKey, math.MaxUint64))
+	mac.Write(header)
+	return mac.Sum(nil)
+}
+
+// readBlocks verifies the blocks of the encrypted payload and returns their content.
+func readBlocks(b, macKey []byte) ([]byte, error) {
+	var payload []byte
+	for index := uint64(0); ; index++ {
+		if len(b) < sha256.Size+4 {
+			return nil, ErrCorrupt
+		}
+		mac, size := b[:sha256.Size], binary.LittleEndian.Uint32(b[sha256.Size:])
+		b = b[sha256.Size+4:]
+		if uint64(size) > uint64(len(b)) {
+			return nil, ErrCorrupt
+		}
+		block := b[:size]
+		b = b[size:]
+		if !hmac.Equal(mac, blockMAC(macKey, index, block)) {
+			return nil, ErrCorrupt
+		}
+		if size == 0 {
+			return payload, nil
+		}
+		payload = append(payload, block...)
+	}
+}
+
+// writeBlocks writes the encrypted payload as blocks of blockSize bytes, followed by the empty block that ends it.
+func writeBlocks(w io.Writer, payload, macKey []byte) error {
+	for index := uint64(0); ; index++ {
+		n := min(len(payload), blockSize)
+		block := payload[:n]
+		payload = payload[n:]
+		size := binary.LittleEndian.AppendUint32(nil, uint32(n))
+		if _, err := w.Write(slices.Concat(blockMAC(macKey, index, block), size, block)); err != nil {
+			return err
+		}
+		if n == 0 {
+			return nil
+		}
+	}
+}
+
+// readInnerHeader parses the inner header at the start of the decrypted payload, and returns the stream that
+// unprotects the protected values of the XML that follows it.
+func readInnerHeader(payload []byte) (innerStream, []byte, error) {
+	var id uint32
+	var key []byte
+	for {
+		if len(payload) < fieldHeaderSize {
+			return nil, nil, ErrCorrupt
+		}
+		typ := payload[0]
+		value, rest, ok := cutSized(payload[1:])
+		if !ok {
+			return nil, nil, ErrCorrupt
+		}
+		payload = rest
+		switch typ {
+		case innerFieldEnd:
+			stream, err := newInnerStream(id, key)
+			return stream, payload, err
+		case innerFieldStreamID:
+			if len(value) != 4 {
+				return nil, nil, ErrCorrupt
+			}
+			id = binary.LittleEndian.Uint32(value)
+		case innerFieldStreamKey:
+			key = value
+		case innerFieldAttachment:
+			// Attachments are not supported.
+		}
+	}
+}
+
+// writeInnerHeader writes an inner header that protects values with the ChaCha20 stream keyed by streamKey.
+func writeInnerHeader(w io.Writer, streamKey []byte) error {
+	var b []byte
+	for _, field := range []struct {
+		typ   byte
+		value []byte
+	}{
+		{innerFieldStreamID, binary.LittleEndian.AppendUint32(nil, innerStreamChaCha20)},
+		{innerFieldStreamKey, streamKey},
+		{innerFieldEnd, nil},
+	} {
+		b = append(b, field.typ)
+		b = binary.LittleEndian.AppendUint32(b, uint32(len(field.value)))
+		b = append(b, field.value...)
+	}
+	_, err := w.Write(b)
+	return err
+}
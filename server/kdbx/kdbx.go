@@ -0,0 +1,235 @@
+// Package kdbx reads and writes KeePass databases in the KDBX 4 format used by KeePass 2.35 and later and by
+// KeePassXC, so that a KeePass database can be migrated into the vault and the vault handed out as one for offline
+// use. It supports the Argon2d, Argon2id, and AES key derivation functions, the AES-256 and ChaCha20 ciphers, and
+// the Salsa20 and ChaCha20 inner streams that protect passwords within the database. Databases are opened with a
+// password only; key files and hardware keys are not supported, and neither are attachments, which are dropped.
+//
+// The package only deals with the file format. It knows nothing of sites or tags: the importer package maps
+// entries to and from the vault.
+package kdbx
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"time"
+)
+
+// Keys of the standard strings of an entry. Any other key is a custom string field.
+const (
+	KeyTitle    = "Title"
+	KeyUserName = "UserName"
+	KeyPassword = "Password"
+	KeyURL      = "URL"
+	KeyNotes    = "Notes"
+)
+
+// Cipher names the cipher a database is encrypted with.
+type Cipher string
+
+// Ciphers supported by Write.
+const (
+	CipherAES256   Cipher = "aes256"
+	CipherChaCha20 Cipher = "chacha20"
+)
+
+// KDF names the function that derives the key of a database from its password.
+type KDF string
+
+// Key derivation functions supported by Write.
+const (
+	KDFArgon2d  KDF = "argon2d"
+	KDFArgon2id KDF = "argon2id"
+	KDFAES      KDF = "aes"
+)
+
+// Options configures Write. The zero value writes AES-256 with Argon2id, which every KDBX 4 reader supports.
+type Options struct {
+	Cipher Cipher
+	KDF    KDF
+}
+
+var (
+	// ErrNotKDBX is returned for a file that is not a KeePass database.
+	ErrNotKDBX = errors.New("not a KeePass database")
+	// ErrUnsupportedVersion is returned for a KeePass database in another format than KDBX 4, such as KDBX 3.1,
+	// which KeePass still saves databases that use AES-KDF in.
+	ErrUnsupportedVersion = errors.New("only KDBX 4 databases are supported; switch the database to Argon2 in KeePass's database settings and save it first")
+	// ErrWrongPassword is returned when the password does not open the database.
+	ErrWrongPassword = errors.New("wrong password")
+	// ErrCorrupt is returned for a database that was damaged or tampered with.
+	ErrCorrupt = errors.New("database is corrupt")
+)
+
+// maxDatabaseSize bounds the decompressed content of a database that Read accepts, so that a small, highly
+// compressed file cannot exhaust memory.
+const maxDatabaseSize = 256 << 20
+
+// Database is the content of a KeePass database.
+type Database struct {
+	// Name is the database name shown by KeePass.
+	Name string
+	// Root is the top-level group, which KeePass names after the database. The recycle bin is left out.
+	Root Group
+}
+
+// Group is a group of entries, shown as a folder by KeePass.
+type Group struct {
+	Name    string
+	Groups  []Group
+	Entries []Entry
+}
+
+// Entry is a KeePass entry.
+type Entry struct {
+	// Strings are the standard strings, such as KeyTitle and KeyPassword, and the custom string fields.
+	Strings []String
+	Tags    []string
+	// CustomData holds the data applications attach to the entry, by key, which KeePass keeps but does not show.
+	CustomData map[string]string
+	// Modified is the time of the last modification, which for an entry of History is when it was replaced.
+	Modified time.Time
+	// History holds the earlier versions of the entry, oldest first. They have no history themselves.
+	History []Entry
+}
+
+// String is a string of an entry.
+type String struct {
+	Key   string
+	Value string
+	// Protected is set for values KeePass keeps encrypted in memory and hides by default, such as the password.
+	Protected bool
+}
+
+// Get returns the value of the entry's string with the key, or "" if it has none.
+func (e Entry) Get(key string) string {
+	for _, s := range e.Strings {
+		if s.Key == key {
+			return s.Value
+		}
+	}
+	return ""
+}
+
+// Read reads a KDBX 4 database and decrypts it with password. It returns ErrWrongPassword if the password does not
+// open the database.
+func Read(r io.Reader, password string) (*Database, error) {
+	file, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	h, err := readHeader(file)
+	if err != nil {
+		return nil, err
+	}
+	rest := file[h.length:]
+	if len(rest) < 2*sha256.Size {
+		return nil, ErrCorrupt
+	}
+	if sum := sha256.Sum256(file[:h.length]); !hmac.Equal(sum[:], rest[:sha256.Size]) {
+		return nil, ErrCorrupt
+	}
+
+	transformed, err := deriveKey(h.kdf, compositeKey(password))
+	if err != nil {
+		return nil, err
+	}
+	encKey, macKey := databaseKeys(h.masterSeed, transformed)
+	if !hmac.Equal(headerMAC(macKey, file[:h.length]), rest[sha256.Size:2*sha256.Size]) {
+		return nil, ErrWrongPassword
+	}
+	ciphertext, err := readBlocks(rest[2*sha256.Size:], macKey)
+	if err != nil {
+		return nil, err
+	}
+	payload, err := decrypt(h.cipher, encKey, h.iv, ciphertext)
+	if err != nil {
+		return nil, err
+	}
+	if h.compressed {
+		if payload, err = gunzip(payload); err != nil {
+			return nil, err
+		}
+	}
+
+	stream, content, err := readInnerHeader(payload)
+	if err != nil {
+		return nil, err
+	}
+	return decodeXML(content, stream)
+}
+
+// Write encrypts db with password and writes it as a KDBX 4 database, which KeePass 2.35 and later and KeePassXC
+// open.
+func Write(w io.Writer, db *Database, password string, opts Options) error {
+	cipherID, ivSize, err := cipherFor(opts.Cipher)
+	if err != nil {
+		return err
+	}
+	kdf, err := newKDFParameters(opts.KDF)
+	if err != nil {
+		return err
+	}
+	masterSeed, iv, streamKey := make([]byte, 32), make([]byte, ivSize), make([]byte, 64)
+	for _, b := range [][]byte{masterSeed, iv, streamKey} {
+		if _, err := rand.Read(b); err != nil {
+			return err
+		}
+	}
+	header := writeHeader(cipherID, masterSeed, iv, kdf)
+
+	transformed, err := deriveKey(kdf.values(), compositeKey(password))
+	if err != nil {
+		return err
+	}
+	encKey, macKey := databaseKeys(masterSeed, transformed)
+
+	var payload bytes.Buffer
+	gz := gzip.NewWriter(&payload)
+	if err := writeInnerHeader(gz, streamKey); err != nil {
+		return err
+	}
+	stream, err := newInnerStream(innerStreamChaCha20, streamKey)
+	if err != nil {
+		return err
+	}
+	if err := encodeXML(gz, db, stream); err != nil {
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		return err
+	}
+
+	ciphertext, err := encrypt(cipherID, encKey, iv, payload.Bytes())
+	if err != nil {
+		return err
+	}
+	sum := sha256.Sum256(header)
+	out := slices.Concat(header, sum[:], headerMAC(macKey, header))
+	if _, err := w.Write(out); err != nil {
+		return err
+	}
+	return writeBlocks(w, ciphertext, macKey)
+}
+
+// gunzip decompresses a gzip payload of at most maxDatabaseSize bytes.
+func gunzip(compressed []byte) ([]byte, error) {
+	gz, err := gzip.NewReader(bytes.NewReader(compressed))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrCorrupt, err)
+	}
+	content, err := io.ReadAll(io.LimitReader(gz, maxDatabaseSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrCorrupt, err)
+	}
+	if len(content) > maxDatabaseSize {
+		return nil, fmt.Errorf("database content exceeds %d bytes", maxDatabaseSize)
+	}
+	return content, nil
+}
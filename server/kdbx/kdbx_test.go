@@ -0,0 +1,126 @@
+package kdbx
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// TestArgon2d checks the Argon2d test vector of RFC 9106, section 5.1.
+func TestArgon2d(t *testing.T) {
+	password := bytes.Repeat([]byte{0x01}, 32)
+	salt := bytes.Repeat([]byte{0x02}, 16)
+	secret := bytes.Repeat([]byte{0x03}, 8)
+	data := bytes.Repeat([]byte{0x04}, 12)
+	want := "512b391b6f1162975371d30919734294f868e3be3984f3c1a13a4db9fabe4acb"
+	if got := hex.EncodeToString(argon2dDeriveKey(password, salt, secret, data, 3, 32, 4, 32)); got != want {
+		t.Errorf("Argon2d tag is %s, want %s", got, want)
+	}
+}
+
+func testDatabase() *Database {
+	replaced := time.Date(2024, 3, 1, 9, 30, 0, 0, time.UTC)
+	modified := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	standard := func(username, password string) []String {
+		return []String{
+			{Key: KeyTitle, Value: "example.com"},
+			{Key: KeyUserName, Value: username},
+			{Key: KeyPassword, Value: password, Protected: true},
+			{Key: KeyURL, Value: "https://example.com"},
+			{Key: KeyNotes, Value: "line one\nline <two> & three"},
+		}
+	}
+	return &Database{
+		Name: "Test",
+		Root: Group{
+			Name: "Test",
+			Entries: []Entry{{
+				Strings:    append(standard("alice", "current password"), String{Key: "PIN", Value: "1234", Protected: true}, String{Key: "Account", Value: "42"}),
+				Tags:       []string{"work", "email"},
+				CustomData: map[string]string{"Plugin.Key": "value"},
+				Modified:   modified,
+				History:    []Entry{{Strings: standard("alice", "old password"), Modified: replaced}},
+			}},
+			Groups: []Group{{
+				Name: "Work",
+				Groups: []Group{{
+					Name:    "Servers",
+					Entries: []Entry{{Strings: []String{{Key: KeyTitle, Value: "router"}, {Key: KeyPassword, Value: "router password", Protected: true}}, Modified: modified}},
+				}},
+			}},
+		},
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	for _, opts := range []Options{
+		{},
+		{Cipher: CipherAES256, KDF: KDFArgon2d},
+		{Cipher: CipherChaCha20, KDF: KDFArgon2id},
+		{Cipher: CipherChaCha20, KDF: KDFAES},
+	} {
+		db := testDatabase()
+		var file bytes.Buffer
+		if err := Write(&file, db, "correct horse", opts); err != nil {
+			t.Fatalf("Write with %+v: %v", opts, err)
+		}
+		if bytes.Contains(file.Bytes(), []byte("current password")) {
+			t.Errorf("the database written with %+v contains a password in plaintext", opts)
+		}
+		got, err := Read(bytes.NewReader(file.Bytes()), "correct horse")
+		if err != nil {
+			t.Fatalf("Read with %+v: %v", opts, err)
+		}
+		if !reflect.DeepEqual(got, db) {
+			t.Errorf("with %+v, read\n%+v\nwant\n%+v", opts, got, db)
+		}
+	}
+}
+
+// TestKDFLimits checks that key derivations too costly to run on the server are refused before they start.
+func TestKDFLimits(t *testing.T) {
+	composite := compositeKey("correct horse")
+	salt := bytes.Repeat([]byte{1}, kdfSeedSize)
+	argon2 := func(memory, iterations uint64) map[string]any {
+		return map[string]any{"$UUID": kdfArgon2idID, "S": salt, "P": uint32(2), "M": memory, "I": iterations, "V": uint32(argon2Version)}
+	}
+	for name, params := range map[string]map[string]any{
+		"Argon2 memory":          argon2(512<<20, 1),
+		"Argon2 iterations":      argon2(1<<20, 1000),
+		"Argon2 memory and time": argon2(128<<20, 32),
+		"AES-KDF rounds":         {"$UUID": kdfAESID, "S": salt, "R": uint64(1 << 40)},
+	} {
+		start := time.Now()
+		if _, err := deriveKey(params, composite); err == nil || errors.Is(err, ErrCorrupt) {
+			t.Errorf("%s: deriveKey returned %v, want the limits exceeded", name, err)
+		}
+		if time.Since(start) > time.Second {
+			t.Errorf("%s: deriveKey took %s before refusing", name, time.Since(start))
+		}
+	}
+	if _, err := deriveKey(argon2(64<<20, 2), composite); err != nil {
+		t.Errorf("deriveKey refused KeePassXC's default memory: %v", err)
+	}
+}
+
+func TestReadErrors(t *testing.T) {
+	var file bytes.Buffer
+	if err := Write(&file, testDatabase(), "correct horse", Options{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Read(bytes.NewReader(file.Bytes()), "wrong horse"); !errors.Is(err, ErrWrongPassword) {
+		t.Errorf("Read with a wrong password returned %v, want ErrWrongPassword", err)
+	}
+
+	tampered := bytes.Clone(file.Bytes())
+	tampered[len(tampered)-1] ^= 1
+	if _, err := Read(bytes.NewReader(tampered), "correct horse"); !errors.Is(err, ErrCorrupt) {
+		t.Errorf("Read of a tampered database returned %v, want ErrCorrupt", err)
+	}
+	if _, err := Read(bytes.NewReader([]byte("not a database")), "correct horse"); !errors.Is(err, ErrNotKDBX) {
+		t.Errorf("Read of another file returned %v, want ErrNotKDBX", err)
+	}
+}
@@ -0,0 +1,345 @@
+package kdbx
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+)
+
+// generator is written to the Generator element of the databases Write produces.
+const generator = "Pasword Mango"
+
+// epoch is the origin of the timestamps of KDBX 4, which count seconds since the start of year 1.
+var epoch = time.Date(1, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// The XML document of a database. Only the elements the vault uses are declared; KeePass fills in defaults for
+// the rest.
+type xmlFile struct {
+	XMLName xml.Name `xml:"KeePassFile"`
+	Meta    xmlMeta  `xml:"Meta"`
+	Root    xmlRoot  `xml:"Root"`
+}
+
+type xmlMeta struct {
+	Generator         string `xml:"Generator"`
+	DatabaseName      string `xml:"DatabaseName"`
+	RecycleBinEnabled string `xml:"RecycleBinEnabled"`
+	RecycleBinUUID    string `xml:"RecycleBinUUID,omitempty"`
+}
+
+type xmlRoot struct {
+	Groups []xmlGroup `xml:"Group"`
+}
+
+type xmlGroup struct {
+	UUID       string     `xml:"UUID"`
+	Name       string     `xml:"Name"`
+	IconID     int        `xml:"IconID"`
+	Times      xmlTimes   `xml:"Times"`
+	IsExpanded string     `xml:"IsExpanded"`
+	Entries    []xmlEntry `xml:"Entry"`
+	Groups     []xmlGroup `xml:"Group"`
+}
+
+type xmlEntry struct {
+	UUID       string         `xml:"UUID"`
+	IconID     int            `xml:"IconID"`
+	Tags       string         `xml:"Tags,omitempty"`
+	Times      xmlTimes       `xml:"Times"`
+	Strings    []xmlString    `xml:"String"`
+	CustomData *xmlCustomData `xml:"CustomData"`
+	History    *xmlHistory    `xml:"History"`
+}
+
+type xmlCustomData struct {
+	Items []xmlCustomDataItem `xml:"Item"`
+}
+
+type xmlCustomDataItem struct {
+	Key   string `xml:"Key"`
+	Value string `xml:"Value"`
+}
+
+type xmlHistory struct {
+	Entries []xmlEntry `xml:"Entry"`
+}
+
+type xmlTimes struct {
+	CreationTime         string `xml:"CreationTime"`
+	LastModificationTime string `xml:"LastModificationTime"`
+	LastAccessTime       string `xml:"LastAccessTime"`
+	ExpiryTime           string `xml:"ExpiryTime"`
+	Expires              string `xml:"Expires"`
+	UsageCount           int    `xml:"UsageCount"`
+	LocationChanged      string `xml:"LocationChanged"`
+}
+
+type xmlString struct {
+	Key   string   `xml:"Key"`
+	Value xmlValue `xml:"Value"`
+}
+
+type xmlValue struct {
+	// Protected is "True" for a value XORed with the inner stream and base64-encoded.
+	Protected string `xml:"Protected,attr,omitempty"`
+	Value     string `xml:",chardata"`
+}
+
+// Icons of the groups and entries Write produces: KeePass's folder and key icons.
+const (
+	groupIcon = 48
+	entryIcon = 0
+)
+
+// decodeXML parses the XML of a database, unprotecting its protected values with stream.
+func decodeXML(content []byte, stream innerStream) (*Database, error) {
+	var f xmlFile
+	d := xml.NewTokenDecoder(&unprotector{d: xml.NewDecoder(bytes.NewReader(content)), stream: stream})
+	if err := d.Decode(&f); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrCorrupt, err)
+	}
+	db := &Database{Name: f.Meta.DatabaseName}
+	if len(f.Root.Groups) == 0 {
+		return db, nil
+	}
+	recycleBin := ""
+	if isTrue(f.Meta.RecycleBinEnabled) {
+		recycleBin = f.Meta.RecycleBinUUID
+	}
+	db.Root = f.Root.Groups[0].group(recycleBin)
+	return db, nil
+}
+
+// unprotector is a token reader that replaces the protected values of the XML with their plaintext. The inner
+// stream runs through protected values in document order, so they must be unprotected as the tokens are read.
+type unprotector struct {
+	d         *xml.Decoder
+	stream    innerStream
+	protected bool
+}
+
+func (u *unprotector) Token() (xml.Token, error) {
+	tok, err := u.d.Token()
+	if err != nil {
+		return tok, err
+	}
+	switch t := tok.(type) {
+	case xml.StartElement:
+		u.protected = false
+		if t.Name.Local == "Value" {
+			for _, attr := range t.Attr {
+				if attr.Name.Local == "Protected" && isTrue(attr.Value) {
+					u.protected = true
+				}
+			}
+		}
+	case xml.CharData:
+		if u.protected {
+			u.protected = false
+			value, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(t)))
+			if err != nil {
+				return nil, fmt.Errorf("invalid protected value: %v", err)
+			}
+			u.stream.XORKeyStream(value, value)
+			return xml.CharData(value), nil
+		}
+	case xml.EndElement:
+		u.protected = false
+	}
+	return tok, nil
+}
+
+// group converts a group of the XML, leaving out the recycle bin with the UUID, if any, and its entries.
+func (g xmlGroup) group(recycleBin string) Group {
+	group := Group{Name: g.Name}
+	for _, e := range g.Entries {
+		group.Entries = append(group.Entries, e.entry())
+	}
+	for _, sub := range g.Groups {
+		if recycleBin != "" && sub.UUID == recycleBin {
+			continue
+		}
+		group.Groups = append(group.Groups, sub.group(recycleBin))
+	}
+	return group
+}
+
+func (e xmlEntry) entry() Entry {
+	entry := Entry{Modified: parseTime(e.Times.LastModificationTime)}
+	for _, s := range e.Strings {
+		entry.Strings = append(entry.Strings, String{Key: s.Key, Value: s.Value.Value, Protected: s.Value.Protected != ""})
+	}
+	for _, tag := range strings.FieldsFunc(e.Tags, func(r rune) bool { return r == ';' || r == ',' }) {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			entry.Tags = append(entry.Tags, tag)
+		}
+	}
+	if e.CustomData != nil {
+		entry.CustomData = make(map[string]string, len(e.CustomData.Items))
+		for _, item := range e.CustomData.Items {
+			entry.CustomData[item.Key] = item.Value
+		}
+	}
+	if e.History != nil {
+		for _, h := range e.History.Entries {
+			entry.History = append(entry.History, h.entry())
+		}
+	}
+	return entry
+}
+
+// encodeXML writes the XML of db, protecting the password and other protected values with stream.
+func encodeXML(w io.Writer, db *Database, stream innerStream) error {
+	now := time.Now()
+	root, err := newXMLGroup(db.Root, now)
+	if err != nil {
+		return err
+	}
+	f := xmlFile{
+		Meta: xmlMeta{Generator: generator, DatabaseName: db.Name, RecycleBinEnabled: "False"},
+		Root: xmlRoot{Groups: []xmlGroup{root}},
+	}
+	// Protect the values in the order xml.Marshal writes them: the strings of an entry, then its history, and the
+	// entries of a group before its subgroups.
+	f.Root.Groups[0].protect(stream)
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "\t")
+	if err := enc.Encode(f); err != nil {
+		return err
+	}
+	return enc.Close()
+}
+
+func newXMLGroup(g Group, now time.Time) (xmlGroup, error) {
+	id, err := newUUID()
+	if err != nil {
+		return xmlGroup{}, err
+	}
+	group := xmlGroup{UUID: id, Name: g.Name, IconID: groupIcon, Times: newTimes(now), IsExpanded: "True"}
+	for _, e := range g.Entries {
+		entry, err := newXMLEntry(e, now)
+		if err != nil {
+			return xmlGroup{}, err
+		}
+		group.Entries = append(group.Entries, entry)
+	}
+	for _, sub := range g.Groups {
+		subgroup, err := newXMLGroup(sub, now)
+		if err != nil {
+			return xmlGroup{}, err
+		}
+		group.Groups = append(group.Groups, subgroup)
+	}
+	return group, nil
+}
+
+func newXMLEntry(e Entry, now time.Time) (xmlEntry, error) {
+	id, err := newUUID()
+	if err != nil {
+		return xmlEntry{}, err
+	}
+	modified := e.Modified
+	if modified.IsZero() {
+		modified = now
+	}
+	entry := xmlEntry{UUID: id, IconID: entryIcon, Tags: strings.Join(e.Tags, ";"), Times: newTimes(modified)}
+	for _, s := range e.Strings {
+		value := xmlValue{Value: s.Value}
+		if s.Protected || s.Key == KeyPassword {
+			value.Protected = "True"
+		}
+		entry.Strings = append(entry.Strings, xmlString{Key: s.Key, Value: value})
+	}
+	if len(e.CustomData) > 0 {
+		entry.CustomData = &xmlCustomData{}
+		for _, key := range slices.Sorted(maps.Keys(e.CustomData)) {
+			entry.CustomData.Items = append(entry.CustomData.Items, xmlCustomDataItem{Key: key, Value: e.CustomData[key]})
+		}
+	}
+	if len(e.History) > 0 {
+		entry.History = &xmlHistory{}
+		for _, h := range e.History {
+			h.History = nil
+			old, err := newXMLEntry(h, now)
+			if err != nil {
+				return xmlEntry{}, err
+			}
+			// Versions of an entry share its UUID.
+			old.UUID = id
+			entry.History.Entries = append(entry.History.Entries, old)
+		}
+	}
+	return entry, nil
+}
+
+func (g *xmlGroup) protect(stream innerStream) {
+	for i := range g.Entries {
+		g.Entries[i].protect(stream)
+	}
+	for i := range g.Groups {
+		g.Groups[i].protect(stream)
+	}
+}
+
+func (e *xmlEntry) protect(stream innerStream) {
+	for i := range e.Strings {
+		v := &e.Strings[i].Value
+		if v.Protected != "" {
+			value := []byte(v.Value)
+			stream.XORKeyStream(value, value)
+			v.Value = base64.StdEncoding.EncodeToString(value)
+		}
+	}
+	if e.History != nil {
+		for i := range e.History.Entries {
+			e.History.Entries[i].protect(stream)
+		}
+	}
+}
+
+func newTimes(t time.Time) xmlTimes {
+	s := formatTime(t)
+	return xmlTimes{CreationTime: s, LastModificationTime: s, LastAccessTime: s, ExpiryTime: s, Expires: "False", LocationChanged: s}
+}
+
+// formatTime encodes a time as KDBX 4 does: the base64 of the little-endian seconds since epoch.
+func formatTime(t time.Time) string {
+	seconds := t.Unix() - epoch.Unix()
+	return base64.StdEncoding.EncodeToString(binary.LittleEndian.AppendUint64(nil, uint64(seconds)))
+}
+
+// parseTime decodes a time of KDBX 4, or the ISO 8601 time of earlier versions that some tools still write. It
+// returns the zero time for anything else.
+func parseTime(s string) time.Time {
+	if b, err := base64.StdEncoding.DecodeString(s); err == nil && len(b) == 8 {
+		return time.Unix(epoch.Unix()+int64(binary.LittleEndian.Uint64(b)), 0).UTC()
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t.UTC()
+	}
+	return time.Time{}
+}
+
+func newUUID() (string, error) {
+	var id [16]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(id[:]), nil
+}
+
+func isTrue(s string) bool {
+	return strings.EqualFold(s, "true")
+}
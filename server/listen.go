@@ -0,0 +1,233 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/rihts-4/pasword-mango/config"
+)
+
+// systemdListenFDsStart is the first file descriptor passed by systemd socket activation.
+const systemdListenFDsStart = 3
+
+// unixSocketSettings restricts access to Unix domain sockets: socket files the server creates get Mode
+// permissions, and only processes running as one of AllowedUIDs may connect, checked with SO_PEERCRED.
+// AnyUID admits any user the file permissions admit.
+type unixSocketSettings struct {
+	Mode        fs.FileMode
+	AllowedUIDs map[int]bool
+	AnyUID      bool
+}
+
+// newUnixSocketSettings converts the validated Unix socket configuration into settings. If no user IDs
+// are configured, only the server's own user may connect.
+func newUnixSocketSettings(cfg config.UnixSocket) (unixSocketSettings, error) {
+	mode, err := cfg.FileMode()
+	if err != nil {
+		return unixSocketSettings{}, err
+	}
+	uids, anyUID, err := cfg.UIDs()
+	if err != nil {
+		return unixSocketSettings{}, err
+	}
+	if len(uids) == 0 && !anyUID {
+		uids[os.Getuid()] = true
+	}
+	return unixSocketSettings{Mode: mode, AllowedUIDs: uids, AnyUID: anyUID}, nil
+}
+
+// serverListener is an open listener and whether it should be served over TLS when TLS is enabled.
+// Unix domain sockets are always served as plain HTTP; they are authenticated by peer credentials instead.
+type serverListener struct {
+	net.Listener
+	name   string
+	useTLS bool
+}
+
+// openListeners opens a listener for every spec. If any listener fails to open, those already opened are closed.
+func openListeners(specs []config.ListenSpec, unixSettings unixSocketSettings) ([]serverListener, error) {
+	var listeners []serverListener
+	fail := func(err error) ([]serverListener, error) {
+		for _, l := range listeners {
+			l.Close()
+		}
+		return nil, err
+	}
+
+	var activated []activatedListener
+	for _, spec := range specs {
+		switch {
+		case spec.Systemd:
+			if activated == nil {
+				var err error
+				if activated, err = systemdListeners(); err != nil {
+					return fail(err)
+				}
+			}
+			matched := false
+			for _, a := range activated {
+				if spec.Address != "" && a.name != spec.Address {
+					continue
+				}
+				matched = true
+				listeners = append(listeners, wrapListener(a.listener, "systemd:"+a.name, unixSettings))
+			}
+			if !matched {
+				return fail(fmt.Errorf("no sockets passed by systemd match %s", spec))
+			}
+
+		case spec.Network == "unix":
+			l, err := listenUnix(spec.Address, unixSettings.Mode)
+			if err != nil {
+				return fail(err)
+			}
+			listeners = append(listeners, wrapListener(l, spec.String(), unixSettings))
+
+		default:
+			l, err := net.Listen("tcp", spec.Address)
+			if err != nil {
+				return fail(fmt.Errorf("could not listen on %s: %w", spec, err))
+			}
+			listeners = append(listeners, serverListener{Listener: l, name: spec.String(), useTLS: true})
+		}
+	}
+	return listeners, nil
+}
+
+// wrapListener adds peer-credential checks to Unix domain socket listeners and marks TCP listeners for TLS.
+func wrapListener(l net.Listener, name string, unixSettings unixSocketSettings) serverListener {
+	if ul, ok := l.(*net.UnixListener); ok {
+		return serverListener{Listener: &peerCheckListener{UnixListener: ul, settings: unixSettings}, name: name}
+	}
+	return serverListener{Listener: l, name: name, useTLS: true}
+}
+
+// listenUnix listens on a Unix domain socket at path with the given file permissions, replacing a stale
+// socket file left behind by a previous run. It refuses to replace anything that is not a socket.
+func listenUnix(path string, mode fs.FileMode) (net.Listener, error) {
+	if info, err := os.Lstat(path); err == nil {
+		if info.Mode()&fs.ModeSocket == 0 {
+			return nil, fmt.Errorf("could not listen on unix://%s: file exists and is not a socket", path)
+		}
+		if conn, err := net.Dial("unix", path); err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("could not listen on unix://%s: socket is in use", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, fmt.Errorf("could not remove stale socket %s: %w", path, err)
+		}
+	}
+
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, fmt.Errorf("could not listen on unix://%s: %w", path, err)
+	}
+	// Connections are also checked against the allowed peer UIDs, which covers the moment before chmod.
+	if err := os.Chmod(path, mode); err != nil {
+		l.Close()
+		return nil, fmt.Errorf("could not set permissions on %s: %w", path, err)
+	}
+	return l, nil
+}
+
+// peerCheckListener accepts Unix domain socket connections only from processes running as an allowed user.
+type peerCheckListener struct {
+	*net.UnixListener
+	settings unixSocketSettings
+}
+
+// peerConn is a Unix domain socket connection annotated with the connecting process's user ID.
+type peerConn struct {
+	net.Conn
+	uid int
+}
+
+// Accept waits for the next connection from an allowed peer, closing connections from other users.
+func (l *peerCheckListener) Accept() (net.Conn, error) {
+	for {
+		conn, err := l.AcceptUnix()
+		if err != nil {
+			return nil, err
+		}
+		uid, err := peerUID(conn)
+		if err != nil {
+			if !l.settings.AnyUID {
+				log.Printf("Rejecting Unix socket connection: %v", err)
+				conn.Close()
+				continue
+			}
+			return conn, nil
+		}
+		if !l.settings.AnyUID && !l.settings.AllowedUIDs[uid] {
+			log.Printf("Rejecting Unix socket connection from uid %d", uid)
+			conn.Close()
+			continue
+		}
+		return &peerConn{Conn: conn, uid: uid}, nil
+	}
+}
+
+// peerActorPrefix identifies audit log actors authenticated by Unix socket peer credentials.
+const peerActorPrefix = "uid:"
+
+// connContext implements http.Server.ConnContext, identifying requests on Unix socket connections by
+// the peer's user ID for the audit log and rate limiting.
+func connContext(ctx context.Context, conn net.Conn) context.Context {
+	if pc, ok := conn.(*peerConn); ok {
+		return context.WithValue(ctx, actorContextKey{}, peerActorPrefix+strconv.Itoa(pc.uid))
+	}
+	return ctx
+}
+
+// activatedListener is a socket passed by systemd socket activation.
+type activatedListener struct {
+	listener net.Listener
+	name     string
+}
+
+// systemdListeners returns the sockets passed by systemd socket activation, following the sd_listen_fds
+// protocol: LISTEN_PID must name this process, LISTEN_FDS counts descriptors starting at 3, and
+// LISTEN_FDNAMES optionally names them. The variables are unset so child processes do not inherit them.
+func systemdListeners() ([]activatedListener, error) {
+	defer func() {
+		os.Unsetenv("LISTEN_PID")
+		os.Unsetenv("LISTEN_FDS")
+		os.Unsetenv("LISTEN_FDNAMES")
+	}()
+
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return nil, errors.New("no sockets were passed by systemd (LISTEN_PID does not match this process)")
+	}
+	count, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || count <= 0 {
+		return nil, errors.New("no sockets were passed by systemd (LISTEN_FDS is not set)")
+	}
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+
+	listeners := make([]activatedListener, 0, count)
+	for i := 0; i < count; i++ {
+		name := "unknown"
+		if i < len(names) && names[i] != "" {
+			name = names[i]
+		}
+		file := os.NewFile(uintptr(systemdListenFDsStart+i), name)
+		l, err := net.FileListener(file)
+		file.Close()
+		if err != nil {
+			for _, a := range listeners {
+				a.listener.Close()
+			}
+			return nil, fmt.Errorf("socket %q passed by systemd is not a listening socket: %w", name, err)
+		}
+		listeners = append(listeners, activatedListener{listener: l, name: name})
+	}
+	return listeners, nil
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/rihts-4/pasword-mango/config"
+)
+
+// setupLogging directs the standard logger, which the server logs through, to a slog handler with the
+// configured level, format, and destination. The level only filters messages logged through slog. It returns a function that closes the log file, if any.
+func setupLogging(cfg config.Log) (func(), error) {
+	var out io.Writer = os.Stderr
+	closeLog := func() {}
+	if cfg.File != "" {
+		file, err := os.OpenFile(cfg.File, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open log file: %w", err)
+		}
+		out = file
+		closeLog = func() { file.Close() }
+	}
+
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(cfg.Level)); err != nil {
+		closeLog()
+		return nil, fmt.Errorf("invalid log level %q", cfg.Level)
+	}
+	opts := &slog.HandlerOptions{Level: level}
+
+	var handler slog.Handler
+	if strings.EqualFold(cfg.Format, "json") {
+		handler = slog.NewJSONHandler(out, opts)
+	} else {
+		handler = slog.NewTextHandler(out, opts)
+	}
+	// This also routes the standard logger through the handler. Its messages, which include the reasons the server
+	// exits for, are logged at info level or at the configured level if that is higher, so that none are filtered out.
+	slog.SetDefault(slog.New(handler))
+	slog.SetLogLoggerLevel(max(level, slog.LevelInfo))
+	return closeLog, nil
+}
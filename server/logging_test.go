@@ -0,0 +1,44 @@
+package main
+
+import (
+	"log"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/rihts-4/pasword-mango/config"
+)
+
+func TestLoggingKeepsStandardLogger(t *testing.T) {
+	defaultLogger, flags := slog.Default(), log.Flags()
+	t.Cleanup(func() {
+		slog.SetDefault(defaultLogger)
+		slog.SetLogLoggerLevel(slog.LevelInfo)
+		log.SetOutput(os.Stderr)
+		log.SetFlags(flags)
+	})
+
+	for _, level := range []string{"debug", "info", "warn", "error"} {
+		file := filepath.Join(t.TempDir(), "server.log")
+		closeLog, err := setupLogging(config.Log{Level: level, Format: "text", File: file})
+		if err != nil {
+			t.Fatal(err)
+		}
+		log.Printf("Failed to start: %s", level)
+		slog.Info("Leveled message", "level", level)
+		closeLog()
+
+		content, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(string(content), "Failed to start: "+level) {
+			t.Errorf("at level %s, the standard logger's message is missing from %q", level, content)
+		}
+		if filtered := level == "warn" || level == "error"; strings.Contains(string(content), "Leveled message") == filtered {
+			t.Errorf("at level %s, the info message was logged: %v, in %q", level, !filtered, content)
+		}
+	}
+}
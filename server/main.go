@@ -2,25 +2,236 @@ package main
 
 import (
 	"context"
+	"flag"
+	"fmt"
+	"log"
 	"net/http"
+	"os"
+
+	"github.com/rihts-4/pasword-mango/config"
+	"github.com/rihts-4/pasword-mango/share"
 )
 
-// main configures HTTP handlers for the /credentials endpoints and starts an HTTP server listening on port 8080, managing the server lifecycle including graceful shutdown.
+// main loads the configuration from the defaults, configuration file, environment, and command-line flags, then
+// runs the requested command. Without a command it serves, as it always has.
+//
+// The commands are `serve`, which starts the server; `init`, which sets up a new vault and its key; `doctor`, which
+// checks the installation; `version`; `config print`, which prints the effective configuration; `client ...`,
+// which manages the client certificates used in mutual TLS mode; `breaches`, which scans the vault for passwords
+// that appear in known breaches; `health`, which reports reused, weak, and old passwords; `audit verify`, which checks
+// the audit log's hash chain; and `backup` and `restore`, which write an encrypted backup archive of the vault and
+// restore one into the configured storage backend.
 func main() {
+	flags := flag.NewFlagSet("pasword-mango", flag.ExitOnError)
+	configFlags := config.RegisterFlags(flags)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: pasword-mango [flags] [serve | init | doctor | version | config print | client ... | breaches | health | audit verify | backup | restore]")
+		flags.PrintDefaults()
+	}
+	flags.Parse(os.Args[1:])
+	args := flags.Args()
+	command := "serve"
+	if len(args) > 0 {
+		command, args = args[0], args[1:]
+	}
+
+	// These commands work with an incomplete or invalid configuration
+	if command == "version" {
+		os.Exit(versionCommand())
+	}
+	cfg, err := configFlags.Load()
+	configFile, envFile := configFlags.Files()
+	switch command {
+	case "config":
+		os.Exit(configCommand(cfg, err, args))
+	case "init":
+		os.Exit(initCommand(context.Background(), cfg, err, configFlags.ConfigFile(), args))
+	case "doctor":
+		os.Exit(doctorCommand(context.Background(), cfg, err, configFile, envFile))
+	}
+	if err != nil {
+		printConfigProblems(err)
+		os.Exit(1)
+	}
+
+	closeLog, err := setupLogging(cfg.Log)
+	if err != nil {
+		log.Fatalf("Failed to set up logging: %v", err)
+	}
+	defer closeLog()
+
+	switch command {
+	case "serve":
+		if len(args) > 0 {
+			flags.Usage()
+			os.Exit(2)
+		}
+		serveCommand(cfg)
+	case "client":
+		os.Exit(clientCommand(context.Background(), cfg, args))
+	case "breaches":
+		os.Exit(breachesCommand(context.Background(), cfg, args))
+	case "health":
+		os.Exit(healthCommand(context.Background(), cfg, args))
+	case "audit":
+		os.Exit(auditCommand(context.Background(), cfg, args))
+	case "backup":
+		os.Exit(backupCommand(context.Background(), cfg, args))
+	case "restore":
+		os.Exit(restoreCommand(context.Background(), cfg, args))
+	default:
+		flags.Usage()
+		os.Exit(2)
+	}
+}
+
+// serveCommand configures HTTP handlers for the /credentials endpoints and starts an HTTP server on the configured
+// listeners, managing the server lifecycle including graceful shutdown.
+func serveCommand(cfg config.Config) {
 	mux := http.NewServeMux()
 
+	// Passwords are checked against a local Pwned Passwords file, if one is configured
+	closeBreachFile, err := openBreachFile(cfg.Passwords)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer closeBreachFile()
+
 	// Set up HTTP handlers
-	// Wrap the handler with the logging middleware
-	loggedCredentialsHandler := loggingMiddleware(http.HandlerFunc(credentialsHandler))
-	mux.Handle("/credentials", loggedCredentialsHandler)
-	mux.Handle("/credentials/", loggedCredentialsHandler)
+	// Wrap the handler with the rate limiting and audit middleware; the audit middleware comes first so that it
+	// also records the requests the rate limiter rejects
+	limiter, err := newRateLimiter(cfg.RateLimits)
+	if err != nil {
+		log.Fatalf("Invalid rate limit configuration: %v", err)
+	}
+	credentials := newCredentialsAPI(cfg.Passwords)
+	credentialsRoute := auditMiddleware(rateLimitMiddleware(limiter, credentialsEndpoint,
+		http.HandlerFunc(credentials.credentialsHandler)))
+	mux.Handle("/credentials", credentialsRoute)
+	mux.Handle("/credentials/", credentialsRoute)
+
+	// Password generation and strength estimation store nothing, so they are only rate limited
+	mux.Handle("/generate", rateLimitMiddleware(limiter, toolEndpoint, http.HandlerFunc(generateHandler)))
+	mux.Handle("/strength", rateLimitMiddleware(limiter, toolEndpoint, http.HandlerFunc(credentials.strengthHandler)))
+
+	// Imports write many credentials at once
+	mux.Handle("/import", auditMiddleware(rateLimitMiddleware(limiter, writeEndpoint, http.HandlerFunc(credentials.importHandler))))
+
+	// Exports reveal every password at once
+	mux.Handle("/export", auditMiddleware(rateLimitMiddleware(limiter, revealEndpoint, http.HandlerFunc(exportHandler))))
+
+	// Rotation policies and the passwords due under them
+	rotation := newRotationAPI(cfg.Rotation)
+	mux.Handle("/rotation/due", auditMiddleware(rateLimitMiddleware(limiter, rotationEndpoint,
+		http.HandlerFunc(rotation.dueHandler))))
+	policiesRoute := auditMiddleware(rateLimitMiddleware(limiter, rotationEndpoint, http.HandlerFunc(rotation.policiesHandler)))
+	mux.Handle("/rotation/policies", policiesRoute)
+	mux.Handle("/rotation/policies/", policiesRoute)
+
+	// Admin endpoints require the admin token; clients that keep presenting a wrong token are locked out
+	lockouts := newLockoutTracker()
+	auditRoute := auditMiddleware(rateLimitMiddleware(limiter, adminEndpoint,
+		lockoutMiddleware(lockouts, adminMiddleware(cfg.Admin.Token, http.HandlerFunc(auditHandler)))))
+	mux.Handle("/admin/audit", auditRoute)
+	mux.Handle("/admin/audit/", auditRoute)
 
-	// Create and configure the HTTP server
+	// The breach scan decrypts every password, so it is audited
+	breachesRoute := auditMiddleware(rateLimitMiddleware(limiter, adminEndpoint,
+		lockoutMiddleware(lockouts, adminMiddleware(cfg.Admin.Token, http.HandlerFunc(breachesHandler)))))
+	mux.Handle("/admin/breaches", breachesRoute)
+
+	// So does the health report, which the security team reviews
+	health := newHealthReporter(cfg.Passwords)
+	mux.Handle("/reports/health", auditMiddleware(rateLimitMiddleware(limiter, adminEndpoint,
+		lockoutMiddleware(lockouts, adminMiddleware(cfg.Admin.Token, http.HandlerFunc(health.healthHandler))))))
+
+	// Backups hold every password, encrypted with a passphrase of their own
+	mux.Handle("/admin/backup", auditMiddleware(rateLimitMiddleware(limiter, adminEndpoint,
+		lockoutMiddleware(lockouts, adminMiddleware(cfg.Admin.Token, http.HandlerFunc(backupHandler))))))
+
+	// Scheduled backups report their status for monitoring; neither endpoint reveals anything stored
+	backups, err := newScheduledBackups(cfg.Backup)
+	if err != nil {
+		log.Fatalf("Invalid backup configuration: %v", err)
+	}
+	mux.Handle("/health", rateLimitMiddleware(limiter, toolEndpoint, http.HandlerFunc(backups.healthHandler)))
+	mux.Handle("/metrics", rateLimitMiddleware(limiter, toolEndpoint, http.HandlerFunc(backups.metricsHandler)))
+
+	// One-time share links reveal a password to whoever holds the link, so creating one counts as a reveal
+	shares := newSharesAPI(cfg.Share)
+	sharesRoute := auditMiddleware(rateLimitMiddleware(limiter, revealEndpoint, http.HandlerFunc(shares.sharesHandler)))
+	mux.Handle("/shares", sharesRoute)
+	mux.Handle("/shares/", sharesRoute)
+
+	// Client certificates for mutual TLS mode are issued and revoked through the admin API
+	clients := newClientAuthority()
+	clientsRoute := auditMiddleware(rateLimitMiddleware(limiter, adminEndpoint,
+		lockoutMiddleware(lockouts, adminMiddleware(cfg.Admin.Token, http.HandlerFunc(clients.clientsHandler)))))
+	mux.Handle("/admin/clients", clientsRoute)
+	mux.Handle("/admin/clients/", clientsRoute)
+
+	// The served TLS certificate's fingerprints, for clients that pin it
+	certs := newCertManager(clients)
+	mux.HandleFunc("/tls/fingerprint", certs.fingerprintHandler)
+
+	// Every request is logged, then checked against the Host allowlist and the CORS policy before routing;
+	// client certificates identify the actor for the audit log. Share pages are opened in the browsers of recipients
+	// without access to the vault, which the CORS policy would turn away, so they only pass the Host allowlist.
+	policy := newAccessPolicy(cfg.Access)
+	root := http.NewServeMux()
+	root.Handle(share.PagePath, jsonContentTypeMiddleware(auditMiddleware(rateLimitMiddleware(limiter, sharePageEndpoint,
+		http.HandlerFunc(shares.pageHandler)))))
+	root.Handle("/", corsMiddleware(policy, jsonContentTypeMiddleware(clientCertMiddleware(clients, mux))))
+	handler := loggingMiddleware(hostMiddleware(policy, root))
+
+	// Create and configure the HTTP server; its listeners come from the listen configuration
 	srv := &http.Server{
-		Addr:    ":8080",
-		Handler: mux,
+		Handler:           handler,
+		ReadHeaderTimeout: cfg.Timeouts.ReadHeader.Std(),
+		ReadTimeout:       cfg.Timeouts.Read.Std(),
+		WriteTimeout:      cfg.Timeouts.Write.Std(),
+		IdleTimeout:       cfg.Timeouts.Idle.Std(),
 	}
 
 	// Start the server and handle graceful shutdown
-	run(context.Background(), srv)
+	run(context.Background(), cfg, srv, certs, newRotationReminders(cfg.Rotation), backups, shares)
+}
+
+// configCommand implements `config print`, which writes the effective configuration with secrets redacted
+// to standard output, followed by any problems found while loading it. It returns the process exit code.
+func configCommand(cfg config.Config, loadErr error, args []string) int {
+	if len(args) != 1 || args[0] != "print" {
+		fmt.Fprintln(os.Stderr, "usage: pasword-mango [flags] config print")
+		return 2
+	}
+	out, err := cfg.Redacted().YAML()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to encode configuration: %v\n", err)
+		return 1
+	}
+	os.Stdout.Write(out)
+	if loadErr != nil {
+		printConfigProblems(loadErr)
+		return 1
+	}
+	return 0
+}
+
+// printConfigProblems reports every configuration problem joined into err, one per line.
+func printConfigProblems(err error) {
+	fmt.Fprintln(os.Stderr, "Invalid configuration:")
+	for _, problem := range configProblems(err) {
+		fmt.Fprintf(os.Stderr, "  - %v\n", problem)
+	}
+}
+
+// configProblems splits the problems joined into err by Load. It returns nil if err is nil.
+func configProblems(err error) []error {
+	if err == nil {
+		return nil
+	}
+	if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		return joined.Unwrap()
+	}
+	return []error{err}
 }
@@ -1,9 +1,14 @@
 package main
 
 import (
+	"context"
+	"crypto/subtle"
 	"log"
 	"net/http"
+	"strings"
 	"time"
+
+	"github.com/rihts-4/pasword-mango/data"
 )
 
 // responseWriter wraps http.ResponseWriter to capture the status code.
@@ -26,3 +31,103 @@ func loggingMiddleware(next http.Handler) http.Handler {
 		log.Printf("%s %s %d %s", r.Method, r.RequestURI, wrapped.statusCode, time.Since(start))
 	})
 }
+
+// actorContextKey is the context key under which the authenticated actor is stored.
+type actorContextKey struct{}
+
+// anonymousActor is recorded for requests that carry no identity.
+const anonymousActor = "anonymous"
+
+// withActor returns a copy of r whose context identifies the given actor.
+func withActor(r *http.Request, actor string) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), actorContextKey{}, actor))
+}
+
+// requestActor returns the actor attached to the request by an authentication layer, or anonymousActor.
+func requestActor(r *http.Request) string {
+	if actor, ok := r.Context().Value(actorContextKey{}).(string); ok && actor != "" {
+		return actor
+	}
+	return anonymousActor
+}
+
+// auditContextKey is the context key under which auditMiddleware stores the request's audit record.
+type auditContextKey struct{}
+
+// auditRecord collects what a handler did so auditMiddleware can log it once the response is written.
+type auditRecord struct {
+	actor  string
+	action data.AuditAction
+	target string
+}
+
+// recordAudit marks the request as auditable with the given action and target, and records the actor that r
+// identifies, which may have been set by a middleware inside auditMiddleware, such as adminMiddleware.
+// It has no effect if the request was not routed through auditMiddleware.
+func recordAudit(r *http.Request, action data.AuditAction, target string) {
+	if rec, ok := r.Context().Value(auditContextKey{}).(*auditRecord); ok {
+		rec.actor = requestActor(r)
+		rec.action = action
+		rec.target = target
+	}
+}
+
+// auditMiddleware appends an entry to the audit log for every request the handler marked with recordAudit,
+// capturing the actor, client address, and the outcome derived from the response status code.
+// Failing to write the entry is logged but does not affect the response, which has already been sent.
+func auditMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rec := &auditRecord{}
+		wrapped := &responseWriter{ResponseWriter: w, statusCode: http.StatusOK}
+		next.ServeHTTP(wrapped, r.WithContext(context.WithValue(r.Context(), auditContextKey{}, rec)))
+		if rec.action == "" {
+			return
+		}
+
+		entry := data.AuditEntry{
+			Actor:      rec.actor,
+			Action:     rec.action,
+			Target:     rec.target,
+			ClientAddr: r.RemoteAddr,
+			Result:     auditResult(wrapped.statusCode),
+			Status:     wrapped.statusCode,
+		}
+		if _, err := data.AppendAudit(context.WithoutCancel(r.Context()), entry); err != nil {
+			log.Printf("Failed to write audit entry for %s %s: %v", rec.action, rec.target, err)
+		}
+	})
+}
+
+// auditResult classifies an HTTP status code as an audit result.
+func auditResult(statusCode int) string {
+	switch {
+	case statusCode < http.StatusBadRequest:
+		return data.AuditSuccess
+	case statusCode == http.StatusUnauthorized, statusCode == http.StatusForbidden, statusCode == http.StatusTooManyRequests:
+		return data.AuditDenied
+	default:
+		return data.AuditFailure
+	}
+}
+
+// adminActor is recorded for requests authenticated with the admin token.
+const adminActor = "admin"
+
+// adminMiddleware restricts a handler to requests bearing the configured admin token.
+// If no token is configured, the admin API is disabled and every request is rejected with 403.
+func adminMiddleware(token string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if token == "" {
+			http.Error(w, "Admin API is disabled. Set ADMIN_TOKEN to enable it.", http.StatusForbidden)
+			return
+		}
+
+		presented, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(presented), []byte(token)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="admin"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, withActor(r, adminActor))
+	})
+}
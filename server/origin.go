@@ -0,0 +1,121 @@
+package main
+
+import (
+	"mime"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/rihts-4/pasword-mango/config"
+)
+
+// accessPolicy decides which Host headers and browser origins may reach the API, and which CORS
+// headers are sent to allowed origins.
+type accessPolicy struct {
+	hosts   map[string]bool
+	origins map[string]bool
+	methods string
+	headers string
+	maxAge  int
+}
+
+// newAccessPolicy builds the access policy from its configuration.
+func newAccessPolicy(cfg config.Access) *accessPolicy {
+	return &accessPolicy{
+		hosts:   toSet(cfg.AllowedHosts),
+		origins: toSet(cfg.CORS.AllowedOrigins),
+		methods: strings.Join(cfg.CORS.AllowedMethods, ", "),
+		headers: strings.Join(cfg.CORS.AllowedHeaders, ", "),
+		maxAge:  cfg.CORS.MaxAge,
+	}
+}
+
+// allowsHost reports whether the request's Host header names an allowed host, ignoring any port.
+func (p *accessPolicy) allowsHost(r *http.Request) bool {
+	host := r.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.TrimSuffix(strings.Trim(host, "[]"), ".")
+	return p.hosts[strings.ToLower(host)]
+}
+
+// allowsOrigin reports whether a browser origin is explicitly allowed. The opaque "null" origin never is.
+func (p *accessPolicy) allowsOrigin(origin string) bool {
+	return origin != "" && origin != "null" && p.origins[strings.ToLower(origin)]
+}
+
+// hostMiddleware rejects requests whose Host header is not in the allowlist with 421 Misdirected Request.
+// This defeats DNS rebinding, where a malicious site's host name is re-pointed at 127.0.0.1 so the
+// browser treats the API as same-origin.
+func hostMiddleware(p *accessPolicy, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !p.allowsHost(r) {
+			http.Error(w, "Host not allowed", http.StatusMisdirectedRequest)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// corsMiddleware applies the CORS policy. Requests that a browser marks as originating from a web page
+// (through the Origin or Sec-Fetch-Site headers) are rejected with 403 unless their origin is explicitly
+// allowed. Allowed origins receive CORS headers, and their preflight requests are answered directly.
+// Requests from non-browser clients such as the desktop app carry neither header and pass through.
+func corsMiddleware(p *accessPolicy, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" && r.Header.Get("Sec-Fetch-Site") == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if !p.allowsOrigin(origin) {
+			http.Error(w, "Cross-origin request not allowed", http.StatusForbidden)
+			return
+		}
+
+		w.Header().Add("Vary", "Origin")
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			w.Header().Set("Access-Control-Allow-Methods", p.methods)
+			w.Header().Set("Access-Control-Allow-Headers", p.headers)
+			w.Header().Set("Access-Control-Max-Age", strconv.Itoa(p.maxAge))
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		w.Header().Set("Access-Control-Expose-Headers", "X-Password-Score, X-Password-Warning, X-Password-Breaches")
+		next.ServeHTTP(w, r)
+	})
+}
+
+// jsonContentTypeMiddleware rejects POST, PUT, and PATCH requests that carry a body with a Content-Type
+// other than application/json, returning 415 Unsupported Media Type. Browsers can send form and plain-text
+// bodies cross-origin without a preflight; JSON bodies always require one.
+func jsonContentTypeMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodPost, http.MethodPut, http.MethodPatch:
+			if r.ContentLength == 0 {
+				break
+			}
+			mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+			if err != nil || mediaType != "application/json" {
+				http.Error(w, "Content-Type must be application/json", http.StatusUnsupportedMediaType)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// toSet converts a list into a set of lower-cased, trimmed, non-empty items.
+func toSet(items []string) map[string]bool {
+	set := make(map[string]bool, len(items))
+	for _, item := range items {
+		if item = strings.ToLower(strings.TrimSpace(item)); item != "" {
+			set[item] = true
+		}
+	}
+	return set
+}
@@ -0,0 +1,14 @@
+//go:build !linux
+
+package main
+
+import (
+	"errors"
+	"net"
+)
+
+// peerUID is only implemented on Linux. Elsewhere connections are rejected unless
+// UNIX_SOCKET_ALLOWED_UIDS is "*", leaving access control to the socket file's permissions.
+func peerUID(*net.UnixConn) (int, error) {
+	return 0, errors.New("peer credential checks are only supported on Linux")
+}
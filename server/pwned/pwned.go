@@ -0,0 +1,219 @@
+// Package pwned checks passwords against a locally downloaded copy of Have I Been Pwned's Pwned Passwords list,
+// so that nothing about a password ever leaves the machine.
+//
+// The list is the single file written by the PwnedPasswordsDownloader (https://github.com/HaveIBeenPwned/PwnedPasswordsDownloader),
+// ordered by hash, with one "HASH:COUNT" line per password, where HASH is the uppercase hex SHA-1 of the password or,
+// when downloaded with -n, its NTLM hash. Such a file runs to tens of gigabytes, so it is never loaded: each lookup is
+// a binary search over byte offsets, reading a few hundred bytes from each of about forty positions. Lookups use
+// ReadAt and are safe for concurrent use.
+package pwned
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+
+	"golang.org/x/crypto/md4"
+)
+
+// Hash formats of a Pwned Passwords file.
+const (
+	FormatSHA1 = "sha1"
+	FormatNTLM = "ntlm"
+)
+
+// hashLengths maps each format to the length of its hashes in hex characters.
+var hashLengths = map[string]int{FormatSHA1: 40, FormatNTLM: 32}
+
+// maxLineLength bounds a line: a hash, a colon, a count, and a CRLF line ending.
+const maxLineLength = 64
+
+// scanWindow is the size below which the search reads the remaining range at once instead of bisecting it.
+const scanWindow = 4096
+
+// File is an open Pwned Passwords file.
+type File struct {
+	f       *os.File
+	size    int64
+	format  string
+	hashLen int
+}
+
+// Open opens a Pwned Passwords file, detecting its format from the length of the first hash.
+func Open(path string) (*File, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	file := &File{f: f, size: info.Size()}
+
+	first, _, err := file.lineFrom(0)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	hash, _, ok := strings.Cut(string(first), ":")
+	for format, length := range hashLengths {
+		if len(hash) == length {
+			file.format, file.hashLen = format, length
+		}
+	}
+	if !ok || file.format == "" || !isHex(hash) {
+		f.Close()
+		return nil, fmt.Errorf("%s is not a Pwned Passwords file: expected lines of the form HASH:COUNT with SHA-1 or NTLM hashes", path)
+	}
+	return file, nil
+}
+
+// Format returns the hash format of the file, FormatSHA1 or FormatNTLM.
+func (f *File) Format() string {
+	return f.format
+}
+
+// Close closes the file.
+func (f *File) Close() error {
+	return f.f.Close()
+}
+
+// Count returns the number of times the password appears in the breaches the file was built from, or 0 if it
+// does not appear.
+func (f *File) Count(password string) (int, error) {
+	return f.CountHash(Hash(password, f.format))
+}
+
+// CountHash is like Count for a password hash already in the file's format, given in hex.
+func (f *File) CountHash(hash string) (int, error) {
+	target := []byte(strings.ToUpper(hash))
+	if len(target) != f.hashLen {
+		return 0, fmt.Errorf("hash must be %d hex characters", f.hashLen)
+	}
+
+	// Every line that could hold the target starts in [lo, hi)
+	lo, hi := int64(0), f.size
+	for hi-lo > scanWindow {
+		mid := lo + (hi-lo)/2
+		line, start, err := f.lineFrom(mid)
+		if err != nil {
+			return 0, err
+		}
+		if start >= hi {
+			// No line starts in [mid, hi)
+			hi = mid
+			continue
+		}
+		switch c := bytes.Compare(lineHash(line, f.hashLen), target); {
+		case c == 0:
+			return lineCount(line)
+		case c < 0:
+			lo = start + int64(len(line))
+		default:
+			hi = start
+		}
+	}
+
+	// Scan the lines starting in the remaining window
+	buf := make([]byte, min(hi+maxLineLength, f.size)-lo)
+	if _, err := f.f.ReadAt(buf, lo); err != nil && err != io.EOF {
+		return 0, err
+	}
+	for offset := 0; int64(offset) < hi-lo && offset < len(buf); {
+		line := buf[offset:]
+		if end := bytes.IndexByte(line, '\n'); end >= 0 {
+			line = line[:end+1]
+		}
+		if bytes.Equal(lineHash(line, f.hashLen), target) {
+			return lineCount(line)
+		}
+		offset += len(line)
+	}
+	return 0, nil
+}
+
+// lineFrom returns the first line starting at or after offset, including its line ending, and where it starts.
+// At the end of the file it returns an empty line starting at the file's size.
+func (f *File) lineFrom(offset int64) ([]byte, int64, error) {
+	start := offset
+	if offset > 0 {
+		// Skip the rest of the line that offset falls in, unless offset is just after a line ending
+		buf := make([]byte, min(maxLineLength+1, f.size-offset+1))
+		if _, err := f.f.ReadAt(buf, offset-1); err != nil && err != io.EOF {
+			return nil, 0, err
+		}
+		newline := bytes.IndexByte(buf, '\n')
+		if newline < 0 {
+			if offset-1+int64(len(buf)) < f.size {
+				return nil, 0, errors.New("line too long")
+			}
+			return nil, f.size, nil
+		}
+		start = offset + int64(newline)
+	}
+	if start >= f.size {
+		return nil, f.size, nil
+	}
+
+	buf := make([]byte, min(maxLineLength, f.size-start))
+	if _, err := f.f.ReadAt(buf, start); err != nil && err != io.EOF {
+		return nil, 0, err
+	}
+	if end := bytes.IndexByte(buf, '\n'); end >= 0 {
+		buf = buf[:end+1]
+	} else if start+int64(len(buf)) < f.size {
+		return nil, 0, errors.New("line too long")
+	}
+	return buf, start, nil
+}
+
+// lineHash returns the uppercase hash at the start of a line.
+func lineHash(line []byte, hashLen int) []byte {
+	if len(line) < hashLen {
+		return bytes.ToUpper(line)
+	}
+	return bytes.ToUpper(line[:hashLen])
+}
+
+// lineCount parses the count after the colon of a line.
+func lineCount(line []byte) (int, error) {
+	_, count, ok := bytes.Cut(bytes.TrimRight(line, "\r\n"), []byte(":"))
+	if !ok {
+		return 0, fmt.Errorf("malformed line %q", line)
+	}
+	n, err := strconv.Atoi(string(count))
+	if err != nil {
+		return 0, fmt.Errorf("malformed count in line %q", line)
+	}
+	return n, nil
+}
+
+// Hash returns the uppercase hex hash of a password in the given format.
+func Hash(password, format string) string {
+	if format == FormatNTLM {
+		// NTLM is MD4 over the UTF-16LE encoding of the password
+		h := md4.New()
+		for _, unit := range utf16.Encode([]rune(password)) {
+			binary.Write(h, binary.LittleEndian, unit)
+		}
+		return strings.ToUpper(hex.EncodeToString(h.Sum(nil)))
+	}
+	sum := sha1.Sum([]byte(password))
+	return strings.ToUpper(hex.EncodeToString(sum[:]))
+}
+
+// isHex reports whether s is made only of hex digits.
+func isHex(s string) bool {
+	_, err := hex.DecodeString(s)
+	return err == nil
+}
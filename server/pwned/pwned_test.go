@@ -0,0 +1,137 @@
+package pwned
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestHash(t *testing.T) {
+	for _, tt := range []struct{ password, format, want string }{
+		{"password", FormatSHA1, "5BAA61E4C9B93F3F0682250B6CF8331B7EE68FD8"},
+		{"password", FormatNTLM, "8846F7EAEE8FB117AD06BDD830B7586C"},
+		{"", FormatNTLM, "31D6CFE0D16AE931B73C59D7E0C089C0"},
+	} {
+		if got := Hash(tt.password, tt.format); got != tt.want {
+			t.Errorf("Hash(%q, %s) = %s, want %s", tt.password, tt.format, got, tt.want)
+		}
+	}
+}
+
+func TestCount(t *testing.T) {
+	for _, format := range []string{FormatSHA1, FormatNTLM} {
+		for _, lineEnding := range []string{"\n", "\r\n"} {
+			t.Run(fmt.Sprintf("%s %q", format, lineEnding), func(t *testing.T) {
+				// Enough lines that lookups bisect the file before scanning what is left
+				counts := make(map[string]int)
+				for i := range 2000 {
+					counts[Hash(fmt.Sprintf("password%d", i), format)] = i + 1
+				}
+				hashes := sortedHashes(counts)
+				f := openList(t, counts, lineEnding, false)
+				if f.Format() != format {
+					t.Errorf("format %s, want %s", f.Format(), format)
+				}
+
+				for i := range 2000 {
+					password := fmt.Sprintf("password%d", i)
+					if count, err := f.Count(password); err != nil || count != i+1 {
+						t.Fatalf("Count(%q) = %d, %v; want %d", password, count, err, i+1)
+					}
+				}
+				first, last := hashes[0], hashes[len(hashes)-1]
+				for _, hash := range []string{first, last, strings.ToLower(last)} {
+					if count, err := f.CountHash(hash); err != nil || count != counts[strings.ToUpper(hash)] {
+						t.Errorf("CountHash(%s) = %d, %v; want %d", hash, count, err, counts[strings.ToUpper(hash)])
+					}
+				}
+
+				// Hashes before the first line, after the last, and between two lines
+				between := []byte(hashes[1000])
+				between[len(between)-1]++
+				for _, hash := range []string{strings.Repeat("0", len(first)), strings.Repeat("F", len(first)), string(between)} {
+					if _, ok := counts[hash]; ok {
+						continue
+					}
+					if count, err := f.CountHash(hash); err != nil || count != 0 {
+						t.Errorf("CountHash(%s) = %d, %v; want 0", hash, count, err)
+					}
+				}
+				if count, err := f.Count("correct horse battery staple"); err != nil || count != 0 {
+					t.Errorf("Count of an absent password = %d, %v; want 0", count, err)
+				}
+				if _, err := f.CountHash(first[:len(first)-1]); err == nil {
+					t.Error("CountHash accepted a hash of the wrong length")
+				}
+			})
+		}
+	}
+}
+
+func TestCountWithoutFinalLineEnding(t *testing.T) {
+	counts := map[string]int{Hash("password", FormatSHA1): 3861493, Hash("123456", FormatSHA1): 37359195}
+	f := openList(t, counts, "\r\n", true)
+	for password, want := range map[string]int{"password": 3861493, "123456": 37359195, "password1": 0} {
+		if count, err := f.Count(password); err != nil || count != want {
+			t.Errorf("Count(%q) = %d, %v; want %d", password, count, err, want)
+		}
+	}
+}
+
+func TestOpenErrors(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{
+		"empty":      "",
+		"no count":   "5BAA61E4C9B93F3F0682250B6CF8331B7EE68FD8\n",
+		"short hash": "5BAA61E4C9B93F3F0682250B6CF8331:3\n",
+		"not hex":    "XBAA61E4C9B93F3F0682250B6CF8331B7EE68FD8:3\n",
+		"text":       "Pwned Passwords, sorted by hash\n",
+	} {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if f, err := Open(path); err == nil {
+			f.Close()
+			t.Errorf("Open accepted a file that is %s", name)
+		}
+	}
+	if _, err := Open(filepath.Join(dir, "missing")); !os.IsNotExist(err) {
+		t.Errorf("Open of a missing file returned %v", err)
+	}
+}
+
+// openList writes a Pwned Passwords file with the counts of the given hashes, ordered by hash, and opens it.
+func openList(t *testing.T, counts map[string]int, lineEnding string, trimLast bool) *File {
+	t.Helper()
+	var b strings.Builder
+	for _, hash := range sortedHashes(counts) {
+		fmt.Fprintf(&b, "%s:%d%s", hash, counts[hash], lineEnding)
+	}
+	content := b.String()
+	if trimLast {
+		content = strings.TrimSuffix(content, lineEnding)
+	}
+	path := filepath.Join(t.TempDir(), "pwned-passwords.txt")
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	f, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { f.Close() })
+	return f
+}
+
+func sortedHashes(counts map[string]int) []string {
+	hashes := make([]string, 0, len(counts))
+	for hash := range counts {
+		hashes = append(hashes, hash)
+	}
+	slices.Sort(hashes)
+	return hashes
+}
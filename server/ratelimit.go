@@ -0,0 +1,292 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rihts-4/pasword-mango/config"
+	"github.com/rihts-4/pasword-mango/data"
+	"golang.org/x/time/rate"
+)
+
+// Endpoint classes share a rate limit configuration. Each client gets its own bucket per class.
+const (
+	endpointList   = "list"
+	endpointReveal = "reveal"
+	endpointWrite  = "write"
+	endpointAdmin  = "admin"
+)
+
+// bucketConfig describes a token bucket: it refills at Rate tokens per second and holds at most Burst tokens.
+type bucketConfig struct {
+	Rate  rate.Limit
+	Burst int
+}
+
+// bucketIdleTimeout is how long a bucket may go unused before it is discarded.
+const bucketIdleTimeout = 10 * time.Minute
+
+// bucket is a client's token bucket for one endpoint class.
+type bucket struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+	// rejecting is set from a rejected request until the next request is allowed.
+	rejecting bool
+}
+
+// rateLimiter holds per-client, per-endpoint-class token buckets.
+type rateLimiter struct {
+	mu        sync.Mutex
+	configs   map[string]bucketConfig
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+// newRateLimiter builds a rate limiter from the bucket specification configured for each endpoint class.
+func newRateLimiter(cfg config.RateLimits) (*rateLimiter, error) {
+	rl := &rateLimiter{
+		configs: make(map[string]bucketConfig),
+		buckets: make(map[string]*bucket),
+	}
+	for class, spec := range map[string]string{
+		endpointList:   cfg.List,
+		endpointReveal: cfg.Reveal,
+		endpointWrite:  cfg.Write,
+		endpointAdmin:  cfg.Admin,
+	} {
+		limit, err := config.ParseRateLimit(spec)
+		if err != nil {
+			return nil, fmt.Errorf("rate limit for %s: %w", class, err)
+		}
+		rl.configs[class] = bucketConfig{Rate: rate.Every(limit.Period / time.Duration(limit.Count)), Burst: limit.Burst}
+	}
+	return rl, nil
+}
+
+// reserve takes a token from the client's bucket for the endpoint class. It returns zero if the
+// request may proceed, or how long the client must wait before a token becomes available and whether
+// this is the first request rejected since the client was last allowed one.
+func (rl *rateLimiter) reserve(client, class string, now time.Time) (wait time.Duration, first bool) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	if now.Sub(rl.lastSweep) > bucketIdleTimeout {
+		for key, b := range rl.buckets {
+			if now.Sub(b.lastSeen) > bucketIdleTimeout {
+				delete(rl.buckets, key)
+			}
+		}
+		rl.lastSweep = now
+	}
+
+	key := class + "|" + client
+	b, ok := rl.buckets[key]
+	if !ok {
+		cfg := rl.configs[class]
+		b = &bucket{limiter: rate.NewLimiter(cfg.Rate, cfg.Burst)}
+		rl.buckets[key] = b
+	}
+	b.lastSeen = now
+
+	reservation := b.limiter.ReserveN(now, 1)
+	if delay := reservation.DelayFrom(now); delay > 0 {
+		reservation.CancelAt(now)
+		first = !b.rejecting
+		b.rejecting = true
+		return delay, first
+	}
+	b.rejecting = false
+	return 0, false
+}
+
+// rateLimitMiddleware rejects requests with 429 Too Many Requests and a Retry-After header once the
+// client has exhausted its token bucket for the endpoint class that classify assigns to the request.
+// Behind auditMiddleware, the first request rejected in a row is recorded in the audit log; the rest
+// are not, so that a client flooding the server cannot flood the log as well.
+func rateLimitMiddleware(rl *rateLimiter, classify func(*http.Request) string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if wait, first := rl.reserve(clientKey(r), classify(r), time.Now()); wait > 0 {
+			if first {
+				recordAudit(r, data.AuditRateLimit, r.URL.Path)
+			}
+			tooManyRequests(w, wait)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// credentialsEndpoint classifies requests to /credentials: listing, revealing a single site, or writing.
+func credentialsEndpoint(r *http.Request) string {
+	switch {
+	case r.Method != http.MethodGet:
+		return endpointWrite
+	case strings.Trim(strings.TrimPrefix(r.URL.Path, "/credentials"), "/") == "":
+		return endpointList
+	default:
+		return endpointReveal
+	}
+}
+
+// toolEndpoint classifies requests to stateless tools such as /generate, which reveal nothing stored, as list requests.
+func toolEndpoint(*http.Request) string {
+	return endpointList
+}
+
+// writeEndpoint classifies every request as a write request.
+func writeEndpoint(*http.Request) string {
+	return endpointWrite
+}
+
+// revealEndpoint classifies every request as a reveal request, for endpoints such as /export that return passwords.
+func revealEndpoint(*http.Request) string {
+	return endpointReveal
+}
+
+// adminEndpoint classifies every request as an admin request.
+func adminEndpoint(*http.Request) string {
+	return endpointAdmin
+}
+
+// Lockout policy: after lockoutThreshold consecutive failures a client is locked out for
+// lockoutBase, doubling with every further failure up to lockoutMax. Failures older than
+// lockoutForgetAfter no longer count.
+const (
+	lockoutThreshold   = 5
+	lockoutBase        = 30 * time.Second
+	lockoutMax         = time.Hour
+	lockoutForgetAfter = 24 * time.Hour
+)
+
+// lockoutTracker counts failed authentication attempts per client and locks out clients that keep
+// failing. Its state is persisted through the data package so a restart does not reset a lockout.
+type lockoutTracker struct {
+	mu       sync.Mutex
+	loaded   bool
+	lockouts map[string]data.Lockout
+}
+
+func newLockoutTracker() *lockoutTracker {
+	return &lockoutTracker{lockouts: make(map[string]data.Lockout)}
+}
+
+// ensureLoadedLocked loads persisted lockouts on first use, since the database is initialized after
+// handlers are built. The caller must hold t.mu.
+func (t *lockoutTracker) ensureLoadedLocked(ctx context.Context) {
+	if t.loaded {
+		return
+	}
+	lockouts, err := data.LoadLockouts(ctx)
+	if err != nil {
+		log.Printf("Failed to load lockouts, will retry: %v", err)
+		return
+	}
+	for client, lockout := range lockouts {
+		t.lockouts[client] = lockout
+	}
+	t.loaded = true
+}
+
+// lockedFor returns how long the client remains locked out, or zero if it is not locked out.
+func (t *lockoutTracker) lockedFor(ctx context.Context, client string, now time.Time) time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.ensureLoadedLocked(ctx)
+
+	if until := t.lockouts[client].LockedUntil; until.After(now) {
+		return until.Sub(now)
+	}
+	return 0
+}
+
+// recordFailure counts a failed attempt and, past the threshold, locks the client out with an
+// exponentially growing duration.
+func (t *lockoutTracker) recordFailure(ctx context.Context, client string, now time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.ensureLoadedLocked(ctx)
+
+	lockout := t.lockouts[client]
+	if now.Sub(lockout.LastFailure) > lockoutForgetAfter {
+		lockout.Failures = 0
+	}
+	lockout.Client = client
+	lockout.Failures++
+	lockout.LastFailure = now
+	if lockout.Failures >= lockoutThreshold {
+		exponent := float64(lockout.Failures - lockoutThreshold)
+		duration := time.Duration(math.Min(float64(lockoutBase)*math.Pow(2, exponent), float64(lockoutMax)))
+		lockout.LockedUntil = now.Add(duration)
+		log.Printf("Locking out client %s for %s after %d failed attempts", client, duration, lockout.Failures)
+	}
+	t.lockouts[client] = lockout
+
+	if err := data.SaveLockout(ctx, lockout); err != nil {
+		log.Printf("Failed to persist lockout: %v", err)
+	}
+}
+
+// recordSuccess clears the failure count for a client after a successful attempt.
+func (t *lockoutTracker) recordSuccess(ctx context.Context, client string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if _, ok := t.lockouts[client]; !ok {
+		return
+	}
+	delete(t.lockouts, client)
+	if err := data.DeleteLockout(ctx, client); err != nil {
+		log.Printf("Failed to clear lockout: %v", err)
+	}
+}
+
+// lockoutMiddleware rejects requests from locked-out clients with 429 Too Many Requests and a
+// Retry-After header. Responses of 401 Unauthorized count as failed attempts; any successful
+// response resets the client's count.
+func lockoutMiddleware(t *lockoutTracker, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		client := clientKey(r)
+		ctx := context.WithoutCancel(r.Context())
+		if wait := t.lockedFor(ctx, client, time.Now()); wait > 0 {
+			tooManyRequests(w, wait)
+			return
+		}
+
+		wrapped := &responseWriter{ResponseWriter: w, statusCode: http.StatusOK}
+		next.ServeHTTP(wrapped, r)
+		switch {
+		case wrapped.statusCode == http.StatusUnauthorized:
+			t.recordFailure(ctx, client, time.Now())
+		case wrapped.statusCode < http.StatusBadRequest:
+			t.recordSuccess(ctx, client)
+		}
+	})
+}
+
+// tooManyRequests writes a 429 response telling the client to retry after the given wait, rounded up to whole seconds.
+func tooManyRequests(w http.ResponseWriter, wait time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	http.Error(w, "Too many requests", http.StatusTooManyRequests)
+}
+
+// clientKey identifies the client for rate limiting and lockouts: by the identity established for its connection,
+// such as a Unix socket peer's user ID or a client certificate's device name, and otherwise by its IP address,
+// ignoring the source port.
+func clientKey(r *http.Request) string {
+	if actor := requestActor(r); actor != anonymousActor {
+		return actor
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil || host == "" {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/rihts-4/pasword-mango/config"
+	"github.com/rihts-4/pasword-mango/data"
+)
+
+func TestRateLimitIsAudited(t *testing.T) {
+	firestore.Reset()
+	limiter, err := newRateLimiter(config.RateLimits{List: "1/1h:1", Reveal: "1/1h:1", Write: "1/1h:1", Admin: "1/1h:1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The handler authenticates its requests like adminMiddleware, inside the rate limiter
+	handler := auditMiddleware(rateLimitMiddleware(limiter, adminEndpoint, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		recordAudit(withActor(r, adminActor), data.AuditScan, "vault")
+	})))
+
+	for i, want := range []int{http.StatusOK, http.StatusTooManyRequests, http.StatusTooManyRequests} {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/admin/breaches", nil))
+		if w.Code != want {
+			t.Fatalf("request %d got status %d, want %d", i+1, w.Code, want)
+		}
+	}
+
+	// Only the first of the rejected requests is recorded, newest first
+	entries, err := data.QueryAudit(context.Background(), data.AuditFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("audit log holds %d entries, want 2: %+v", len(entries), entries)
+	}
+	limited, allowed := entries[0], entries[1]
+	if limited.Action != data.AuditRateLimit || limited.Target != "/admin/breaches" || limited.Result != data.AuditDenied ||
+		limited.Status != http.StatusTooManyRequests || limited.Actor != anonymousActor {
+		t.Errorf("rejected request recorded as %+v", limited)
+	}
+	if allowed.Action != data.AuditScan || allowed.Actor != adminActor || allowed.Result != data.AuditSuccess {
+		t.Errorf("allowed request recorded as %+v, want a scan by %s", allowed, adminActor)
+	}
+}
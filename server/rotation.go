@@ -0,0 +1,299 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/rihts-4/pasword-mango/config"
+	"github.com/rihts-4/pasword-mango/data"
+)
+
+// maxRotationDays bounds a rotation policy's period at ten years.
+const maxRotationDays = 3650
+
+// rotationAPI serves the rotation policies and the passwords due for rotation.
+type rotationAPI struct {
+	// notice is how long before expiry a password is listed as due.
+	notice time.Duration
+}
+
+// newRotationAPI configures the rotation endpoints.
+func newRotationAPI(cfg config.Rotation) *rotationAPI {
+	return &rotationAPI{notice: cfg.Notice.Std()}
+}
+
+// dueHandler serves GET /rotation/due, which lists the credentials whose passwords expire within the configured
+// notice period, or have expired, as data.RotationItem values, soonest first. The within query parameter, a Go
+// duration such as "720h", overrides the notice period.
+//
+// The handler returns 400 for an invalid within, 500 for data errors, and 405 for methods other than GET.
+func (a *rotationAPI) dueHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	recordAudit(r, data.AuditList, "rotation")
+	within := a.notice
+	if value := r.URL.Query().Get("within"); value != "" {
+		parsed, err := time.ParseDuration(value)
+		if err != nil || parsed < 0 {
+			http.Error(w, "within must be a non-negative duration such as 720h", http.StatusBadRequest)
+			return
+		}
+		within = parsed
+	}
+	items, err := data.RotationDue(r.Context(), within)
+	if err != nil {
+		log.Printf("Failed to list passwords due for rotation: %v", err)
+		http.Error(w, "Failed to list passwords due for rotation", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(items)
+}
+
+// policiesHandler serves the rotation policies under /rotation/policies:
+//
+// - GET /rotation/policies: lists every policy, site policies first.
+// - PUT /rotation/policies/{site|tag}/{name}: creates or replaces a policy from a JSON body with `days`, and returns the stored policy.
+// - DELETE /rotation/policies/{site|tag}/{name}: deletes a policy.
+//
+// The handler returns 400 for malformed requests, 404 for an unknown site or policy, 500 for data errors, and 405
+// for unsupported methods.
+func (a *rotationAPI) policiesHandler(w http.ResponseWriter, r *http.Request) {
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, "/rotation/policies"), "/")
+	ctx := r.Context()
+
+	if path == "" {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		recordAudit(r, data.AuditList, "rotation/policies")
+		policies, err := data.RotationPolicies(ctx)
+		if err != nil {
+			http.Error(w, "Failed to retrieve rotation policies", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(policies)
+		return
+	}
+
+	scope, name, ok := strings.Cut(path, "/")
+	if !ok || name == "" || (scope != data.RotationScopeSite && scope != data.RotationScopeTag) {
+		http.Error(w, "Policy path must be /rotation/policies/site/{site} or /rotation/policies/tag/{tag}", http.StatusBadRequest)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPut:
+		recordAudit(r, data.AuditUpdate, "rotation/"+path)
+		var payload struct {
+			Days int `json:"days"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		if payload.Days <= 0 || payload.Days > maxRotationDays {
+			http.Error(w, fmt.Sprintf("days must be between 1 and %d", maxRotationDays), http.StatusBadRequest)
+			return
+		}
+		policy, err := data.SetRotationPolicy(ctx, data.RotationPolicy{Scope: scope, Name: name, Days: payload.Days})
+		switch {
+		case errors.Is(err, data.ErrNotFound):
+			http.Error(w, "Credentials not found", http.StatusNotFound)
+			return
+		case errors.Is(err, data.ErrInvalidPolicy):
+			http.Error(w, "Invalid tag", http.StatusBadRequest)
+			return
+		case err != nil:
+			http.Error(w, "Failed to store rotation policy", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(policy)
+
+	case http.MethodDelete:
+		recordAudit(r, data.AuditDelete, "rotation/"+path)
+		if err := data.DeleteRotationPolicy(ctx, scope, name); err != nil {
+			if errors.Is(err, data.ErrNotFound) {
+				http.Error(w, "Rotation policy not found", http.StatusNotFound)
+				return
+			}
+			http.Error(w, "Failed to delete rotation policy", http.StatusInternalServerError)
+			return
+		}
+		fmt.Fprintln(w, "Rotation policy deleted successfully.")
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// rotationEndpoint classifies requests to /rotation: reads are list requests, changes to policies are writes.
+func rotationEndpoint(r *http.Request) string {
+	if r.Method == http.MethodGet {
+		return endpointList
+	}
+	return endpointWrite
+}
+
+// webhookTimeout bounds a reminder webhook delivery.
+const webhookTimeout = 10 * time.Second
+
+// rotationReminder is a reminder for a password due for rotation, as logged and sent to the webhook.
+type rotationReminder struct {
+	data.RotationItem
+	// Status is "due" before the password expires and "overdue" after.
+	Status string `json:"status"`
+}
+
+// rotationReminders periodically looks for passwords due for rotation and sends a reminder for each: once when it
+// comes within the notice period, and once more when it expires. Reminders are logged and, if a webhook is
+// configured, POSTed to it. What has been sent is only remembered in memory, so a restart sends the reminders for
+// passwords still due again.
+type rotationReminders struct {
+	notice   time.Duration
+	interval time.Duration
+	webhook  string
+	client   *http.Client
+	// sent maps each site to the status and expiry it was last reminded of.
+	sent map[string]string
+}
+
+// newRotationReminders configures the rotation reminders.
+func newRotationReminders(cfg config.Rotation) *rotationReminders {
+	return &rotationReminders{
+		notice:   cfg.Notice.Std(),
+		interval: cfg.CheckInterval.Std(),
+		webhook:  cfg.Webhook,
+		client:   &http.Client{Timeout: webhookTimeout},
+		sent:     make(map[string]string),
+	}
+}
+
+// watch checks for passwords due for rotation at startup and then every check interval, until done is closed. It
+// does nothing if the check interval is 0.
+func (rr *rotationReminders) watch(done <-chan struct{}) {
+	if rr.interval <= 0 {
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		<-done
+		cancel()
+	}()
+
+	ticker := time.NewTicker(rr.interval)
+	defer ticker.Stop()
+	for {
+		rr.check(ctx)
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// check sends the reminders that are due. If the webhook delivery fails, the reminders are not marked as sent, so
+// they are logged and delivered again on the next check.
+func (rr *rotationReminders) check(ctx context.Context) {
+	items, err := data.RotationDue(ctx, rr.notice)
+	if err != nil {
+		if ctx.Err() == nil {
+			log.Printf("Failed to check for passwords due for rotation: %v", err)
+		}
+		return
+	}
+
+	due := make(map[string]bool, len(items))
+	var reminders []rotationReminder
+	for _, item := range items {
+		due[item.Site] = true
+		reminder := rotationReminder{RotationItem: item, Status: "due"}
+		if item.Overdue {
+			reminder.Status = "overdue"
+		}
+		if rr.sent[item.Site] == reminder.key() {
+			continue
+		}
+		reminders = append(reminders, reminder)
+	}
+	// Forget the sites that were rotated, or whose policy was removed
+	for site := range rr.sent {
+		if !due[site] {
+			delete(rr.sent, site)
+		}
+	}
+	if len(reminders) == 0 {
+		return
+	}
+
+	for _, reminder := range reminders {
+		if reminder.Overdue {
+			log.Printf("Rotation reminder: the password for site %s (%s) expired on %s under the %d-day policy for %s",
+				reminder.Site, reminder.Username, reminder.ExpiresAt.Format(time.DateOnly), reminder.Policy.Days, reminder.Policy)
+		} else {
+			log.Printf("Rotation reminder: the password for site %s (%s) expires on %s under the %d-day policy for %s",
+				reminder.Site, reminder.Username, reminder.ExpiresAt.Format(time.DateOnly), reminder.Policy.Days, reminder.Policy)
+		}
+	}
+	if rr.webhook != "" {
+		if err := rr.deliver(ctx, reminders); err != nil {
+			log.Printf("Failed to deliver %d rotation reminders to the webhook: %v", len(reminders), err)
+			return
+		}
+	}
+	for _, reminder := range reminders {
+		rr.sent[reminder.Site] = reminder.key()
+	}
+}
+
+// key identifies what a reminder was about, so that it is sent only once.
+func (r rotationReminder) key() string {
+	return r.Status + " " + r.ExpiresAt.Format(time.RFC3339)
+}
+
+// deliver POSTs reminders to the webhook as {"event": "rotation", "reminders": [...]}. Any status other than 2xx
+// is a failure.
+func (rr *rotationReminders) deliver(ctx context.Context, reminders []rotationReminder) error {
+	body, err := json.Marshal(struct {
+		Event     string             `json:"event"`
+		Reminders []rotationReminder `json:"reminders"`
+	}{"rotation", reminders})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, rr.webhook, bytes.NewReader(body))
+	if err != nil {
+		// The URL may hold a secret, so the error, which repeats it, is not returned
+		return errors.New("invalid webhook URL")
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "pasword-mango/"+version)
+	resp, err := rr.client.Do(req)
+	if err != nil {
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			return urlErr.Err
+		}
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	gcfirestore "cloud.google.com/go/firestore"
+
+	"github.com/rihts-4/pasword-mango/data"
+)
+
+// webhookRecorder is a reminder webhook that records what it receives, or fails while failing is set.
+type webhookRecorder struct {
+	mu        sync.Mutex
+	failing   bool
+	reminders [][]string
+}
+
+func (wr *webhookRecorder) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var payload struct {
+		Event     string             `json:"event"`
+		Reminders []rotationReminder `json:"reminders"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil || payload.Event != "rotation" {
+		http.Error(w, "unexpected payload", http.StatusBadRequest)
+		return
+	}
+	wr.mu.Lock()
+	defer wr.mu.Unlock()
+	if wr.failing {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	var received []string
+	for _, reminder := range payload.Reminders {
+		received = append(received, reminder.Status+" "+reminder.Site)
+	}
+	slices.Sort(received)
+	wr.reminders = append(wr.reminders, received)
+}
+
+func (wr *webhookRecorder) setFailing(failing bool) {
+	wr.mu.Lock()
+	defer wr.mu.Unlock()
+	wr.failing = failing
+}
+
+// delivered returns the reminders received since the last call, one slice per delivery.
+func (wr *webhookRecorder) delivered() [][]string {
+	wr.mu.Lock()
+	defer wr.mu.Unlock()
+	delivered := wr.reminders
+	wr.reminders = nil
+	return delivered
+}
+
+func TestRotationRemindersSentOnce(t *testing.T) {
+	firestore.Reset()
+	ctx := context.Background()
+	raw, err := gcfirestore.NewClient(ctx, "pasword-mango-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer raw.Close()
+	now := time.Now().UTC()
+	changed := func(site string, daysAgo int) {
+		t.Helper()
+		creds := map[string]any{"username": "alice", "password": "unused", "tags": []string{"work"}, "passwordChangedAt": now.AddDate(0, 0, -daysAgo)}
+		if _, err := raw.Collection("credentials").Doc(site).Set(ctx, creds); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := data.SetRotationPolicy(ctx, data.RotationPolicy{Scope: data.RotationScopeTag, Name: "work", Days: 30}); err != nil {
+		t.Fatal(err)
+	}
+
+	webhook := &webhookRecorder{}
+	server := httptest.NewServer(webhook)
+	defer server.Close()
+	rr := &rotationReminders{notice: 7 * 24 * time.Hour, webhook: server.URL, client: server.Client(), sent: make(map[string]string)}
+	checkDelivered := func(step string, want ...[]string) {
+		t.Helper()
+		rr.check(ctx)
+		if got := webhook.delivered(); !slices.EqualFunc(got, want, slices.Equal[[]string]) {
+			t.Errorf("%s: delivered %q, want %q", step, got, want)
+		}
+	}
+
+	changed("expiring.com", 27)
+	changed("expired.com", 40)
+	changed("later.com", 10)
+	checkDelivered("first check", []string{"due expiring.com", "overdue expired.com"})
+	checkDelivered("second check")
+
+	// A password that was due and has now expired is reminded of once more
+	changed("expiring.com", 31)
+	checkDelivered("after expiring", []string{"overdue expiring.com"})
+	checkDelivered("after expiring, again")
+
+	// Reminders that could not be delivered are sent again on the next check
+	changed("later.com", 25)
+	webhook.setFailing(true)
+	checkDelivered("with the webhook failing")
+	webhook.setFailing(false)
+	checkDelivered("with the webhook back", []string{"due later.com"})
+
+	// A rotated password is forgotten, so that it is reminded of again when it comes due under the same expiry
+	changed("expired.com", 0)
+	checkDelivered("after rotating")
+	if _, ok := rr.sent["expired.com"]; ok {
+		t.Error("the rotated site is still remembered as reminded of")
+	}
+	changed("expired.com", 40)
+	checkDelivered("after the rotation was undone", []string{"overdue expired.com"})
+}
@@ -7,43 +7,98 @@ import (
 	"os"
 	"os/signal"
 	"syscall"
-	"time"
 
+	"github.com/rihts-4/pasword-mango/config"
 	"github.com/rihts-4/pasword-mango/data"
 )
 
-// run starts the HTTP server, initializes the database, waits for SIGINT or SIGTERM to trigger a graceful shutdown with a 5-second timeout, and closes the database connection.
-func run(ctx context.Context, server *http.Server) {
-	err := data.InitDB(ctx)
+// run starts the HTTP server, initializes the database, waits for SIGINT or SIGTERM to trigger a graceful shutdown with the configured timeout, and closes the database connection.
+//
+// The server listens on every configured address: TCP sockets, Unix domain sockets, and sockets passed by systemd socket activation.
+// If TLS is enabled, TCP listeners serve HTTPS with the certificate managed by certs; SIGHUP reloads the certificate from disk.
+// While the server runs, reminders checks for passwords due for rotation, backups takes the scheduled backups, and shares purges expired shares in the background.
+func run(ctx context.Context, cfg config.Config, server *http.Server, certs *certManager, reminders *rotationReminders, backups *scheduledBackups, shares *sharesAPI) {
+	err := data.InitDB(ctx, cfg.Storage, cfg.Key)
 	if err != nil {
 		log.Fatalf("Failed to initialize database: %v", err)
 	}
 	log.Println("Database initialized successfully.")
 	defer data.CloseDB()
 
-	// Start the server in a goroutine
-	go func() {
-		log.Printf("Server starting on %s", server.Addr)
-		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			log.Fatalf("Could not listen on %s: %v\n", server.Addr, err)
+	tlsSettings, err := newTLSSettings(cfg.TLS)
+	if err != nil {
+		log.Fatalf("Invalid TLS configuration: %v", err)
+	}
+	done := make(chan struct{})
+	defer close(done)
+	if tlsSettings.Enabled {
+		if err := certs.configure(tlsSettings); err != nil {
+			log.Fatalf("Failed to configure TLS: %v", err)
 		}
-	}()
+		server.TLSConfig = certs.tlsConfig()
+		go certs.watch(done)
+	}
+	go reminders.watch(done)
+	go backups.watch(done)
+	go shares.watch(done)
+
+	specs, err := config.ParseListen(cfg.Listen)
+	if err != nil {
+		log.Fatalf("Invalid listen configuration: %v", err)
+	}
+	unixSettings, err := newUnixSocketSettings(cfg.UnixSocket)
+	if err != nil {
+		log.Fatalf("Invalid Unix socket configuration: %v", err)
+	}
+	listeners, err := openListeners(specs, unixSettings)
+	if err != nil {
+		log.Fatalf("Failed to open listeners: %v", err)
+	}
+	server.ConnContext = connContext
+
+	// Start serving each listener in its own goroutine
+	for _, l := range listeners {
+		go func() {
+			var err error
+			if tlsSettings.Enabled && l.useTLS {
+				log.Printf("Server starting on %s (HTTPS)", l.name)
+				err = server.ServeTLS(l, "", "")
+			} else {
+				log.Printf("Server starting on %s", l.name)
+				err = server.Serve(l)
+			}
+			if err != nil && err != http.ErrServerClosed {
+				log.Fatalf("Could not serve on %s: %v\n", l.name, err)
+			}
+		}()
+	}
 
-	// Set up a channel to listen for OS signals for graceful shutdown
+	// Set up a channel to listen for OS signals for graceful shutdown; SIGHUP reloads the TLS certificate
 	stop := make(chan os.Signal, 1)
-	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
-	<-stop // Wait for a signal
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+	for sig := range stop { // Wait for a signal
+		if sig != syscall.SIGHUP {
+			break
+		}
+		if !tlsSettings.Enabled {
+			continue
+		}
+		log.Println("Reloading TLS certificate...")
+		if err := certs.reload(); err != nil {
+			log.Printf("Keeping previous TLS certificate: %v", err)
+		}
+	}
 
 	log.Println("Shutting down server...")
 
 	// Create a context with a timeout for the shutdown
-	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.Timeouts.Shutdown.Std())
 	defer cancel()
 
-	// Attempt to gracefully shut down the server
+	// Attempt to gracefully shut down the server; this also closes the listeners and removes Unix socket files
 	if err := server.Shutdown(shutdownCtx); err != nil {
 		log.Fatalf("Server shutdown failed: %v", err)
 	}
 
 	log.Println("Server and database connection shut down gracefully.")
-}
\ No newline at end of file
+}
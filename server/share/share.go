@@ -0,0 +1,146 @@
+// Package share seals the content of one-time share links. A share is encrypted with AES-256-GCM under a random key
+// that only ever appears in the fragment of the share's link, which browsers do not send to the server, so the
+// server stores nothing it can decrypt. A link has the form
+//
+//	https://vault.example.com/s/ID#KEY
+//
+// where ID is the share's random identifier and KEY the base64url-encoded key. The sealed form is the 12-byte GCM
+// nonce followed by the ciphertext and tag, with the share ID as additional data, so that a ciphertext cannot be
+// moved to another share. The share page decrypts it with WebCrypto the same way.
+package share
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// KeySize is the size of a share key.
+const KeySize = 32
+
+// idSize is the number of random bytes in a share ID, which is hard to guess on its own, though only the key keeps
+// the content secret.
+const idSize = 16
+
+// PagePath is the path under which share links point at a share's page: PagePath + ID.
+const PagePath = "/s/"
+
+// additionalDataPrefix is prepended to the share ID to form the GCM additional data.
+const additionalDataPrefix = "pasword-mango share "
+
+// ErrInvalidLink is returned by ParseLink for a link that is not a share link.
+var ErrInvalidLink = errors.New("not a share link")
+
+// ErrDecrypt is returned by Open when the content cannot be decrypted, because the key is wrong or the ciphertext
+// was altered.
+var ErrDecrypt = errors.New("share cannot be decrypted with this key")
+
+// Content is what a share holds: the credentials of a site, or free text.
+type Content struct {
+	Site     string `json:"site,omitempty"`
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+	Text     string `json:"text,omitempty"`
+}
+
+// NewID returns a random share ID, safe to use in a URL path.
+func NewID() (string, error) {
+	id := make([]byte, idSize)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(id), nil
+}
+
+// ValidID reports whether id has the form of a share ID returned by NewID.
+func ValidID(id string) bool {
+	decoded, err := base64.RawURLEncoding.DecodeString(id)
+	return err == nil && len(decoded) == idSize
+}
+
+// NewKey returns a random share key.
+func NewKey() ([]byte, error) {
+	key := make([]byte, KeySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// Seal encrypts content for the share with the given ID.
+func Seal(key []byte, id string, content Content) ([]byte, error) {
+	plaintext, err := json.Marshal(content)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, []byte(additionalDataPrefix+id)), nil
+}
+
+// Open decrypts what Seal returned for the share with the given ID.
+func Open(key []byte, id string, sealed []byte) (Content, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return Content{}, err
+	}
+	if len(sealed) < aead.NonceSize()+aead.Overhead() {
+		return Content{}, ErrDecrypt
+	}
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	plaintext, err := aead.Open(nil, nonce, ciphertext, []byte(additionalDataPrefix+id))
+	if err != nil {
+		return Content{}, ErrDecrypt
+	}
+	var content Content
+	if err := json.Unmarshal(plaintext, &content); err != nil {
+		return Content{}, fmt.Errorf("invalid share content: %v", err)
+	}
+	return content, nil
+}
+
+// newAEAD returns AES-256-GCM with the given key.
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	if len(key) != KeySize {
+		return nil, fmt.Errorf("share key must be %d bytes", KeySize)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// Link returns the link to a share under baseURL, such as "https://vault.example.com", with the key in its fragment.
+func Link(baseURL, id string, key []byte) string {
+	return strings.TrimRight(baseURL, "/") + PagePath + id + "#" + base64.RawURLEncoding.EncodeToString(key)
+}
+
+// ParseLink splits a share link into the base URL of its server, the share ID, and the key.
+func ParseLink(link string) (baseURL, id string, key []byte, err error) {
+	u, err := url.Parse(strings.TrimSpace(link))
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "", "", nil, ErrInvalidLink
+	}
+	prefix, id, ok := strings.Cut(u.EscapedPath(), PagePath)
+	if !ok || id == "" || strings.Contains(id, "/") {
+		return "", "", nil, ErrInvalidLink
+	}
+	key, err = base64.RawURLEncoding.DecodeString(u.Fragment)
+	if err != nil || len(key) != KeySize {
+		return "", "", nil, fmt.Errorf("%w: the key after # is missing or damaged", ErrInvalidLink)
+	}
+	return u.Scheme + "://" + u.Host + prefix, id, key, nil
+}
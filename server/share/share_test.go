@@ -0,0 +1,134 @@
+package share
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestSealOpen(t *testing.T) {
+	key, err := NewKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, err := NewID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	content := Content{Site: "example.com", Username: "alice", Password: "correct horse battery staple"}
+	sealed, err := Seal(key, id, content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(sealed, []byte(content.Password)) {
+		t.Fatal("the sealed share contains the password")
+	}
+	if opened, err := Open(key, id, sealed); err != nil || opened != content {
+		t.Fatalf("Open = %+v, %v; want %+v", opened, err, content)
+	}
+
+	otherKey, err := NewKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherID, err := NewID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tampered := bytes.Clone(sealed)
+	tampered[len(tampered)-1] ^= 1
+	for name, open := range map[string]func() (Content, error){
+		"wrong key":                      func() (Content, error) { return Open(otherKey, id, sealed) },
+		"ciphertext moved to another ID": func() (Content, error) { return Open(key, otherID, sealed) },
+		"altered ciphertext":             func() (Content, error) { return Open(key, id, tampered) },
+		"truncated ciphertext":           func() (Content, error) { return Open(key, id, sealed[:20]) },
+		"empty ciphertext":               func() (Content, error) { return Open(key, id, nil) },
+	} {
+		if opened, err := open(); !errors.Is(err, ErrDecrypt) {
+			t.Errorf("%s: Open = %+v, %v; want ErrDecrypt", name, opened, err)
+		}
+	}
+
+	if _, err := Seal(key[:16], id, content); err == nil {
+		t.Error("Seal accepted a 16-byte key")
+	}
+	if _, err := Open(key[:16], id, sealed); err == nil || errors.Is(err, ErrDecrypt) {
+		t.Errorf("Open with a 16-byte key returned %v, want a key size error", err)
+	}
+}
+
+func TestSealIsRandomized(t *testing.T) {
+	key, err := NewKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, err := Seal(key, "id", Content{Text: "hello"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := Seal(key, "id", Content{Text: "hello"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(first, second) {
+		t.Error("sealing the same content twice gave the same ciphertext")
+	}
+}
+
+func TestID(t *testing.T) {
+	id, err := NewID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ValidID(id) {
+		t.Errorf("ValidID(%q) = false for a new ID", id)
+	}
+	for _, id := range []string{"", "abc", id + "A", id[:len(id)-1], "AAAAAAAAAAAAAAAAAAAAA+", "../../etc/passwd"} {
+		if ValidID(id) {
+			t.Errorf("ValidID(%q) = true", id)
+		}
+	}
+}
+
+func TestParseLink(t *testing.T) {
+	key, err := NewKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, err := NewID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for base, want := range map[string]string{
+		"https://vault.example.com":          "https://vault.example.com",
+		"https://vault.example.com/":         "https://vault.example.com",
+		"http://localhost:8080":              "http://localhost:8080",
+		"https://example.com/vault":          "https://example.com/vault",
+		"https://example.com/path%20with/sp": "https://example.com/path%20with/sp",
+	} {
+		link := Link(base, id, key)
+		gotBase, gotID, gotKey, err := ParseLink(" " + link + "\n")
+		if err != nil || gotBase != want || gotID != id || !bytes.Equal(gotKey, key) {
+			t.Errorf("ParseLink(%q) = %q, %q, %x, %v; want %q, %q, %x", link, gotBase, gotID, gotKey, err, want, id, key)
+		}
+	}
+
+	link := Link("https://vault.example.com", id, key)
+	for _, bad := range []string{
+		"",
+		"vault.example.com/s/" + id,
+		"ftp://vault.example.com/s/" + id,
+		"https:///s/" + id,
+		"https://vault.example.com/shares/" + id,
+		"https://vault.example.com/s/",
+		"https://vault.example.com/s/" + id + "/more",
+		"https://vault.example.com/s/" + id,
+		link[:len(link)-1],
+		link + "A",
+		link + "!",
+	} {
+		if _, _, _, err := ParseLink(bad); !errors.Is(err, ErrInvalidLink) {
+			t.Errorf("ParseLink(%q) returned %v, want ErrInvalidLink", bad, err)
+		}
+	}
+}
@@ -0,0 +1,383 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/rihts-4/pasword-mango/config"
+	"github.com/rihts-4/pasword-mango/data"
+	"github.com/rihts-4/pasword-mango/share"
+)
+
+// maxShareTextLength bounds the free text of a share.
+const maxShareTextLength = 64 << 10
+
+// sharesAPI serves one-time share links: POST /shares creates them, and the share page under share.PagePath opens
+// them. The server encrypts a share with a random key, returns the key in the fragment of the share's link, and
+// forgets it, so it only stores ciphertext; the page decrypts it in the recipient's browser.
+type sharesAPI struct {
+	baseURL       string
+	defaultTTL    time.Duration
+	maxTTL        time.Duration
+	maxViews      int
+	sweepInterval time.Duration
+}
+
+// newSharesAPI configures the share endpoints.
+func newSharesAPI(cfg config.Share) *sharesAPI {
+	return &sharesAPI{
+		baseURL:       strings.TrimRight(cfg.BaseURL, "/"),
+		defaultTTL:    cfg.DefaultTTL.Std(),
+		maxTTL:        cfg.MaxTTL.Std(),
+		maxViews:      cfg.MaxViews,
+		sweepInterval: cfg.SweepInterval.Std(),
+	}
+}
+
+// sharesHandler serves the share API under /shares:
+//
+// - POST /shares: creates a share from a JSON body with either the `site` whose username and password to share, or
+// free `text`, and optionally `maxViews` (default 1) and a `ttl` such as "1h" (default the configured default TTL).
+// It returns 201 with the share's `id`, its `url` with the key in the fragment, `maxViews`, and `expiresAt`.
+// - DELETE /shares/{id}: deletes a share before it is spent.
+//
+// The handler returns 400 for malformed requests, 404 for an unknown site or share, 500 for data errors, and 405
+// for unsupported methods.
+func (a *sharesAPI) sharesHandler(w http.ResponseWriter, r *http.Request) {
+	id := strings.Trim(strings.TrimPrefix(r.URL.Path, "/shares"), "/")
+	switch {
+	case r.Method == http.MethodPost && id == "":
+		a.create(w, r)
+	case r.Method == http.MethodDelete && id != "":
+		recordAudit(r, data.AuditDelete, "share")
+		if !share.ValidID(id) {
+			http.Error(w, "Share not found", http.StatusNotFound)
+			return
+		}
+		if err := data.DeleteShare(r.Context(), id); err != nil {
+			if errors.Is(err, data.ErrShareNotFound) {
+				http.Error(w, "Share not found", http.StatusNotFound)
+				return
+			}
+			log.Printf("Failed to delete share: %v", err)
+			http.Error(w, "Failed to delete share", http.StatusInternalServerError)
+			return
+		}
+		fmt.Fprintln(w, "Share deleted successfully.")
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// create serves POST /shares.
+func (a *sharesAPI) create(w http.ResponseWriter, r *http.Request) {
+	recordAudit(r, data.AuditShare, "")
+	var payload struct {
+		Site     string `json:"site"`
+		Text     string `json:"text"`
+		MaxViews int    `json:"maxViews"`
+		TTL      string `json:"ttl"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	payload.Site = strings.TrimSpace(payload.Site)
+	recordAudit(r, data.AuditShare, payload.Site)
+	if (payload.Site == "") == (payload.Text == "") {
+		http.Error(w, "Exactly one of site and text is required", http.StatusBadRequest)
+		return
+	}
+	if len(payload.Text) > maxShareTextLength {
+		http.Error(w, fmt.Sprintf("text must not exceed %d bytes", maxShareTextLength), http.StatusBadRequest)
+		return
+	}
+	if payload.MaxViews == 0 {
+		payload.MaxViews = 1
+	}
+	if payload.MaxViews < 1 || payload.MaxViews > a.maxViews {
+		http.Error(w, fmt.Sprintf("maxViews must be between 1 and %d", a.maxViews), http.StatusBadRequest)
+		return
+	}
+	ttl := a.defaultTTL
+	if payload.TTL != "" {
+		parsed, err := time.ParseDuration(payload.TTL)
+		if err != nil || parsed <= 0 || parsed > a.maxTTL {
+			http.Error(w, fmt.Sprintf("ttl must be a positive duration such as 1h, up to %s", a.maxTTL), http.StatusBadRequest)
+			return
+		}
+		ttl = parsed
+	}
+
+	content := share.Content{Text: payload.Text}
+	if payload.Site != "" {
+		creds, found := data.Retrieve(r.Context(), payload.Site)
+		if !found {
+			http.Error(w, "Credentials not found", http.StatusNotFound)
+			return
+		}
+		content = share.Content{Site: payload.Site, Username: creds.Username, Password: creds.Password}
+	}
+
+	id, err := share.NewID()
+	if err != nil {
+		http.Error(w, "Failed to create share", http.StatusInternalServerError)
+		return
+	}
+	key, err := share.NewKey()
+	if err != nil {
+		http.Error(w, "Failed to create share", http.StatusInternalServerError)
+		return
+	}
+	sealed, err := share.Seal(key, id, content)
+	if err != nil {
+		log.Printf("Failed to encrypt share: %v", err)
+		http.Error(w, "Failed to create share", http.StatusInternalServerError)
+		return
+	}
+	now := time.Now().UTC()
+	stored := data.Share{
+		Ciphertext: sealed,
+		Site:       payload.Site,
+		MaxViews:   payload.MaxViews,
+		CreatedAt:  now,
+		ExpiresAt:  now.Add(ttl),
+	}
+	if err := data.CreateShare(r.Context(), id, stored); err != nil {
+		log.Printf("Failed to create share: %v", err)
+		http.Error(w, "Failed to create share", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(struct {
+		ID        string    `json:"id"`
+		URL       string    `json:"url"`
+		MaxViews  int       `json:"maxViews"`
+		ExpiresAt time.Time `json:"expiresAt"`
+	}{id, share.Link(a.linkBase(r), id, key), stored.MaxViews, stored.ExpiresAt})
+}
+
+// linkBase returns the base URL of share links: the configured one, or else the scheme and host of the request.
+func (a *sharesAPI) linkBase(r *http.Request) string {
+	if a.baseURL != "" {
+		return a.baseURL
+	}
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host
+}
+
+// pageHandler serves the share page under share.PagePath, which recipients open without access to the vault:
+//
+// - GET /s/{id}: returns the page, which does nothing until the recipient asks it to reveal the share, so that link
+// previews and scanners that fetch the link do not spend it.
+// - POST /s/{id}: opens the share, counting a view, and returns its `ciphertext` (base64), `views`, `maxViews`, and
+// `expiresAt`. The view that reaches maxViews deletes the share. Cross-site requests are refused with 403, so that
+// another site cannot spend a share.
+//
+// Every share that does not exist, has expired, or has been spent is 404, alike. The handler returns 500 for data
+// errors and 405 for other methods.
+func (a *sharesAPI) pageHandler(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, share.PagePath)
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Header().Set("Cache-Control", "no-store")
+		w.Header().Set("Content-Security-Policy", sharePageCSP)
+		w.Header().Set("Referrer-Policy", "no-referrer")
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		fmt.Fprint(w, sharePage)
+
+	case http.MethodPost:
+		recordAudit(r, data.AuditReveal, "share")
+		if site := r.Header.Get("Sec-Fetch-Site"); site != "" && site != "same-origin" && site != "none" {
+			http.Error(w, "Cross-origin request not allowed", http.StatusForbidden)
+			return
+		}
+		var stored data.Share
+		err := data.ErrShareNotFound
+		if share.ValidID(id) {
+			stored, err = data.OpenShare(r.Context(), id)
+		}
+		if errors.Is(err, data.ErrShareNotFound) {
+			http.Error(w, "Share not found, expired, or already opened", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			log.Printf("Failed to open share: %v", err)
+			http.Error(w, "Failed to open share", http.StatusInternalServerError)
+			return
+		}
+		if stored.Site != "" {
+			recordAudit(r, data.AuditReveal, "share/"+stored.Site)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		json.NewEncoder(w).Encode(struct {
+			Ciphertext []byte    `json:"ciphertext"`
+			Views      int       `json:"views"`
+			MaxViews   int       `json:"maxViews"`
+			ExpiresAt  time.Time `json:"expiresAt"`
+		}{stored.Ciphertext, stored.Views, stored.MaxViews, stored.ExpiresAt})
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// sharePageEndpoint classifies requests to the share page: fetching the page is a list request, opening a share a
+// reveal.
+func sharePageEndpoint(r *http.Request) string {
+	if r.Method == http.MethodGet || r.Method == http.MethodHead {
+		return endpointList
+	}
+	return endpointReveal
+}
+
+// watch purges expired shares at startup and then every sweep interval, until done is closed. It does nothing if
+// the sweep interval is 0.
+func (a *sharesAPI) watch(done <-chan struct{}) {
+	if a.sweepInterval <= 0 {
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		<-done
+		cancel()
+	}()
+
+	ticker := time.NewTicker(a.sweepInterval)
+	defer ticker.Stop()
+	for {
+		purged, err := data.PurgeExpiredShares(ctx, time.Now())
+		if err != nil && ctx.Err() == nil {
+			log.Printf("Failed to purge expired shares: %v", err)
+		}
+		if purged > 0 {
+			log.Printf("Purged %d expired shares", purged)
+		}
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// sharePageScript decrypts a share in the recipient's browser with the key from the link's fragment, as
+// share.Open does, then removes the key from the address bar.
+const sharePageScript = `
+const status = document.getElementById("status");
+const reveal = document.getElementById("reveal");
+const output = document.getElementById("content");
+const id = location.pathname.split("/").pop();
+const fragment = location.hash.slice(1);
+
+function decode(text) {
+  text = text.replace(/-/g, "+").replace(/_/g, "/");
+  while (text.length % 4) text += "=";
+  return Uint8Array.from(atob(text), c => c.charCodeAt(0));
+}
+
+function row(label, value) {
+  const term = document.createElement("dt");
+  term.textContent = label;
+  const detail = document.createElement("dd");
+  const code = document.createElement("code");
+  code.textContent = value;
+  detail.append(code);
+  output.append(term, detail);
+}
+
+if (!fragment) {
+  status.textContent = "This link is incomplete: the key after # is missing.";
+  reveal.hidden = true;
+}
+
+reveal.addEventListener("click", async () => {
+  reveal.disabled = true;
+  try {
+    const key = await crypto.subtle.importKey("raw", decode(fragment), "AES-GCM", false, ["decrypt"]);
+    const response = await fetch(location.pathname, {method: "POST"});
+    if (response.status === 404) {
+      status.textContent = "This share has expired or has already been opened.";
+      reveal.hidden = true;
+      return;
+    }
+    if (!response.ok) throw new Error(await response.text());
+    const opened = await response.json();
+    const sealed = decode(opened.ciphertext);
+    const plaintext = await crypto.subtle.decrypt(
+      {name: "AES-GCM", iv: sealed.slice(0, 12), additionalData: new TextEncoder().encode("pasword-mango share " + id)},
+      key, sealed.slice(12));
+    const content = JSON.parse(new TextDecoder().decode(plaintext));
+    history.replaceState(null, "", location.pathname);
+    if (content.site) row("Site", content.site);
+    if (content.username) row("Username", content.username);
+    if (content.password) row("Password", content.password);
+    if (content.text) row("Text", content.text);
+    const left = opened.maxViews - opened.views;
+    status.textContent = left > 0
+      ? "This share can be opened " + left + " more time" + (left === 1 ? "" : "s") + " until " + new Date(opened.expiresAt).toLocaleString() + "."
+      : "This share has now been deleted. Copy what you need before leaving the page.";
+    reveal.hidden = true;
+  } catch (err) {
+    status.textContent = "The share could not be opened: " + err.message;
+    reveal.disabled = false;
+  }
+});
+`
+
+// sharePageStyle styles the share page.
+const sharePageStyle = `
+body { font-family: system-ui, sans-serif; max-width: 40rem; margin: 4rem auto; padding: 0 1rem; color: #222; }
+dt { font-weight: 600; margin-top: 1rem; }
+dd { margin: 0.25rem 0 0; }
+code { font-size: 1.1rem; white-space: pre-wrap; word-break: break-all; }
+button { font-size: 1rem; padding: 0.5rem 1rem; }
+`
+
+// sharePage is the page served for share links. Its script and style are inline, allowed by their hashes in
+// sharePageCSP, so that it needs nothing but the server.
+const sharePage = `<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<meta name="robots" content="noindex">
+<title>Shared secret</title>
+<style>` + sharePageStyle + `</style>
+</head>
+<body>
+<h1>Shared secret</h1>
+<p id="status">Someone shared a secret with you through Pasword Mango. It can only be opened a limited number of times, so reveal it when you are ready to copy it.</p>
+<button id="reveal" type="button">Reveal</button>
+<dl id="content"></dl>
+<script>` + sharePageScript + `</script>
+</body>
+</html>
+`
+
+// sharePageCSP lets the share page run its own script and style, and talk only to the server.
+var sharePageCSP = "default-src 'none'; script-src '" + cspHash(sharePageScript) + "'; style-src '" + cspHash(sharePageStyle) +
+	"'; connect-src 'self'; base-uri 'none'; form-action 'none'; frame-ancestors 'none'"
+
+// cspHash returns the Content-Security-Policy source expression that allows an inline script or style.
+func cspHash(source string) string {
+	sum := sha256.Sum256([]byte(source))
+	return "sha256-" + base64.StdEncoding.EncodeToString(sum[:])
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rihts-4/pasword-mango/config"
+	"github.com/rihts-4/pasword-mango/data"
+	"github.com/rihts-4/pasword-mango/share"
+)
+
+// newTestShares serves the share API and page on an empty vault.
+func newTestShares(t *testing.T) *httptest.Server {
+	t.Helper()
+	firestore.Reset()
+	api := newSharesAPI(config.Share{
+		DefaultTTL: config.Duration(time.Hour),
+		MaxTTL:     config.Duration(24 * time.Hour),
+		MaxViews:   5,
+	})
+	mux := http.NewServeMux()
+	mux.HandleFunc("/shares", api.sharesHandler)
+	mux.HandleFunc("/shares/", api.sharesHandler)
+	mux.HandleFunc(share.PagePath, api.pageHandler)
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server
+}
+
+// openShare opens a share through its page, returning the response status and, on success, the opened share.
+func openShare(t *testing.T, server *httptest.Server, id string) (int, data.Share) {
+	t.Helper()
+	resp, err := http.Post(server.URL+share.PagePath+id, "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var opened struct {
+		Ciphertext []byte    `json:"ciphertext"`
+		Views      int       `json:"views"`
+		MaxViews   int       `json:"maxViews"`
+		ExpiresAt  time.Time `json:"expiresAt"`
+	}
+	if resp.StatusCode == http.StatusOK {
+		if err := json.NewDecoder(resp.Body).Decode(&opened); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return resp.StatusCode, data.Share{Ciphertext: opened.Ciphertext, Views: opened.Views, MaxViews: opened.MaxViews, ExpiresAt: opened.ExpiresAt}
+}
+
+func TestShareViews(t *testing.T) {
+	server := newTestShares(t)
+	ctx := context.Background()
+	if err := data.Store(ctx, "example.com", data.Credentials{Username: "alice", Password: "correct horse battery staple"}); err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := http.Post(server.URL+"/shares", "application/json", strings.NewReader(`{"site": "example.com", "maxViews": 2, "ttl": "10m"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("POST /shares: status %d", resp.StatusCode)
+	}
+	var created struct {
+		ID        string    `json:"id"`
+		URL       string    `json:"url"`
+		MaxViews  int       `json:"maxViews"`
+		ExpiresAt time.Time `json:"expiresAt"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&created); err != nil {
+		t.Fatal(err)
+	}
+	baseURL, id, key, err := share.ParseLink(created.URL)
+	if err != nil || baseURL != server.URL || id != created.ID {
+		t.Fatalf("ParseLink(%q) = %q, %q, %v", created.URL, baseURL, id, err)
+	}
+	if ttl := time.Until(created.ExpiresAt); created.MaxViews != 2 || ttl <= 9*time.Minute || ttl > 10*time.Minute {
+		t.Errorf("share allows %d views and expires in %s, want 2 and 10m", created.MaxViews, ttl)
+	}
+
+	for views := 1; views <= 2; views++ {
+		status, opened := openShare(t, server, id)
+		if status != http.StatusOK || opened.Views != views || opened.MaxViews != 2 {
+			t.Fatalf("open %d: status %d, %d of %d views", views, status, opened.Views, opened.MaxViews)
+		}
+		content, err := share.Open(key, id, opened.Ciphertext)
+		want := share.Content{Site: "example.com", Username: "alice", Password: "correct horse battery staple"}
+		if err != nil || content != want {
+			t.Errorf("open %d: content %+v, %v; want %+v", views, content, err, want)
+		}
+	}
+	if status, _ := openShare(t, server, id); status != http.StatusNotFound {
+		t.Errorf("open after the last view: status %d, want 404", status)
+	}
+	if err := data.DeleteShare(ctx, id); !errors.Is(err, data.ErrShareNotFound) {
+		t.Errorf("the spent share was not deleted: DeleteShare returned %v", err)
+	}
+}
+
+func TestShareExpiry(t *testing.T) {
+	server := newTestShares(t)
+	ctx := context.Background()
+	now := time.Now().UTC()
+	for name, expiresAt := range map[string]time.Time{
+		"expired": now.Add(-time.Minute),
+		"stale":   now.Add(-time.Hour),
+		"current": now.Add(time.Hour),
+	} {
+		stored := data.Share{Ciphertext: []byte("sealed"), MaxViews: 3, CreatedAt: now.Add(-2 * time.Hour), ExpiresAt: expiresAt}
+		if err := data.CreateShare(ctx, shareID(name), stored); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Opening an expired share deletes it, and reads as if it never existed
+	if _, err := data.OpenShare(ctx, shareID("expired")); !errors.Is(err, data.ErrShareNotFound) {
+		t.Errorf("OpenShare of an expired share returned %v, want ErrShareNotFound", err)
+	}
+	if err := data.DeleteShare(ctx, shareID("expired")); !errors.Is(err, data.ErrShareNotFound) {
+		t.Errorf("the expired share was not deleted: DeleteShare returned %v", err)
+	}
+	if status, _ := openShare(t, server, shareID("stale")); status != http.StatusNotFound {
+		t.Errorf("open of an expired share: status %d, want 404", status)
+	}
+
+	// The sweeper purges expired shares nobody tried to open, and leaves current ones
+	if err := data.CreateShare(ctx, shareID("stale"), data.Share{MaxViews: 1, ExpiresAt: now.Add(-time.Hour)}); err != nil {
+		t.Fatal(err)
+	}
+	if purged, err := data.PurgeExpiredShares(ctx, now); err != nil || purged != 1 {
+		t.Errorf("PurgeExpiredShares = %d, %v; want 1", purged, err)
+	}
+	if opened, err := data.OpenShare(ctx, shareID("current")); err != nil || opened.Views != 1 || opened.MaxViews != 3 {
+		t.Errorf("OpenShare of a current share = %+v, %v", opened, err)
+	}
+}
+
+func TestShareRequests(t *testing.T) {
+	server := newTestShares(t)
+	for body, want := range map[string]int{
+		`{"text": "hello"}`:                                             http.StatusCreated,
+		`{"text": "hello", "maxViews": 5}`:                              http.StatusCreated,
+		`{}`:                                                            http.StatusBadRequest,
+		`{"site": "example.com", "text": "hi"}`:                         http.StatusBadRequest,
+		`{"site": "missing.org"}`:                                       http.StatusNotFound,
+		`{"text": "hello", "maxViews": 6}`:                              http.StatusBadRequest,
+		`{"text": "hello", "maxViews": -1}`:                             http.StatusBadRequest,
+		`{"text": "hello", "ttl": "25h"}`:                               http.StatusBadRequest,
+		`{"text": "hello", "ttl": "-1h"}`:                               http.StatusBadRequest,
+		`{"text": "hello", "ttl": "soon"}`:                              http.StatusBadRequest,
+		`{"text": "` + strings.Repeat("x", maxShareTextLength+1) + `"}`: http.StatusBadRequest,
+	} {
+		resp, err := http.Post(server.URL+"/shares", "application/json", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != want {
+			t.Errorf("POST /shares %.60s: status %d, want %d", body, resp.StatusCode, want)
+		}
+	}
+
+	// Another site cannot spend a share, and IDs that could not have been issued are not looked up
+	id := shareID("cross-site")
+	if err := data.CreateShare(context.Background(), id, data.Share{MaxViews: 1, ExpiresAt: time.Now().Add(time.Hour)}); err != nil {
+		t.Fatal(err)
+	}
+	r, _ := http.NewRequest(http.MethodPost, server.URL+share.PagePath+id, nil)
+	r.Header.Set("Sec-Fetch-Site", "cross-site")
+	resp, err := http.DefaultClient.Do(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("cross-site open: status %d, want 403", resp.StatusCode)
+	}
+	if status, opened := openShare(t, server, id); status != http.StatusOK || opened.Views != 1 {
+		t.Errorf("open after the cross-site attempt: status %d, %d views", status, opened.Views)
+	}
+	if status, _ := openShare(t, server, "not-an-id"); status != http.StatusNotFound {
+		t.Errorf("open of an invalid ID: status %d, want 404", status)
+	}
+}
+
+// shareID derives a well-formed share ID from a name of up to 16 characters, so that tests can name their shares.
+func shareID(name string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf("%-16s", name)))
+}
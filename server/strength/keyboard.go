@@ -0,0 +1,164 @@
+package strength
+
+import "strings"
+
+// Keyboard layouts, one row per line. Each key is written as the characters it types, unshifted then shifted.
+// Typewriter rows are indented one more column than the row above to show their stagger; keypads are aligned grids.
+var (
+	qwertyRows = []string{
+		"`~ 1! 2@ 3# 4$ 5% 6^ 7& 8* 9( 0) -_ =+",
+		"    qQ wW eE rR tT yY uU iI oO pP [{ ]} \\|",
+		"     aA sS dD fF gG hH jJ kK lL ;: '\"",
+		"      zZ xX cC vV bB nN mM ,< .> /?",
+	}
+	dvorakRows = []string{
+		"`~ 1! 2@ 3# 4$ 5% 6^ 7& 8* 9( 0) [{ ]}",
+		"    '\" ,< .> pP yY fF gG cC rR lL /? =+ \\|",
+		"     aA oO eE uU iI dD hH tT nN sS -_",
+		"      ;: qQ jJ kK xX bB mM wW vV zZ",
+	}
+	keypadRows = []string{
+		"  / * -",
+		"7 8 9 +",
+		"4 5 6",
+		"1 2 3",
+		"  0 .",
+	}
+	macKeypadRows = []string{
+		"  = / *",
+		"7 8 9 -",
+		"4 5 6 +",
+		"1 2 3",
+		"  0 .",
+	}
+)
+
+// keyboardGraph records, for every character of a layout, the keys around the key that types it.
+type keyboardGraph struct {
+	name string
+	// adjacent maps a character to its neighbouring keys in a fixed order of directions, with "" where there is no
+	// key, so that the index of a neighbour identifies the direction of a move.
+	adjacent map[rune][]string
+	// typewriter is set for layouts with a shift key, whose use makes a pattern harder to guess.
+	typewriter bool
+	// startingPositions and averageDegree size the space of patterns on the layout.
+	startingPositions int
+	averageDegree     float64
+}
+
+// keyboardGraphs are the layouts searched for spatial patterns.
+var keyboardGraphs = []*keyboardGraph{
+	newKeyboardGraph("qwerty", qwertyRows, true),
+	newKeyboardGraph("dvorak", dvorakRows, true),
+	newKeyboardGraph("keypad", keypadRows, false),
+	newKeyboardGraph("mac_keypad", macKeypadRows, false),
+}
+
+// newKeyboardGraph builds the adjacency graph of a layout. Typewriter rows are staggered, so each key has six
+// neighbours; keypad keys have eight.
+func newKeyboardGraph(name string, rows []string, typewriter bool) *keyboardGraph {
+	type position struct{ x, y int }
+	keys := make(map[position]string)
+	for y, row := range rows {
+		slant := 0
+		if typewriter {
+			slant = y
+		}
+		start := -1
+		for i := 0; i <= len(row); i++ {
+			if i < len(row) && row[i] != ' ' {
+				if start < 0 {
+					start = i
+				}
+				continue
+			}
+			if start >= 0 {
+				token := row[start:i]
+				keys[position{(start - slant) / (len(token) + 1), y}] = token
+				start = -1
+			}
+		}
+	}
+
+	neighbours := func(x, y int) []position {
+		if typewriter {
+			return []position{{x - 1, y}, {x, y - 1}, {x + 1, y - 1}, {x + 1, y}, {x, y + 1}, {x - 1, y + 1}}
+		}
+		return []position{{x - 1, y}, {x - 1, y - 1}, {x, y - 1}, {x + 1, y - 1}, {x + 1, y}, {x + 1, y + 1}, {x, y + 1}, {x - 1, y + 1}}
+	}
+
+	g := &keyboardGraph{name: name, adjacent: make(map[rune][]string), typewriter: typewriter}
+	degrees := 0
+	for pos, token := range keys {
+		var adjacent []string
+		for _, n := range neighbours(pos.x, pos.y) {
+			adjacent = append(adjacent, keys[n])
+			if keys[n] != "" {
+				degrees++
+			}
+		}
+		for _, c := range token {
+			g.adjacent[c] = adjacent
+		}
+	}
+	g.startingPositions = len(g.adjacent)
+	// Every character of a key shares its neighbours, so the average over keys is the average over characters
+	g.averageDegree = float64(degrees) / float64(len(keys))
+	return g
+}
+
+// shiftedChars are the characters typed with shift on the typewriter layouts.
+const shiftedChars = "~!@#$%^&*()_+QWERTYUIOP{}|ASDFGHJKL:\"ZXCVBNM<>?"
+
+// spatialMatch finds runs of three or more characters typed on neighbouring keys, such as "qwerty" or "zaqwsx".
+func spatialMatch(password []rune, graph *keyboardGraph) []*match {
+	var matches []*match
+	i := 0
+	for i < len(password)-1 {
+		j := i + 1
+		lastDirection := -1
+		turns := 0
+		shiftedCount := 0
+		if graph.typewriter && strings.ContainsRune(shiftedChars, password[i]) {
+			shiftedCount = 1
+		}
+		for {
+			found := false
+			if j < len(password) {
+				for direction, adjacent := range graph.adjacent[password[j-1]] {
+					index := strings.IndexRune(adjacent, password[j])
+					if index < 0 {
+						continue
+					}
+					found = true
+					if index == 1 {
+						shiftedCount++
+					}
+					if lastDirection != direction {
+						turns++
+						lastDirection = direction
+					}
+					break
+				}
+			}
+			if found {
+				j++
+				continue
+			}
+			if j-i > 2 {
+				matches = append(matches, &match{
+					pattern:      patternSpatial,
+					i:            i,
+					j:            j - 1,
+					token:        string(password[i:j]),
+					graph:        graph,
+					turns:        turns,
+					shiftedCount: shiftedCount,
+				})
+			}
+			i = j
+			break
+		}
+	}
+	return matches
+}
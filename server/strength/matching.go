@@ -0,0 +1,610 @@
+package strength
+
+import (
+	"bufio"
+	_ "embed"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// Patterns a match can describe.
+const (
+	patternDictionary = "dictionary"
+	patternSpatial    = "spatial"
+	patternRepeat     = "repeat"
+	patternSequence   = "sequence"
+	patternYear       = "year"
+	patternDate       = "date"
+	patternBruteforce = "bruteforce"
+)
+
+// match is a part of the password, password[i..j] inclusive in runes, that follows a guessable pattern. Only the
+// fields of its pattern are set.
+type match struct {
+	pattern string
+	i, j    int
+	token   string
+
+	// Dictionary words, possibly reversed or with l33t substitutions
+	matchedWord    string
+	rank           int
+	dictionaryName string
+	reversed       bool
+	l33t           bool
+	sub            map[rune]rune // substituted character => letter
+
+	// Keyboard patterns
+	graph        *keyboardGraph
+	turns        int
+	shiftedCount int
+
+	// Repeats
+	baseToken   string
+	baseGuesses float64
+	repeatCount int
+
+	// Sequences
+	ascending bool
+
+	// Years and dates
+	year      int
+	separator string
+
+	// guesses is computed once by estimateGuesses.
+	guesses float64
+}
+
+// length returns the length of the match in runes.
+func (m *match) length() int {
+	return m.j - m.i + 1
+}
+
+// Frequency lists ranked from most to least common, from zxcvbn (Copyright (c) 2012-2016 Dan Wheeler and
+// Dropbox, Inc.) as packaged by zxcvbn-go (Copyright (c) Nathan Button), both under the MIT license:
+// https://github.com/dropbox/zxcvbn. The English list is truncated to 30,000 words and surnames to 10,000.
+var (
+	//go:embed passwords.txt
+	passwordsList string
+	//go:embed english.txt
+	englishList string
+	//go:embed female_names.txt
+	femaleNamesList string
+	//go:embed male_names.txt
+	maleNamesList string
+	//go:embed surnames.txt
+	surnamesList string
+)
+
+// Names of the dictionaries, used to choose feedback.
+const (
+	dictionaryPasswords   = "passwords"
+	dictionaryEnglish     = "english"
+	dictionaryFemaleNames = "female_names"
+	dictionaryMaleNames   = "male_names"
+	dictionarySurnames    = "surnames"
+	dictionaryUserInputs  = "user_inputs"
+)
+
+// rankedDictionary maps lowercase words to their rank, starting at 1 for the most common.
+type rankedDictionary struct {
+	name      string
+	ranks     map[string]int
+	maxLength int
+}
+
+// newRankedDictionary ranks words in the order given, keeping the first rank of a repeated word.
+func newRankedDictionary(name string, words []string) rankedDictionary {
+	d := rankedDictionary{name: name, ranks: make(map[string]int, len(words))}
+	for _, word := range words {
+		if _, ok := d.ranks[word]; ok || word == "" {
+			continue
+		}
+		d.ranks[word] = len(d.ranks) + 1
+		d.maxLength = max(d.maxLength, len([]rune(word)))
+	}
+	return d
+}
+
+// dictionaries returns the embedded frequency lists, parsed on first use.
+var dictionaries = sync.OnceValue(func() []rankedDictionary {
+	lines := func(list string) []string {
+		var words []string
+		scanner := bufio.NewScanner(strings.NewReader(list))
+		for scanner.Scan() {
+			words = append(words, scanner.Text())
+		}
+		return words
+	}
+	return []rankedDictionary{
+		newRankedDictionary(dictionaryPasswords, lines(passwordsList)),
+		newRankedDictionary(dictionaryEnglish, lines(englishList)),
+		newRankedDictionary(dictionaryFemaleNames, lines(femaleNamesList)),
+		newRankedDictionary(dictionaryMaleNames, lines(maleNamesList)),
+		newRankedDictionary(dictionarySurnames, lines(surnamesList)),
+	}
+})
+
+// userInputDictionary ranks words that an attacker targeting the password's owner would try first, such as the
+// site and username. Each input is also split into its runs of letters and digits, so that "jane.doe@example.com"
+// contributes "jane", "doe", and "example".
+func userInputDictionary(inputs []string) rankedDictionary {
+	var words []string
+	for _, input := range inputs {
+		input = strings.ToLower(strings.TrimSpace(input))
+		words = append(words, input)
+		words = append(words, strings.FieldsFunc(input, func(c rune) bool {
+			return !unicode.IsLetter(c) && !unicode.IsDigit(c)
+		})...)
+	}
+	return newRankedDictionary(dictionaryUserInputs, words)
+}
+
+// l33tTable lists the characters commonly substituted for each letter.
+var l33tTable = map[rune][]rune{
+	'a': {'4', '@'},
+	'b': {'8'},
+	'c': {'(', '{', '[', '<'},
+	'e': {'3'},
+	'g': {'6', '9'},
+	'i': {'1', '!', '|'},
+	'l': {'1', '|', '7'},
+	'o': {'0'},
+	's': {'$', '5'},
+	't': {'+', '7'},
+	'x': {'%'},
+	'z': {'2'},
+}
+
+// Bounds on the dates recognized.
+const (
+	dateMinYear = 1000
+	dateMaxYear = 2050
+)
+
+// dateSplits lists, for each length of a date without separators, where to split it into three numbers.
+var dateSplits = map[int][][2]int{
+	4: {{1, 2}, {2, 3}},
+	5: {{1, 3}, {2, 3}},
+	6: {{1, 2}, {2, 4}, {4, 5}},
+	7: {{1, 3}, {2, 3}, {4, 5}, {4, 6}},
+	8: {{2, 4}, {4, 6}},
+}
+
+// maxSequenceDelta is the largest difference between consecutive characters that still counts as a sequence,
+// so that "acegi" and "97531" are found.
+const maxSequenceDelta = 5
+
+// matcher finds every guessable pattern in a password.
+type matcher struct {
+	dictionaries  []rankedDictionary
+	referenceYear int
+}
+
+// omnimatch returns the matches of every pattern, sorted by position.
+func (mt *matcher) omnimatch(password []rune) []*match {
+	var matches []*match
+	matches = append(matches, mt.dictionaryMatch(password)...)
+	matches = append(matches, mt.reverseDictionaryMatch(password)...)
+	matches = append(matches, mt.l33tMatch(password)...)
+	for _, graph := range keyboardGraphs {
+		matches = append(matches, spatialMatch(password, graph)...)
+	}
+	matches = append(matches, mt.repeatMatch(password)...)
+	matches = append(matches, sequenceMatch(password)...)
+	matches = append(matches, yearMatch(password)...)
+	matches = append(matches, mt.dateMatch(password)...)
+	sortMatches(matches)
+	return matches
+}
+
+// sortMatches orders matches by start, then end.
+func sortMatches(matches []*match) {
+	slices.SortStableFunc(matches, func(a, b *match) int {
+		if a.i != b.i {
+			return a.i - b.i
+		}
+		return a.j - b.j
+	})
+}
+
+// dictionaryMatch finds every substring of the password that is a dictionary word, ignoring case.
+func (mt *matcher) dictionaryMatch(password []rune) []*match {
+	lower := make([]rune, len(password))
+	for i, c := range password {
+		lower[i] = unicode.ToLower(c)
+	}
+	var matches []*match
+	for _, d := range mt.dictionaries {
+		for i := range password {
+			for j := i; j < len(password) && j-i < d.maxLength; j++ {
+				word := string(lower[i : j+1])
+				if rank, ok := d.ranks[word]; ok {
+					matches = append(matches, &match{
+						pattern:        patternDictionary,
+						i:              i,
+						j:              j,
+						token:          string(password[i : j+1]),
+						matchedWord:    word,
+						rank:           rank,
+						dictionaryName: d.name,
+					})
+				}
+			}
+		}
+	}
+	return matches
+}
+
+// reverseDictionaryMatch finds dictionary words written backwards.
+func (mt *matcher) reverseDictionaryMatch(password []rune) []*match {
+	reversed := slices.Clone(password)
+	slices.Reverse(reversed)
+	matches := mt.dictionaryMatch(reversed)
+	for _, m := range matches {
+		token := []rune(m.token)
+		slices.Reverse(token)
+		m.token = string(token)
+		m.reversed = true
+		m.i, m.j = len(password)-1-m.j, len(password)-1-m.i
+	}
+	return matches
+}
+
+// l33tMatch finds dictionary words with l33t substitutions, such as "p@ssw0rd". Characters such as '1' can stand
+// for more than one letter, so every assignment of letters to the substituted characters present is tried.
+func (mt *matcher) l33tMatch(password []rune) []*match {
+	candidates := make(map[rune][]rune)
+	var subbed []rune
+	for letter, subs := range l33tTable {
+		for _, c := range subs {
+			if slices.Contains(password, c) {
+				if len(candidates[c]) == 0 {
+					subbed = append(subbed, c)
+				}
+				candidates[c] = append(candidates[c], letter)
+			}
+		}
+	}
+	if len(subbed) == 0 {
+		return nil
+	}
+	slices.Sort(subbed)
+
+	// Enumerate every assignment of a letter to each substituted character
+	subs := []map[rune]rune{{}}
+	for _, c := range subbed {
+		var next []map[rune]rune
+		for _, sub := range subs {
+			for _, letter := range candidates[c] {
+				extended := make(map[rune]rune, len(sub)+1)
+				for k, v := range sub {
+					extended[k] = v
+				}
+				extended[c] = letter
+				next = append(next, extended)
+			}
+		}
+		subs = next
+	}
+
+	var matches []*match
+	seen := make(map[string]bool)
+	for _, sub := range subs {
+		translated := make([]rune, len(password))
+		for i, c := range password {
+			if letter, ok := sub[c]; ok {
+				translated[i] = letter
+			} else {
+				translated[i] = c
+			}
+		}
+		for _, m := range mt.dictionaryMatch(translated) {
+			token := password[m.i : m.j+1]
+			// Single characters and words without any substitution are matched elsewhere
+			if len(token) <= 1 || strings.ToLower(string(token)) == m.matchedWord {
+				continue
+			}
+			key := strconv.Itoa(m.i) + ":" + strconv.Itoa(m.j) + ":" + m.dictionaryName + ":" + m.matchedWord
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			m.token = string(token)
+			m.l33t = true
+			m.sub = make(map[rune]rune)
+			for _, c := range token {
+				if letter, ok := sub[c]; ok {
+					m.sub[c] = letter
+				}
+			}
+			matches = append(matches, m)
+		}
+	}
+	return matches
+}
+
+// repeatMatch finds repeats of a character or string, such as "aaa" or "abcabcabc". At each position it takes the
+// longest repeat, made of the shortest unit that builds it.
+func (mt *matcher) repeatMatch(password []rune) []*match {
+	var matches []*match
+	for i := 0; i < len(password); {
+		span, unit := 0, 0
+		for l := 1; i+2*l <= len(password); l++ {
+			count := 1
+			for i+(count+1)*l <= len(password) && slices.Equal(password[i:i+l], password[i+count*l:i+(count+1)*l]) {
+				count++
+			}
+			if count >= 2 && count*l > span {
+				span, unit = count*l, l
+			}
+		}
+		if span == 0 {
+			i++
+			continue
+		}
+		base := password[i : i+unit]
+		guesses, _ := mostGuessableSequence(base, mt.omnimatch(base), false, mt.referenceYear)
+		matches = append(matches, &match{
+			pattern:     patternRepeat,
+			i:           i,
+			j:           i + span - 1,
+			token:       string(password[i : i+span]),
+			baseToken:   string(base),
+			baseGuesses: guesses,
+			repeatCount: span / unit,
+		})
+		i += span
+	}
+	return matches
+}
+
+// sequenceMatch finds runs of characters whose code points change by the same small amount, such as "abcd", "9753",
+// or "ZYX".
+func sequenceMatch(password []rune) []*match {
+	if len(password) <= 1 {
+		return nil
+	}
+	var matches []*match
+	add := func(i, j, delta int) {
+		if j-i > 1 || abs(delta) == 1 {
+			if d := abs(delta); d > 0 && d <= maxSequenceDelta {
+				matches = append(matches, &match{
+					pattern:   patternSequence,
+					i:         i,
+					j:         j,
+					token:     string(password[i : j+1]),
+					ascending: delta > 0,
+				})
+			}
+		}
+	}
+	i := 0
+	lastDelta := int(password[1]) - int(password[0])
+	for k := 2; k < len(password); k++ {
+		delta := int(password[k]) - int(password[k-1])
+		if delta == lastDelta {
+			continue
+		}
+		add(i, k-1, lastDelta)
+		i = k - 1
+		lastDelta = delta
+	}
+	add(i, len(password)-1, lastDelta)
+	return matches
+}
+
+// yearMatch finds four-digit years from 1900 to 2099.
+func yearMatch(password []rune) []*match {
+	var matches []*match
+	for i := 0; i+4 <= len(password); {
+		token := password[i : i+4]
+		if allDigits(token) && (string(token[:2]) == "19" || string(token[:2]) == "20") {
+			year, _ := strconv.Atoi(string(token))
+			matches = append(matches, &match{pattern: patternYear, i: i, j: i + 3, token: string(token), year: year})
+			i += 4
+			continue
+		}
+		i++
+	}
+	return matches
+}
+
+// dateMatch finds dates written as day, month, and year in any order that is commonly used, with two- or
+// four-digit years, either run together ("13101986") or with a consistent separator ("13.10.86"). Dates that lie
+// within longer dates are dropped.
+func (mt *matcher) dateMatch(password []rune) []*match {
+	var matches []*match
+
+	// Without separators, dates are from 4 ("1191") to 8 ("11111991") digits long
+	for i := 0; i+4 <= len(password); i++ {
+		for j := i + 3; j <= i+7 && j < len(password); j++ {
+			token := password[i : j+1]
+			if !allDigits(token) {
+				break
+			}
+			var best *date
+			for _, split := range dateSplits[len(token)] {
+				k, l := split[0], split[1]
+				d := mapIntsToDMY([3]int{atoi(token[:k]), atoi(token[k:l]), atoi(token[l:])})
+				if d == nil {
+					continue
+				}
+				// Prefer the reading closest to the present
+				if best == nil || abs(d.year-mt.referenceYear) < abs(best.year-mt.referenceYear) {
+					best = d
+				}
+			}
+			if best != nil {
+				matches = append(matches, &match{pattern: patternDate, i: i, j: j, token: string(token), year: best.year})
+			}
+		}
+	}
+
+	// With separators, dates are from 6 ("1/1/91") to 10 ("11/11/1991") characters long
+	for i := 0; i+6 <= len(password); i++ {
+		for j := i + 5; j <= i+9 && j < len(password); j++ {
+			token := password[i : j+1]
+			parts, separator, ok := splitDate(token)
+			if !ok {
+				continue
+			}
+			d := mapIntsToDMY([3]int{atoi(parts[0]), atoi(parts[1]), atoi(parts[2])})
+			if d == nil {
+				continue
+			}
+			matches = append(matches, &match{
+				pattern:   patternDate,
+				i:         i,
+				j:         j,
+				token:     string(token),
+				year:      d.year,
+				separator: string(separator),
+			})
+		}
+	}
+
+	// "2015_06_04" also contains "15_06_04", "2015", and others; keep only the longest
+	var kept []*match
+	for _, m := range matches {
+		submatch := false
+		for _, other := range matches {
+			if other != m && other.i <= m.i && other.j >= m.j {
+				submatch = true
+				break
+			}
+		}
+		if !submatch {
+			kept = append(kept, m)
+		}
+	}
+	return kept
+}
+
+// splitDate splits a token of the form digits, separator, digits, the same separator, digits, where the parts
+// have 1-4, 1-2, and 1-4 digits.
+func splitDate(token []rune) (parts [3][]rune, separator rune, ok bool) {
+	isSeparator := func(c rune) bool { return unicode.IsSpace(c) || strings.ContainsRune("/\\_.-", c) }
+	first := slices.IndexFunc(token, isSeparator)
+	if first < 1 || first > 4 {
+		return parts, 0, false
+	}
+	separator = token[first]
+	rest := token[first+1:]
+	second := slices.Index(rest, separator)
+	if second < 1 || second > 2 {
+		return parts, 0, false
+	}
+	parts = [3][]rune{token[:first], rest[:second], rest[second+1:]}
+	if len(parts[2]) < 1 || len(parts[2]) > 4 {
+		return parts, 0, false
+	}
+	for _, part := range parts {
+		if !allDigits(part) {
+			return parts, 0, false
+		}
+	}
+	return parts, separator, true
+}
+
+// date is a calendar date read from a password.
+type date struct {
+	year, month, day int
+}
+
+// mapIntsToDMY reads three numbers as a date, with the year first or last, or returns nil if they cannot be one.
+func mapIntsToDMY(ints [3]int) *date {
+	// The middle number is always the day or month
+	if ints[1] > 31 || ints[1] <= 0 {
+		return nil
+	}
+	over12, over31, under1 := 0, 0, 0
+	for _, n := range ints {
+		if (99 < n && n < dateMinYear) || n > dateMaxYear {
+			return nil
+		}
+		if n > 31 {
+			over31++
+		}
+		if n > 12 {
+			over12++
+		}
+		if n <= 0 {
+			under1++
+		}
+	}
+	if over31 >= 2 || over12 == 3 || under1 >= 2 {
+		return nil
+	}
+
+	splits := []struct {
+		year int
+		rest [2]int
+	}{
+		{ints[2], [2]int{ints[0], ints[1]}}, // year last
+		{ints[0], [2]int{ints[1], ints[2]}}, // year first
+	}
+	for _, s := range splits {
+		if dateMinYear <= s.year && s.year <= dateMaxYear {
+			day, month, ok := mapIntsToDM(s.rest)
+			if !ok {
+				return nil
+			}
+			return &date{year: s.year, month: month, day: day}
+		}
+	}
+	// Two-digit years
+	for _, s := range splits {
+		if day, month, ok := mapIntsToDM(s.rest); ok {
+			return &date{year: twoToFourDigitYear(s.year), month: month, day: day}
+		}
+	}
+	return nil
+}
+
+// mapIntsToDM reads two numbers as a day and month in either order.
+func mapIntsToDM(ints [2]int) (day, month int, ok bool) {
+	for _, dm := range [][2]int{{ints[0], ints[1]}, {ints[1], ints[0]}} {
+		if 1 <= dm[0] && dm[0] <= 31 && 1 <= dm[1] && dm[1] <= 12 {
+			return dm[0], dm[1], true
+		}
+	}
+	return 0, 0, false
+}
+
+// twoToFourDigitYear expands a two-digit year to the closest century, so that "86" is 1986 and "12" is 2012.
+func twoToFourDigitYear(year int) int {
+	switch {
+	case year > 99:
+		return year
+	case year > 50:
+		return year + 1900
+	default:
+		return year + 2000
+	}
+}
+
+// allDigits reports whether s is made only of ASCII digits.
+func allDigits(s []rune) bool {
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return len(s) > 0
+}
+
+// atoi parses ASCII digits known to be valid.
+func atoi(s []rune) int {
+	n, _ := strconv.Atoi(string(s))
+	return n
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
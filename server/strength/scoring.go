@@ -0,0 +1,306 @@
+package strength
+
+import (
+	"math"
+	"strings"
+	"unicode"
+)
+
+// Constants of the guess estimates, from zxcvbn.
+const (
+	// bruteforceCardinality is the guesses per character of a part that follows no pattern.
+	bruteforceCardinality = 10
+	// minGuessesBeforeGrowingSequence penalizes splitting a password into more parts, so that a long run of
+	// short matches is not preferred over a single bruteforce part.
+	minGuessesBeforeGrowingSequence = 10000
+	// Matches smaller than the whole password are assumed to take at least this many guesses.
+	minSubmatchGuessesSingleChar = 10
+	minSubmatchGuessesMultiChar  = 50
+	// minYearSpace is the fewest years an attacker is assumed to try around the present.
+	minYearSpace = 20
+)
+
+// mostGuessableSequence finds the sequence of non-overlapping matches covering the password that takes the fewest
+// guesses, filling the gaps with bruteforce parts. An attacker is assumed to know the patterns and to try every
+// sequence of l parts in every order, so a sequence costs l! times the product of its parts' guesses, plus a
+// penalty that grows with l.
+//
+// optimal.g[k][l] is the fewest guesses of a sequence of l parts covering password[0..k], ending in optimal.m[k][l]
+// with a product of optimal.pi[k][l].
+func mostGuessableSequence(password []rune, matches []*match, excludeAdditive bool, referenceYear int) (float64, []*match) {
+	n := len(password)
+	if n == 0 {
+		return 1, nil
+	}
+	byEnd := make([][]*match, n)
+	for _, m := range matches {
+		byEnd[m.j] = append(byEnd[m.j], m)
+	}
+	for _, list := range byEnd {
+		sortMatches(list)
+	}
+
+	optimal := struct {
+		m  []map[int]*match
+		pi []map[int]float64
+		g  []map[int]float64
+	}{make([]map[int]*match, n), make([]map[int]float64, n), make([]map[int]float64, n)}
+	for k := range n {
+		optimal.m[k] = make(map[int]*match)
+		optimal.pi[k] = make(map[int]float64)
+		optimal.g[k] = make(map[int]float64)
+	}
+
+	update := func(m *match, l int) {
+		k := m.j
+		pi := estimateGuesses(m, n, referenceYear)
+		if l > 1 {
+			pi *= optimal.pi[m.i-1][l-1]
+		}
+		g := factorial(l) * pi
+		if !excludeAdditive {
+			g += math.Pow(minGuessesBeforeGrowingSequence, float64(l-1))
+		}
+		// Keep the match only if no sequence as short or shorter does better
+		for competingL, competingG := range optimal.g[k] {
+			if competingL <= l && competingG <= g {
+				return
+			}
+		}
+		optimal.g[k][l] = g
+		optimal.m[k][l] = m
+		optimal.pi[k][l] = pi
+	}
+
+	bruteforce := func(i, j int) *match {
+		return &match{pattern: patternBruteforce, i: i, j: j, token: string(password[i : j+1])}
+	}
+
+	for k := range n {
+		for _, m := range byEnd[k] {
+			if m.i > 0 {
+				for l := range optimal.m[m.i-1] {
+					update(m, l+1)
+				}
+			} else {
+				update(m, 1)
+			}
+		}
+
+		// Bruteforce parts ending at k, never next to another bruteforce part since they would be one part
+		update(bruteforce(0, k), 1)
+		for i := 1; i <= k; i++ {
+			m := bruteforce(i, k)
+			for l, last := range optimal.m[i-1] {
+				if last.pattern != patternBruteforce {
+					update(m, l+1)
+				}
+			}
+		}
+	}
+
+	// Unwind the best sequence covering the whole password
+	bestL, bestG := 0, math.Inf(1)
+	for l, g := range optimal.g[n-1] {
+		if g < bestG || (g == bestG && l < bestL) {
+			bestL, bestG = l, g
+		}
+	}
+	sequence := make([]*match, bestL)
+	for k, l := n-1, bestL; k >= 0; l-- {
+		m := optimal.m[k][l]
+		sequence[l-1] = m
+		k = m.i - 1
+	}
+	return bestG, sequence
+}
+
+// estimateGuesses returns the guesses needed to find a match, once its pattern is known.
+func estimateGuesses(m *match, passwordLength int, referenceYear int) float64 {
+	if m.guesses != 0 {
+		return m.guesses
+	}
+	minGuesses := 1.0
+	if m.length() < passwordLength {
+		minGuesses = minSubmatchGuessesMultiChar
+		if m.length() == 1 {
+			minGuesses = minSubmatchGuessesSingleChar
+		}
+	}
+	var guesses float64
+	switch m.pattern {
+	case patternBruteforce:
+		guesses = bruteforceGuesses(m)
+	case patternDictionary:
+		guesses = dictionaryGuesses(m)
+	case patternSpatial:
+		guesses = spatialGuesses(m)
+	case patternRepeat:
+		guesses = m.baseGuesses * float64(m.repeatCount)
+	case patternSequence:
+		guesses = sequenceGuesses(m)
+	case patternYear:
+		guesses = float64(max(abs(m.year-referenceYear), minYearSpace))
+	case patternDate:
+		guesses = float64(max(abs(m.year-referenceYear), minYearSpace)) * 365
+		if m.separator != "" {
+			guesses *= 4
+		}
+	}
+	m.guesses = max(guesses, minGuesses)
+	return m.guesses
+}
+
+func bruteforceGuesses(m *match) float64 {
+	guesses := math.Pow(bruteforceCardinality, float64(m.length()))
+	if math.IsInf(guesses, 1) {
+		guesses = math.MaxFloat64
+	}
+	// A bruteforce part always takes more guesses than a match of the same length would be credited with
+	minGuesses := float64(minSubmatchGuessesMultiChar + 1)
+	if m.length() == 1 {
+		minGuesses = minSubmatchGuessesSingleChar + 1
+	}
+	return max(guesses, minGuesses)
+}
+
+func dictionaryGuesses(m *match) float64 {
+	guesses := float64(m.rank) * uppercaseVariations(m.token) * l33tVariations(m)
+	if m.reversed {
+		guesses *= 2
+	}
+	return guesses
+}
+
+// uppercaseVariations counts the ways a word could have been capitalized. Capitalizing the first or last letter,
+// or every letter, is common enough to only double the guesses.
+func uppercaseVariations(word string) float64 {
+	upper, lower := 0, 0
+	for _, c := range word {
+		switch {
+		case unicode.IsUpper(c):
+			upper++
+		case unicode.IsLower(c):
+			lower++
+		}
+	}
+	if upper == 0 {
+		return 1
+	}
+	if lower == 0 || startUpper(word) || endUpper(word) {
+		return 2
+	}
+	variations := 0.0
+	for i := 1; i <= min(upper, lower); i++ {
+		variations += nCk(upper+lower, i)
+	}
+	return variations
+}
+
+// startUpper reports whether only the first character of word is uppercase.
+func startUpper(word string) bool {
+	runes := []rune(word)
+	return len(runes) > 1 && unicode.IsUpper(runes[0]) && strings.IndexFunc(string(runes[1:]), unicode.IsUpper) < 0
+}
+
+// endUpper reports whether only the last character of word is uppercase.
+func endUpper(word string) bool {
+	runes := []rune(word)
+	last := len(runes) - 1
+	return last > 0 && unicode.IsUpper(runes[last]) && strings.IndexFunc(string(runes[:last]), unicode.IsUpper) < 0
+}
+
+// l33tVariations counts the ways the substitutions of a match could have been applied to its word.
+func l33tVariations(m *match) float64 {
+	if !m.l33t {
+		return 1
+	}
+	variations := 1.0
+	lower := strings.ToLower(m.token)
+	for subbed, unsubbed := range m.sub {
+		s := strings.Count(lower, string(subbed))
+		u := strings.Count(lower, string(unsubbed))
+		if s == 0 || u == 0 {
+			// Either every occurrence of the letter was substituted or none was
+			variations *= 2
+			continue
+		}
+		possibilities := 0.0
+		for i := 1; i <= min(s, u); i++ {
+			possibilities += nCk(u+s, i)
+		}
+		variations *= possibilities
+	}
+	return variations
+}
+
+// spatialGuesses counts the keyboard patterns of the match's length with up to as many turns, from any starting key.
+func spatialGuesses(m *match) float64 {
+	s := float64(m.graph.startingPositions)
+	d := m.graph.averageDegree
+	guesses := 0.0
+	length := m.length()
+	for i := 2; i <= length; i++ {
+		possibleTurns := min(m.turns, i-1)
+		for j := 1; j <= possibleTurns; j++ {
+			guesses += nCk(i-1, j-1) * s * math.Pow(d, float64(j))
+		}
+	}
+	if m.shiftedCount > 0 {
+		shifted := m.shiftedCount
+		unshifted := length - shifted
+		if shifted == 0 || unshifted == 0 {
+			guesses *= 2
+		} else {
+			variations := 0.0
+			for i := 1; i <= min(shifted, unshifted); i++ {
+				variations += nCk(shifted+unshifted, i)
+			}
+			guesses *= variations
+		}
+	}
+	return guesses
+}
+
+// sequenceGuesses credits sequences starting at an obvious character, such as "abc" or "123", with fewer guesses.
+func sequenceGuesses(m *match) float64 {
+	first := []rune(m.token)[0]
+	var base float64
+	switch {
+	case strings.ContainsRune("aAzZ019", first):
+		base = 4
+	case unicode.IsDigit(first):
+		base = 10
+	default:
+		base = 26
+	}
+	if !m.ascending {
+		base *= 2
+	}
+	return base * float64(m.length())
+}
+
+// nCk returns the binomial coefficient "n choose k".
+func nCk(n, k int) float64 {
+	if k > n {
+		return 0
+	}
+	if k == 0 {
+		return 1
+	}
+	r := 1.0
+	for d := 1; d <= k; d++ {
+		r *= float64(n)
+		r /= float64(d)
+		n--
+	}
+	return r
+}
+
+func factorial(n int) float64 {
+	f := 1.0
+	for i := 2; i <= n; i++ {
+		f *= float64(i)
+	}
+	return f
+}
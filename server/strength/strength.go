@@ -0,0 +1,257 @@
+// Package strength estimates how hard a password is to guess, in the manner of Dropbox's zxcvbn. Rather than
+// counting character classes, it looks for the patterns attackers try first: common passwords, words, and names
+// (also reversed, capitalized, or with l33t substitutions such as "p@ssw0rd"), keyboard patterns such as "qwerty"
+// and "zaqwsx", repeats, sequences such as "abcd" and "9753", years, and dates. It then finds the combination of
+// patterns that is cheapest to guess and scores the password from 0 to 4.
+//
+// It is shared by the server's POST /strength endpoint, the strength checks on POST and PUT /credentials, and the
+// mango client.
+package strength
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Scores, from too guessable to very unguessable.
+const (
+	ScoreTooGuessable      = 0 // under 10^3 guesses: risky
+	ScoreVeryGuessable     = 1 // under 10^6 guesses: protects from throttled online attacks
+	ScoreSomewhatGuessable = 2 // under 10^8 guesses: protects from unthrottled online attacks
+	ScoreSafelyUnguessable = 3 // under 10^10 guesses: moderate protection from offline attacks
+	ScoreVeryUnguessable   = 4 // 10^10 guesses or more: strong protection from offline attacks
+
+	MaxScore = ScoreVeryUnguessable
+)
+
+// MaxAnalyzedLength is the number of characters examined for patterns. Matching takes time that grows quickly
+// with length, and a password this long scores the maximum unless it is made of patterns, so any further
+// characters are ignored; the estimate may only be too low.
+const MaxAnalyzedLength = 64
+
+// offlineGuessesPerSecond is the rate assumed for CrackTime: an offline attack on a slow password hash such as
+// bcrypt or Argon2 on many cores.
+const offlineGuessesPerSecond = 1e4
+
+// Result is the strength estimate of a password.
+type Result struct {
+	// Score is the strength from 0 (too guessable) to 4 (very unguessable).
+	Score int `json:"score"`
+	// GuessesLog10 is log10 of the estimated number of guesses needed to find the password.
+	GuessesLog10 float64 `json:"guessesLog10"`
+	// EntropyBits is log2 of the estimated number of guesses.
+	EntropyBits float64 `json:"entropyBits"`
+	// CrackTime is how long the guesses would take in an offline attack on a slow hash, such as "3 hours".
+	CrackTime string `json:"crackTime"`
+	// Feedback explains a low score and suggests improvements.
+	Feedback Feedback `json:"feedback"`
+}
+
+// Feedback explains a weak password. It is empty for passwords scoring 3 or more.
+type Feedback struct {
+	// Warning describes the main problem, such as "This is a top-100 common password". It may be empty.
+	Warning string `json:"warning,omitempty"`
+	// Suggestions say how to make the password stronger.
+	Suggestions []string `json:"suggestions,omitempty"`
+}
+
+// Estimate estimates the strength of a password. userInputs are words an attacker targeting its owner would try
+// first, such as the site, username, or email address; passwords built from them score lower.
+func Estimate(password string, userInputs ...string) Result {
+	runes := []rune(password)
+	if len(runes) > MaxAnalyzedLength {
+		runes = runes[:MaxAnalyzedLength]
+	}
+	mt := &matcher{
+		dictionaries:  append([]rankedDictionary{userInputDictionary(userInputs)}, dictionaries()...),
+		referenceYear: time.Now().Year(),
+	}
+	guesses, sequence := mostGuessableSequence(runes, mt.omnimatch(runes), false, mt.referenceYear)
+	log10 := math.Log10(guesses)
+	score := guessesToScore(guesses)
+	return Result{
+		Score:        score,
+		GuessesLog10: round(log10),
+		EntropyBits:  round(log10 * math.Log2(10)),
+		CrackTime:    displayTime(guesses / offlineGuessesPerSecond),
+		Feedback:     feedback(score, sequence),
+	}
+}
+
+// Reason returns the main reason a password is weak: the feedback's warning or, without one, its first suggestion.
+// It is empty for strong passwords.
+func (r Result) Reason() string {
+	if r.Feedback.Warning != "" {
+		return r.Feedback.Warning
+	}
+	if len(r.Feedback.Suggestions) > 0 {
+		return r.Feedback.Suggestions[0]
+	}
+	return ""
+}
+
+// guessesToScore maps guesses to a score. The small delta keeps passwords that match a threshold exactly, such as
+// a bruteforce part of three digits, in the lower score.
+func guessesToScore(guesses float64) int {
+	const delta = 5
+	switch {
+	case guesses < 1e3+delta:
+		return ScoreTooGuessable
+	case guesses < 1e6+delta:
+		return ScoreVeryGuessable
+	case guesses < 1e8+delta:
+		return ScoreSomewhatGuessable
+	case guesses < 1e10+delta:
+		return ScoreSafelyUnguessable
+	default:
+		return ScoreVeryUnguessable
+	}
+}
+
+// displayTime describes a number of seconds in the largest whole unit.
+func displayTime(seconds float64) string {
+	const (
+		minute  = 60
+		hour    = minute * 60
+		day     = hour * 24
+		month   = day * 31
+		year    = month * 12
+		century = year * 100
+	)
+	unit := func(n float64, name string) string {
+		rounded := int(math.Round(n))
+		if rounded == 1 {
+			return "1 " + name
+		}
+		return fmt.Sprintf("%d %ss", rounded, name)
+	}
+	switch {
+	case seconds < 1:
+		return "less than a second"
+	case seconds < minute:
+		return unit(seconds, "second")
+	case seconds < hour:
+		return unit(seconds/minute, "minute")
+	case seconds < day:
+		return unit(seconds/hour, "hour")
+	case seconds < month:
+		return unit(seconds/day, "day")
+	case seconds < year:
+		return unit(seconds/month, "month")
+	case seconds < century:
+		return unit(seconds/year, "year")
+	default:
+		return "centuries"
+	}
+}
+
+// Suggestions given with every weak password.
+const (
+	suggestionDefault   = "Use a few words, avoid common phrases"
+	suggestionNoSymbols = "No need for symbols, digits, or uppercase letters"
+	suggestionAddWord   = "Add another word or two. Uncommon words are better."
+)
+
+// feedback explains the longest match of a weak password's sequence.
+func feedback(score int, sequence []*match) Feedback {
+	if len(sequence) == 0 {
+		return Feedback{Suggestions: []string{suggestionDefault, suggestionNoSymbols}}
+	}
+	if score > ScoreSomewhatGuessable {
+		return Feedback{}
+	}
+	longest := sequence[0]
+	for _, m := range sequence[1:] {
+		if m.length() > longest.length() {
+			longest = m
+		}
+	}
+	f := matchFeedback(longest, len(sequence) == 1)
+	f.Suggestions = append([]string{suggestionAddWord}, f.Suggestions...)
+	return f
+}
+
+// matchFeedback explains why a pattern is guessable.
+func matchFeedback(m *match, soleMatch bool) Feedback {
+	switch m.pattern {
+	case patternDictionary:
+		return dictionaryFeedback(m, soleMatch)
+	case patternSpatial:
+		warning := "Short keyboard patterns are easy to guess"
+		if m.turns == 1 {
+			warning = "Straight rows of keys are easy to guess"
+		}
+		return Feedback{Warning: warning, Suggestions: []string{"Use a longer keyboard pattern with more turns"}}
+	case patternRepeat:
+		warning := `Repeats like "abcabcabc" are only slightly harder to guess than "abc"`
+		if utf8.RuneCountInString(m.baseToken) == 1 {
+			warning = `Repeats like "aaa" are easy to guess`
+		}
+		return Feedback{Warning: warning, Suggestions: []string{"Avoid repeated words and characters"}}
+	case patternSequence:
+		return Feedback{Warning: "Sequences like abc or 6543 are easy to guess", Suggestions: []string{"Avoid sequences"}}
+	case patternYear:
+		return Feedback{
+			Warning:     "Recent years are easy to guess",
+			Suggestions: []string{"Avoid recent years", "Avoid years that are associated with you"},
+		}
+	case patternDate:
+		return Feedback{
+			Warning:     "Dates are often easy to guess",
+			Suggestions: []string{"Avoid dates and years that are associated with you"},
+		}
+	}
+	return Feedback{}
+}
+
+// dictionaryFeedback explains why a word is guessable, and why its variations do not help much.
+func dictionaryFeedback(m *match, soleMatch bool) Feedback {
+	var f Feedback
+	switch m.dictionaryName {
+	case dictionaryPasswords:
+		switch {
+		case soleMatch && !m.l33t && !m.reversed && m.rank <= 10:
+			f.Warning = "This is a top-10 common password"
+		case soleMatch && !m.l33t && !m.reversed && m.rank <= 100:
+			f.Warning = "This is a top-100 common password"
+		case soleMatch && !m.l33t && !m.reversed:
+			f.Warning = "This is a very common password"
+		case math.Log10(m.guesses) <= 4:
+			f.Warning = "This is similar to a commonly used password"
+		}
+	case dictionaryEnglish:
+		if soleMatch {
+			f.Warning = "A word by itself is easy to guess"
+		}
+	case dictionarySurnames, dictionaryMaleNames, dictionaryFemaleNames:
+		if soleMatch {
+			f.Warning = "Names and surnames by themselves are easy to guess"
+		} else {
+			f.Warning = "Common names and surnames are easy to guess"
+		}
+	case dictionaryUserInputs:
+		f.Warning = "Passwords based on the site, username, or other personal details are easy to guess"
+	}
+
+	word := m.token
+	if startUpper(word) {
+		f.Suggestions = append(f.Suggestions, "Capitalization doesn't help very much")
+	} else if strings.ToUpper(word) == word && strings.ToLower(word) != word {
+		f.Suggestions = append(f.Suggestions, "All-uppercase is almost as easy to guess as all-lowercase")
+	}
+	if m.reversed && m.length() >= 4 {
+		f.Suggestions = append(f.Suggestions, "Reversed words aren't much harder to guess")
+	}
+	if m.l33t {
+		f.Suggestions = append(f.Suggestions, "Predictable substitutions like '@' instead of 'a' don't help very much")
+	}
+	return f
+}
+
+// round rounds to one decimal place for display.
+func round(x float64) float64 {
+	return math.Round(x*10) / 10
+}
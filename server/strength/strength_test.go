@@ -0,0 +1,203 @@
+package strength
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+// Suggestions that follow from the longest match of a weak password.
+const (
+	suggestionCapitalization = "Capitalization doesn't help very much"
+	suggestionAllUppercase   = "All-uppercase is almost as easy to guess as all-lowercase"
+	suggestionReversed       = "Reversed words aren't much harder to guess"
+	suggestionL33t           = "Predictable substitutions like '@' instead of 'a' don't help very much"
+	suggestionKeyboard       = "Use a longer keyboard pattern with more turns"
+	suggestionRepeats        = "Avoid repeated words and characters"
+	suggestionSequences      = "Avoid sequences"
+	suggestionDates          = "Avoid dates and years that are associated with you"
+)
+
+func TestEstimate(t *testing.T) {
+	tests := []struct {
+		password   string
+		userInputs []string
+		score      int
+		// patterns is the pattern and token of each part of the cheapest sequence.
+		patterns    []string
+		warning     string
+		suggestions []string
+	}{
+		// Dictionary words
+		{password: "password", score: 0, patterns: []string{"dictionary:password"},
+			warning: "This is a top-10 common password"},
+		{password: "monkey", score: 0, patterns: []string{"dictionary:monkey"},
+			warning: "This is a top-100 common password"},
+		{password: "qwertyuiop", score: 1, patterns: []string{"dictionary:qwertyuiop"},
+			warning: "This is a very common password"},
+		{password: "Password", score: 0, patterns: []string{"dictionary:Password"},
+			warning: "This is a top-10 common password", suggestions: []string{suggestionCapitalization}},
+		{password: "PASSWORD", score: 0, patterns: []string{"dictionary:PASSWORD"},
+			warning: "This is a top-10 common password", suggestions: []string{suggestionAllUppercase}},
+		{password: "drowssap", score: 0, patterns: []string{"dictionary:drowssap"},
+			warning: "This is similar to a commonly used password", suggestions: []string{suggestionReversed}},
+		{password: "correct", score: 1, patterns: []string{"dictionary:correct"},
+			warning: "A word by itself is easy to guess"},
+		{password: "jennifer", score: 0, patterns: []string{"dictionary:jennifer"},
+			warning: "Names and surnames by themselves are easy to guess"},
+		{password: "jenniferSmith", score: 1, patterns: []string{"dictionary:jennifer", "dictionary:Smith"},
+			warning: "Common names and surnames are easy to guess"},
+		{password: "alice2024", userInputs: []string{"example.com", "alice@example.com"}, score: 1,
+			patterns: []string{"dictionary:alice", "year:2024"},
+			warning:  "Passwords based on the site, username, or other personal details are easy to guess"},
+		{password: "correcthorsebatterystaple", score: 4,
+			patterns: []string{"dictionary:correct", "dictionary:horse", "dictionary:battery", "dictionary:staple"}},
+
+		// l33t substitutions
+		{password: "p@ssw0rd", score: 0, patterns: []string{"dictionary:p@ssw0rd"},
+			warning: "This is similar to a commonly used password", suggestions: []string{suggestionL33t}},
+		{password: "P4$$w0rd", score: 0, patterns: []string{"dictionary:P4$$w0rd"},
+			warning:     "This is similar to a commonly used password",
+			suggestions: []string{suggestionCapitalization, suggestionL33t}},
+		{password: "Sm1th", score: 0, patterns: []string{"dictionary:Sm1th"},
+			warning:     "Names and surnames by themselves are easy to guess",
+			suggestions: []string{suggestionCapitalization, suggestionL33t}},
+
+		// Keyboard patterns
+		{password: "hjkl", score: 1, patterns: []string{"spatial:hjkl"},
+			warning: "Straight rows of keys are easy to guess", suggestions: []string{suggestionKeyboard}},
+		{password: "ZXCVBNM<>?", score: 1, patterns: []string{"spatial:ZXCVBNM<>?"},
+			warning: "Straight rows of keys are easy to guess", suggestions: []string{suggestionKeyboard}},
+		{password: "3edc4rfv", score: 2, patterns: []string{"spatial:3edc", "spatial:4rfv"},
+			warning: "Straight rows of keys are easy to guess", suggestions: []string{suggestionKeyboard}},
+		{password: "poiuyhjk", score: 1, patterns: []string{"spatial:poiuyhjk"},
+			warning: "Short keyboard patterns are easy to guess", suggestions: []string{suggestionKeyboard}},
+		{password: "aoeuidhtns", score: 1, patterns: []string{"spatial:aoeuidhtns"},
+			warning: "Straight rows of keys are easy to guess", suggestions: []string{suggestionKeyboard}},
+		{password: "9632147", score: 1, patterns: []string{"spatial:9632147"},
+			warning: "Short keyboard patterns are easy to guess", suggestions: []string{suggestionKeyboard}},
+
+		// Repeats
+		{password: "aaaaaaaa", score: 0, patterns: []string{"repeat:aaaaaaaa"},
+			warning: `Repeats like "aaa" are easy to guess`, suggestions: []string{suggestionRepeats}},
+		{password: "abcabcabc", score: 0, patterns: []string{"repeat:abcabcabc"},
+			warning:     `Repeats like "abcabcabc" are only slightly harder to guess than "abc"`,
+			suggestions: []string{suggestionRepeats}},
+
+		// Sequences
+		{password: "abcdefg", score: 0, patterns: []string{"sequence:abcdefg"},
+			warning: "Sequences like abc or 6543 are easy to guess", suggestions: []string{suggestionSequences}},
+		{password: "9753", score: 0, patterns: []string{"sequence:9753"},
+			warning: "Sequences like abc or 6543 are easy to guess", suggestions: []string{suggestionSequences}},
+		{password: "ZYXWVU", score: 0, patterns: []string{"sequence:ZYXWVU"},
+			warning: "Sequences like abc or 6543 are easy to guess", suggestions: []string{suggestionSequences}},
+
+		// Years and dates
+		{password: "1986", score: 0, patterns: []string{"year:1986"},
+			warning: "Recent years are easy to guess", suggestions: []string{"Avoid recent years", "Avoid years that are associated with you"}},
+		{password: "13101986", score: 1, patterns: []string{"date:13101986"},
+			warning: "Dates are often easy to guess", suggestions: []string{suggestionDates}},
+		{password: "13.10.86", score: 1, patterns: []string{"date:13.10.86"},
+			warning: "Dates are often easy to guess", suggestions: []string{suggestionDates}},
+		{password: "2015_06_04", score: 1, patterns: []string{"date:2015_06_04"},
+			warning: "Dates are often easy to guess", suggestions: []string{suggestionDates}},
+
+		// Passwords that follow no pattern
+		{password: "xK9#mQ2$vL7@", score: 4, patterns: []string{"bruteforce:xK9#mQ2$vL7@"}},
+		{password: "x", score: 0, patterns: []string{"bruteforce:x"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.password, func(t *testing.T) {
+			r := Estimate(tt.password, tt.userInputs...)
+			if r.Score != tt.score {
+				t.Errorf("score %d, want %d", r.Score, tt.score)
+			}
+			if got := sequencePatterns(tt.password, tt.userInputs); !reflect.DeepEqual(got, tt.patterns) {
+				t.Errorf("matched %v, want %v", got, tt.patterns)
+			}
+			var want Feedback
+			if tt.score <= ScoreSomewhatGuessable {
+				want = Feedback{Warning: tt.warning, Suggestions: append([]string{suggestionAddWord}, tt.suggestions...)}
+			}
+			if !reflect.DeepEqual(r.Feedback, want) {
+				t.Errorf("feedback %+v, want %+v", r.Feedback, want)
+			}
+		})
+	}
+}
+
+func TestEstimateEmpty(t *testing.T) {
+	r := Estimate("")
+	want := Feedback{Suggestions: []string{suggestionDefault, suggestionNoSymbols}}
+	if r.Score != ScoreTooGuessable || r.GuessesLog10 != 0 || !reflect.DeepEqual(r.Feedback, want) {
+		t.Errorf("Estimate(\"\") = %+v", r)
+	}
+	if r.Reason() != suggestionDefault {
+		t.Errorf("reason %q, want %q", r.Reason(), suggestionDefault)
+	}
+}
+
+// TestGuesses pins the guesses credited to each pattern, without the penalty for the number of parts, with the
+// years and dates measured from 2025.
+func TestGuesses(t *testing.T) {
+	tests := []struct {
+		password string
+		guesses  float64
+	}{
+		{"password", 1},
+		{"Password", 2},
+		{"drowssap", 2},
+		{"p@ssw0rd", 4},
+		{"correct", 1_525},
+		{"jenniferSmith", 5_000},
+		{"hjkl", 1_296},
+		{"9632147", 74_491},
+		{"aaaaaaaa", 48},
+		{"abcabcabc", 39},
+		{"abcdefg", 28},
+		{"9753", 32},
+		{"1986", 39},
+		{"2024", 20},
+		{"13101986", 14_235},
+		{"13.10.86", 56_940},
+		{"xK9#mQ2$vL7@", 1e12},
+	}
+	for _, tt := range tests {
+		runes := []rune(tt.password)
+		mt := &matcher{dictionaries: dictionaries(), referenceYear: 2025}
+		guesses, _ := mostGuessableSequence(runes, mt.omnimatch(runes), true, mt.referenceYear)
+		if math.Abs(guesses-tt.guesses) > 0.5 {
+			t.Errorf("%q takes %.1f guesses, want %.0f", tt.password, guesses, tt.guesses)
+		}
+	}
+}
+
+func TestCrackTime(t *testing.T) {
+	for seconds, want := range map[float64]string{
+		0.5:         "less than a second",
+		1:           "1 second",
+		59:          "59 seconds",
+		90:          "2 minutes",
+		3 * 3600:    "3 hours",
+		86400:       "1 day",
+		40 * 86400:  "1 month",
+		400 * 86400: "1 year",
+		1e12:        "centuries",
+	} {
+		if got := displayTime(seconds); got != want {
+			t.Errorf("displayTime(%g) = %q, want %q", seconds, got, want)
+		}
+	}
+}
+
+// sequencePatterns returns the pattern and token of each part of the cheapest sequence covering password.
+func sequencePatterns(password string, userInputs []string) []string {
+	runes := []rune(password)
+	mt := &matcher{dictionaries: append([]rankedDictionary{userInputDictionary(userInputs)}, dictionaries()...), referenceYear: 2025}
+	_, sequence := mostGuessableSequence(runes, mt.omnimatch(runes), false, mt.referenceYear)
+	var patterns []string
+	for _, m := range sequence {
+		patterns = append(patterns, m.pattern+":"+m.token)
+	}
+	return patterns
+}
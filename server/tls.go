@@ -0,0 +1,332 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rihts-4/pasword-mango/config"
+)
+
+// Constants for the built-in TLS support.
+const (
+	selfSignedValidity    = 5 * 365 * 24 * time.Hour
+	certReloadInterval    = time.Minute
+	selfSignedCertName    = "cert.pem"
+	selfSignedKeyName     = "key.pem"
+	selfSignedOrgName     = "Pasword Mango"
+	selfSignedCommonName  = "Pasword Mango local server"
+	fingerprintFileSuffix = ".sha256"
+)
+
+// tlsSettings configures native HTTPS. If no certificate and key are configured, a self-signed certificate
+// is generated in Dir on first run and reused afterwards. In ClientAuth mode, clients must present a
+// certificate issued by the server's CA in Dir (mutual TLS).
+type tlsSettings struct {
+	Enabled    bool
+	ClientAuth bool
+	CertFile   string
+	KeyFile    string
+	Dir        string
+	Hosts      []string
+	MinVersion uint16
+}
+
+// newTLSSettings converts the validated TLS configuration into settings.
+func newTLSSettings(cfg config.TLS) (tlsSettings, error) {
+	minVersion, err := cfg.Version()
+	if err != nil {
+		return tlsSettings{}, err
+	}
+	settings := tlsSettings{
+		Enabled:    cfg.Enabled,
+		ClientAuth: cfg.ClientAuth,
+		CertFile:   cfg.CertFile,
+		KeyFile:    cfg.KeyFile,
+		Dir:        cfg.Dir,
+		Hosts:      cfg.Hosts,
+		MinVersion: minVersion,
+	}
+	if settings.CertFile == "" {
+		settings.CertFile = filepath.Join(settings.Dir, selfSignedCertName)
+		settings.KeyFile = filepath.Join(settings.Dir, selfSignedKeyName)
+	}
+	return settings, nil
+}
+
+// selfSigned reports whether the server manages its own certificate rather than serving a configured one.
+func (s tlsSettings) selfSigned() bool {
+	return s.CertFile == filepath.Join(s.Dir, selfSignedCertName) && s.KeyFile == filepath.Join(s.Dir, selfSignedKeyName)
+}
+
+// certManager serves the current certificate to TLS handshakes and swaps it when the files on disk change,
+// so certificates can be renewed without restarting the server. In mutual TLS mode it also requires
+// clients to present a certificate accepted by its client certificate authority.
+type certManager struct {
+	mu       sync.RWMutex
+	settings tlsSettings
+	cert     *tls.Certificate
+	modTime  time.Time
+	clients  *clientAuthority
+}
+
+func newCertManager(clients *clientAuthority) *certManager {
+	return &certManager{clients: clients}
+}
+
+// configure loads the certificate described by settings, generating a self-signed one first if the
+// server manages its own certificate and none exists yet or the existing one has expired. In mutual TLS
+// mode it also loads, or creates, the client certificate authority.
+func (m *certManager) configure(settings tlsSettings) error {
+	m.mu.Lock()
+	m.settings = settings
+	m.mu.Unlock()
+
+	if settings.selfSigned() {
+		if err := ensureSelfSignedCert(settings); err != nil {
+			return err
+		}
+	}
+	if settings.ClientAuth {
+		if err := m.clients.load(settings.Dir); err != nil {
+			return err
+		}
+	}
+	return m.reload()
+}
+
+// reload re-reads the certificate and key from disk and starts serving them.
+func (m *certManager) reload() error {
+	m.mu.RLock()
+	settings := m.settings
+	m.mu.RUnlock()
+
+	cert, err := tls.LoadX509KeyPair(settings.CertFile, settings.KeyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load TLS certificate: %w", err)
+	}
+	info, err := os.Stat(settings.CertFile)
+	if err != nil {
+		return fmt.Errorf("failed to stat TLS certificate: %w", err)
+	}
+
+	m.mu.Lock()
+	m.cert = &cert
+	m.modTime = info.ModTime()
+	m.mu.Unlock()
+
+	log.Printf("Serving TLS certificate %s with SHA-256 fingerprint %s", settings.CertFile, certFingerprint(cert.Leaf))
+	if err := writeFingerprintFile(settings.CertFile, cert.Leaf); err != nil {
+		log.Printf("Failed to write certificate fingerprint: %v", err)
+	}
+	return nil
+}
+
+// reloadIfChanged reloads the certificate when the certificate file's modification time has changed.
+func (m *certManager) reloadIfChanged() {
+	m.mu.RLock()
+	certFile, modTime := m.settings.CertFile, m.modTime
+	m.mu.RUnlock()
+
+	info, err := os.Stat(certFile)
+	if err != nil || info.ModTime().Equal(modTime) {
+		return
+	}
+	if err := m.reload(); err != nil {
+		log.Printf("Keeping previous TLS certificate: %v", err)
+	}
+}
+
+// watch polls the certificate file and reloads it when it changes, until done is closed.
+func (m *certManager) watch(done <-chan struct{}) {
+	ticker := time.NewTicker(certReloadInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			m.reloadIfChanged()
+		}
+	}
+}
+
+// getCertificate implements tls.Config.GetCertificate.
+func (m *certManager) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if m.cert == nil {
+		return nil, errors.New("no TLS certificate loaded")
+	}
+	return m.cert, nil
+}
+
+// tlsConfig returns the server TLS configuration backed by the manager.
+func (m *certManager) tlsConfig() *tls.Config {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	config := &tls.Config{
+		MinVersion:     m.settings.MinVersion,
+		GetCertificate: m.getCertificate,
+	}
+	if m.settings.ClientAuth {
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+		config.ClientCAs = m.clients.pool()
+		config.VerifyConnection = m.clients.verifyConnection
+	}
+	return config
+}
+
+// fingerprintHandler serves GET /tls/fingerprint, reporting the SHA-256 fingerprints of the served
+// certificate and of its public key so clients can pin either. It returns 404 when TLS is disabled.
+//
+// Pins should be obtained out of band, from the server log or the fingerprint file written next to the
+// certificate; this endpoint lets a client confirm which certificate it is talking to.
+func (m *certManager) fingerprintHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	m.mu.RLock()
+	cert := m.cert
+	m.mu.RUnlock()
+	if cert == nil {
+		http.Error(w, "TLS is not enabled", http.StatusNotFound)
+		return
+	}
+
+	spki := sha256.Sum256(cert.Leaf.RawSubjectPublicKeyInfo)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		SHA256     string    `json:"sha256"`
+		SPKISHA256 string    `json:"spkiSha256"`
+		NotAfter   time.Time `json:"notAfter"`
+	}{
+		SHA256:     certFingerprint(cert.Leaf),
+		SPKISHA256: base64.StdEncoding.EncodeToString(spki[:]),
+		NotAfter:   cert.Leaf.NotAfter,
+	})
+}
+
+// certFingerprint formats the SHA-256 digest of a certificate as colon-separated hex, as printed by
+// `openssl x509 -fingerprint -sha256`.
+func certFingerprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	parts := make([]string, len(sum))
+	for i, b := range sum {
+		parts[i] = fmt.Sprintf("%02X", b)
+	}
+	return strings.Join(parts, ":")
+}
+
+// writeFingerprintFile writes the certificate fingerprint next to the certificate so clients can be
+// configured to pin it.
+func writeFingerprintFile(certFile string, cert *x509.Certificate) error {
+	return os.WriteFile(certFile+fingerprintFileSuffix, []byte(certFingerprint(cert)+"\n"), 0644)
+}
+
+// ensureSelfSignedCert generates and persists a self-signed ECDSA P-256 certificate unless a valid one
+// already exists in the settings' directory. The private key is written with owner-only permissions.
+func ensureSelfSignedCert(settings tlsSettings) error {
+	if cert, err := tls.LoadX509KeyPair(settings.CertFile, settings.KeyFile); err == nil {
+		if time.Now().Before(cert.Leaf.NotAfter) {
+			return nil
+		}
+		log.Printf("Self-signed certificate %s expired on %s; generating a new one. Clients must update their pinned fingerprint.",
+			settings.CertFile, cert.Leaf.NotAfter.Format(time.DateOnly))
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("failed to load self-signed certificate: %w", err)
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return fmt.Errorf("failed to generate TLS key: %w", err)
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return fmt.Errorf("failed to generate certificate serial number: %w", err)
+	}
+
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{Organization: []string{selfSignedOrgName}, CommonName: selfSignedCommonName},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(selfSignedValidity),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+	}
+	for _, host := range settings.Hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, host)
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return fmt.Errorf("failed to create self-signed certificate: %w", err)
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return fmt.Errorf("failed to encode TLS key: %w", err)
+	}
+
+	if err := os.MkdirAll(settings.Dir, 0700); err != nil {
+		return fmt.Errorf("failed to create TLS directory: %w", err)
+	}
+	if err := writePEM(settings.KeyFile, "PRIVATE KEY", keyDER, 0600); err != nil {
+		return err
+	}
+	if err := writePEM(settings.CertFile, "CERTIFICATE", der, 0644); err != nil {
+		return err
+	}
+	log.Printf("Generated self-signed TLS certificate %s", settings.CertFile)
+	return nil
+}
+
+// writePEM atomically writes a single PEM block to path with the given permissions.
+func writePEM(path, blockType string, der []byte, perm os.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	if err := pem.Encode(tmp, &pem.Block{Type: blockType, Bytes: der}); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	return nil
+}